@@ -0,0 +1,115 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/logger"
+	"github.com/qvantel/nerd/internal/nets"
+)
+
+// DeleteAlias godoc
+// @Summary Alias deletion endpoint
+// @Description Will delete the alias with the specified name (the net it points to won't be affected)
+// @Produce json
+// @Param name path string true "Alias name"
+// @Success 200 {object} types.SimpleRes
+// @Failure 404 {object} types.SimpleRes "When the alias doesn't exist"
+// @Failure 500 {object} types.SimpleRes "When there is an error deleting the alias"
+// @Router /aliases/{name} [delete]
+func (h *Handler) DeleteAlias(c *gin.Context) {
+	name := aliasName(c)
+	found, err := nets.DeleteAlias(name, h.NPS)
+	if err != nil {
+		logger.Error("Failed to delete alias "+name, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error deleting alias, see logs for more info"))
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, types.NewErrorRes("Alias "+name+" could not be found"))
+		return
+	}
+	c.JSON(http.StatusOK, types.NewOkRes("Alias "+name+" was successfully deleted"))
+}
+
+// ListAliases godoc
+// @Summary Aliases endpoint
+// @Description Will return every alias in the system sorted by name
+// @Produce json
+// @Success 200 {array} types.Alias
+// @Failure 500 {object} types.SimpleRes "When there is an error retrieving the aliases"
+// @Router /aliases [get]
+func (h *Handler) ListAliases(c *gin.Context) {
+	aliases, err := nets.Aliases(h.NPS)
+	if err != nil {
+		logger.Error("Failed to get list of aliases", err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error getting list of aliases, see logs for more info"))
+		return
+	}
+	c.JSON(http.StatusOK, aliases)
+}
+
+// SetAlias godoc
+// @Summary Alias creation endpoint
+// @Description Will create or update an alias so that it points to the given net
+// @Accept json
+// @Produce json
+// @Param name path string true "Alias name"
+// @Success 200 {object} types.SimpleRes
+// @Failure 400 {object} types.SimpleRes "When the request body or the alias name are formatted incorrectly"
+// @Failure 404 {object} types.SimpleRes "When the net doesn't exist"
+// @Failure 500 {object} types.SimpleRes "When there is an error saving the alias"
+// @Router /aliases/{name} [put]
+func (h *Handler) SetAlias(c *gin.Context) {
+	var alias types.Alias
+	err := c.ShouldBind(&alias)
+	if err != nil {
+		logger.Debug("Failed to unmarshal message (" + err.Error() + ")")
+		c.JSON(http.StatusBadRequest, types.NewErrorRes("Wrong format"))
+		return
+	}
+	alias.Name = aliasName(c)
+	net, ok := h.loadNet(c, alias.NetID)
+	if !ok {
+		return
+	}
+	alias.NetID = net.ID() // In case it was given another alias
+	err = nets.SetAlias(alias.Name, alias.NetID, h.NPS)
+	if err != nil {
+		logger.Debug("Failed to save alias " + alias.Name + " (" + err.Error() + ")")
+		c.JSON(http.StatusBadRequest, types.NewErrorRes("Error saving alias ("+err.Error()+")"))
+		return
+	}
+	c.JSON(http.StatusOK, types.NewOkRes("Alias "+alias.Name+" now points to "+alias.NetID))
+}
+
+// ShowAlias godoc
+// @Summary Alias resolution endpoint
+// @Description Will return the alias with the specified name, which includes the ID of the net it points to
+// @Produce json
+// @Param name path string true "Alias name"
+// @Success 200 {object} types.Alias
+// @Failure 404 {object} types.SimpleRes "When the alias doesn't exist"
+// @Failure 500 {object} types.SimpleRes "When there is an error retrieving the alias"
+// @Router /aliases/{name} [get]
+func (h *Handler) ShowAlias(c *gin.Context) {
+	name := aliasName(c)
+	id, err := nets.Resolve(name, h.NPS)
+	if err != nil {
+		logger.Error("Failed to resolve alias "+name, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error resolving alias, see logs for more info"))
+		return
+	}
+	if id == "" {
+		c.JSON(http.StatusNotFound, types.NewErrorRes("Alias "+name+" could not be found"))
+		return
+	}
+	c.JSON(http.StatusOK, types.Alias{Name: name, NetID: id})
+}
+
+// aliasName returns the alias name from the path, which can contain slashes
+func aliasName(c *gin.Context) string {
+	return strings.TrimPrefix(c.Param("name"), "/")
+}
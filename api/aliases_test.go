@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/nets"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/resilience"
+)
+
+func TestAliases(t *testing.T) {
+	// Build API
+	conf := config.Config{
+		ML: config.MLParams{
+			StoreType:   config.FileParamStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+		Series: config.SeriesParams{
+			StoreType:   config.FileSeriesStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+	}
+	api, err := New(nil, newTestRegistry(t), nets.NewPool(conf.ML), resilience.NewBreakers(conf.ML), conf)
+	if err != nil {
+		t.Fatalf("Failed to initialize API (%s)", err.Error())
+	}
+
+	// Create two nets for the same output with different accuracies
+	prefix := "test-aliases-51e1890284194a8e4bb9923994e46cf59cfdd90d-"
+	worse := prefix + "89368e1d68015693ab48ee189d0632cb5d6edfb3-" + types.MultilayerPerceptron
+	better := prefix + "79368e1d68015693ab48ee189d0632cb5d6edfb3-" + types.MultilayerPerceptron
+	params := paramstores.MLPParams{
+		Accuracy:       0.8,
+		ActivationFunc: types.BipolarSigmoid,
+		Inputs:         []string{"subs", "events"},
+		LearningRate:   0.25,
+		Topology:       []int{2, 2, 1},
+		Outputs:        []string{"size"},
+		Weights: [][]float32{
+			{0.4, 0.7, -0.2, 0.6, -0.4, 0.3},
+			{-0.3, 0.5, 0.1},
+		},
+	}
+	api.NPS.Save(worse, &params)
+	defer nets.Delete(worse, api.NPS)
+	params.Accuracy = 0.9
+	api.NPS.Save(better, &params)
+	defer nets.Delete(better, api.NPS)
+
+	ts := httptest.NewServer(api.Router)
+
+	// Resolve the best net
+	resp, err := http.Get(ts.URL + base + "/v1/series/test-aliases/nets/best?output=size")
+	if err != nil {
+		t.Fatalf("A valid GET to the best net endpoint returned an error (%s)", err.Error())
+	}
+	var best types.BriefNet
+	err = json.NewDecoder(resp.Body).Decode(&best)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to parse response body (%s)", err.Error())
+	}
+	if best.ID != better {
+		t.Errorf("Expected the best net to be %s, got %s", better, best.ID)
+	}
+
+	// Create an alias
+	name := "test-aliases/size/prod"
+	raw, _ := json.Marshal(types.Alias{NetID: better})
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+base+"/v1/aliases/"+name, bytes.NewBuffer(raw))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("A valid PUT to the aliases endpoint returned an error (%s)", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("A valid PUT to the aliases endpoint returned an unexpected status code (%s)", resp.Status)
+	}
+	defer nets.DeleteAlias(name, api.NPS)
+
+	// Resolve it
+	resp, err = http.Get(ts.URL + base + "/v1/aliases/" + name)
+	if err != nil {
+		t.Fatalf("A valid GET to the aliases endpoint returned an error (%s)", err.Error())
+	}
+	var alias types.Alias
+	err = json.NewDecoder(resp.Body).Decode(&alias)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to parse response body (%s)", err.Error())
+	}
+	if alias.Name != name || alias.NetID != better {
+		t.Errorf("Expected alias %s to point to %s, got %+v", name, better, alias)
+	}
+
+	// It can be used instead of the net ID (escaping the slashes)
+	raw, _ = json.Marshal(map[string]float32{"subs": 1, "events": 2})
+	resp, err = http.Post(ts.URL+base+"/v1/nets/"+url.PathEscape(name)+"/evaluate", "application/json", bytes.NewBuffer(raw))
+	if err != nil {
+		t.Fatalf("A valid POST to the evaluate endpoint returned an error (%s)", err.Error())
+	}
+	var outputs map[string]float32
+	err = json.NewDecoder(resp.Body).Decode(&outputs)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the alias to be evaluated like its net, got %s (%v)", resp.Status, err)
+	}
+	if _, ok := outputs["size"]; !ok {
+		t.Errorf("Expected the output of the aliased net, got %v", outputs)
+	}
+
+	// Every other endpoint of the net takes it too
+	_, err = api.NPS.SaveVersion(better, &params)
+	if err != nil {
+		t.Fatalf("Failed to save version (%s)", err.Error())
+	}
+	err = api.NPS.Save(paramstores.AuxID(better, nets.DriftRecord), paramstores.JSON{Value: types.Drift{Checked: 1612706310}})
+	if err != nil {
+		t.Fatalf("Failed to save drift scores (%s)", err.Error())
+	}
+	requests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/drift"},
+		{http.MethodGet, "/history"},
+		{http.MethodGet, "/promotions"},
+		{http.MethodGet, "/versions"},
+		{http.MethodPost, "/versions/1/evaluate"},
+		{http.MethodPost, "/versions/1/rollback"},
+	}
+	for _, r := range requests {
+		raw, _ = json.Marshal(map[string]float32{"subs": 1, "events": 2})
+		req, _ = http.NewRequest(r.method, ts.URL+base+"/v1/nets/"+url.PathEscape(name)+r.path, bytes.NewBuffer(raw))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("A valid %s to %s returned an error (%s)", r.method, r.path, err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected a %s to %s through the alias to succeed, got %s", r.method, r.path, resp.Status)
+		}
+	}
+
+	// Aliases are kept separately so updating one doesn't affect the rest
+	err = nets.SetAlias("test-aliases/size/old", worse, api.NPS)
+	if err != nil {
+		t.Fatalf("Failed to save alias (%s)", err.Error())
+	}
+	defer nets.DeleteAlias("test-aliases/size/old", api.NPS)
+	aliases, err := nets.Aliases(api.NPS)
+	if err != nil {
+		t.Fatalf("Failed to list aliases (%s)", err.Error())
+	}
+	if len(aliases) != 2 || aliases[0].Name != "test-aliases/size/old" || aliases[1].NetID != better {
+		t.Errorf("Expected both aliases sorted by name, got %+v", aliases)
+	}
+
+	// Deleting the net (which can also be done through the alias) should remove the alias
+	req, _ = http.NewRequest(http.MethodDelete, ts.URL+base+"/v1/nets/"+url.PathEscape(name), nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("A valid DELETE to the nets endpoint returned an error (%s)", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the net to be deleted through the alias, got %s", resp.Status)
+	}
+	if net, _ := nets.LoadNetwork(better, types.MultilayerPerceptron, api.NPS); net != nil {
+		t.Errorf("Expected net %s to be deleted", better)
+	}
+	resp, err = http.Get(ts.URL + base + "/v1/aliases/" + name)
+	if err != nil {
+		t.Fatalf("A GET to the aliases endpoint returned an error (%s)", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 for the alias of a deleted net, got %s", resp.Status)
+	}
+	if aliases, _ := nets.Aliases(api.NPS); len(aliases) != 1 || aliases[0].NetID != worse {
+		t.Errorf("Expected only the alias of %s to be left, got %+v", worse, aliases)
+	}
+}
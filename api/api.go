@@ -5,10 +5,12 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/qvantel/nerd/api/types"
 	"github.com/qvantel/nerd/internal/config"
 	"github.com/qvantel/nerd/internal/logger"
+	"github.com/qvantel/nerd/internal/nets"
 	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/nets/queues"
+	"github.com/qvantel/nerd/internal/resilience"
 	"github.com/qvantel/nerd/internal/series/pointstores"
 )
 
@@ -16,11 +18,14 @@ const base = "/api"
 
 // Handler holds the API's state
 type Handler struct {
-	Conf   config.Config
-	NPS    paramstores.NetParamStore
-	PS     pointstores.PointStore
-	Router *gin.Engine
-	TServ  chan types.TrainRequest
+	Breakers *resilience.Breakers
+	Conf     config.Config
+	Jobs     *nets.Registry
+	NPS      paramstores.NetParamStore
+	PS       pointstores.PointStore
+	Pool     *nets.Pool
+	Router   *gin.Engine
+	TServ    queues.Queue
 }
 
 // @title Nerd
@@ -31,7 +36,7 @@ type Handler struct {
 // @BasePath /api/v1
 
 // New initializes the Gin rest api and returns a handler
-func New(tServ chan types.TrainRequest, conf config.Config) (*Handler, error) {
+func New(tServ queues.Queue, jobs *nets.Registry, pool *nets.Pool, bs *resilience.Breakers, conf config.Config) (*Handler, error) {
 	// Set up net param store
 	nps, err := paramstores.New(conf)
 	if err != nil {
@@ -47,13 +52,18 @@ func New(tServ chan types.TrainRequest, conf config.Config) (*Handler, error) {
 	}
 
 	router := gin.New()
+	// Aliases can be used instead of net IDs, so the slashes in them have to be escaped to be routed as a single param
+	router.UseRawPath = true
 
 	h := Handler{
-		Conf:   conf,
-		NPS:    nps,
-		PS:     ps,
-		Router: router,
-		TServ:  tServ,
+		Breakers: bs,
+		Conf:     conf,
+		Jobs:     jobs,
+		NPS:      nps,
+		PS:       ps,
+		Pool:     pool,
+		Router:   router,
+		TServ:    tServ,
 	}
 
 	// Global middleware
@@ -64,9 +74,17 @@ func New(tServ chan types.TrainRequest, conf config.Config) (*Handler, error) {
 	router.GET("/", h.ShowWelcomeMsg)
 	v1 := router.Group(base + "/v1")
 	{
+		aliases := v1.Group("/aliases")
+		{
+			aliases.GET("", h.ListAliases)
+			aliases.DELETE("/*name", h.DeleteAlias)
+			aliases.GET("/*name", h.ShowAlias)
+			aliases.PUT("/*name", h.SetAlias)
+		}
 		health := v1.Group("/health")
 		{
 			health.GET("/startup", StartupCheck)
+			health.GET("/training", h.TrainingCheck)
 		}
 		nets := v1.Group("/nets")
 		{
@@ -74,15 +92,44 @@ func New(tServ chan types.TrainRequest, conf config.Config) (*Handler, error) {
 			nets.POST("", h.Train)
 			nets.DELETE("/:id", h.DeleteNet)
 			nets.POST("/:id/evaluate", h.Evaluate)
+			nets.POST("/:id/evaluate/batch", h.EvaluateBatch)
+			nets.GET("/:id/drift", h.ShowDrift)
+			nets.GET("/:id/history", h.ShowHistory)
+			nets.GET("/:id/promotions", h.ListPromotions)
+			nets.GET("/:id/versions", h.ListVersions)
+			nets.POST("/:id/versions/:version/evaluate", h.EvaluateVersion)
+			nets.POST("/:id/versions/:version/rollback", h.Rollback)
+		}
+		v1.GET("/pool", h.ShowPool)
+		queue := v1.Group("/queue")
+		{
+			queue.GET("/dead", h.ListDeadLetters)
+		}
+		schedules := v1.Group("/schedules")
+		{
+			schedules.GET("", h.ListSchedules)
+			schedules.POST("", h.CreateSchedule)
+			schedules.DELETE("/:id", h.DeleteSchedule)
+			schedules.GET("/:id", h.ShowSchedule)
+			schedules.PUT("/:id", h.UpdateSchedule)
 		}
 		series := v1.Group("/series")
 		{
 			series.GET("", h.ListSeries)
 			series.DELETE("/:id", h.DeleteSeries)
 			series.GET("/:id/nets", h.ListSeriesNets)
+			series.GET("/:id/nets/best", h.ShowBestNet)
 			series.GET("/:id/points", h.ListPoints)
+			series.GET("/:id/retention", h.ShowRetention)
+			series.PUT("/:id/retention", h.SetRetention)
 			series.POST("/process", h.ProcessEvent)
 		}
+		training := v1.Group("/training")
+		{
+			training.GET("", h.ListTraining)
+			training.DELETE("/:id", h.CancelTraining)
+			training.GET("/:id", h.ShowTraining)
+		}
 	}
 
 	logger.Info("API initialized")
@@ -7,6 +7,16 @@ import (
 	"github.com/qvantel/nerd/api/types"
 )
 
+// TrainingCheck godoc
+// @Summary Training health endpoint
+// @Description Will return the state of the stores used by the training service, the status is degraded while any of them is failing (the API keeps working)
+// @Produce json
+// @Success 200 {object} types.Health
+// @Router /health/training [get]
+func (h *Handler) TrainingCheck(c *gin.Context) {
+	c.JSON(http.StatusOK, h.Breakers.Health())
+}
+
 // StartupCheck godoc
 // @Summary Kubernetes startup probe endpoint
 // @Description Will return a 200 as long as the API is up
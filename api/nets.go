@@ -1,14 +1,22 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/qvantel/nerd/api/types"
 	"github.com/qvantel/nerd/internal/logger"
 	"github.com/qvantel/nerd/internal/nets"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
 )
 
 // DeleteNet godoc
@@ -20,8 +28,11 @@ import (
 // @Failure 500 {object} types.SimpleRes "When there is an error deleting the net"
 // @Router /nets/{id} [delete]
 func (h *Handler) DeleteNet(c *gin.Context) {
-	id := c.Param("id")
-	err := h.NPS.Delete(id)
+	id, ok := h.netID(c)
+	if !ok {
+		return
+	}
+	err := nets.Delete(id, h.NPS)
 	if err != nil {
 		logger.Error("Failed to delete net "+id, err)
 		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error deleting net "+id+", see logs for more info"))
@@ -36,8 +47,9 @@ func (h *Handler) DeleteNet(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param id path string true "Net ID"
+// @Param timestamp query int false "Unix timestamp that time features are calculated for, defaults to now"
 // @Success 200 {object} map[string]float32
-// @Failure 400 {object} types.SimpleRes "When the request body is formatted incorrectly"
+// @Failure 400 {object} types.SimpleRes "When the request body or the timestamp are formatted incorrectly or some of the inputs are missing"
 // @Failure 404 {object} types.SimpleRes "When the provided net ID isn't found"
 // @Failure 500 {object} types.SimpleRes "When there is an error loading the net or evaluating the inputs"
 // @Router /nets/{id}/evaluate [post]
@@ -50,29 +62,188 @@ func (h *Handler) Evaluate(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, types.NewErrorRes("Wrong format"))
 		return
 	}
+	ts, ok := evalTime(c)
+	if !ok {
+		return
+	}
 
-	nType, err := nets.ID2Type(id)
+	net, ok := h.loadNet(c, id)
+	if !ok {
+		return
+	}
+	inputs, err = nets.Derive(net, inputs, ts, h.PS)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, types.NewErrorRes(err.Error()))
+		logger.Error("Failed to derive the features of net "+id, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error deriving features ("+err.Error()+")"))
+		return
+	}
+	if missing := missingInputs(net.Params().Brief().Inputs, inputs); len(missing) > 0 {
+		c.JSON(http.StatusBadRequest, types.NewErrorRes("missing inputs: "+strings.Join(missing, ", ")))
+		return
+	}
+	res, err := net.Evaluate(inputs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error evaluting inputs ("+err.Error()+")"))
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// EvaluateBatch godoc
+// @Summary Batch evaluation endpoint
+// @Description Will return the outputs produced by the given net for each of the inputs, which can be sent as a JSON array or as newline-delimited JSON. The results keep the order of the inputs and those that can't be evaluated get an error instead
+// @Accept json
+// @Accept x-ndjson
+// @Produce json
+// @Param id path string true "Net ID"
+// @Param timestamp query int false "Unix timestamp that time features are calculated for, defaults to now"
+// @Success 200 {array} types.BatchResult
+// @Failure 400 {object} types.SimpleRes "When the request body or the timestamp are formatted incorrectly"
+// @Failure 404 {object} types.SimpleRes "When the provided net ID isn't found"
+// @Failure 500 {object} types.SimpleRes "When there is an error loading the net or its previous points"
+// @Router /nets/{id}/evaluate/batch [post]
+func (h *Handler) EvaluateBatch(c *gin.Context) {
+	rows, err := readBatch(c.Request.Body)
+	if err != nil {
+		logger.Debug("Failed to read batch (" + err.Error() + ")")
+		c.JSON(http.StatusBadRequest, types.NewErrorRes("Wrong format"))
+		return
+	}
+	ts, ok := evalTime(c)
+	if !ok {
+		return
+	}
+
+	net, ok := h.loadNet(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	// The previous points are the same for every row so they're only fetched once
+	history, err := nets.History(net, ts, h.PS)
+	if err != nil {
+		logger.Error("Failed to load the previous points of net "+net.ID(), err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error loading the previous points, see logs for more info"))
 		return
 	}
+	labels := net.Params().Brief().Inputs
+	res := make([]types.BatchResult, len(rows))
+	for i, row := range rows {
+		var inputs map[string]float32
+		err := json.Unmarshal(row, &inputs)
+		if err != nil {
+			res[i].Error = "wrong format (" + err.Error() + ")"
+			continue
+		}
+		inputs, err = nets.DeriveFrom(net, inputs, ts, history)
+		if err != nil {
+			res[i].Error = "error deriving features (" + err.Error() + ")"
+			continue
+		}
+		if missing := missingInputs(labels, inputs); len(missing) > 0 {
+			res[i].Error = "missing inputs: " + strings.Join(missing, ", ")
+			continue
+		}
+		res[i].Outputs, err = net.Evaluate(inputs)
+		if err != nil {
+			res[i].Error = "error evaluating inputs (" + err.Error() + ")"
+		}
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// missingInputs returns the inputs of a net (labels) that aren't in the given ones, as they would otherwise be taken
+// as zeros
+func missingInputs(labels []string, inputs map[string]float32) []string {
+	missing := []string{}
+	for _, label := range labels {
+		if _, ok := inputs[label]; !ok {
+			missing = append(missing, label)
+		}
+	}
+	return missing
+}
+
+// readBatch splits a batch into its rows, which can come in a JSON array or as newline-delimited JSON
+func readBatch(r io.Reader) ([]json.RawMessage, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	body = bytes.TrimSpace(body)
+	rows := []json.RawMessage{}
+	if len(body) > 0 && body[0] == '[' {
+		err = json.Unmarshal(body, &rows)
+		return rows, err
+	}
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		rows = append(rows, json.RawMessage(line))
+	}
+	return rows, nil
+}
+
+// evalTime returns the moment that time features should be calculated for, if the timestamp query param is formatted
+// incorrectly, it will write the corresponding error response and return false
+func evalTime(c *gin.Context) (time.Time, bool) {
+	raw := c.Query("timestamp")
+	if raw == "" {
+		return time.Now(), true
+	}
+	ts, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorRes("timestamp must be a valid integer"))
+		return time.Time{}, false
+	}
+	return time.Unix(ts, 0), true
+}
 
+// loadNet retrieves the net with the given ID (or alias), if that isn't possible, it will write the corresponding error
+// response and return false
+func (h *Handler) loadNet(c *gin.Context, id string) (nets.Network, bool) {
+	id, ok := h.resolve(c, id)
+	if !ok {
+		return nil, false
+	}
+	nType, err := nets.ID2Type(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorRes(err.Error()))
+		return nil, false
+	}
 	net, err := nets.LoadNetwork(id, nType, h.NPS)
 	if err != nil {
 		logger.Error("Failed to load net "+id, err)
 		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error loading net, see logs for more info"))
-		return
+		return nil, false
 	}
 	if net == nil {
 		c.JSON(http.StatusNotFound, types.NewErrorRes("Net with ID "+id+" could not be found"))
-		return
+		return nil, false
 	}
-	res, err := net.Evaluate(inputs)
+	return net, true
+}
+
+// netID returns the ID of the net in the path, which can also be given through an alias. If the alias can't be
+// resolved, it will write the corresponding error response and return false
+func (h *Handler) netID(c *gin.Context) (string, bool) {
+	return h.resolve(c, c.Param("id"))
+}
+
+// resolve returns the ID of the net an alias points to or the given ID if it isn't one. If that can't be checked, it
+// will write the corresponding error response and return false
+func (h *Handler) resolve(c *gin.Context, id string) (string, bool) {
+	target, err := nets.Resolve(id, h.NPS)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error evaluting inputs ("+err.Error()+")"))
-		return
+		logger.Error("Failed to resolve alias "+id, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error resolving alias, see logs for more info"))
+		return "", false
 	}
-	c.JSON(http.StatusOK, res)
+	if target != "" {
+		return target, true
+	}
+	return id, true
 }
 
 // ListNets godoc
@@ -105,7 +276,7 @@ func (h *Handler) ListNets(c *gin.Context) {
 	if seriesID == "" {
 		seriesID = "*"
 	} else {
-		seriesID += "-????????????????????????????????????????-????????????????????????????????????????-*"
+		seriesID = nets.SeriesPattern(seriesID)
 	}
 
 	nets, cursor, err := nets.List(offset, limit, seriesID, h.NPS)
@@ -117,13 +288,81 @@ func (h *Handler) ListNets(c *gin.Context) {
 	c.JSON(http.StatusOK, types.PagedRes{Last: cursor == 0, Next: cursor, Results: nets})
 }
 
+// ShowDrift godoc
+// @Summary Drift scores endpoint
+// @Description Will return the population stability index of each input of the net, calculated with the latest window of points of its series
+// @Produce json
+// @Param id path string true "Net ID"
+// @Success 200 {object} types.Drift
+// @Failure 404 {object} types.SimpleRes "When the net hasn't been checked for drift yet"
+// @Failure 500 {object} types.SimpleRes "When there is an error loading the drift scores"
+// @Router /nets/{id}/drift [get]
+func (h *Handler) ShowDrift(c *gin.Context) {
+	id, ok := h.netID(c)
+	if !ok {
+		return
+	}
+	var drift types.Drift
+	found, err := h.NPS.Load(paramstores.AuxID(id, nets.DriftRecord), paramstores.JSON{Value: &drift})
+	if err != nil {
+		logger.Error("Failed to load the drift scores of net "+id, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error loading drift scores, see logs for more info"))
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, types.NewErrorRes("Net with ID "+id+" hasn't been checked for drift yet"))
+		return
+	}
+	c.JSON(http.StatusOK, drift)
+}
+
+// ListPromotions godoc
+// @Summary Promotion decisions endpoint
+// @Description Will return the latest decisions on whether a retrained version of the net (challenger) should replace the current one (champion), oldest first
+// @Produce json
+// @Param id path string true "Net ID"
+// @Success 200 {array} types.Promotion
+// @Failure 500 {object} types.SimpleRes "When there is an error loading the decisions"
+// @Router /nets/{id}/promotions [get]
+func (h *Handler) ListPromotions(c *gin.Context) {
+	id, ok := h.netID(c)
+	if !ok {
+		return
+	}
+	promotions, err := nets.Promotions(id, h.NPS)
+	if err != nil {
+		logger.Error("Failed to load the promotion decisions of net "+id, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error loading promotion decisions, see logs for more info"))
+		return
+	}
+	c.JSON(http.StatusOK, promotions)
+}
+
+// ShowHistory godoc
+// @Summary Training history endpoint
+// @Description Will return the training and validation loss of each epoch and, if the net was found by the genetic algorithm, the best fitness of each generation
+// @Produce json
+// @Param id path string true "Net ID"
+// @Success 200 {object} types.History
+// @Failure 400 {object} types.SimpleRes "When the provided net ID is formatted incorrectly"
+// @Failure 404 {object} types.SimpleRes "When the provided net ID isn't found"
+// @Failure 500 {object} types.SimpleRes "When there is an error loading the net"
+// @Router /nets/{id}/history [get]
+func (h *Handler) ShowHistory(c *gin.Context) {
+	net, ok := h.loadNet(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, net.Params().TrainingHistory())
+}
+
 // Train godoc
 // @Summary Net training endpoint
 // @Description Used for training new or existing networks with the points from an existing series
 // @Accept json
 // @Produce json
-// @Success 200 {object} types.SimpleRes
-// @Failure 400 {object} types.SimpleRes "When the request body is formatted incorrectly"
+// @Success 202 {object} types.SimpleRes "The Location header points to the training job, which can be checked and cancelled"
+// @Failure 400 {object} types.ValidationRes "When the request body is formatted incorrectly or doesn't match the series (every problem is listed)"
 // @Failure 404 {object} types.SimpleRes "When the provided series ID isn't found"
 // @Failure 500 {object} types.SimpleRes "When there is an error processing the request"
 // @Router /nets [post]
@@ -135,18 +374,61 @@ func (h *Handler) Train(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, types.NewErrorRes("Wrong format"))
 		return
 	}
+	if !h.checkRequest(c, &tr) {
+		return
+	}
+	sort.Strings(tr.Inputs)
+	sort.Strings(tr.Outputs)
+	tr.ID = uuid.New().String()
+	if tr.Priority == types.PriorityAutomatic {
+		tr.Priority = types.PriorityManual
+	}
+	// The job is registered first so that the training service always finds it, and discarded if the push fails
+	_, err = h.Jobs.Queue(tr)
+	if err != nil {
+		logger.Error("Failed to register training job for series "+tr.SeriesID, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error processing training request, see logs for more info"))
+		return
+	}
+	err = h.TServ.Push(tr)
+	if err != nil {
+		logger.Error("Failed to queue training request for series "+tr.SeriesID, err)
+		err = h.Jobs.Discard(tr.ID)
+		if err != nil {
+			logger.Error("Failed to discard training job "+tr.ID, err)
+		}
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error processing training request, see logs for more info"))
+		return
+	}
+	c.Header("Location", base+"/v1/training/"+tr.ID)
+	c.JSON(http.StatusAccepted, types.NewOkRes("Training request for series "+tr.SeriesID+" created successfully (job "+tr.ID+")"))
+}
+
+// checkRequest fills in the defaults of a training request and validates it against its series, writing the error
+// response and returning false if it can't be accepted
+func (h *Handler) checkRequest(c *gin.Context, tr *types.TrainRequest) bool {
 	exists, err := h.PS.Exists(tr.SeriesID)
 	if err != nil {
 		logger.Error("Failed to check if series with ID "+tr.SeriesID+" exists", err)
 		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error processing training request, see logs for more info"))
-		return
+		return false
 	}
 	if !exists {
 		c.JSON(http.StatusNotFound, types.NewErrorRes("Series with ID "+tr.SeriesID+" could not be found"))
-		return
+		return false
 	}
-	sort.Strings(tr.Inputs)
-	sort.Strings(tr.Outputs)
-	h.TServ <- tr
-	c.JSON(http.StatusAccepted, types.NewOkRes("Training request for series "+tr.SeriesID+" created successfully"))
+	if tr.Required == 0 {
+		tr.Required = nets.RequiredFor(*tr, h.Conf)
+	}
+	problems, err := nets.ValidateRequest(*tr, h.PS)
+	if err != nil {
+		logger.Error("Failed to validate training request for series "+tr.SeriesID, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error processing training request, see logs for more info"))
+		return false
+	}
+	if len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, types.NewValidationRes("Invalid training request", problems))
+		return false
+	}
+	return true
 }
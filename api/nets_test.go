@@ -10,7 +10,10 @@ import (
 
 	"github.com/qvantel/nerd/api/types"
 	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/nets"
 	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/resilience"
+	"github.com/qvantel/nerd/internal/series/pointstores"
 )
 
 func TestEvaluate(t *testing.T) {
@@ -26,7 +29,7 @@ func TestEvaluate(t *testing.T) {
 		},
 	}
 	id := "test-evaluate-51e1890284194a8e4bb9923994e46cf59cfdd90d-89368e1d68015693ab48ee189d0632cb5d6edfb3-" + types.MultilayerPerceptron
-	api, err := New(nil, conf)
+	api, err := New(nil, newTestRegistry(t), nets.NewPool(conf.ML), resilience.NewBreakers(conf.ML), conf)
 	if err != nil {
 		t.Fatalf("Failed to initialize API (%s)", err.Error())
 	}
@@ -74,4 +77,194 @@ func TestEvaluate(t *testing.T) {
 	if len(outputs) == 1 && outputs["size"] != -0.1806419 {
 		t.Errorf("Output is incorrect, expected %f got %f", -0.1806419, outputs["size"])
 	}
+
+	// Missing inputs shouldn't be taken as zeros
+	raw, _ = json.Marshal(map[string]float32{"subs": -1})
+	resp, err = http.Post(ts.URL+base+"/v1/nets/"+id+"/evaluate", "application/json", bytes.NewBuffer(raw))
+	if err != nil {
+		t.Fatalf("A POST to the evaluate endpoint returned an error (%s)", err.Error())
+	}
+	var res types.SimpleRes
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusBadRequest || res.Msg != "missing inputs: events" {
+		t.Errorf("Expected a 400 listing the missing input, got %s %+v (%v)", resp.Status, res, err)
+	}
+}
+
+func TestShowHistory(t *testing.T) {
+	// Build API
+	conf := config.Config{
+		ML: config.MLParams{
+			StoreType:   config.FileParamStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+		Series: config.SeriesParams{
+			StoreType:   config.FileSeriesStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+	}
+	id := "test-history-51e1890284194a8e4bb9923994e46cf59cfdd90d-89368e1d68015693ab48ee189d0632cb5d6edfb3-" + types.MultilayerPerceptron
+	api, err := New(nil, newTestRegistry(t), nets.NewPool(conf.ML), resilience.NewBreakers(conf.ML), conf)
+	if err != nil {
+		t.Fatalf("Failed to initialize API (%s)", err.Error())
+	}
+
+	// Create a network with a known history
+	params := paramstores.MLPParams{
+		ActivationFunc: types.BipolarSigmoid,
+		History: &types.History{
+			Epochs:      []types.Epoch{{Training: 0.5, Validation: 0.6}, {Training: 0.3, Validation: 0.4}},
+			Generations: []float32{0.7, 0.8},
+		},
+		Inputs:       []string{"subs", "events"},
+		LearningRate: 0.25,
+		Topology:     []int{2, 2, 1},
+		Outputs:      []string{"size"},
+		Weights: [][]float32{
+			{0.4, 0.7, -0.2, 0.6, -0.4, 0.3},
+			{-0.3, 0.5, 0.1},
+		},
+	}
+	api.NPS.Save(id, &params)
+	defer api.NPS.Delete(id)
+
+	ts := httptest.NewServer(api.Router)
+
+	resp, err := http.Get(ts.URL + base + "/v1/nets/" + id + "/history")
+	if err != nil {
+		t.Fatalf("A valid GET to the history endpoint returned an error (%s)", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("A valid GET to the history endpoint returned an unexpected status code (%s)", resp.Status)
+	}
+	var history types.History
+	err = json.NewDecoder(resp.Body).Decode(&history)
+	if err != nil {
+		t.Fatalf("Failed to parse response body (%s)", err.Error())
+	}
+	if len(history.Epochs) != 2 || history.Epochs[1].Validation != 0.4 || len(history.Generations) != 2 {
+		t.Errorf("History is incorrect, expected %+v got %+v", *params.History, history)
+	}
+
+	resp, err = http.Get(ts.URL + base + "/v1/nets/missing-" + id + "/history")
+	if err != nil {
+		t.Fatalf("A GET to the history endpoint for a missing net returned an error (%s)", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 for a missing net, got %s", resp.Status)
+	}
+}
+
+func TestEvaluateBatch(t *testing.T) {
+	// Build API
+	conf := config.Config{
+		ML: config.MLParams{
+			StoreType:   config.FileParamStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+		Series: config.SeriesParams{
+			StoreType:   config.FileSeriesStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+	}
+	id := "test-batch-51e1890284194a8e4bb9923994e46cf59cfdd90d-89368e1d68015693ab48ee189d0632cb5d6edfb3-" + types.MultilayerPerceptron
+	api, err := New(nil, newTestRegistry(t), nets.NewPool(conf.ML), resilience.NewBreakers(conf.ML), conf)
+	if err != nil {
+		t.Fatalf("Failed to initialize API (%s)", err.Error())
+	}
+
+	// Create a network in a known state
+	params := paramstores.MLPParams{
+		ActivationFunc: types.BipolarSigmoid,
+		Inputs:         []string{"subs", "events"},
+		LearningRate:   0.25,
+		Topology:       []int{2, 2, 1},
+		Outputs:        []string{"size"},
+		Weights: [][]float32{
+			{0.4, 0.7, -0.2, 0.6, -0.4, 0.3},
+			{-0.3, 0.5, 0.1},
+		},
+	}
+	api.NPS.Save(id, &params)
+	defer api.NPS.Delete(id)
+
+	ts := httptest.NewServer(api.Router)
+
+	bodies := map[string]string{
+		"application/json":     `[{"subs": -1, "events": 1}, {"subs": -1}, "wrong", {"events": 1, "subs": -1}]`,
+		"application/x-ndjson": "{\"subs\": -1, \"events\": 1}\n{\"subs\": -1}\nwrong\n\n{\"events\": 1, \"subs\": -1}\n",
+	}
+	for format, body := range bodies {
+		resp, err := http.Post(ts.URL+base+"/v1/nets/"+id+"/evaluate/batch", format, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("A valid POST to the batch evaluation endpoint returned an error (%s)", err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("A valid POST to the batch evaluation endpoint returned an unexpected status code (%s)", resp.Status)
+		}
+		var results []types.BatchResult
+		err = json.NewDecoder(resp.Body).Decode(&results)
+		if err != nil {
+			t.Fatalf("Failed to parse response body (%s)", err.Error())
+		}
+		if len(results) != 4 {
+			t.Fatalf("Expected a result per input (%s), got %+v", format, results)
+		}
+		for _, i := range []int{0, 3} {
+			if results[i].Error != "" || results[i].Outputs["size"] != -0.1806419 {
+				t.Errorf("Result %d is incorrect (%s), expected %f got %+v", i, format, -0.1806419, results[i])
+			}
+		}
+		for _, i := range []int{1, 2} {
+			if results[i].Error == "" || results[i].Outputs != nil {
+				t.Errorf("Expected result %d to be an error (%s), got %+v", i, format, results[i])
+			}
+		}
+	}
+}
+
+func TestTrainValidation(t *testing.T) {
+	// Build API
+	conf := config.Config{
+		ML: config.MLParams{
+			StoreType:   config.FileParamStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+		Series: config.SeriesParams{
+			StoreType:   config.FileSeriesStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+	}
+	api, err := New(nil, newTestRegistry(t), nets.NewPool(conf.ML), resilience.NewBreakers(conf.ML), conf)
+	if err != nil {
+		t.Fatalf("Failed to initialize API (%s)", err.Error())
+	}
+	seriesID := "test-train-validation"
+	err = api.PS.AddPoint(seriesID, pointstores.Point{TimeStamp: 1612706310, Values: map[string]float32{"subs": 1, "size": 2}})
+	if err != nil {
+		t.Fatalf("Failed to add point (%s)", err.Error())
+	}
+	defer api.PS.DeleteSeries(seriesID)
+
+	ts := httptest.NewServer(api.Router)
+
+	// A request with several problems should list all of them
+	raw, _ := json.Marshal(types.TrainRequest{Inputs: []string{"sbus"}, Outputs: []string{"size"}, Required: 10, SeriesID: seriesID})
+	resp, err := http.Post(ts.URL+base+"/v1/nets", "application/json", bytes.NewBuffer(raw))
+	if err != nil {
+		t.Fatalf("A POST to the nets endpoint returned an error (%s)", err.Error())
+	}
+	var res types.ValidationRes
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to parse response body (%s)", err.Error())
+	}
+	if resp.StatusCode != http.StatusBadRequest || len(res.Problems) != 3 {
+		t.Errorf("Expected a 400 listing the margin, the missing input and the missing points, got %s %+v", resp.Status, res)
+	}
 }
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/logger"
+	"github.com/qvantel/nerd/internal/nets"
+)
+
+// CreateSchedule godoc
+// @Summary Schedule creation endpoint
+// @Description Will create a schedule that periodically queues the given training request (skipping the runs in which no new points have been added to the series)
+// @Accept json
+// @Produce json
+// @Success 201 {object} types.Schedule "The Location header points to the new schedule"
+// @Failure 400 {object} types.ValidationRes "When the request body is formatted incorrectly or its training request doesn't match the series"
+// @Failure 404 {object} types.SimpleRes "When the provided series ID isn't found"
+// @Failure 500 {object} types.SimpleRes "When there is an error saving the schedule"
+// @Router /schedules [post]
+func (h *Handler) CreateSchedule(c *gin.Context) {
+	s, ok := h.bindSchedule(c)
+	if !ok {
+		return
+	}
+	s.ID = uuid.New().String()
+	h.saveSchedule(c, s, http.StatusCreated)
+}
+
+// DeleteSchedule godoc
+// @Summary Schedule deletion endpoint
+// @Description Will delete the schedule with the specified ID (jobs it has already queued won't be affected)
+// @Produce json
+// @Param id path string true "Schedule ID"
+// @Success 200 {object} types.SimpleRes
+// @Failure 404 {object} types.SimpleRes "When the schedule doesn't exist"
+// @Failure 500 {object} types.SimpleRes "When there is an error deleting the schedule"
+// @Router /schedules/{id} [delete]
+func (h *Handler) DeleteSchedule(c *gin.Context) {
+	id := c.Param("id")
+	found, err := nets.DeleteSchedule(id, h.NPS)
+	if err != nil {
+		logger.Error("Failed to delete schedule "+id, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error deleting schedule, see logs for more info"))
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, types.NewErrorRes("Schedule "+id+" could not be found"))
+		return
+	}
+	c.JSON(http.StatusOK, types.NewOkRes("Schedule "+id+" was successfully deleted"))
+}
+
+// ListSchedules godoc
+// @Summary Schedules endpoint
+// @Description Will return every schedule in the system sorted by ID, including when each last ran
+// @Produce json
+// @Success 200 {array} types.Schedule
+// @Failure 500 {object} types.SimpleRes "When there is an error retrieving the schedules"
+// @Router /schedules [get]
+func (h *Handler) ListSchedules(c *gin.Context) {
+	schedules, err := nets.Schedules(h.NPS)
+	if err != nil {
+		logger.Error("Failed to get list of schedules", err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error getting list of schedules, see logs for more info"))
+		return
+	}
+	c.JSON(http.StatusOK, schedules)
+}
+
+// ShowSchedule godoc
+// @Summary Schedule endpoint
+// @Description Will return the schedule with the specified ID, including when it last ran and when it will run next
+// @Produce json
+// @Param id path string true "Schedule ID"
+// @Success 200 {object} types.Schedule
+// @Failure 404 {object} types.SimpleRes "When the schedule doesn't exist"
+// @Failure 500 {object} types.SimpleRes "When there is an error retrieving the schedule"
+// @Router /schedules/{id} [get]
+func (h *Handler) ShowSchedule(c *gin.Context) {
+	id := c.Param("id")
+	s, found, err := nets.GetSchedule(id, h.NPS)
+	if err != nil {
+		logger.Error("Failed to get schedule "+id, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error getting schedule, see logs for more info"))
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, types.NewErrorRes("Schedule "+id+" could not be found"))
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// UpdateSchedule godoc
+// @Summary Schedule update endpoint
+// @Description Will replace the timing and training request of the schedule with the specified ID, keeping the record of its last run
+// @Accept json
+// @Produce json
+// @Param id path string true "Schedule ID"
+// @Success 200 {object} types.Schedule
+// @Failure 400 {object} types.ValidationRes "When the request body is formatted incorrectly or its training request doesn't match the series"
+// @Failure 404 {object} types.SimpleRes "When the schedule or the provided series ID aren't found"
+// @Failure 500 {object} types.SimpleRes "When there is an error saving the schedule"
+// @Router /schedules/{id} [put]
+func (h *Handler) UpdateSchedule(c *gin.Context) {
+	id := c.Param("id")
+	_, found, err := nets.GetSchedule(id, h.NPS)
+	if err != nil {
+		logger.Error("Failed to get schedule "+id, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error saving schedule, see logs for more info"))
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, types.NewErrorRes("Schedule "+id+" could not be found"))
+		return
+	}
+	s, ok := h.bindSchedule(c)
+	if !ok {
+		return
+	}
+	s.ID = id
+	h.saveSchedule(c, s, http.StatusOK)
+}
+
+// bindSchedule reads and validates the schedule in the body of the request, writing the error response if needed
+func (h *Handler) bindSchedule(c *gin.Context) (types.Schedule, bool) {
+	var s types.Schedule
+	err := c.ShouldBind(&s)
+	if err != nil {
+		logger.Debug("Failed to unmarshal message (" + err.Error() + ")")
+		c.JSON(http.StatusBadRequest, types.NewErrorRes("Wrong format"))
+		return s, false
+	}
+	err = nets.ValidateSchedule(s)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorRes(err.Error()))
+		return s, false
+	}
+	if !h.checkRequest(c, &s.Request) {
+		return s, false
+	}
+	sort.Strings(s.Request.Inputs)
+	sort.Strings(s.Request.Outputs)
+	s.Request.ID = ""
+	return s, true
+}
+
+// saveSchedule stores a bound schedule and responds with it
+func (h *Handler) saveSchedule(c *gin.Context, s types.Schedule, status int) {
+	saved, err := nets.SaveSchedule(s, h.NPS)
+	if err != nil {
+		logger.Error("Failed to save schedule "+s.ID, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error saving schedule, see logs for more info"))
+		return
+	}
+	c.Header("Location", base+"/v1/schedules/"+saved.ID)
+	c.JSON(status, saved)
+}
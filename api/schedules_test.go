@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/nets"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/resilience"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+func TestSchedules(t *testing.T) {
+	// Build API
+	conf := config.Config{
+		ML: config.MLParams{
+			StoreType:   config.FileParamStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+		Series: config.SeriesParams{
+			StoreType:   config.FileSeriesStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+	}
+	api, err := New(nil, newTestRegistry(t), nets.NewPool(conf.ML), resilience.NewBreakers(conf.ML), conf)
+	if err != nil {
+		t.Fatalf("Failed to initialize API (%s)", err.Error())
+	}
+	seriesID := "test-schedules-api"
+	err = api.PS.AddPoint(seriesID, pointstores.Point{TimeStamp: 1612706310, Values: map[string]float32{"a": 1, "b": 2}})
+	if err != nil {
+		t.Fatalf("Failed to add point (%s)", err.Error())
+	}
+	defer api.PS.DeleteSeries(seriesID)
+	defer api.NPS.Delete(paramstores.AuxID("", "schedules"))
+
+	ts := httptest.NewServer(api.Router)
+
+	// Invalid cron expression
+	tr := types.TrainRequest{ErrMargin: 0.1, Inputs: []string{"a"}, Outputs: []string{"b"}, Required: 1, SeriesID: seriesID}
+	raw, _ := json.Marshal(types.Schedule{Cron: "0 0 * *", Request: tr})
+	resp, err := http.Post(ts.URL+base+"/v1/schedules", "application/json", bytes.NewBuffer(raw))
+	if err != nil {
+		t.Fatalf("A POST to the schedules endpoint returned an error (%s)", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a 400 for an invalid cron expression, got %s", resp.Status)
+	}
+
+	// Create a schedule
+	raw, _ = json.Marshal(types.Schedule{Cron: "@daily", Request: tr})
+	resp, err = http.Post(ts.URL+base+"/v1/schedules", "application/json", bytes.NewBuffer(raw))
+	if err != nil {
+		t.Fatalf("A valid POST to the schedules endpoint returned an error (%s)", err.Error())
+	}
+	var s types.Schedule
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to parse response body (%s)", err.Error())
+	}
+	if resp.StatusCode != http.StatusCreated || s.ID == "" || s.NextRun == 0 {
+		t.Fatalf("Expected the schedule to be created with an ID and its next run, got %s %+v", resp.Status, s)
+	}
+	if location := resp.Header.Get("Location"); location != base+"/v1/schedules/"+s.ID {
+		t.Errorf("Expected the Location header to point to the schedule, got %s", location)
+	}
+
+	// List it
+	resp, err = http.Get(ts.URL + base + "/v1/schedules")
+	if err != nil {
+		t.Fatalf("A valid GET to the schedules endpoint returned an error (%s)", err.Error())
+	}
+	var list []types.Schedule
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to parse response body (%s)", err.Error())
+	}
+	if len(list) != 1 || list[0].ID != s.ID {
+		t.Errorf("Expected the list to contain schedule %s, got %+v", s.ID, list)
+	}
+
+	// Delete it
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+base+"/v1/schedules/"+s.ID, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("A valid DELETE to the schedules endpoint returned an error (%s)", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected a 200 when deleting the schedule, got %s", resp.Status)
+	}
+	resp, err = http.Get(ts.URL + base + "/v1/schedules/" + s.ID)
+	if err != nil {
+		t.Fatalf("A GET to the schedules endpoint returned an error (%s)", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 for a deleted schedule, got %s", resp.Status)
+	}
+}
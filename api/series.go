@@ -3,14 +3,19 @@ package api
 import (
 	"net/http"
 	"strconv"
+	"strings"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/gin-gonic/gin"
 	"github.com/qvantel/nerd/api/types"
 	"github.com/qvantel/nerd/internal/logger"
+	"github.com/qvantel/nerd/internal/nets"
 	"github.com/qvantel/nerd/internal/series"
 )
 
+// labelParam is the prefix of the query params used to filter points by label
+const labelParam = "label."
+
 // DeleteSeries godoc
 // @Summary Series deletion endpoint
 // @Description Will delete the series with the specified ID
@@ -44,10 +49,11 @@ func (h *Handler) DeleteSeries(c *gin.Context) {
 
 // ListPoints godoc
 // @Summary Retrieve points from series
-// @Description Will return the last N points for the given series
+// @Description Will return the last N points for the given series, optionally only those with certain labels (for example label.stage=production)
 // @Produce json
 // @Param id path string true "Series ID"
 // @Param limit query int false "How many points to fetch" default(10) maximum(500)
+// @Param label.{name} query string false "Only return points where the label has this value (can be repeated for different labels)"
 // @Success 200 {array} pointstores.Point
 // @Failure 404 {object} types.SimpleRes "When the series doesn't exist"
 // @Failure 500 {object} types.SimpleRes "When there is an error fetching the points"
@@ -57,6 +63,7 @@ func (h *Handler) ListPoints(c *gin.Context) {
 	limit, err := strconv.Atoi(raw)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, types.NewErrorRes("limit must be a valid integer"))
+		return
 	}
 	if limit > 500 {
 		limit = 500 // So things won't get too much out of control
@@ -73,7 +80,7 @@ func (h *Handler) ListPoints(c *gin.Context) {
 		return
 	}
 
-	points, err := h.PS.GetLastN(id, nil, limit)
+	points, err := h.PS.GetLastN(id, labelParams(c), limit)
 	if err != nil {
 		logger.Error("Failed to get points from series with ID " + id + " (" + err.Error() + ")")
 		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error fetching points, see logs for more info"))
@@ -127,6 +134,110 @@ func (h *Handler) ListSeriesNets(c *gin.Context) {
 	h.ListNets(c)
 }
 
+// SetRetention godoc
+// @Summary Series retention update endpoint
+// @Description Will change the number of days that the points of the series are kept for (0 keeps them forever)
+// @Accept json
+// @Produce json
+// @Param id path string true "Series ID"
+// @Success 200 {object} types.Retention
+// @Failure 400 {object} types.SimpleRes "When the request body is formatted incorrectly or the retention is negative"
+// @Failure 404 {object} types.SimpleRes "When the series doesn't exist"
+// @Failure 500 {object} types.SimpleRes "When there is an error changing the retention"
+// @Router /series/{id}/retention [put]
+func (h *Handler) SetRetention(c *gin.Context) {
+	var r types.Retention
+	err := c.ShouldBind(&r)
+	if err != nil {
+		logger.Debug("Failed to unmarshal message (" + err.Error() + ")")
+		c.JSON(http.StatusBadRequest, types.NewErrorRes("Wrong format"))
+		return
+	}
+	if r.Days < 0 {
+		c.JSON(http.StatusBadRequest, types.NewErrorRes("days can't be negative"))
+		return
+	}
+	id := c.Param("id")
+	exists, err := h.PS.Exists(id)
+	if err != nil {
+		logger.Error("Failed to check if series with ID "+id+" exists", err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error changing retention, see logs for more info"))
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, types.NewErrorRes("Series with ID "+id+" could not be found"))
+		return
+	}
+	err = h.PS.SetRetention(id, r.Days)
+	if err != nil {
+		logger.Error("Failed to change the retention of series "+id, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error changing retention, see logs for more info"))
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// ShowBestNet godoc
+// @Summary Best net resolution endpoint
+// @Description Will return the most accurate net trained with the given series that produces the given output
+// @Produce json
+// @Param id path string true "Series ID"
+// @Param output query string true "Output name"
+// @Success 200 {object} types.BriefNet
+// @Failure 400 {object} types.SimpleRes "When the output isn't provided"
+// @Failure 404 {object} types.SimpleRes "When there are no nets for the given series and output"
+// @Failure 500 {object} types.SimpleRes "When there is an error fetching the nets"
+// @Router /series/{id}/nets/best [get]
+func (h *Handler) ShowBestNet(c *gin.Context) {
+	id := c.Param("id")
+	output := c.Query("output")
+	if output == "" {
+		c.JSON(http.StatusBadRequest, types.NewErrorRes("output is required"))
+		return
+	}
+	best, err := nets.Best(id, output, h.NPS)
+	if err != nil {
+		logger.Error("Failed to find the best net of series "+id+" for "+output, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error fetching nets, see logs for more info"))
+		return
+	}
+	if best == nil {
+		c.JSON(http.StatusNotFound, types.NewErrorRes("There are no nets for "+output+" in series "+id))
+		return
+	}
+	c.JSON(http.StatusOK, best)
+}
+
+// ShowRetention godoc
+// @Summary Series retention endpoint
+// @Description Will return the number of days that the points of the series are kept for (0 means forever)
+// @Produce json
+// @Param id path string true "Series ID"
+// @Success 200 {object} types.Retention
+// @Failure 404 {object} types.SimpleRes "When the series doesn't exist"
+// @Failure 500 {object} types.SimpleRes "When there is an error fetching the retention"
+// @Router /series/{id}/retention [get]
+func (h *Handler) ShowRetention(c *gin.Context) {
+	id := c.Param("id")
+	exists, err := h.PS.Exists(id)
+	if err != nil {
+		logger.Error("Failed to check if series with ID "+id+" exists", err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error fetching retention, see logs for more info"))
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, types.NewErrorRes("Series with ID "+id+" could not be found"))
+		return
+	}
+	days, err := h.PS.GetRetention(id)
+	if err != nil {
+		logger.Error("Failed to get the retention of series "+id, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error fetching retention, see logs for more info"))
+		return
+	}
+	c.JSON(http.StatusOK, types.Retention{Days: days})
+}
+
 // ProcessEvent godoc
 // @Summary Metric ingestion endpoint
 // @Description Will process the provided metrics update
@@ -146,7 +257,7 @@ func (h *Handler) ProcessEvent(c *gin.Context) {
 		return
 	}
 
-	err = series.ProcessUpdate(event, h.PS, h.TServ, h.Conf)
+	err = series.ProcessUpdate(event, h.PS, h.NPS, h.TServ, h.Conf)
 	if err != nil {
 		logger.Error("Failed to process event", err)
 		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error processing event, see logs for more info"))
@@ -155,3 +266,14 @@ func (h *Handler) ProcessEvent(c *gin.Context) {
 
 	c.JSON(http.StatusAccepted, types.NewOkRes("Metrics update processed successfully"))
 }
+
+// labelParams returns the label filters in the query params of the request, which look like label.{name}={value}
+func labelParams(c *gin.Context) map[string]string {
+	labels := map[string]string{}
+	for key, values := range c.Request.URL.Query() {
+		if strings.HasPrefix(key, labelParam) && len(key) > len(labelParam) {
+			labels[key[len(labelParam):]] = values[0]
+		}
+	}
+	return labels
+}
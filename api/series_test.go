@@ -0,0 +1,155 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/nets"
+	"github.com/qvantel/nerd/internal/resilience"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+func TestListPoints(t *testing.T) {
+	// Build API
+	conf := config.Config{
+		ML: config.MLParams{
+			StoreType:   config.FileParamStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+		Series: config.SeriesParams{
+			StoreType:   config.FileSeriesStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+	}
+	api, err := New(nil, newTestRegistry(t), nets.NewPool(conf.ML), resilience.NewBreakers(conf.ML), conf)
+	if err != nil {
+		t.Fatalf("Failed to initialize API (%s)", err.Error())
+	}
+	seriesID := "test-list-points"
+	points := []pointstores.Point{
+		{Labels: map[string]string{"stage": "production", "subject": "env-a"}, Values: map[string]float32{"size": 1}, TimeStamp: 1612706310},
+		{Labels: map[string]string{"stage": "production", "subject": "env-b"}, Values: map[string]float32{"size": 2}, TimeStamp: 1612706370},
+		{Labels: map[string]string{"stage": "test", "subject": "env-a"}, Values: map[string]float32{"size": 3}, TimeStamp: 1612706430},
+	}
+	for _, p := range points {
+		err = api.PS.AddPoint(seriesID, p)
+		if err != nil {
+			t.Fatalf("Failed to add point (%s)", err.Error())
+		}
+	}
+	defer api.PS.DeleteSeries(seriesID)
+
+	ts := httptest.NewServer(api.Router)
+
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{"", 3},
+		{"?label.stage=production", 2},
+		{"?label.stage=production&label.subject=env-a", 1},
+		{"?label.stage=test&label.subject=env-b&limit=5", 0},
+	}
+	for _, test := range tests {
+		resp, err := http.Get(ts.URL + base + "/v1/series/" + seriesID + "/points" + test.query)
+		if err != nil {
+			t.Fatalf("A valid GET to the points endpoint returned an error (%s)", err.Error())
+		}
+		var res []pointstores.Point
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to parse response body (%s)", err.Error())
+		}
+		if len(res) != test.expected {
+			t.Errorf("Expected %d points for %q, got %d", test.expected, test.query, len(res))
+		}
+	}
+}
+
+func TestRetention(t *testing.T) {
+	// Build API
+	conf := config.Config{
+		ML: config.MLParams{
+			StoreType:   config.FileParamStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+		Series: config.SeriesParams{
+			Retention:   90,
+			StoreType:   config.FileSeriesStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+	}
+	api, err := New(nil, newTestRegistry(t), nets.NewPool(conf.ML), resilience.NewBreakers(conf.ML), conf)
+	if err != nil {
+		t.Fatalf("Failed to initialize API (%s)", err.Error())
+	}
+	seriesID := "test-retention"
+	p := pointstores.Point{Values: map[string]float32{"size": 1}, TimeStamp: 1612706310}
+	err = api.PS.AddPoint(seriesID, p)
+	if err != nil {
+		t.Fatalf("Failed to add point (%s)", err.Error())
+	}
+	defer api.PS.DeleteSeries(seriesID)
+
+	ts := httptest.NewServer(api.Router)
+	url := ts.URL + base + "/v1/series/" + seriesID + "/retention"
+
+	// New series get the default retention
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("A valid GET to the retention endpoint returned an error (%s)", err.Error())
+	}
+	var r types.Retention
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to parse response body (%s)", err.Error())
+	}
+	if r.Days != 90 {
+		t.Errorf("Expected the series to have the default retention of 90 days, got %d", r.Days)
+	}
+
+	tests := []struct {
+		days   int
+		status int
+	}{
+		{30, http.StatusOK},
+		{0, http.StatusOK},
+		{-1, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		raw, _ := json.Marshal(types.Retention{Days: test.days})
+		req, _ := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(raw))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("A PUT to the retention endpoint returned an error (%s)", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("Expected a PUT with a retention of %d days to return %d, got %d", test.days, test.status, resp.StatusCode)
+		}
+	}
+	days, err := api.PS.GetRetention(seriesID)
+	if err != nil {
+		t.Fatalf("Failed to get retention (%s)", err.Error())
+	}
+	if days != 0 {
+		t.Errorf("Expected the last valid retention (0 days) to be kept, got %d", days)
+	}
+
+	resp, err = http.Get(ts.URL + base + "/v1/series/" + seriesID + "-should-not-exist/retention")
+	if err != nil {
+		t.Fatalf("A GET to the retention endpoint returned an error (%s)", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a GET for a series that doesn't exist to return 404, got %d", resp.StatusCode)
+	}
+}
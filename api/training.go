@@ -0,0 +1,111 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/logger"
+)
+
+// cancelTimeout is how long the API will wait for a cancelled job to save the best nets it has found so far
+const cancelTimeout = 30 * time.Second
+
+// CancelTraining godoc
+// @Summary Training cancellation endpoint
+// @Description Will stop the training job with the specified ID (even if it's running in another instance), saving the best nets found so far
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} types.Job
+// @Failure 404 {object} types.SimpleRes "When the provided job ID isn't found"
+// @Failure 409 {object} types.SimpleRes "When the job has already finished"
+// @Failure 500 {object} types.SimpleRes "When there is an error accessing the job"
+// @Router /training/{id} [delete]
+func (h *Handler) CancelTraining(c *gin.Context) {
+	id := c.Param("id")
+	job, ok, err := h.Jobs.Cancel(id, cancelTimeout)
+	if err != nil {
+		logger.Error("Failed to cancel training job "+id, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error cancelling training job, see logs for more info"))
+		return
+	}
+	if ok {
+		c.JSON(http.StatusOK, job)
+		return
+	}
+	if job.ID != "" {
+		c.JSON(http.StatusConflict, types.NewErrorRes("Training job with ID "+id+" has already finished"))
+		return
+	}
+	c.JSON(http.StatusNotFound, types.NewErrorRes("Training job with ID "+id+" could not be found"))
+}
+
+// ListDeadLetters godoc
+// @Summary Dead-letter list endpoint
+// @Description Will return the training requests that were given up on after failing too many times, oldest first
+// @Produce json
+// @Success 200 {array} types.DeadLetter
+// @Failure 500 {object} types.SimpleRes
+// @Router /queue/dead [get]
+func (h *Handler) ListDeadLetters(c *gin.Context) {
+	dls, err := h.TServ.DeadLetters()
+	if err != nil {
+		logger.Error("Failed to list dead letters", err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error listing dead letters, see logs for more info"))
+		return
+	}
+	c.JSON(http.StatusOK, dls)
+}
+
+// ListTraining godoc
+// @Summary Training jobs endpoint
+// @Description Will return the training jobs that are queued, running or have recently finished, newest first
+// @Produce json
+// @Param status query string false "Filter by status (queued, running, succeeded, failed or cancelled)"
+// @Success 200 {array} types.Job
+// @Failure 500 {object} types.SimpleRes
+// @Router /training [get]
+func (h *Handler) ListTraining(c *gin.Context) {
+	jobs, err := h.Jobs.List(c.Query("status"))
+	if err != nil {
+		logger.Error("Failed to list training jobs", err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error listing training jobs, see logs for more info"))
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// ShowPool godoc
+// @Summary Training pool endpoint
+// @Description Will return the state of the training workers of the instance and the requests waiting for them
+// @Produce json
+// @Success 200 {object} types.Pool
+// @Router /pool [get]
+func (h *Handler) ShowPool(c *gin.Context) {
+	c.JSON(http.StatusOK, h.Pool.State())
+}
+
+// ShowTraining godoc
+// @Summary Training job endpoint
+// @Description Will return the status, progress, resulting nets and errors of the training job with the specified ID
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} types.Job
+// @Failure 404 {object} types.SimpleRes "When the provided job ID isn't found"
+// @Failure 500 {object} types.SimpleRes "When there is an error loading the job"
+// @Router /training/{id} [get]
+func (h *Handler) ShowTraining(c *gin.Context) {
+	id := c.Param("id")
+	job, ok, err := h.Jobs.Get(id)
+	if err != nil {
+		logger.Error("Failed to load training job "+id, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error loading training job, see logs for more info"))
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, types.NewErrorRes("Training job with ID "+id+" could not be found"))
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
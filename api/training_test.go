@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/nets"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/resilience"
+)
+
+// newTestRegistry returns a job registry that keeps its jobs in a temporary directory
+func newTestRegistry(t *testing.T) *nets.Registry {
+	nps, err := paramstores.NewFileAdapter(map[string]interface{}{"Path": t.TempDir()}, 0)
+	if err != nil {
+		t.Fatalf("Failed to initialize job store (%s)", err.Error())
+	}
+	return nets.NewRegistry(nps)
+}
+
+func TestTraining(t *testing.T) {
+	// Build API
+	conf := config.Config{
+		ML: config.MLParams{
+			StoreType:   config.FileParamStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+			Workers:     2,
+		},
+		Series: config.SeriesParams{
+			StoreType:   config.FileSeriesStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+	}
+	api, err := New(nil, newTestRegistry(t), nets.NewPool(conf.ML), resilience.NewBreakers(conf.ML), conf)
+	if err != nil {
+		t.Fatalf("Failed to initialize API (%s)", err.Error())
+	}
+	tr := types.TrainRequest{ID: "test-job", SeriesID: "test"}
+	_, err = api.Jobs.Queue(tr)
+	if err != nil {
+		t.Fatalf("Failed to queue job (%s)", err.Error())
+	}
+
+	ts := httptest.NewServer(api.Router)
+
+	resp, err := http.Get(ts.URL + base + "/v1/training/" + tr.ID)
+	if err != nil {
+		t.Fatalf("A valid GET to the training job endpoint returned an error (%s)", err.Error())
+	}
+	defer resp.Body.Close()
+	var job types.Job
+	err = json.NewDecoder(resp.Body).Decode(&job)
+	if err != nil {
+		t.Fatalf("Failed to parse response body (%s)", err.Error())
+	}
+	if job.ID != tr.ID || job.Status != types.JobQueued {
+		t.Errorf("Expected job %s to be queued, got %+v", tr.ID, job)
+	}
+
+	resp, err = http.Get(ts.URL + base + "/v1/training?status=" + types.JobQueued)
+	if err != nil {
+		t.Fatalf("A valid GET to the training jobs endpoint returned an error (%s)", err.Error())
+	}
+	defer resp.Body.Close()
+	var jobs []types.Job
+	err = json.NewDecoder(resp.Body).Decode(&jobs)
+	if err != nil {
+		t.Fatalf("Failed to parse response body (%s)", err.Error())
+	}
+	if len(jobs) != 1 || jobs[0].ID != tr.ID {
+		t.Errorf("Expected only job %s to be queued, got %+v", tr.ID, jobs)
+	}
+
+	// Cancel it twice, the second time it should already be finished
+	for _, expected := range []int{http.StatusOK, http.StatusConflict} {
+		req, _ := http.NewRequest(http.MethodDelete, ts.URL+base+"/v1/training/"+tr.ID, nil)
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("A DELETE to the training job endpoint returned an error (%s)", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("Expected a %d when cancelling the job, got %s", expected, resp.Status)
+		}
+	}
+	if job, _, _ := api.Jobs.Get(tr.ID); job.Status != types.JobCancelled {
+		t.Errorf("Expected job %s to be cancelled, got %+v", tr.ID, job)
+	}
+
+	resp, err = http.Get(ts.URL + base + "/v1/training/missing-" + tr.ID)
+	if err != nil {
+		t.Fatalf("A GET to the training job endpoint for a missing job returned an error (%s)", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 for a missing job, got %s", resp.Status)
+	}
+
+	resp, err = http.Get(ts.URL + base + "/v1/pool")
+	if err != nil {
+		t.Fatalf("A valid GET to the pool endpoint returned an error (%s)", err.Error())
+	}
+	defer resp.Body.Close()
+	var pool types.Pool
+	err = json.NewDecoder(resp.Body).Decode(&pool)
+	if err != nil {
+		t.Fatalf("Failed to parse response body (%s)", err.Error())
+	}
+	if len(pool.Workers) != 2 || len(pool.Pending) != 0 || pool.CPUBudget < 1 {
+		t.Errorf("Expected an idle pool with 2 workers, got %+v", pool)
+	}
+}
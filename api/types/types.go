@@ -1,25 +1,103 @@
 // Package types contains most of the objects that the API reads or writes
 package types
 
+import (
+	"sort"
+	"strconv"
+)
+
 const (
 	BipolarSigmoid = "bipolar-sigmoid"
 
 	MultilayerPerceptron = "mlp"
+
+	ZScore  = "z-score"
+	MinMax  = "min-max"
+	Robust  = "robust"
+	LogNorm = "log"
+	NoNorm  = "none"
+	// Excluded isn't selectable, it marks values that never changed in the training set and were thus left out
+	Excluded = "excluded"
+
+	Genetic      = "genetic"
+	RandomSearch = "random"
+	GridSearch   = "grid"
+	Bayesian     = "bayesian"
+
+	// Time features are derived from the timestamp of each point (UTC) and used as inputs under these names
+	Hour       = "@hour"        // Hour of the day (0 to 23)
+	HourSin    = "@hour-sin"    // Sine of the hour of the day on a 24 hour circle
+	HourCos    = "@hour-cos"    // Cosine of the hour of the day on a 24 hour circle
+	Weekday    = "@weekday"     // Day of the week (0 to 6, starting on Sunday)
+	WeekdaySin = "@weekday-sin" // Sine of the day of the week on a 7 day circle
+	WeekdayCos = "@weekday-cos" // Cosine of the day of the week on a 7 day circle
+	Month      = "@month"       // Month of the year (1 to 12)
+	MonthSin   = "@month-sin"   // Sine of the month on a 12 month circle
+	MonthCos   = "@month-cos"   // Cosine of the month on a 12 month circle
+	Elapsed    = "@elapsed"     // Days since the start of the series (the oldest training point)
+
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+
+	Mean = "mean"
+	Min  = "min"
+	Max  = "max"
+
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+
+	// Requests with a higher priority are trained first
+	PriorityAutomatic = 0 // Requests triggered by the ingestion of points or by drift
+	PriorityManual    = 1 // Requests sent through the API without a priority
+
+	ScheduleQueued  = "queued"  // The run queued a training request
+	ScheduleSkipped = "skipped" // The run found no new points in the series since the last request
 )
 
 var activationFuncs = []string{BipolarSigmoid}
+var aggregates = []string{Mean, Min, Max}
 var nets = []string{MultilayerPerceptron}
+var normalizations = []string{ZScore, MinMax, Robust, LogNorm, NoNorm}
+var strategies = []string{Genetic, RandomSearch, GridSearch, Bayesian}
+var timeFeatures = []string{Hour, HourSin, HourCos, Weekday, WeekdaySin, WeekdayCos, Month, MonthSin, MonthCos, Elapsed}
 
 // ActivationFuncs returns the list of supported neuron activation functions
 func ActivationFuncs() []string {
 	return activationFuncs
 }
 
+// Aggregates returns the list of supported rolling window aggregates
+func Aggregates() []string {
+	return aggregates
+}
+
+// Normalizations returns the list of supported value normalization schemes
+func Normalizations() []string {
+	return normalizations
+}
+
 // Nets returns the list of supported network types
 func Nets() []string {
 	return nets
 }
 
+// Strategies returns the list of supported hyperparameter search strategies
+func Strategies() []string {
+	return strategies
+}
+
+// TimeFeatures returns the list of supported time features
+func TimeFeatures() []string {
+	return timeFeatures
+}
+
 // PagedRes is a wrapper for a paged response where next can be provided as offset for the subsequent request and last
 // can be used to determine when there is nothing left to read
 type PagedRes struct {
@@ -45,28 +123,257 @@ func NewErrorRes(msg string) *SimpleRes {
 	return &SimpleRes{Result: "error", Msg: msg}
 }
 
+// ValidationRes is used when a request is rejected for several reasons, listing all of them so that they can be fixed
+// at once
+type ValidationRes struct {
+	Problems []string `json:"problems"`
+	Result   string   `json:"result"` // Always "error"
+	Msg      string   `json:"message"`
+}
+
+// NewValidationRes is a shortcut for building a ValidationRes
+func NewValidationRes(msg string, problems []string) *ValidationRes {
+	return &ValidationRes{Problems: problems, Result: "error", Msg: msg}
+}
+
+// Alias is a human readable name for a net
+type Alias struct {
+	Name  string `json:"name"`
+	NetID string `json:"netID"`
+}
+
+// BatchResult holds the outcome of evaluating one of the inputs of a batch, only one of its fields is filled in
+type BatchResult struct {
+	Error   string             `json:"error,omitempty"`
+	Outputs map[string]float32 `json:"outputs,omitempty"`
+}
+
 // BriefNet is a lightweight and standardized representation for neural network parameters
 type BriefNet struct {
-	Accuracy       float32            `json:"accuracy" example:"0.9"` // Fraction of patterns that were predicted correctly during testing
-	ActivationFunc string             `json:"activationFunc"`         // Function used to calculate the output of a neuron based on its inputs
-	Averages       map[string]float32 `json:"averages"`               // Averages of each value in the patterns that were used for training
-	Deviations     map[string]float32 `json:"deviations"`             // Standard deviation of each value in the patterns that were used for training
-	ErrMargin      float32            `json:"errMargin"`              // Maximum difference between the expected and produced result to still be considered correct during testing
-	HLayers        int                `json:"hLayers"`                // Number of hidden layers
-	ID             string             `json:"id"`
-	Inputs         []string           `json:"inputs"`
-	LearningRate   float32            `json:"learningRate"` // How much new inputs altered the network during training
-	Outputs        []string           `json:"outputs"`
-	Type           string             `json:"type"`
+	Accuracy       float32                 `json:"accuracy" example:"0.9"`  // Fraction of patterns that were predicted correctly during testing
+	ActivationFunc string                  `json:"activationFunc"`          // Function used to calculate the output of a neuron based on its inputs
+	Averages       map[string]float32      `json:"averages"`                // Averages of each value in the patterns that were used for training
+	Deviations     map[string]float32      `json:"deviations"`              // Standard deviation of each value in the patterns that were used for training
+	Distributions  map[string]Distribution `json:"distributions,omitempty"` // How the values of each input were spread in the patterns that were used for training
+	ErrMargin      float32                 `json:"errMargin"`               // Maximum difference between the expected and produced result to still be considered correct during testing
+	Features       *Features               `json:"features,omitempty"`      // Inputs that are derived instead of read from the points
+	HLayers        int                     `json:"hLayers"`                 // Number of hidden layers
+	ID             string                  `json:"id"`
+	Inputs         []string                `json:"inputs"`
+	LearningRate   float32                 `json:"learningRate"`  // How much new inputs altered the network during training
+	Normalization  map[string]string       `json:"normalization"` // Scheme used to normalize each of the inputs and outputs
+	Outputs        []string                `json:"outputs"`
+	Selection      *Selection              `json:"selection,omitempty"` // Points of the series the net was trained with, when not all of them could be used
+	Type           string                  `json:"type"`
 }
 
 // TrainRequest as its name implies, is used to ask the training service to create or update a net
 type TrainRequest struct {
-	ErrMargin float32  `json:"errMargin"` // Maximum difference between the expected and produced result to still be considered correct during testing
-	Inputs    []string `json:"inputs"`    // Which of the series values should be treated as inputs
-	Outputs   []string `json:"outputs"`   // Which of the series values should be treated as outputs
-	Required  int      `json:"required"`  // Number of points from the series that should be used to train and test
-	SeriesID  string   `json:"seriesID"`
+	Budget        int               `json:"budget"`        // Maximum number of seconds the search can take (0 means only the global budget applies)
+	ErrMargin     float32           `json:"errMargin"`     // Maximum difference between the expected and produced result to still be considered correct during testing
+	ID            string            `json:"id"`            // Job ID, assigned when the request is accepted
+	Inputs        []string          `json:"inputs"`        // Which of the series values should be treated as inputs
+	Lags          []Lag             `json:"lags"`          // Previous values that should be added to the inputs
+	Normalization map[string]string `json:"normalization"` // Normalization scheme for each value (z-score is used for those that aren't included)
+	Outputs       []string          `json:"outputs"`       // Which of the series values should be treated as outputs
+	Priority      int               `json:"priority"`      // Requests with a higher priority are trained first
+	Required      int               `json:"required"`      // Number of points from the series that should be used to train and test
+	Selection     Selection         `json:"selection"`     // Which of the points of the series can be used (all of them by default)
+	SeriesID      string            `json:"seriesID"`
+	Strategy      string            `json:"strategy"`     // Hyperparameter search strategy, the configured default is used when empty
+	TimeFeatures  []string          `json:"timeFeatures"` // Time features that should be added to the inputs
+	Windows       []Window          `json:"windows"`      // Aggregates of previous values that should be added to the inputs
+}
+
+// DeadLetter is a training request that was moved out of the queue after failing too many times
+type DeadLetter struct {
+	Attempts int          `json:"attempts"`
+	Buried   int64        `json:"buried"` // Unix timestamp of when it was moved to the dead-letter list
+	Reason   string       `json:"reason"` // Error returned by the last attempt
+	Request  TrainRequest `json:"request"`
+}
+
+// Distribution describes how a set of values is spread using bins that hold roughly the same number of them
+type Distribution struct {
+	Edges     []float32 `json:"edges"`     // Lower bound of every bin but the first one, which has none
+	Fractions []float32 `json:"fractions"` // Fraction of the values that fell in each bin
+}
+
+// Bin returns the index of the bin the given value falls in
+func (d Distribution) Bin(value float32) int {
+	return sort.Search(len(d.Edges), func(i int) bool { return value < d.Edges[i] })
+}
+
+// Drift holds the result of comparing the latest points of a series with the distribution a net was trained with
+type Drift struct {
+	Checked   int64              `json:"checked"`   // Unix timestamp of the check
+	Points    int                `json:"points"`    // Number of points the scores were calculated with
+	Requested int64              `json:"requested"` // Unix timestamp of the last retraining request caused by drift (0 if none)
+	Scores    map[string]float32 `json:"scores"`    // Population stability index of each input
+}
+
+// Epoch holds the mean squared error of a net on its training and test sets after an iteration over the former
+type Epoch struct {
+	Training   float32 `json:"training"`
+	Validation float32 `json:"validation"` // -1 when there was no test set
+}
+
+// Features describes the inputs of a net that are derived from the points of its series instead of read from them
+type Features struct {
+	Lags    []Lag    `json:"lags"`
+	Origin  int64    `json:"origin"` // Unix timestamp of the oldest training point, used for calculating the elapsed time
+	Time    []string `json:"time"`
+	Windows []Window `json:"windows"`
+}
+
+// History holds the learning curves of a net, meant for diagnosing under or overfitting
+type History struct {
+	Epochs      []Epoch   `json:"epochs"`
+	Generations []float32 `json:"generations"` // Best fitness in each generation (only when found by the genetic algorithm)
+}
+
+// Health describes whether the training service can reach the stores it depends on
+type Health struct {
+	Status string        `json:"status"` // ok or degraded (when a store is failing)
+	Stores []StoreHealth `json:"stores"`
+}
+
+// Job represents the processing of a training request
+type Job struct {
+	Created  int64              `json:"created"` // Unix timestamp of when the request was received
+	Errors   []string           `json:"errors"`
+	Finished int64              `json:"finished"` // Unix timestamp of when the job ended (0 if it hasn't)
+	ID       string             `json:"id"`
+	Nets     []BriefNet         `json:"nets"` // Nets that have been produced so far
+	Priority int                `json:"priority"`
+	Progress Progress           `json:"progress"`
+	SeriesID string             `json:"seriesID"`
+	Started  int64              `json:"started"`          // Unix timestamp of when training started (0 if it hasn't)
+	Status   string             `json:"status"`           // One of queued, running, succeeded, failed or cancelled
+	Trials   map[string][]Trial `json:"trials,omitempty"` // Configurations evaluated in the search for the net of each output
+}
+
+// Lag asks for the values that one of the series values had in the previous points to be used as inputs
+type Lag struct {
+	Label string `json:"label"`
+	Steps int    `json:"steps"` // How many previous values should be used (t-1 to t-steps)
+}
+
+// Names returns the names of the inputs that hold the lagged values
+func (l Lag) Names() []string {
+	names := make([]string, l.Steps)
+	for step := 1; step <= l.Steps; step++ {
+		names[step-1] = l.Label + "@t-" + strconv.Itoa(step)
+	}
+	return names
+}
+
+// NetVersion is a lightweight representation of one of the stored versions of a net
+type NetVersion struct {
+	BriefNet
+	Version int `json:"version"`
+}
+
+// Pool describes the state of the training workers of an instance
+type Pool struct {
+	CPUBudget    int          `json:"cpuBudget"`    // Maximum number of requests that can be trained at the same time
+	MemoryBudget int          `json:"memoryBudget"` // Megabytes in use above which no new requests are started (0 means no limit)
+	MemoryInUse  int          `json:"memoryInUse"`  // Megabytes currently in use
+	Pending      []PoolEntry  `json:"pending"`      // Requests waiting for a worker, in the order they would be picked now
+	Workers      []PoolWorker `json:"workers"`
+}
+
+// PoolEntry is a training request that is waiting for a worker
+type PoolEntry struct {
+	ID       string `json:"id"` // Job ID
+	Priority int    `json:"priority"`
+	SeriesID string `json:"seriesID"`
+}
+
+// PoolWorker is the state of one of the training workers
+type PoolWorker struct {
+	Job      string `json:"job"` // ID of the job being trained (empty when idle)
+	SeriesID string `json:"seriesID"`
+	Since    int64  `json:"since"` // Unix timestamp of when the worker started its current job (0 when idle)
+}
+
+// Progress describes what a running training job is currently doing
+type Progress struct {
+	Epoch      int    `json:"epoch"`      // Current epoch of the net being trained
+	Generation int    `json:"generation"` // Current generation (only when using the genetic algorithm)
+	Output     string `json:"output"`     // Output that a net is currently being searched for
+	Trials     int    `json:"trials"`     // Number of configurations evaluated so far for the current output
+}
+
+// Promotion records the comparison between a retrained net (challenger) and the one it would replace (champion)
+type Promotion struct {
+	Challenger float32 `json:"challenger"` // Accuracy of the challenger on the holdout set
+	Champion   float32 `json:"champion"`   // Accuracy of the champion on the holdout set
+	Decided    int64   `json:"decided"`    // Unix timestamp of the decision
+	Holdout    int     `json:"holdout"`    // Number of points both nets were tested with
+	Promoted   bool    `json:"promoted"`
+}
+
+// Retention is how long the points of a series are kept
+type Retention struct {
+	Days int `json:"days"` // Points older than this are deleted (0 means they're kept forever)
+}
+
+// Schedule retrains the nets of a series and output set periodically, using either a cron expression or an interval
+type Schedule struct {
+	Cron       string       `json:"cron"`       // Five field cron expression (minute hour day month weekday) evaluated in UTC
+	ID         string       `json:"id"`         // Assigned when the schedule is created
+	Interval   int          `json:"interval"`   // Seconds between runs, used when there is no cron expression
+	LastJob    string       `json:"lastJob"`    // ID of the training job queued by the latest run that wasn't skipped
+	LastPoint  int64        `json:"lastPoint"`  // Timestamp of the newest point of the series when LastJob was queued
+	LastResult string       `json:"lastResult"` // Either queued or skipped
+	LastRun    int64        `json:"lastRun"`    // Unix timestamp of the latest run (0 if it hasn't run yet)
+	NextRun    int64        `json:"nextRun"`    // Unix timestamp of the next run
+	Request    TrainRequest `json:"request"`    // Request queued on each run (with a new ID every time)
+}
+
+// Selection restricts the points of a series to those with certain labels and (optionally) within a time range
+type Selection struct {
+	From   int64             `json:"from"`   // Unix timestamp of the oldest point that can be used (0 means no limit)
+	Labels map[string]string `json:"labels"` // Only points with all of these labels can be used
+	To     int64             `json:"to"`     // Unix timestamp of the newest point that can be used (0 means no limit)
+}
+
+// Empty returns true if the selection doesn't restrict the points in any way
+func (s Selection) Empty() bool {
+	return s.From == 0 && len(s.Labels) == 0 && s.To == 0
+}
+
+// Window asks for an aggregate of the values that one of the series values had in the previous points to be used as
+// an input
+type Window struct {
+	Aggregate string `json:"aggregate"` // One of mean, min or max
+	Label     string `json:"label"`
+	Size      int    `json:"size"` // Number of previous points the aggregate is calculated over
+}
+
+// Name returns the name of the input that holds the aggregate
+func (w Window) Name() string {
+	return w.Label + "@" + w.Aggregate + "-" + strconv.Itoa(w.Size)
+}
+
+// StoreHealth is the state of the circuit breaker that protects the calls to a store
+type StoreHealth struct {
+	Failures  int    `json:"failures"` // Number of consecutive failed calls
+	LastError string `json:"lastError"`
+	Name      string `json:"name"`
+	Since     int64  `json:"since"` // Unix timestamp of when the breaker changed to its current state
+	State     string `json:"state"` // One of closed, open or half-open
+}
+
+// Trial holds the hyperparameters of one of the nets that were evaluated during a search along with its accuracy
+type Trial struct {
+	ActivationFunc string  `json:"activationFunc"`
+	Error          string  `json:"error,omitempty"` // Why the net couldn't be trained, if it failed
+	Fitness        float32 `json:"fitness"`
+	HLayers        int     `json:"hLayers"`
+	LearningRate   float32 `json:"learningRate"`
+	Type           string  `json:"type"`
 }
 
 // BriefSeries is a lightweight representation of a time series
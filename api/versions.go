@@ -0,0 +1,151 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/logger"
+	"github.com/qvantel/nerd/internal/nets"
+)
+
+// EvaluateVersion godoc
+// @Summary Version evaluation endpoint
+// @Description Will return the output produced by the given version of the net for the given input
+// @Accept json
+// @Produce json
+// @Param id path string true "Net ID"
+// @Param version path int true "Version number"
+// @Param timestamp query int false "Unix timestamp that time features are calculated for, defaults to now"
+// @Success 200 {object} map[string]float32
+// @Failure 400 {object} types.SimpleRes "When the request body, the version or the timestamp are formatted incorrectly or some of the inputs are missing"
+// @Failure 404 {object} types.SimpleRes "When the provided version isn't found"
+// @Failure 500 {object} types.SimpleRes "When there is an error loading the version or evaluating the inputs"
+// @Router /nets/{id}/versions/{version}/evaluate [post]
+func (h *Handler) EvaluateVersion(c *gin.Context) {
+	id, ok := h.netID(c)
+	if !ok {
+		return
+	}
+	var inputs map[string]float32
+	err := c.ShouldBind(&inputs)
+	if err != nil {
+		logger.Debug("Failed to unmarshal message (" + err.Error() + ")")
+		c.JSON(http.StatusBadRequest, types.NewErrorRes("Wrong format"))
+		return
+	}
+	nType, version, ok := versionParams(c, id)
+	if !ok {
+		return
+	}
+	ts, ok := evalTime(c)
+	if !ok {
+		return
+	}
+
+	net, err := nets.LoadVersion(id, nType, version, h.NPS)
+	if err != nil {
+		logger.Error("Failed to load version "+c.Param("version")+" of net "+id, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error loading net, see logs for more info"))
+		return
+	}
+	if net == nil {
+		c.JSON(http.StatusNotFound, types.NewErrorRes("Version "+c.Param("version")+" of net "+id+" could not be found"))
+		return
+	}
+	inputs, err = nets.Derive(net, inputs, ts, h.PS)
+	if err != nil {
+		logger.Error("Failed to derive the features of net "+id, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error deriving features ("+err.Error()+")"))
+		return
+	}
+	if missing := missingInputs(net.Params().Brief().Inputs, inputs); len(missing) > 0 {
+		c.JSON(http.StatusBadRequest, types.NewErrorRes("missing inputs: "+strings.Join(missing, ", ")))
+		return
+	}
+	res, err := net.Evaluate(inputs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error evaluting inputs ("+err.Error()+")"))
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// ListVersions godoc
+// @Summary Net versions endpoint
+// @Description Will return the stored versions of the net, oldest first
+// @Produce json
+// @Param id path string true "Net ID"
+// @Success 200 {array} types.NetVersion
+// @Failure 400 {object} types.SimpleRes "When the provided net ID is formatted incorrectly"
+// @Failure 500 {object} types.SimpleRes "When there is an error retrieving the versions"
+// @Router /nets/{id}/versions [get]
+func (h *Handler) ListVersions(c *gin.Context) {
+	id, ok := h.netID(c)
+	if !ok {
+		return
+	}
+	nType, err := nets.ID2Type(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorRes(err.Error()))
+		return
+	}
+	versions, err := nets.ListVersions(id, nType, h.NPS)
+	if err != nil {
+		logger.Error("Failed to list the versions of net "+id, err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error listing versions, see logs for more info"))
+		return
+	}
+	c.JSON(http.StatusOK, versions)
+}
+
+// Rollback godoc
+// @Summary Net rollback endpoint
+// @Description Will replace the current params of the net with those of the given version
+// @Produce json
+// @Param id path string true "Net ID"
+// @Param version path int true "Version number"
+// @Success 200 {object} types.SimpleRes
+// @Failure 400 {object} types.SimpleRes "When the net ID or the version are formatted incorrectly"
+// @Failure 404 {object} types.SimpleRes "When the provided version isn't found"
+// @Failure 500 {object} types.SimpleRes "When there is an error rolling back"
+// @Router /nets/{id}/versions/{version}/rollback [post]
+func (h *Handler) Rollback(c *gin.Context) {
+	id, ok := h.netID(c)
+	if !ok {
+		return
+	}
+	nType, version, ok := versionParams(c, id)
+	if !ok {
+		return
+	}
+	found, err := nets.Rollback(id, nType, version, h.NPS)
+	if err != nil {
+		logger.Error("Failed to roll back net "+id+" to version "+c.Param("version"), err)
+		c.JSON(http.StatusInternalServerError, types.NewErrorRes("Error rolling back net, see logs for more info"))
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, types.NewErrorRes("Version "+c.Param("version")+" of net "+id+" could not be found"))
+		return
+	}
+	c.JSON(http.StatusOK, types.NewOkRes("Net "+id+" was successfully rolled back to version "+c.Param("version")))
+}
+
+// versionParams extracts the net type from the (resolved) ID and the version number from the path, if that isn't
+// possible, it will write the corresponding error response and return false
+func versionParams(c *gin.Context, id string) (string, int, bool) {
+	nType, err := nets.ID2Type(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorRes(err.Error()))
+		return "", 0, false
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorRes("version must be a valid integer"))
+		return "", 0, false
+	}
+	return nType, version, true
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/nets"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/resilience"
+)
+
+func TestVersions(t *testing.T) {
+	// Build API
+	conf := config.Config{
+		ML: config.MLParams{
+			StoreType:   config.FileParamStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+			Versions:    5,
+		},
+		Series: config.SeriesParams{
+			StoreType:   config.FileSeriesStore,
+			StoreParams: map[string]interface{}{"Path": "."},
+		},
+	}
+	id := "test-versions-51e1890284194a8e4bb9923994e46cf59cfdd90d-89368e1d68015693ab48ee189d0632cb5d6edfb3-" + types.MultilayerPerceptron
+	api, err := New(nil, newTestRegistry(t), nets.NewPool(conf.ML), resilience.NewBreakers(conf.ML), conf)
+	if err != nil {
+		t.Fatalf("Failed to initialize API (%s)", err.Error())
+	}
+
+	// Store two versions of a net in a known state, the second being the current one
+	first := paramstores.MLPParams{
+		ActivationFunc: types.BipolarSigmoid,
+		Inputs:         []string{"subs", "events"},
+		LearningRate:   0.25,
+		Topology:       []int{2, 2, 1},
+		Outputs:        []string{"size"},
+		Weights: [][]float32{
+			{0.4, 0.7, -0.2, 0.6, -0.4, 0.3},
+			{-0.3, 0.5, 0.1},
+		},
+	}
+	second := first
+	second.Weights = [][]float32{
+		{-0.4, -0.7, 0.2, -0.6, 0.4, -0.3},
+		{0.3, -0.5, -0.1},
+	}
+	api.NPS.SaveVersion(id, &first)
+	api.NPS.SaveVersion(id, &second)
+	api.NPS.Save(id, &second)
+	defer api.NPS.Delete(id)
+
+	ts := httptest.NewServer(api.Router)
+
+	// List
+	resp, err := http.Get(ts.URL + base + "/v1/nets/" + id + "/versions")
+	if err != nil {
+		t.Fatalf("A valid GET to the versions endpoint returned an error (%s)", err.Error())
+	}
+	var versions []types.NetVersion
+	err = json.NewDecoder(resp.Body).Decode(&versions)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to parse response body (%s)", err.Error())
+	}
+	if len(versions) != 2 || versions[0].Version != 1 || versions[1].Version != 2 || versions[0].ID != id {
+		t.Fatalf("Expected versions 1 and 2 of %s, got %+v", id, versions)
+	}
+
+	// Evaluate the first version
+	raw, _ := json.Marshal(map[string]float32{"subs": -1, "events": 1})
+	resp, err = http.Post(ts.URL+base+"/v1/nets/"+id+"/versions/1/evaluate", "application/json", bytes.NewBuffer(raw))
+	if err != nil {
+		t.Fatalf("A valid POST to the version evaluate endpoint returned an error (%s)", err.Error())
+	}
+	var outputs map[string]float32
+	err = json.NewDecoder(resp.Body).Decode(&outputs)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to parse response body (%s)", err.Error())
+	}
+	if outputs["size"] != -0.1806419 {
+		t.Errorf("Output of version 1 is incorrect, expected %f got %f", -0.1806419, outputs["size"])
+	}
+	resp, err = http.Post(ts.URL+base+"/v1/nets/"+id+"/versions/9/evaluate", "application/json", bytes.NewBuffer(raw))
+	if err != nil {
+		t.Fatalf("A POST to the version evaluate endpoint returned an error (%s)", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 when evaluating a missing version, got %s", resp.Status)
+	}
+
+	// Roll back to the first version
+	resp, err = http.Post(ts.URL+base+"/v1/nets/"+id+"/versions/1/rollback", "application/json", nil)
+	if err != nil {
+		t.Fatalf("A valid POST to the rollback endpoint returned an error (%s)", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("A valid POST to the rollback endpoint returned an unexpected status code (%s)", resp.Status)
+	}
+	var current paramstores.MLPParams
+	api.NPS.Load(id, &current)
+	if current.String() != first.String() {
+		t.Errorf("Expected the net to be rolled back to %s, got %s", first.String(), current.String())
+	}
+}
@@ -2,17 +2,23 @@ package main
 
 import (
 	"context"
+	"errors"
+	"math/rand"
 	"net/http"
 	"os"
+	"syscall"
 	"time"
 
 	"github.com/oklog/run"
 	"github.com/qvantel/nerd/api"
-	"github.com/qvantel/nerd/api/types"
 	"github.com/qvantel/nerd/internal/config"
 	"github.com/qvantel/nerd/internal/logger"
 	"github.com/qvantel/nerd/internal/nets"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/nets/queues"
+	"github.com/qvantel/nerd/internal/resilience"
 	"github.com/qvantel/nerd/internal/series"
+	"github.com/qvantel/nerd/internal/series/pointstores"
 	"github.com/segmentio/kafka-go"
 )
 
@@ -27,12 +33,36 @@ func main() {
 		logger.Error("Error encountered while loading configuration", err)
 		os.Exit(1)
 	}
+	// The searches for the optimal nets draw from the default source, which is only seeded here so that they can be
+	// reproduced in tests
+	rand.Seed(time.Now().UnixNano())
 
 	var g run.Group
 
 	// Initialize training service
-	tServ := make(chan types.TrainRequest, 10)
-	g.Add(func() error { return nets.Trainer(tServ, *conf) }, func(error) { close(tServ) })
+	tServ, err := queues.New(*conf)
+	if err != nil {
+		logger.Error("Error encountered initializing training queue", err)
+		os.Exit(1)
+	}
+	breakers := resilience.NewBreakers(conf.ML)
+	jStore, err := paramstores.New(*conf)
+	if err != nil {
+		logger.Error("Error encountered initializing the job store", err)
+		os.Exit(1)
+	}
+	jobs := nets.NewRegistry(resilience.NetParamStore(jStore, breakers))
+	pool := nets.NewPool(conf.ML)
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error { return nets.Trainer(ctx, tServ, jobs, pool, breakers, *conf) }, func(error) { cancel() })
+
+	// Initialize retraining scheduler
+	sCtx, sCancel := context.WithCancel(context.Background())
+	g.Add(func() error { return nets.Scheduler(sCtx, tServ, jobs, breakers, *conf) }, func(error) { sCancel() })
+
+	// Initialize retention pruner
+	pCtx, pCancel := context.WithCancel(context.Background())
+	g.Add(func() error { return series.Pruner(pCtx, *conf) }, func(error) { pCancel() })
 
 	// Conditionally initialize consumer
 	if conf.Series.Source.Brokers != nil {
@@ -49,7 +79,7 @@ func main() {
 	}
 
 	// Initialize API
-	api, err := api.New(tServ, *conf)
+	api, err := api.New(tServ, jobs, pool, breakers, *conf)
 	if err != nil {
 		logger.Error("Error encountered initializing API", err)
 		os.Exit(1)
@@ -69,7 +99,21 @@ func main() {
 		}
 	})
 
+	// Stop gracefully on termination so that the memory stores can be snapshotted
+	g.Add(run.SignalHandler(context.Background(), syscall.SIGINT, syscall.SIGTERM))
+
 	err = g.Run()
+	if sErr := pointstores.SaveSnapshots(); sErr != nil {
+		logger.Error("Failed to save the snapshot of the point store", sErr)
+	}
+	if sErr := paramstores.SaveSnapshots(); sErr != nil {
+		logger.Error("Failed to save the snapshot of the net param store", sErr)
+	}
+	var sigErr run.SignalError
+	if errors.As(err, &sigErr) {
+		logger.Info("Received " + sigErr.Signal.String() + ", exiting")
+		return
+	}
 	if err != nil {
 		logger.Error("Critical error encountered, exiting", err)
 		os.Exit(1)
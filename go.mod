@@ -16,6 +16,7 @@ require (
 	github.com/oklog/run v1.1.0
 	github.com/segmentio/kafka-go v0.4.17
 	github.com/testcontainers/testcontainers-go v0.11.1
+	go.etcd.io/bbolt v1.3.6
 	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897 // indirect
 	golang.org/x/sys v0.0.0-20210324051608-47abb6519492 // indirect
 	google.golang.org/protobuf v1.25.0 // indirect
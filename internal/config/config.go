@@ -7,23 +7,48 @@ import (
 	"os"
 	"strconv"
 	"strings"
+
+	"github.com/qvantel/nerd/api/types"
 )
 
 // Supported network parameter store types
 const (
-	FileParamStore  = "file"
-	RedisParamStore = "redis"
+	FileParamStore   = "file"
+	MemoryParamStore = "memory"
+	RedisParamStore  = "redis"
 )
 
-var paramStoreTypes = []string{FileParamStore, RedisParamStore}
+var paramStoreTypes = []string{FileParamStore, MemoryParamStore, RedisParamStore}
 
 // Supported series store types
 const (
 	FileSeriesStore          = FileParamStore
+	MemorySeriesStore        = MemoryParamStore
+	BoltSeriesStore          = "bolt"
 	ElasticsearchSeriesStore = "elasticsearch"
 )
 
-var seriesStoreTypes = []string{FileSeriesStore, ElasticsearchSeriesStore}
+var seriesStoreTypes = []string{FileSeriesStore, MemorySeriesStore, BoltSeriesStore, ElasticsearchSeriesStore}
+
+// Supported training queue types
+const (
+	FileQueue  = FileParamStore
+	RedisQueue = RedisParamStore
+)
+
+var queueTypes = []string{FileQueue, RedisQueue}
+
+// Supported genetic algorithm operators
+const (
+	RouletteSelection   = "roulette"
+	TournamentSelection = "tournament"
+
+	PointCrossover   = "n-point"
+	UniformCrossover = "uniform"
+)
+
+var selectionTypes = []string{RouletteSelection, TournamentSelection}
+var crossoverTypes = []string{PointCrossover, UniformCrossover}
 
 // Kafka holds the necessary configuration to set up the connection to a Kafka cluster
 type Kafka struct {
@@ -41,55 +66,164 @@ type LoggerParams struct {
 
 // MLParams holds the parameters that determine how the ML package will behave and how it will store its data
 type MLParams struct {
-	Generations int // Number of cycles to run the genetic algorithm for in search of the optimal net params
+	Attempts    int     // Number of times a training request is processed before it's moved to the dead-letter list
+	Backoff     int     // Seconds to wait before the first retry of a failed training request (doubled after each one)
+	BreakerCool int     // Seconds a store is given to recover after its circuit breaker opens
+	BreakerMax  int     // Number of consecutive failed calls to a store after which its circuit breaker opens
+	Budget      int     // Maximum number of seconds a training request can take (0 means no limit)
+	CPUBudget   int     // Maximum number of requests trained at the same time (0 means one per CPU)
+	Crossover   string  // How the genes of the parents are combined in the genetic algorithm
+	Elitism     int     // Number of fittest individuals that are carried over unchanged to the next generation
+	Generations int     // Number of cycles to run the genetic algorithm for in search of the optimal net params
+	Holdout     float32 // Fraction of the most recent points used for comparing a retrained net with the current one
+	Lease       int     // Seconds a training group stays locked by an instance that stops renewing its lease
+	Margin      float32 // Minimum accuracy improvement for a retrained net to replace the current one
 	MaxEpoch    int
-	MaxHLayers  int // Maximum starting number of hidden layers (the genetic algorithm can surpass it)
-	MinHLayers  int // Minimum starting number of hidden layers (the genetic algorithm can go down to 1)
+	MaxMemory   int     // Megabytes in use above which no new training requests are started (0 means no limit)
+	MaxHLayers  int     // Maximum starting number of hidden layers (the genetic algorithm can surpass it)
+	MinHLayers  int     // Minimum starting number of hidden layers (the genetic algorithm can go down to 1)
+	Mutation    float32 // Probability of each gene being mutated in the offspring of the genetic algorithm
+	QueueType   string
+	QueueParams map[string]interface{}
+	Selection   string // How the parents are picked in each generation of the genetic algorithm
 	StoreType   string
 	StoreParams map[string]interface{}
+	StoreRetry  int    // Number of times a call to a store is attempted before giving up
+	StoreWait   int    // Milliseconds to wait before retrying a failed call to a store (doubled after each one)
+	Strategy    string // Default hyperparameter search strategy
 	TestSet     float32
 	Tolerance   float32
+	Tournament  int // Number of individuals competing in each tournament when using tournament selection
+	Trials      int // Number of network configs to evaluate when using the random or bayesian search strategies
 	Variations  int // Number of different network configs to evaluate in each generation of the genetic algorithm
+	Versions    int // Number of previous versions of each net that are kept (0 means all of them)
+	Visibility  int // Seconds after which a training request that is being processed is delivered again if not renewed
+	Workers     int // Number of training workers
 }
 
 // Check will return an error if any of the machine learning params have semantically incorrect values
 func (mlParams *MLParams) Check() error {
+	if mlParams.Attempts < 1 {
+		return errors.New("training requests must be attempted at least once")
+	}
+	if mlParams.Backoff < 0 {
+		return errors.New("the retry backoff can't be negative")
+	}
+	if mlParams.BreakerCool < 1 {
+		return errors.New("the circuit breaker cooldown must be at least 1 second")
+	}
+	if mlParams.BreakerMax < 1 {
+		return errors.New("circuit breakers need a threshold of at least 1 failure")
+	}
+	if mlParams.Budget < 0 {
+		return errors.New("the training budget can't be negative")
+	}
+	if mlParams.CPUBudget < 0 {
+		return errors.New("the CPU budget can't be negative")
+	}
+	if !Present(crossoverTypes, mlParams.Crossover) {
+		return errors.New(mlParams.Crossover + " is not a valid crossover type")
+	}
+	if mlParams.Elitism < 0 || mlParams.Elitism >= mlParams.Variations {
+		return errors.New("elitism must be between 0 (included) and the number of variations (not included)")
+	}
 	if mlParams.Generations < 1 {
 		return errors.New("at least one generation is needed to allow for networks to be trained")
 	}
+	if mlParams.Holdout < 0 || mlParams.Holdout >= 1 {
+		return errors.New("holdout must be between 0 (included) and 1 (not included)")
+	}
+	if mlParams.Lease < 1 {
+		return errors.New("training leases must last at least 1 second")
+	}
+	if mlParams.Margin < -1 || mlParams.Margin > 1 {
+		return errors.New("promotion margin must be between -1 and 1")
+	}
 	if mlParams.MaxEpoch < 1 {
 		return errors.New("a max epoch bellow 1 would mean that networks wouldn't be trained at all")
 	}
+	if mlParams.MaxMemory < 0 {
+		return errors.New("the memory budget can't be negative")
+	}
 	if mlParams.MinHLayers < 1 {
 		return errors.New("there should be at least 1 hidden layer in all nets")
 	}
 	if mlParams.MaxHLayers < mlParams.MinHLayers {
 		return errors.New("the maximum number of hidden layers must be equal or greater than the minimum")
 	}
+	if mlParams.Mutation < 0 || mlParams.Mutation > 1 {
+		return errors.New("mutation probability must be between 0 and 1")
+	}
+	if !Present(queueTypes, mlParams.QueueType) {
+		return errors.New(mlParams.QueueType + " is not a valid training queue type")
+	}
+	if !Present(selectionTypes, mlParams.Selection) {
+		return errors.New(mlParams.Selection + " is not a valid selection type")
+	}
 	if !Present(paramStoreTypes, mlParams.StoreType) {
 		return errors.New(mlParams.StoreType + " is not a valid param store type")
 	}
+	if mlParams.StoreRetry < 1 {
+		return errors.New("store calls must be attempted at least once")
+	}
+	if mlParams.StoreWait < 0 {
+		return errors.New("the store retry backoff can't be negative")
+	}
+	if !Present(types.Strategies(), mlParams.Strategy) {
+		return errors.New(mlParams.Strategy + " is not a valid search strategy")
+	}
 	if mlParams.TestSet < 0 || mlParams.TestSet >= 1 {
 		return errors.New("test set must be between 0 (included) and 1 (not included)")
 	}
+	if mlParams.Selection == TournamentSelection && (mlParams.Tournament < 2 || mlParams.Tournament > mlParams.Variations) {
+		return errors.New("tournament size must be between 2 and the number of variations (both included)")
+	}
+	if mlParams.Trials < 1 {
+		return errors.New("at least one trial is needed to allow for networks to be trained")
+	}
 	if mlParams.Variations < 4 {
 		return errors.New("at least four variations are needed")
 	}
+	if mlParams.Versions < 0 {
+		return errors.New("the number of versions to keep can't be negative")
+	}
+	if mlParams.Visibility < 1 {
+		return errors.New("the visibility timeout of the training queue must be at least 1 second")
+	}
+	if mlParams.Workers < 1 {
+		return errors.New("at least one training worker is needed")
+	}
 	return nil
 }
 
 // SeriesParams holds the parameters that determine how the series package will behave and how it will store its data
 type SeriesParams struct {
-	FailLimit   int
-	Source      Kafka
-	StoreType   string
-	StoreParams map[string]interface{}
-	StorePass   string
-	StoreUser   string
+	DriftCooldown  int     // Minimum number of seconds between two retraining requests caused by the drift of a net
+	DriftThreshold float32 // Population stability index above which a net is retrained (0 disables drift detection)
+	DriftWindow    int     // Number of new points after which drift is checked, using those same points
+	FailLimit      int
+	Retention      int // Days that the points of new series are kept for by default (0 keeps them forever)
+	Source         Kafka
+	StoreType      string
+	StoreParams    map[string]interface{}
+	StorePass      string
+	StoreUser      string
 }
 
 // Check will return an error if any of the series params have semantically incorrect values
 func (seriesParams *SeriesParams) Check() error {
+	if seriesParams.DriftCooldown < 0 {
+		return errors.New("the drift cooldown can't be negative")
+	}
+	if seriesParams.DriftThreshold < 0 {
+		return errors.New("the drift threshold can't be negative")
+	}
+	if seriesParams.DriftThreshold > 0 && seriesParams.DriftWindow < 10 {
+		return errors.New("at least 10 points are needed to check for drift")
+	}
+	if seriesParams.Retention < 0 {
+		return errors.New("the retention of the series can't be negative")
+	}
 	if !Present(seriesStoreTypes, seriesParams.StoreType) {
 		return errors.New(seriesParams.StoreType + " is not a valid point store type")
 	}
@@ -148,10 +282,53 @@ func Getenv(env, fallback string) string {
 
 // loadMLParams parses the part of the config that determines the behavior of the machine learning logic
 func loadMLParams(conf *Config) (err error) {
+	conf.ML.Attempts, err = strconv.Atoi(Getenv("ML_QUEUE_ATTEMPTS", "3"))
+	if err != nil {
+		return err
+	}
+	conf.ML.Backoff, err = strconv.Atoi(Getenv("ML_QUEUE_BACKOFF", "30"))
+	if err != nil {
+		return err
+	}
+	conf.ML.BreakerCool, err = strconv.Atoi(Getenv("ML_BREAKER_COOLDOWN", "30"))
+	if err != nil {
+		return err
+	}
+	conf.ML.BreakerMax, err = strconv.Atoi(Getenv("ML_BREAKER_THRESHOLD", "5"))
+	if err != nil {
+		return err
+	}
+	conf.ML.Budget, err = strconv.Atoi(Getenv("ML_TRAIN_BUDGET", "0"))
+	if err != nil {
+		return err
+	}
+	conf.ML.CPUBudget, err = strconv.Atoi(Getenv("ML_CPU_BUDGET", "0"))
+	if err != nil {
+		return err
+	}
+	conf.ML.Crossover = Getenv("ML_GA_CROSSOVER", UniformCrossover)
+	conf.ML.Elitism, err = strconv.Atoi(Getenv("ML_GA_ELITISM", "1"))
+	if err != nil {
+		return err
+	}
 	conf.ML.Generations, err = strconv.Atoi(Getenv("ML_GENS", "5"))
 	if err != nil {
 		return err
 	}
+	holdout, err := strconv.ParseFloat(Getenv("ML_HOLDOUT", "0.2"), 32)
+	if err != nil {
+		return err
+	}
+	conf.ML.Holdout = float32(holdout)
+	conf.ML.Lease, err = strconv.Atoi(Getenv("ML_LEASE_TTL", "60"))
+	if err != nil {
+		return err
+	}
+	margin, err := strconv.ParseFloat(Getenv("ML_PROMOTION_MARGIN", "0"), 32)
+	if err != nil {
+		return err
+	}
+	conf.ML.Margin = float32(margin)
 	conf.ML.MinHLayers, err = strconv.Atoi(Getenv("ML_MIN_HLAYERS", "1"))
 	if err != nil {
 		return err
@@ -164,6 +341,16 @@ func loadMLParams(conf *Config) (err error) {
 	if err != nil {
 		return err
 	}
+	conf.ML.MaxMemory, err = strconv.Atoi(Getenv("ML_MEMORY_BUDGET", "0"))
+	if err != nil {
+		return err
+	}
+	mutation, err := strconv.ParseFloat(Getenv("ML_GA_MUTATION", "0.2"), 32)
+	if err != nil {
+		return err
+	}
+	conf.ML.Mutation = float32(mutation)
+	conf.ML.Selection = Getenv("ML_GA_SELECTION", TournamentSelection)
 	conf.ML.StoreType = Getenv("ML_STORE_TYPE", FileParamStore)
 	defNPSParams := `{"Path": "."}`
 	redis := os.Getenv("SD_REDIS")
@@ -174,6 +361,30 @@ func loadMLParams(conf *Config) (err error) {
 	if err != nil {
 		return err
 	}
+	// The queue only defaults to Redis when SD_REDIS is set and, unless it's given its own params, it only uses the
+	// replica in SD_REDIS if it's the Redis one (the params of each type aren't interchangeable)
+	defQueueType := FileQueue
+	if redis != "" {
+		defQueueType = RedisQueue
+	}
+	conf.ML.QueueType = Getenv("ML_QUEUE_TYPE", defQueueType)
+	defQueueParams := `{"Path": "."}`
+	if conf.ML.QueueType == RedisQueue && redis != "" {
+		defQueueParams = `{"URL": "` + redis + `"}`
+	}
+	err = json.Unmarshal([]byte(Getenv("ML_QUEUE_PARAMS", defQueueParams)), &conf.ML.QueueParams)
+	if err != nil {
+		return err
+	}
+	conf.ML.StoreRetry, err = strconv.Atoi(Getenv("ML_STORE_RETRIES", "3"))
+	if err != nil {
+		return err
+	}
+	conf.ML.StoreWait, err = strconv.Atoi(Getenv("ML_STORE_BACKOFF", "200"))
+	if err != nil {
+		return err
+	}
+	conf.ML.Strategy = Getenv("ML_STRATEGY", types.Genetic)
 	ts, err := strconv.ParseFloat(Getenv("ML_TEST_SET", "0.4"), 32)
 	if err != nil {
 		return err
@@ -184,19 +395,56 @@ func loadMLParams(conf *Config) (err error) {
 		return err
 	}
 	conf.ML.Tolerance = float32(tolerance)
+	conf.ML.Tournament, err = strconv.Atoi(Getenv("ML_GA_TOURNAMENT", "3"))
+	if err != nil {
+		return err
+	}
+	conf.ML.Trials, err = strconv.Atoi(Getenv("ML_TRIALS", "16"))
+	if err != nil {
+		return err
+	}
 	conf.ML.Variations, err = strconv.Atoi(Getenv("ML_VARS", "6"))
 	if err != nil {
 		return err
 	}
+	conf.ML.Versions, err = strconv.Atoi(Getenv("ML_VERSIONS", "5"))
+	if err != nil {
+		return err
+	}
+	conf.ML.Visibility, err = strconv.Atoi(Getenv("ML_QUEUE_VISIBILITY", "120"))
+	if err != nil {
+		return err
+	}
+	conf.ML.Workers, err = strconv.Atoi(Getenv("ML_WORKERS", "2"))
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
 // loadSeriesParams parses the part of the config that determines the behavior of the series logic
 func loadSeriesParams(conf *Config) (err error) {
+	conf.Series.DriftCooldown, err = strconv.Atoi(Getenv("SERIES_DRIFT_COOLDOWN", "3600"))
+	if err != nil {
+		return err
+	}
+	threshold, err := strconv.ParseFloat(Getenv("SERIES_DRIFT_THRESHOLD", "0"), 32)
+	if err != nil {
+		return err
+	}
+	conf.Series.DriftThreshold = float32(threshold)
+	conf.Series.DriftWindow, err = strconv.Atoi(Getenv("SERIES_DRIFT_WINDOW", "100"))
+	if err != nil {
+		return err
+	}
 	conf.Series.FailLimit, err = strconv.Atoi(Getenv("SERIES_FAIL_LIMIT", "5"))
 	if err != nil {
 		return err
 	}
+	conf.Series.Retention, err = strconv.Atoi(Getenv("SERIES_RETENTION_DAYS", "90"))
+	if err != nil {
+		return err
+	}
 	brokers := os.Getenv("SD_KAFKA")
 	if brokers != "" {
 		conf.Series.Source = Kafka{
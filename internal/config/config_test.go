@@ -2,6 +2,8 @@ package config
 
 import (
 	"testing"
+
+	"github.com/qvantel/nerd/api/types"
 )
 
 func TestGetenv(t *testing.T) {
@@ -13,22 +15,91 @@ func TestGetenv(t *testing.T) {
 
 func TestMLParamsCheck(t *testing.T) {
 	valid := MLParams{
+		Attempts:    3,
+		Backoff:     30,
+		BreakerCool: 30,
+		BreakerMax:  5,
+		Budget:      0,
+		CPUBudget:   0,
+		Crossover:   UniformCrossover,
+		Elitism:     1,
 		Generations: 5,
+		Holdout:     0.2,
+		Lease:       60,
+		Margin:      0,
 		MaxEpoch:    1000,
+		MaxMemory:   0,
 		MaxHLayers:  5,
 		MinHLayers:  1,
+		Mutation:    0.2,
+		QueueType:   FileQueue,
+		QueueParams: map[string]interface{}{"Path": "."},
+		Selection:   TournamentSelection,
 		StoreType:   FileParamStore,
 		StoreParams: map[string]interface{}{"Path": "."},
+		StoreRetry:  3,
+		StoreWait:   200,
+		Strategy:    types.Genetic,
 		TestSet:     0.4,
 		Tolerance:   0.1,
+		Tournament:  3,
+		Trials:      16,
 		Variations:  6,
+		Versions:    5,
+		Visibility:  120,
+		Workers:     2,
 	}
-	gens, maxE, maxL, minL, storeT, testS, vars := valid, valid, valid, valid, valid, valid, valid
+	att, back, cool, bMax, budget, cpu, cross, elit, gens, hold, lease, marg, maxE, maxM, maxL, minL, mut, queueT, sel, storeT, retry, wait, strat, testS, tour, trials, vars, vers, vis, work := valid,
+		valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid, valid
 
 	err := valid.Check()
 	if err != nil {
 		t.Errorf("MLParams check returned an error for valid params (%s)", err.Error())
 	}
+	att.Attempts = 0
+	if att.Check() == nil {
+		t.Error("A number of attempts lower than 1 didn't return an error when checked")
+	}
+	back.Backoff = -1
+	if back.Check() == nil {
+		t.Error("A negative retry backoff didn't return an error when checked")
+	}
+	cool.BreakerCool = 0
+	if cool.Check() == nil {
+		t.Error("A circuit breaker cooldown lower than 1 second didn't return an error when checked")
+	}
+	bMax.BreakerMax = 0
+	if bMax.Check() == nil {
+		t.Error("A circuit breaker threshold lower than 1 didn't return an error when checked")
+	}
+	budget.Budget = -1
+	if budget.Check() == nil {
+		t.Error("A negative training budget didn't return an error when checked")
+	}
+	cpu.CPUBudget = -1
+	if cpu.Check() == nil {
+		t.Error("A negative CPU budget didn't return an error when checked")
+	}
+	hold.Holdout = 1
+	if hold.Check() == nil {
+		t.Error("A holdout of 1 didn't return an error when checked")
+	}
+	lease.Lease = 0
+	if lease.Check() == nil {
+		t.Error("A lease shorter than 1 second didn't return an error when checked")
+	}
+	marg.Margin = 1.5
+	if marg.Check() == nil {
+		t.Error("A promotion margin greater than 1 didn't return an error when checked")
+	}
+	cross.Crossover = "invalid-crossover"
+	if cross.Check() == nil {
+		t.Error("An invalid crossover type didn't return an error when checked")
+	}
+	elit.Elitism = elit.Variations
+	if elit.Check() == nil {
+		t.Error("An elitism value equal to the number of variations didn't return an error when checked")
+	}
 	gens.Generations = 0
 	if gens.Check() == nil {
 		t.Error("A generations value of less than 1 didn't return an error when checked")
@@ -37,6 +108,10 @@ func TestMLParamsCheck(t *testing.T) {
 	if maxE.Check() == nil {
 		t.Error("A max epoch of 0 didn't return an error when checked")
 	}
+	maxM.MaxMemory = -1
+	if maxM.Check() == nil {
+		t.Error("A negative memory budget didn't return an error when checked")
+	}
 	maxL.MaxHLayers = 0
 	if maxL.Check() == nil {
 		t.Error("A max hidden layers value lower than the min didn't return an error when checked")
@@ -45,10 +120,34 @@ func TestMLParamsCheck(t *testing.T) {
 	if minL.Check() == nil {
 		t.Error("A min hidden layers value lower than 1 didn't return an error when checked")
 	}
+	mut.Mutation = 1.5
+	if mut.Check() == nil {
+		t.Error("A mutation probability greater than 1 didn't return an error when checked")
+	}
+	queueT.QueueType = "invalid-type"
+	if queueT.Check() == nil {
+		t.Error("An invalid training queue type didn't return an error when checked")
+	}
+	sel.Selection = "invalid-selection"
+	if sel.Check() == nil {
+		t.Error("An invalid selection type didn't return an error when checked")
+	}
 	storeT.StoreType = "invalid-type"
 	if storeT.Check() == nil {
 		t.Error("An invalid param store type didn't return an error when checked")
 	}
+	retry.StoreRetry = 0
+	if retry.Check() == nil {
+		t.Error("A number of store attempts lower than 1 didn't return an error when checked")
+	}
+	wait.StoreWait = -1
+	if wait.Check() == nil {
+		t.Error("A negative store retry backoff didn't return an error when checked")
+	}
+	strat.Strategy = "invalid-strategy"
+	if strat.Check() == nil {
+		t.Error("An invalid search strategy didn't return an error when checked")
+	}
 	testS.TestSet = -1
 	if testS.Check() == nil {
 		t.Error("A negative test set didn't return an error when checked")
@@ -57,15 +156,39 @@ func TestMLParamsCheck(t *testing.T) {
 	if testS.Check() == nil {
 		t.Error("A test set of 1 (no patterns left for training) didn't return an error when checked")
 	}
+	tour.Tournament = 1
+	if tour.Check() == nil {
+		t.Error("A tournament size lower than 2 didn't return an error when checked")
+	}
+	trials.Trials = 0
+	if trials.Check() == nil {
+		t.Error("A trials value lower than 1 didn't return an error when checked")
+	}
 	vars.Variations = 3
 	if vars.Check() == nil {
 		t.Error("A variations value lower than 4 didn't return an error when checked")
 	}
+	vers.Versions = -1
+	if vers.Check() == nil {
+		t.Error("A negative number of versions didn't return an error when checked")
+	}
+	vis.Visibility = 0
+	if vis.Check() == nil {
+		t.Error("A visibility timeout lower than 1 second didn't return an error when checked")
+	}
+	work.Workers = 0
+	if work.Check() == nil {
+		t.Error("A number of workers lower than 1 didn't return an error when checked")
+	}
 }
 
 func TestSeriesParamsCheck(t *testing.T) {
 	valid := SeriesParams{
-		FailLimit: 5,
+		DriftCooldown:  3600,
+		DriftThreshold: 0.2,
+		DriftWindow:    100,
+		FailLimit:      5,
+		Retention:      90,
 		Source: Kafka{
 			Brokers: []string{"localhost:9092"},
 			GroupID: "nerd",
@@ -74,12 +197,36 @@ func TestSeriesParamsCheck(t *testing.T) {
 		StoreType:   FileSeriesStore,
 		StoreParams: map[string]interface{}{"Path": "."},
 	}
+	cooldown := valid
+	threshold := valid
+	window := valid
+	retention := valid
 	storeT := valid
 
 	err := valid.Check()
 	if err != nil {
 		t.Errorf("SeriesParams check returned an error for valid params (%s)", err.Error())
 	}
+	cooldown.DriftCooldown = -1
+	if cooldown.Check() == nil {
+		t.Error("A negative drift cooldown didn't return an error when checked")
+	}
+	threshold.DriftThreshold = -0.1
+	if threshold.Check() == nil {
+		t.Error("A negative drift threshold didn't return an error when checked")
+	}
+	window.DriftWindow = 9
+	if window.Check() == nil {
+		t.Error("A drift window smaller than 10 didn't return an error when checked")
+	}
+	window.DriftThreshold = 0
+	if window.Check() != nil {
+		t.Error("The drift window shouldn't be checked when drift detection is disabled")
+	}
+	retention.Retention = -1
+	if retention.Check() == nil {
+		t.Error("A negative retention didn't return an error when checked")
+	}
 	storeT.StoreType = "invalid-type"
 	if storeT.Check() == nil {
 		t.Error("An invalid point store type didn't return an error when checked")
@@ -92,3 +239,25 @@ func TestNew(t *testing.T) {
 		t.Fatalf("Failed to get config (%s)", err.Error())
 	}
 }
+
+func TestNewWithRedis(t *testing.T) {
+	t.Setenv("SD_REDIS", "localhost:6379")
+	t.Setenv("ML_STORE_TYPE", RedisParamStore)
+	conf, err := New()
+	if err != nil {
+		t.Fatalf("Failed to get config (%s)", err.Error())
+	}
+	if conf.ML.QueueType != RedisQueue || conf.ML.QueueParams["URL"] != "localhost:6379" {
+		t.Errorf("Expected the queue to default to the Redis replica in SD_REDIS, got %s with %v", conf.ML.QueueType, conf.ML.QueueParams)
+	}
+
+	// The file queue must still get a path even though SD_REDIS is set
+	t.Setenv("ML_QUEUE_TYPE", FileQueue)
+	conf, err = New()
+	if err != nil {
+		t.Fatalf("Failed to get config (%s)", err.Error())
+	}
+	if conf.ML.QueueParams["Path"] != "." {
+		t.Errorf("Expected the file queue to default to the current directory, got %v", conf.ML.QueueParams)
+	}
+}
@@ -0,0 +1,112 @@
+package nets
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"sort"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+)
+
+// AliasRecord is the kind of auxiliary record that holds each alias, which keeps them out of the list of nets and lets
+// them be updated independently of each other
+const AliasRecord = "alias"
+
+// aliasFormat is what alias names must look like, slashes can be used for namespacing (like banknote/class/prod)
+var aliasFormat = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._\-/]*$`)
+
+// aliasID returns the key under which the given alias is kept, escaping the slashes so that it's a valid file name
+func aliasID(name string) string {
+	return paramstores.AuxID(url.PathEscape(name), AliasRecord)
+}
+
+// Aliases returns every alias in the store sorted by name
+func Aliases(nps paramstores.NetParamStore) ([]types.Alias, error) {
+	names, err := nps.ListAux(AliasRecord)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]types.Alias, 0, len(names))
+	for _, escaped := range names {
+		name, err := url.PathUnescape(escaped)
+		if err != nil {
+			return nil, err
+		}
+		id, err := Resolve(name, nps)
+		if err != nil {
+			return nil, err
+		}
+		if id != "" { // It could have been deleted in the meantime
+			res = append(res, types.Alias{Name: name, NetID: id})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// Resolve returns the ID of the net an alias points to or an empty string if the alias doesn't exist
+func Resolve(name string, nps paramstores.NetParamStore) (string, error) {
+	var id string
+	_, err := nps.Load(aliasID(name), paramstores.JSON{Value: &id})
+	return id, err
+}
+
+// SetAlias creates or updates an alias so that it points to the given net
+func SetAlias(name, id string, nps paramstores.NetParamStore) error {
+	if !aliasFormat.MatchString(name) {
+		return errors.New("alias names must start with a letter or a digit and can only contain letters, digits, dots, dashes, underscores and slashes")
+	}
+	return nps.Save(aliasID(name), paramstores.JSON{Value: id})
+}
+
+// DeleteAlias removes an alias, returns false if it didn't exist
+func DeleteAlias(name string, nps paramstores.NetParamStore) (bool, error) {
+	id, err := Resolve(name, nps)
+	if err != nil || id == "" {
+		return false, err
+	}
+	return true, nps.Delete(aliasID(name))
+}
+
+// deleteNetAliases removes every alias that points to the given net
+func deleteNetAliases(id string, nps paramstores.NetParamStore) error {
+	aliases, err := Aliases(nps)
+	if err != nil {
+		return err
+	}
+	for _, alias := range aliases {
+		if alias.NetID == id {
+			err = nps.Delete(aliasID(alias.Name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Best returns the most accurate net trained with the given series that produces the given output, or nil if there
+// are none
+func Best(seriesID, output string, nps paramstores.NetParamStore) (*types.BriefNet, error) {
+	var best *types.BriefNet
+	cursor := 0
+	for {
+		list, next, err := List(cursor, 50, SeriesPattern(seriesID), nps)
+		if err != nil {
+			return nil, err
+		}
+		for i := range list {
+			for _, label := range list[i].Outputs {
+				if label == output && (best == nil || list[i].Accuracy > best.Accuracy) {
+					best = &list[i]
+				}
+			}
+		}
+		if next == 0 {
+			return best, nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,157 @@
+package nets
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+const (
+	tpeCandidates = 24   // Number of configurations sampled from the good density before picking the next trial
+	tpeGamma      = 0.25 // Fraction of the trials that are considered good
+	tpeMinRate    = 0.001
+	tpeMaxRate    = 0.999
+)
+
+// TPE is a bayesian optimization search strategy based on the Tree-structured Parzen Estimator. After a few random
+// trials, it splits the evaluated configurations into good and bad ones and picks the candidate that maximizes the
+// ratio between the density of the good ones and that of the bad ones
+type TPE struct {
+	trialRecorder
+	params config.MLParams
+}
+
+// Search evaluates as many configurations as trials are configured and returns the best net. Configurations that fail
+// to train are recorded but left out of the densities (configurations are drawn at random until enough have been
+// trained), only if all of them fail is the last error returned
+func (tpe *TPE) Search(ctx context.Context, tr types.TrainRequest, outputs []string, points []pointstores.Point) (Network, []types.Trial, error) {
+	startup := tpe.params.Trials / 4
+	if startup < 4 {
+		startup = 4
+	}
+	observed := []Chromosome{}
+	var err error
+	for i := 0; i < tpe.params.Trials; i++ {
+		var c Chromosome
+		if len(observed) < startup {
+			c = randomChromosome(tpe.params)
+		} else {
+			c = tpe.suggest(observed)
+		}
+		err = tpe.check(ctx, &c, tr, outputs, points, tpe.params)
+		if stopped(err) {
+			return interrupted(fittest(observed), tpe.trials, err)
+		}
+		if err == nil {
+			observed = append(observed, c)
+		}
+	}
+	return searched(fittest(observed), tpe.trials, err)
+}
+
+// fittest returns the chromosome with the highest fitness or nil if there are none
+func fittest(chromosomes []Chromosome) *Chromosome {
+	var best *Chromosome
+	for i := range chromosomes {
+		if best == nil || chromosomes[i].Fitness > best.Fitness {
+			best = &chromosomes[i]
+		}
+	}
+	return best
+}
+
+// suggest proposes the next configuration to evaluate given the ones that have been evaluated so far
+func (tpe *TPE) suggest(observed []Chromosome) Chromosome {
+	sorted := make([]Chromosome, len(observed))
+	copy(sorted, observed)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Fitness > sorted[j].Fitness })
+	nGood := int(math.Ceil(tpeGamma * float64(len(sorted))))
+	good, bad := sorted[:nGood], sorted[nGood:]
+
+	var best Chromosome
+	bestScore := math.Inf(-1)
+	for i := 0; i < tpeCandidates; i++ {
+		c := tpe.sample(good)
+		score := math.Log(density(c, good)) - math.Log(density(c, bad))
+		if score > bestScore {
+			best, bestScore = c, score
+		}
+	}
+	return best
+}
+
+// sample draws a configuration from the density around the given chromosomes
+func (tpe *TPE) sample(around []Chromosome) Chromosome {
+	c := around[rand.Intn(len(around))]
+	c.Net = nil
+	c.Fitness = -1
+	if rand.Float64() < 0.2 {
+		c.ActivationFunc = randomString(types.ActivationFuncs())
+	}
+	if rand.Float64() < 0.2 {
+		c.Type = randomString(types.Nets())
+	}
+	c.HLayers += rand.Intn(3) - 1
+	if c.HLayers < tpe.params.MinHLayers {
+		c.HLayers = tpe.params.MinHLayers
+	}
+	if c.HLayers > tpe.params.MaxHLayers {
+		c.HLayers = tpe.params.MaxHLayers
+	}
+	lr := math.Log(float64(c.LearningRate)) + rand.NormFloat64()*bandwidth(around)
+	lr = math.Max(math.Log(tpeMinRate), math.Min(math.Log(tpeMaxRate), lr))
+	c.LearningRate = float32(math.Round(math.Exp(lr)*1000) / 1000)
+	if c.LearningRate < tpeMinRate {
+		c.LearningRate = tpeMinRate
+	}
+	return c
+}
+
+// bandwidth returns the width of the kernel used for the learning rate based on how spread out the given
+// configurations are (in log space)
+func bandwidth(chromosomes []Chromosome) float64 {
+	if len(chromosomes) < 2 {
+		return 1
+	}
+	logs := make([]float32, len(chromosomes))
+	for i := range chromosomes {
+		logs[i] = float32(math.Log(float64(chromosomes[i].LearningRate)))
+	}
+	_, dev := meanDev(logs)
+	return math.Max(0.1, float64(dev))
+}
+
+// density returns the Parzen estimate of the likelihood of a configuration given a set of chromosomes
+func density(c Chromosome, around []Chromosome) float64 {
+	if len(around) == 0 {
+		return 1
+	}
+	bw := bandwidth(around)
+	lr := math.Log(float64(c.LearningRate))
+	total := 0.0
+	for _, o := range around {
+		d := (lr - math.Log(float64(o.LearningRate))) / bw
+		k := math.Exp(-d*d/2) / (bw * math.Sqrt(2*math.Pi))
+		switch diff := c.HLayers - o.HLayers; {
+		case diff == 0:
+			k *= 0.5
+		case diff == 1 || diff == -1:
+			k *= 0.25
+		default:
+			k *= 0.01
+		}
+		if c.ActivationFunc != o.ActivationFunc {
+			k *= 0.2
+		}
+		if c.Type != o.Type {
+			k *= 0.2
+		}
+		total += k
+	}
+	return total/float64(len(around)) + 1e-12
+}
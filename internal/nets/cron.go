@@ -0,0 +1,146 @@
+package nets
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMacros are the shorthands accepted in place of a full cron expression
+var cronMacros = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// cronFields describe the range of values that each of the fields of a cron expression accepts
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of the month", 1, 31},
+	{"month", 1, 12},
+	{"day of the week", 0, 7}, // Both 0 and 7 are Sunday
+}
+
+// cronSchedule is a parsed cron expression, each field is a bit set of the values it matches
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	anyDOM, anyDOW                bool // Whether the day fields are unrestricted, which changes how they're combined
+}
+
+// parseCron reads a standard five field cron expression (minute hour day month weekday) supporting lists, ranges and
+// steps, or one of the @yearly, @monthly, @weekly, @daily and @hourly shorthands
+func parseCron(expr string) (cronSchedule, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(expr)]; ok {
+		expr = macro
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return cronSchedule{}, errors.New("cron expressions must have five fields (minute hour day month weekday)")
+	}
+	sets := make([]uint64, len(parts))
+	for i, part := range parts {
+		set, err := parseCronField(part, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return cronSchedule{}, errors.New("invalid " + cronFields[i].name + " field in cron expression (" + err.Error() + ")")
+		}
+		sets[i] = set
+	}
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return cronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		anyDOM: strings.HasPrefix(parts[2], "*"),
+		anyDOW: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+// parseCronField returns the set of values matched by a comma separated list of values, ranges (a-b) and steps (*/n
+// or a-b/n)
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step < 1 {
+				return 0, errors.New("steps must be positive numbers, got " + item[i+1:])
+			}
+			rng = item[:i]
+		}
+		from, to := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			var err1, err2 error
+			from, err1 = strconv.Atoi(bounds[0])
+			to, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, errors.New(rng + " is not a valid range")
+			}
+		default:
+			var err error
+			from, err = strconv.Atoi(rng)
+			if err != nil {
+				return 0, errors.New(rng + " is not a number")
+			}
+			to = from
+			if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, errors.New(rng + " is out of range (" + strconv.Itoa(min) + "-" + strconv.Itoa(max) + ")")
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// next returns the first time after the given one that matches the schedule, the zero time will be returned if there
+// isn't one within the next five years (like for the 30th of February)
+func (cs cronSchedule) next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case cs.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !cs.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case cs.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case cs.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay returns true if the day of the given time matches the schedule. Like in cron, when both day fields are
+// restricted a day matches if either of them does
+func (cs cronSchedule) matchesDay(t time.Time) bool {
+	dom := cs.dom&(1<<uint(t.Day())) != 0
+	dow := cs.dow&(1<<uint(t.Weekday())) != 0
+	if !cs.anyDOM && !cs.anyDOW {
+		return dom || dow
+	}
+	return dom && dow
+}
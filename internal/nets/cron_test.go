@@ -0,0 +1,46 @@
+package nets
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	invalid := []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"}
+	for _, expr := range invalid {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("Expected %q to be rejected", expr)
+		}
+	}
+	cs, err := parseCron("0,30 */6 1-10/3 * 7")
+	if err != nil {
+		t.Fatalf("Failed to parse cron expression (%s)", err.Error())
+	}
+	if cs.minute != 1|1<<30 || cs.hour != 1|1<<6|1<<12|1<<18 || cs.dom != 1<<1|1<<4|1<<7|1<<10 || cs.dow&1 == 0 {
+		t.Errorf("Unexpected sets for the parsed expression, got %+v", cs)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	start := time.Date(2021, time.February, 26, 13, 45, 30, 0, time.UTC) // A Friday
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"*/15 * * * *", time.Date(2021, time.February, 26, 14, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2021, time.February, 27, 0, 0, 0, 0, time.UTC)},
+		{"30 2 * * 1", time.Date(2021, time.March, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 15 * 0", time.Date(2021, time.February, 28, 12, 0, 0, 0, time.UTC)}, // Either day field can match
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		cs, err := parseCron(test.expr)
+		if err != nil {
+			t.Fatalf("Failed to parse cron expression %q (%s)", test.expr, err.Error())
+		}
+		if next := cs.next(start); !next.Equal(test.expected) {
+			t.Errorf("Expected %q to run next at %s, got %s", test.expr, test.expected, next)
+		}
+	}
+}
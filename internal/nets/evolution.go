@@ -1,10 +1,11 @@
 package nets
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
-	"time"
+	"sort"
 
 	"github.com/qvantel/nerd/api/types"
 	"github.com/qvantel/nerd/internal/config"
@@ -23,17 +24,17 @@ type Chromosome struct {
 }
 
 // Check trains a network with the chromosome's config and updates its fitness based on the accuracy
-func (c *Chromosome) Check(tr types.TrainRequest, outputs []string, points []pointstores.Point, params config.MLParams) error {
+func (c *Chromosome) Check(ctx context.Context, tr types.TrainRequest, outputs []string, points []pointstores.Point, params config.MLParams) error {
 	if c.Net != nil {
 		return nil
 	}
-	id := tr.SeriesID + "-" + hash(tr.Inputs) + "-" + hash(outputs) + "-" + c.Type
+	id := tr.SeriesID + "-" + inputsHash(tr.Inputs, tr.Selection) + "-" + hash(outputs) + "-" + c.Type
 	var err error
-	c.Net, err = NewNetwork(id, tr.Inputs, outputs, *c)
+	c.Net, err = NewNetwork(id, tr.Inputs, outputs, tr.Normalization, *c)
 	if err != nil {
 		return err
 	}
-	c.Fitness, err = c.Net.Train(points, params.MaxEpoch, tr.ErrMargin, params.TestSet, params.Tolerance)
+	c.Fitness, err = c.Net.Train(ctx, points, params.MaxEpoch, tr.ErrMargin, params.TestSet, params.Tolerance)
 	if err != nil {
 		return err
 	}
@@ -52,6 +53,31 @@ func (c Chromosome) Crossover(b Chromosome, n int) []Chromosome {
 	if n >= 2 {
 		c.ActivationFunc, b.ActivationFunc = b.ActivationFunc, c.ActivationFunc
 	}
+	if n >= 3 {
+		c.Type, b.Type = b.Type, c.Type
+	}
+	return []Chromosome{c, b}
+}
+
+// UniformCrossover exchanges each of the parameters between the chromosomes with a 50% chance to create two new
+// configurations
+func (c Chromosome) UniformCrossover(b Chromosome) []Chromosome {
+	// Clear the net pointers from the copies of c and b
+	c.Net, b.Net = nil, nil
+	c.Fitness, b.Fitness = -1, -1
+
+	if rand.Intn(2) == 0 {
+		c.ActivationFunc, b.ActivationFunc = b.ActivationFunc, c.ActivationFunc
+	}
+	if rand.Intn(2) == 0 {
+		c.HLayers, b.HLayers = b.HLayers, c.HLayers
+	}
+	if rand.Intn(2) == 0 {
+		c.LearningRate, b.LearningRate = b.LearningRate, c.LearningRate
+	}
+	if rand.Intn(2) == 0 {
+		c.Type, b.Type = b.Type, c.Type
+	}
 	return []Chromosome{c, b}
 }
 
@@ -67,7 +93,6 @@ func decimals(number float32) int {
 
 // Mutate randomly alters the given gene
 func (c *Chromosome) Mutate(gene int) {
-	rand.Seed(time.Now().UnixNano())
 	switch gene {
 	case 0:
 		c.ActivationFunc = randomString(types.ActivationFuncs())
@@ -84,6 +109,8 @@ func (c *Chromosome) Mutate(gene int) {
 		} else {
 			c.LearningRate += float32(rand.Intn(2)*2-1) / d
 		}
+	case 3:
+		c.Type = randomString(types.Nets())
 	default:
 		return
 	}
@@ -91,90 +118,88 @@ func (c *Chromosome) Mutate(gene int) {
 	c.Net = nil
 }
 
+// genes is the number of mutable parameters in a chromosome
+const genes = 4
+
 // Population represents a collection of individuals and their metadata
 type Population struct {
-	first       int
+	trialRecorder
+	best        Chromosome // Fittest individual seen so far, which might not survive without elitism
+	generations []float32  // Best fitness in each generation
 	individuals []Chromosome
-	last        int
 	params      config.MLParams
-	second      int
 }
 
 // NewPopulation creates a new set of individuals and initializes their metadata
 func NewPopulation(params config.MLParams) *Population {
-	pop := Population{
-		first:  -1,
-		last:   -1,
-		params: params,
-		second: -1,
-	}
+	pop := Population{best: Chromosome{Fitness: -1}, params: params}
 	pop.individuals = make([]Chromosome, params.Variations)
-	rand.Seed(time.Now().UnixNano())
 	for i := 0; i < params.Variations; i++ {
-		pop.individuals[i] = Chromosome{
-			ActivationFunc: randomString(types.ActivationFuncs()),
-			Fitness:        -1,
-			HLayers:        rand.Intn(params.MaxHLayers+1) + params.MinHLayers,
-			LearningRate:   float32(rand.Intn(999)+1) / 1000,
-			Type:           randomString(types.Nets()),
-		}
+		pop.individuals[i] = randomChromosome(params)
 	}
 	return &pop
 }
 
 // Optimal uses a genetic algorithm to find the config that results in the most accurate net for the given points and
-// returns that net
-func (pop *Population) Optimal(tr types.TrainRequest, outputs []string, points []pointstores.Point) (Network, error) {
+// returns that net. If the context is cancelled, the search will stop and the best net found so far will be returned
+func (pop *Population) Optimal(ctx context.Context, tr types.TrainRequest, outputs []string, points []pointstores.Point) (Network, error) {
 	for gen := 0; gen < pop.params.Generations; gen++ {
-		rand.Seed(time.Now().UnixNano())
+		report(ctx, func(p *types.Progress) {
+			p.Generation = gen + 1
+		})
 		// Calculate fitness for each individual
-		err := pop.rank(tr, outputs, points)
+		err := pop.rank(ctx, tr, outputs, points)
 		if err != nil {
-			return nil, err
+			return pop.interrupted(err)
 		}
+		pop.generations = append(pop.generations, pop.individuals[0].Fitness)
 		logger.Debug(fmt.Sprintf(
 			"Gen %d fitness: first = %f, second = %f, last = %f",
 			gen,
-			pop.individuals[pop.first].Fitness,
-			pop.individuals[pop.second].Fitness,
-			pop.individuals[pop.last].Fitness,
+			pop.individuals[0].Fitness,
+			pop.individuals[1].Fitness,
+			pop.individuals[len(pop.individuals)-1].Fitness,
 		))
 
-		// Cross fittest individuals
-		cp := rand.Intn(3)
-		offspring := pop.individuals[pop.first].Crossover(pop.individuals[pop.second], cp)
-
-		// Mutate offspring (20% chance)
-		mc := rand.Intn(5)
-		if mc == 0 {
-			gene := rand.Intn(3)
-			offspring[0].Mutate(gene)
-			gene = rand.Intn(3)
-			offspring[1].Mutate(gene)
-		}
+		// Carry over the fittest individuals (their nets included, so they won't have to be trained again)
+		next := make([]Chromosome, 0, len(pop.individuals))
+		next = append(next, pop.individuals[:pop.params.Elitism]...)
 
-		// Calculate offspring fitness
-		for index := range offspring {
-			err := offspring[index].Check(tr, outputs, points, pop.params)
-			if err != nil {
-				return nil, err
+		// Fill the rest of the next generation with the offspring of the selected parents
+		for len(next) < len(pop.individuals) {
+			a, b := pop.selectParent(), pop.selectParent()
+			var offspring []Chromosome
+			if pop.params.Crossover == config.PointCrossover {
+				offspring = a.Crossover(b, rand.Intn(genes))
+			} else {
+				offspring = a.UniformCrossover(b)
+			}
+			for index := range offspring {
+				for gene := 0; gene < genes; gene++ {
+					if rand.Float32() < pop.params.Mutation {
+						offspring[index].Mutate(gene)
+					}
+				}
+				if len(next) < len(pop.individuals) {
+					next = append(next, offspring[index])
+				}
 			}
 		}
-
-		// Replace least fit individual with fittest offspring
-		if offspring[0].Fitness > offspring[1].Fitness {
-			pop.individuals[pop.last] = offspring[0]
-		} else {
-			pop.individuals[pop.last] = offspring[1]
-		}
+		pop.individuals = next
 	}
 
-	// If the fittest offspring is better, return it instead
-	if pop.individuals[pop.last].Fitness > pop.individuals[pop.first].Fitness {
-		return pop.individuals[pop.last].Net, nil
+	err := pop.rank(ctx, tr, outputs, points)
+	if err != nil {
+		return pop.interrupted(err)
 	}
+	pop.generations = append(pop.generations, pop.individuals[0].Fitness)
+	return pop.annotate(pop.best.Net), nil
+}
 
-	return pop.individuals[pop.first].Net, nil
+// Search runs the genetic algorithm (see Optimal) and returns the resulting net along with the history of trials
+func (pop *Population) Search(ctx context.Context, tr types.TrainRequest, outputs []string, points []pointstores.Point) (Network, []types.Trial, error) {
+	net, err := pop.Optimal(ctx, tr, outputs, points)
+	return net, pop.trials, err
 }
 
 // randomString returns a randomly selected string from a slice
@@ -183,28 +208,65 @@ func randomString(options []string) string {
 	return options[i]
 }
 
-// rank traverses the population calculating the fitness of each individual and identifying the fittest, second fittest
-// and least fit
-func (pop *Population) rank(tr types.TrainRequest, outputs []string, points []pointstores.Point) error {
-	pop.first, pop.second, pop.last = -1, -1, -1
+// interrupted returns the best net found so far when the search was stopped because the context was cancelled
+func (pop *Population) interrupted(err error) (Network, error) {
+	if pop.best.Net != nil && stopped(err) {
+		return pop.annotate(pop.best.Net), nil
+	}
+	return nil, err
+}
+
+// annotate adds the best fitness of each generation to the history of the given net
+func (pop *Population) annotate(net Network) Network {
+	net.Params().TrainingHistory().Generations = append([]float32{}, pop.generations...)
+	return net
+}
+
+// rank calculates the fitness of each individual in the population and sorts them from fittest to least fit
+func (pop *Population) rank(ctx context.Context, tr types.TrainRequest, outputs []string, points []pointstores.Point) error {
 	for index := range pop.individuals {
-		err := pop.individuals[index].Check(tr, outputs, points, pop.params)
+		err := pop.check(ctx, &pop.individuals[index], tr, outputs, points, pop.params)
 		if err != nil {
 			return err
 		}
-		if pop.first == -1 || pop.individuals[index].Fitness > pop.individuals[pop.first].Fitness {
-			if pop.last == -1 {
-				pop.last = pop.second
-			}
-			pop.second, pop.first = pop.first, index
-		} else if pop.second == -1 || pop.individuals[index].Fitness > pop.individuals[pop.second].Fitness {
-			if pop.last == -1 {
-				pop.last = pop.second
-			}
-			pop.second = index
-		} else if pop.last == -1 || pop.individuals[index].Fitness < pop.individuals[pop.last].Fitness {
-			pop.last = index
+		if pop.best.Net == nil || pop.individuals[index].Fitness > pop.best.Fitness {
+			pop.best = pop.individuals[index]
 		}
 	}
+	sort.SliceStable(pop.individuals, func(i, j int) bool {
+		return pop.individuals[i].Fitness > pop.individuals[j].Fitness
+	})
 	return nil
 }
+
+// selectParent picks an individual from an already ranked population using the configured selection method
+func (pop *Population) selectParent() Chromosome {
+	if pop.params.Selection == config.RouletteSelection {
+		// Shift the fitness values so that they are all positive while giving the least fit a small chance too
+		min := pop.individuals[len(pop.individuals)-1].Fitness
+		total := float32(0)
+		for _, individual := range pop.individuals {
+			total += individual.Fitness - min + 0.01
+		}
+		spin := rand.Float32() * total
+		for _, individual := range pop.individuals {
+			spin -= individual.Fitness - min + 0.01
+			if spin <= 0 {
+				return individual
+			}
+		}
+		return pop.individuals[len(pop.individuals)-1]
+	}
+	size := pop.params.Tournament
+	if size < 2 {
+		size = 2
+	}
+	// As the population is sorted, the winner of the tournament is the contestant with the lowest index
+	winner := len(pop.individuals) - 1
+	for i := 0; i < size; i++ {
+		if contestant := rand.Intn(len(pop.individuals)); contestant < winner {
+			winner = contestant
+		}
+	}
+	return pop.individuals[winner]
+}
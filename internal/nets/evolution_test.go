@@ -1,6 +1,7 @@
 package nets
 
 import (
+	"context"
 	"testing"
 
 	"github.com/qvantel/nerd/api/types"
@@ -34,6 +35,31 @@ func TestCrossover(t *testing.T) {
 	}
 }
 
+func TestUniformCrossover(t *testing.T) {
+	a := Chromosome{
+		ActivationFunc: "act1",
+		HLayers:        1,
+		LearningRate:   0.01,
+		Type:           "type1",
+	}
+	b := Chromosome{
+		ActivationFunc: "act2",
+		HLayers:        2,
+		LearningRate:   0.02,
+		Type:           "type2",
+	}
+
+	res := a.UniformCrossover(b)
+	if len(res) != 2 {
+		t.Fatalf("Expected 2 chromosomes from crossover, got %d instead", len(res))
+	}
+	// Every gene must come from one of the parents and each parent's gene must end up in one of the children
+	if res[0].ActivationFunc == res[1].ActivationFunc || res[0].HLayers == res[1].HLayers ||
+		res[0].LearningRate == res[1].LearningRate || res[0].Type == res[1].Type {
+		t.Errorf("Uniform crossover should exchange genes without duplicating them, got %v and %v", res[0], res[1])
+	}
+}
+
 func TestMutate(t *testing.T) {
 	a := Chromosome{
 		ActivationFunc: "act1",
@@ -72,6 +98,11 @@ func TestMutate(t *testing.T) {
 		t.Errorf("When HLayers is 0.02, the only possible mutations are 0.01 or 0.03, got %d instead", a.HLayers)
 	}
 
+	a.Mutate(3)
+	if a.Type != types.MultilayerPerceptron {
+		t.Errorf("Mutating gene 3 should result in a supported net type, got %s instead", a.Type)
+	}
+
 }
 
 func TestOptimal(t *testing.T) {
@@ -102,7 +133,7 @@ func TestOptimal(t *testing.T) {
 		Outputs:   []string{"value-9"},
 		SeriesID:  "file-test-set",
 	}
-	net, err := pop.Optimal(tr, tr.Outputs, points)
+	net, err := pop.Optimal(context.Background(), tr, tr.Outputs, points)
 	if err != nil {
 		t.Fatalf("Optimal search failed (%s)", err.Error())
 	}
@@ -0,0 +1,252 @@
+package nets
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+// Derive returns a copy of the given inputs with the values of the features that the net derives from its series
+// added, ts is the moment time features are calculated for. Lags and windows that aren't part of the inputs are
+// calculated from the points of the series that precede ts (with the labels the net was trained with)
+func Derive(net Network, inputs map[string]float32, ts time.Time, ps pointstores.PointStore) (map[string]float32, error) {
+	var history []pointstores.Point
+	if missingHistory(net, inputs) {
+		var err error
+		history, err = History(net, ts, ps)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return DeriveFrom(net, inputs, ts, history)
+}
+
+// History returns the points of the series of the net that the lags and windows of inputs up to ts can be calculated
+// from, newest first. Only the points before ts are included, as one stored at ts would be the one being evaluated. It
+// can be fetched once for the latest moment of a batch and passed to DeriveFrom for every input
+func History(net Network, ts time.Time, ps pointstores.PointStore) ([]pointstores.Point, error) {
+	brief := net.Params().Brief()
+	if brief.Features == nil {
+		return nil, nil
+	}
+	depth := historyDepth(brief.Features.Lags, brief.Features.Windows)
+	if depth == 0 {
+		return nil, nil
+	}
+	sel := types.Selection{To: ts.Unix() - 1}
+	if brief.Selection != nil {
+		sel.Labels = brief.Selection.Labels // The previous values come from the same slice of the series the net was trained with
+	}
+	return ps.GetLastNSelected(ID2Series(net.ID()), sel, depth)
+}
+
+// DeriveFrom works like Derive but takes the lags and windows from the given history (as returned by History) instead
+// of the store, only using the points that precede ts
+func DeriveFrom(net Network, inputs map[string]float32, ts time.Time, history []pointstores.Point) (map[string]float32, error) {
+	res := make(map[string]float32, len(inputs))
+	for label, value := range inputs {
+		res[label] = value
+	}
+	features := net.Params().Brief().Features
+	if features == nil {
+		return res, nil
+	}
+	for label, value := range timeValues(features.Time, features.Origin, ts.Unix()) {
+		res[label] = value
+	}
+	if !missingHistory(net, inputs) {
+		return res, nil
+	}
+	depth := historyDepth(features.Lags, features.Windows)
+	points := []pointstores.Point{}
+	for _, point := range history {
+		if point.TimeStamp < ts.Unix() && len(points) < depth {
+			points = append(points, point)
+		}
+	}
+	if len(points) < depth {
+		return nil, fmt.Errorf("the series needs at least %d points to calculate the previous values, it has %d", depth, len(points))
+	}
+	for label, value := range historyValues(features, ordered(points)) {
+		if _, ok := inputs[label]; !ok {
+			res[label] = value
+		}
+	}
+	return res, nil
+}
+
+// missingHistory returns true if any of the lags or windows of the net isn't part of the given inputs
+func missingHistory(net Network, inputs map[string]float32) bool {
+	features := net.Params().Brief().Features
+	if features == nil {
+		return false
+	}
+	for _, label := range historyLabels(features) {
+		if _, ok := inputs[label]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ID2Series extracts the ID of the series a net was trained with from the ID of the net
+func ID2Series(id string) string {
+	parts := strings.Split(id, "-")
+	if len(parts) < 4 {
+		return id
+	}
+	return strings.Join(parts[:len(parts)-3], "-")
+}
+
+// PointInputs returns the inputs of the net that are read directly from the points of its series
+func PointInputs(net types.BriefNet) []string {
+	if net.Features == nil {
+		return net.Inputs
+	}
+	derived := map[string]bool{}
+	for _, label := range append(historyLabels(net.Features), net.Features.Time...) {
+		derived[label] = true
+	}
+	inputs := []string{}
+	for _, input := range net.Inputs {
+		if !derived[input] {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// deriveFeatures returns copies of the points with the requested features added, ordered from oldest to newest, along
+// with the inputs of the nets that will be trained with them. The first points are only used as history when there
+// are lags or windows
+func deriveFeatures(tr types.TrainRequest, points []pointstores.Point) ([]pointstores.Point, []string, *types.Features) {
+	if len(tr.TimeFeatures) == 0 && len(tr.Lags) == 0 && len(tr.Windows) == 0 {
+		return points, tr.Inputs, nil
+	}
+	features := &types.Features{Lags: tr.Lags, Time: tr.TimeFeatures, Windows: tr.Windows}
+	points = ordered(points)
+	if len(points) > 0 {
+		features.Origin = points[0].TimeStamp
+	}
+	depth := historyDepth(tr.Lags, tr.Windows)
+	res := []pointstores.Point{}
+	for i := depth; i < len(points); i++ {
+		point := pointstores.Point{TimeStamp: points[i].TimeStamp, Values: map[string]float32{}}
+		for label, value := range points[i].Values {
+			point.Values[label] = value
+		}
+		for label, value := range timeValues(features.Time, features.Origin, point.TimeStamp) {
+			point.Values[label] = value
+		}
+		for label, value := range historyValues(features, points[i-depth:i]) {
+			point.Values[label] = value
+		}
+		res = append(res, point)
+	}
+	inputs := append(append(append([]string{}, tr.Inputs...), tr.TimeFeatures...), historyLabels(features)...)
+	sort.Strings(inputs)
+	return res, inputs, features
+}
+
+// historyDepth returns the number of previous points needed to calculate the given lags and windows
+func historyDepth(lags []types.Lag, windows []types.Window) int {
+	depth := 0
+	for _, lag := range lags {
+		if lag.Steps > depth {
+			depth = lag.Steps
+		}
+	}
+	for _, window := range windows {
+		if window.Size > depth {
+			depth = window.Size
+		}
+	}
+	return depth
+}
+
+// historyLabels returns the names of the inputs that are calculated from previous points
+func historyLabels(features *types.Features) []string {
+	labels := []string{}
+	for _, lag := range features.Lags {
+		labels = append(labels, lag.Names()...)
+	}
+	for _, window := range features.Windows {
+		labels = append(labels, window.Name())
+	}
+	return labels
+}
+
+// historyValues calculates the lags and windows of a point given the ones that preceded it (oldest first)
+func historyValues(features *types.Features, history []pointstores.Point) map[string]float32 {
+	values := map[string]float32{}
+	for _, lag := range features.Lags {
+		for step, label := range lag.Names() {
+			values[label] = history[len(history)-step-1].Values[lag.Label]
+		}
+	}
+	for _, window := range features.Windows {
+		var res float32
+		for i, point := range history[len(history)-window.Size:] {
+			value := point.Values[window.Label]
+			switch {
+			case window.Aggregate == types.Mean:
+				res += value / float32(window.Size)
+			case i == 0,
+				window.Aggregate == types.Min && value < res,
+				window.Aggregate == types.Max && value > res:
+				res = value
+			}
+		}
+		values[window.Name()] = res
+	}
+	return values
+}
+
+// ordered returns a copy of the points sorted from oldest to newest
+func ordered(points []pointstores.Point) []pointstores.Point {
+	res := append([]pointstores.Point{}, points...)
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].TimeStamp < res[j].TimeStamp
+	})
+	return res
+}
+
+// timeValues calculates the requested time features for the given unix timestamp, origin is the timestamp that the
+// elapsed time is measured from
+func timeValues(features []string, origin, ts int64) map[string]float32 {
+	t := time.Unix(ts, 0).UTC()
+	hour := float64(t.Hour()) + float64(t.Minute())/60
+	weekday := float64(t.Weekday())
+	month := float64(t.Month() - 1)
+	values := make(map[string]float32, len(features))
+	for _, feature := range features {
+		switch feature {
+		case types.Hour:
+			values[feature] = float32(t.Hour())
+		case types.HourSin:
+			values[feature] = float32(math.Sin(2 * math.Pi * hour / 24))
+		case types.HourCos:
+			values[feature] = float32(math.Cos(2 * math.Pi * hour / 24))
+		case types.Weekday:
+			values[feature] = float32(weekday)
+		case types.WeekdaySin:
+			values[feature] = float32(math.Sin(2 * math.Pi * weekday / 7))
+		case types.WeekdayCos:
+			values[feature] = float32(math.Cos(2 * math.Pi * weekday / 7))
+		case types.Month:
+			values[feature] = float32(month + 1)
+		case types.MonthSin:
+			values[feature] = float32(math.Sin(2 * math.Pi * month / 12))
+		case types.MonthCos:
+			values[feature] = float32(math.Cos(2 * math.Pi * month / 12))
+		case types.Elapsed:
+			values[feature] = float32(ts-origin) / 86400
+		}
+	}
+	return values
+}
@@ -0,0 +1,158 @@
+package nets
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+func TestTimeValues(t *testing.T) {
+	// Saturday 1994-08-27 18:00:00 UTC
+	ts := time.Date(1994, time.August, 27, 18, 0, 0, 0, time.UTC).Unix()
+	values := timeValues(types.TimeFeatures(), ts-2*86400, ts)
+	expected := map[string]float32{
+		types.Hour:       18,
+		types.HourSin:    -1,
+		types.HourCos:    0,
+		types.Weekday:    6,
+		types.WeekdaySin: float32(math.Sin(2 * math.Pi * 6 / 7)),
+		types.WeekdayCos: float32(math.Cos(2 * math.Pi * 6 / 7)),
+		types.Month:      8,
+		types.MonthSin:   float32(math.Sin(2 * math.Pi * 7 / 12)),
+		types.MonthCos:   float32(math.Cos(2 * math.Pi * 7 / 12)),
+		types.Elapsed:    2,
+	}
+	for feature, value := range expected {
+		if math.Abs(float64(values[feature]-value)) > 0.0001 {
+			t.Errorf("Wrong value for %s, expected %f got %f", feature, value, values[feature])
+		}
+	}
+}
+
+func TestDeriveFeatures(t *testing.T) {
+	points := []pointstores.Point{
+		{TimeStamp: 7200, Values: map[string]float32{"subs": 1, "size": 2}},
+		{TimeStamp: 3600, Values: map[string]float32{"subs": 2, "size": 3}},
+	}
+	tr := types.TrainRequest{
+		Inputs:       []string{"subs"},
+		Outputs:      []string{"size"},
+		TimeFeatures: []string{types.Hour, types.Elapsed},
+	}
+	derived, inputs, features := deriveFeatures(tr, points)
+	if len(inputs) != 3 || inputs[0] != types.Elapsed || inputs[1] != types.Hour || inputs[2] != "subs" {
+		t.Errorf("Expected the inputs to include the time features in order, got %v", inputs)
+	}
+	if features == nil || features.Origin != 3600 {
+		t.Fatalf("Expected the oldest point to be used as origin, got %+v", features)
+	}
+	if derived[0].Values[types.Hour] != 1 || derived[1].Values[types.Hour] != 2 || derived[1].Values["subs"] != 1 {
+		t.Errorf("Derived points are incorrect, got %+v", derived)
+	}
+	if _, ok := points[0].Values[types.Hour]; ok {
+		t.Errorf("The original points shouldn't be modified")
+	}
+
+	// Evaluation should calculate the same features for the given moment
+	net, err := NewMLP("test", inputs, tr.Outputs, nil, Chromosome{ActivationFunc: types.BipolarSigmoid, HLayers: 1, LearningRate: 0.1})
+	if err != nil {
+		t.Fatalf("Failed to create net (%s)", err.Error())
+	}
+	*net.Params().DerivedFeatures() = *features
+	values, err := Derive(net, map[string]float32{"subs": 3}, time.Unix(3600+86400+3*3600, 0), nil)
+	if err != nil {
+		t.Fatalf("Failed to derive features (%s)", err.Error())
+	}
+	if values["subs"] != 3 || values[types.Hour] != 4 || values[types.Elapsed] != 1.125 {
+		t.Errorf("Derived inputs are incorrect, got %v", values)
+	}
+	brief := net.Params().Brief()
+	if inputs := PointInputs(*brief); len(inputs) != 1 || inputs[0] != "subs" {
+		t.Errorf("Expected only subs to be read from the points, got %v", inputs)
+	}
+}
+
+func TestHistoryFeatures(t *testing.T) {
+	points := []pointstores.Point{}
+	for i := 5; i >= 0; i-- { // Newest first, like the stores return them
+		points = append(points, pointstores.Point{TimeStamp: int64(1612706310 + i), Values: map[string]float32{"subs": float32(i), "size": float32(10 * i)}})
+	}
+	tr := types.TrainRequest{
+		Inputs:  []string{"subs"},
+		Lags:    []types.Lag{{Label: "size", Steps: 2}},
+		Outputs: []string{"size"},
+		Windows: []types.Window{{Aggregate: types.Mean, Label: "subs", Size: 3}, {Aggregate: types.Max, Label: "subs", Size: 2}},
+	}
+	derived, inputs, features := deriveFeatures(tr, points)
+	if len(derived) != 3 {
+		t.Fatalf("Expected the 3 oldest points to be used only as history, got %d points", len(derived))
+	}
+	if len(inputs) != 5 {
+		t.Errorf("Expected the inputs to include 2 lags and 2 windows, got %v", inputs)
+	}
+	// The first point with enough history is subs = 3
+	first := derived[0].Values
+	if first["subs"] != 3 || first["size@t-1"] != 20 || first["size@t-2"] != 10 || first["subs@mean-3"] != 1 || first["subs@max-2"] != 2 {
+		t.Errorf("Derived values are incorrect, got %v", first)
+	}
+
+	// Evaluation should fetch the history from the store
+	ps, _ := pointstores.NewFileAdapter(map[string]interface{}{"Path": "."})
+	seriesID := "test-history-features"
+	defer ps.DeleteSeries(seriesID)
+	for _, point := range points {
+		err := ps.AddPoint(seriesID, point)
+		if err != nil {
+			t.Fatalf("Failed to add point (%s)", err.Error())
+		}
+	}
+	id := seriesID + "-" + hash(inputs) + "-" + hash(tr.Outputs) + "-" + types.MultilayerPerceptron
+	if ID2Series(id) != seriesID {
+		t.Errorf("Expected the series ID to be %s, got %s", seriesID, ID2Series(id))
+	}
+	net, err := NewMLP(id, inputs, tr.Outputs, nil, Chromosome{ActivationFunc: types.BipolarSigmoid, HLayers: 1, LearningRate: 0.1})
+	if err != nil {
+		t.Fatalf("Failed to create net (%s)", err.Error())
+	}
+	*net.Params().DerivedFeatures() = *features
+	values, err := Derive(net, map[string]float32{"subs": 6, "size@t-1": 7}, time.Now(), ps)
+	if err != nil {
+		t.Fatalf("Failed to derive features (%s)", err.Error())
+	}
+	if values["size@t-1"] != 7 || values["size@t-2"] != 40 || values["subs@mean-3"] != 4 || values["subs@max-2"] != 5 {
+		t.Errorf("Derived inputs are incorrect, got %v", values)
+	}
+
+	// Inputs from the past should only use the points that preceded them, not the one stored at that same moment
+	past := time.Unix(1612706310+3, 0)
+	values, err = Derive(net, map[string]float32{"subs": 6, "size@t-1": 7}, past, ps)
+	if err != nil {
+		t.Fatalf("Failed to derive features (%s)", err.Error())
+	}
+	if values["size@t-2"] != 10 || values["subs@mean-3"] != 1 || values["subs@max-2"] != 2 {
+		t.Errorf("Derived inputs for a past moment are incorrect, got %v", values)
+	}
+	history, err := History(net, past, ps)
+	if err != nil {
+		t.Fatalf("Failed to load history (%s)", err.Error())
+	}
+	batched, err := DeriveFrom(net, map[string]float32{"subs": 6, "size@t-1": 7}, past, history)
+	if err != nil {
+		t.Fatalf("Failed to derive features (%s)", err.Error())
+	}
+	for label, value := range values {
+		if batched[label] != value {
+			t.Errorf("Expected the history fetched beforehand to give the same %s (%f), got %f", label, value, batched[label])
+		}
+	}
+	if _, err = DeriveFrom(net, map[string]float32{"subs": 6}, time.Unix(1612706310+1, 0), history); err == nil {
+		t.Error("Expected an error when there aren't enough points before the given moment")
+	}
+	brief := net.Params().Brief()
+	if inputs := PointInputs(*brief); len(inputs) != 1 || inputs[0] != "subs" {
+		t.Errorf("Expected only subs to be read from the points, got %v", inputs)
+	}
+}
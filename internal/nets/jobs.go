@@ -0,0 +1,429 @@
+package nets
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/logger"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+)
+
+// JobRecord is the kind of auxiliary record that holds the state of a training job, which is kept in the net param
+// store so that every instance sees the same jobs and they survive restarts
+const JobRecord = "job"
+
+// cancelRecord is the kind of auxiliary record that asks the instance running a job to stop it
+const cancelRecord = "cancel"
+
+// maxFinished is the number of finished jobs that the registry remembers
+const maxFinished = 100
+
+// progressSave is the minimum time between writes of the progress of a running job to the store
+const progressSave = time.Second
+
+// cancelPoll is how often running jobs check if they have been cancelled through another instance
+const cancelPoll = 2 * time.Second
+
+// progressKey is the context key under which the function that updates the progress of a job is kept
+type progressKey struct{}
+
+// job holds the state of a training request that is running in this instance
+type job struct {
+	cancel    context.CancelFunc
+	cancelled bool
+	done      chan struct{}
+	info      types.Job
+	saved     time.Time // When the info was last written to the store
+}
+
+// Registry keeps track of the training jobs that are queued, running or have recently finished so that they can be
+// checked and cancelled from any instance. The jobs are kept in the net param store and the ones running in this
+// instance in memory too
+type Registry struct {
+	mu      sync.Mutex
+	nps     paramstores.NetParamStore
+	poll    time.Duration // How often to check if a job has been cancelled through another instance
+	running map[string]*job
+}
+
+// NewRegistry returns a job registry that keeps the jobs in the given store
+func NewRegistry(nps paramstores.NetParamStore) *Registry {
+	return &Registry{nps: nps, poll: cancelPoll, running: map[string]*job{}}
+}
+
+// Cancel stops the job with the given ID and waits (up to the given timeout) for it to save the best nets found so
+// far, which are returned as part of the job. If the job isn't queued or running, false will be returned along with
+// the job (which will be empty if it doesn't exist)
+func (r *Registry) Cancel(id string, timeout time.Duration) (types.Job, bool, error) {
+	r.mu.Lock()
+	j, ok := r.running[id]
+	if ok {
+		j.cancelled = true
+		r.mu.Unlock()
+		j.cancel()
+		select {
+		case <-j.done:
+		case <-time.After(timeout):
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return j.info, true, nil
+	}
+	r.mu.Unlock()
+	info, found, err := r.load(id)
+	if err != nil || !found || info.Finished != 0 {
+		return info, false, err
+	}
+	err = r.nps.Save(paramstores.AuxID(id, cancelRecord), paramstores.JSON{Value: time.Now().Unix()})
+	if err != nil {
+		return info, false, err
+	}
+	if info.Status == types.JobQueued {
+		// It will be skipped when its turn comes
+		markFinished(&info, true, nil)
+		return info, true, r.save(info)
+	}
+	// It's running in another instance, which will notice the request and stop it
+	deadline := time.Now().Add(timeout)
+	for info.Finished == 0 && time.Now().Before(deadline) {
+		time.Sleep(r.poll)
+		latest, found, err := r.load(id)
+		if err != nil {
+			return info, true, err
+		}
+		if found {
+			info = latest
+		}
+	}
+	return info, true, nil
+}
+
+// Discard forgets a queued job, for when its request couldn't be added to the training queue
+func (r *Registry) Discard(id string) error {
+	return r.nps.Delete(paramstores.AuxID(id, JobRecord))
+}
+
+// Get returns the job with the given ID, false will be returned if it's unknown or finished too long ago
+func (r *Registry) Get(id string) (types.Job, bool, error) {
+	r.mu.Lock()
+	j, ok := r.running[id]
+	if ok {
+		defer r.mu.Unlock()
+		return j.info, true, nil
+	}
+	r.mu.Unlock()
+	return r.load(id)
+}
+
+// List returns the jobs in the registry, newest first. If status isn't empty, only the jobs in that state are included
+func (r *Registry) List(status string) ([]types.Job, error) {
+	all, err := r.all()
+	if err != nil {
+		return nil, err
+	}
+	jobs := []types.Job{}
+	for _, info := range all {
+		if status == "" || info.Status == status {
+			jobs = append(jobs, info)
+		}
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].Created > jobs[j].Created || (jobs[i].Created == jobs[j].Created && jobs[i].ID < jobs[j].ID)
+	})
+	return jobs, nil
+}
+
+// Queue registers a request that hasn't been picked up by the training service yet
+func (r *Registry) Queue(tr types.TrainRequest) (types.Job, error) {
+	info := newInfo(tr)
+	return info, r.save(info)
+}
+
+// all returns every job in the store, with the latest state of the ones running in this instance
+func (r *Registry) all() ([]types.Job, error) {
+	ids, err := r.nps.ListAux(JobRecord)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]types.Job, 0, len(ids))
+	for _, id := range ids {
+		info, found, err := r.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			jobs = append(jobs, info)
+		}
+	}
+	return jobs, nil
+}
+
+// cancelRequested returns true if the job with the given ID has been cancelled through another instance
+func (r *Registry) cancelRequested(id string) bool {
+	var requested int64
+	found, err := r.nps.Load(paramstores.AuxID(id, cancelRecord), paramstores.JSON{Value: &requested})
+	if err != nil {
+		logger.Error("Failed to check if job "+id+" has been cancelled", err)
+	}
+	return found
+}
+
+// end marks a job of this instance as finished, writes it to the store and forgets the oldest finished jobs beyond the
+// limit, err is the error that stopped it (if any). The lock must be held
+func (r *Registry) end(j *job, err error) {
+	markFinished(&j.info, j.cancelled, err)
+	close(j.done)
+	delete(r.running, j.info.ID)
+	r.store(j)
+	if j.cancelled {
+		err = r.nps.Delete(paramstores.AuxID(j.info.ID, cancelRecord))
+		if err != nil {
+			logger.Error("Failed to delete the cancellation of job "+j.info.ID, err)
+		}
+	}
+	err = r.forget()
+	if err != nil {
+		logger.Error("Failed to forget the oldest finished jobs", err)
+	}
+}
+
+// fail adds an error to the info of a job
+func (r *Registry) fail(id string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.running[id]
+	if !ok {
+		return
+	}
+	j.info.Errors = append(j.info.Errors, err.Error())
+	r.store(j)
+}
+
+// finish marks a job as finished and notifies anyone waiting for it, err is the error that stopped it (if any)
+func (r *Registry) finish(id string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.running[id]
+	if !ok {
+		return
+	}
+	j.cancel()
+	r.end(j, err)
+}
+
+// forget deletes the oldest finished jobs beyond the limit from the store
+func (r *Registry) forget() error {
+	ids, err := r.nps.ListAux(JobRecord)
+	if err != nil || len(ids) <= maxFinished {
+		return err
+	}
+	finished := []types.Job{}
+	for _, id := range ids {
+		info, found, err := r.load(id)
+		if err != nil {
+			return err
+		}
+		if found && info.Finished != 0 {
+			finished = append(finished, info)
+		}
+	}
+	sort.SliceStable(finished, func(i, j int) bool { return finished[i].Finished < finished[j].Finished })
+	for len(finished) > maxFinished {
+		err = r.Discard(finished[0].ID)
+		if err != nil {
+			return err
+		}
+		finished = finished[1:]
+	}
+	return nil
+}
+
+// load returns the job with the given ID from the store, false will be returned if it isn't there
+func (r *Registry) load(id string) (types.Job, bool, error) {
+	var info types.Job
+	found, err := r.nps.Load(paramstores.AuxID(id, JobRecord), paramstores.JSON{Value: &info})
+	return info, found, err
+}
+
+// record adds a net produced by a job to its info
+func (r *Registry) record(id string, net types.BriefNet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.running[id]
+	if !ok {
+		return
+	}
+	j.info.Nets = append(j.info.Nets, net)
+	r.store(j)
+}
+
+// searched adds the configurations that were evaluated in the search for the net of the given output to the job
+func (r *Registry) searched(id, output string, trials []types.Trial) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.running[id]
+	if !ok {
+		return
+	}
+	if j.info.Trials == nil {
+		j.info.Trials = map[string][]types.Trial{}
+	}
+	j.info.Trials[output] = trials
+	r.store(j)
+}
+
+// requeue records the error that made a job fail and marks it as queued again so that it can be retried (in any
+// instance), returns false if the job was cancelled (in which case it shouldn't be retried)
+func (r *Registry) requeue(id string, err error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.running[id]
+	if !ok {
+		return true
+	}
+	if j.cancelled || r.cancelRequested(id) {
+		j.cancelled = true
+		return false
+	}
+	j.cancel()
+	j.info.Errors = append(j.info.Errors, err.Error())
+	j.info.Progress = types.Progress{}
+	j.info.Started = 0
+	j.info.Status = types.JobQueued
+	close(j.done)
+	delete(r.running, id)
+	r.store(j)
+	return true
+}
+
+// save writes the info of a job to the store
+func (r *Registry) save(info types.Job) error {
+	return r.nps.Save(paramstores.AuxID(info.ID, JobRecord), paramstores.JSON{Value: info})
+}
+
+// start marks the job of the given request as running (registering it if it wasn't queued) and returns the context it
+// should use, which will be cancelled once the budget (if any) runs out or the job is cancelled through any instance.
+// If the job was cancelled while queued, false will be returned and it shouldn't be processed
+func (r *Registry) start(tr types.TrainRequest, budget time.Duration) (context.Context, bool) {
+	info, found, err := r.load(tr.ID)
+	if err != nil {
+		logger.Error("Failed to load job "+tr.ID+", starting it anew", err)
+	}
+	if !found {
+		info = newInfo(tr)
+	}
+	if info.Finished != 0 {
+		// Cancelling a queued job finishes it right away, the request only had to be skipped
+		err = r.nps.Delete(paramstores.AuxID(tr.ID, cancelRecord))
+		if err != nil {
+			logger.Error("Failed to delete the cancellation of job "+tr.ID, err)
+		}
+		return nil, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j := &job{cancel: func() {}, done: make(chan struct{}), info: info}
+	if r.cancelRequested(tr.ID) {
+		j.cancelled = true
+		r.end(j, nil)
+		return nil, false
+	}
+	var ctx context.Context
+	if budget > 0 {
+		ctx, j.cancel = context.WithTimeout(context.Background(), budget)
+	} else {
+		ctx, j.cancel = context.WithCancel(context.Background())
+	}
+	j.info.Started = time.Now().Unix()
+	j.info.Status = types.JobRunning
+	r.running[tr.ID] = j
+	r.store(j)
+	go r.watch(j)
+	return context.WithValue(ctx, progressKey{}, func(update func(*types.Progress)) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		update(&j.info.Progress)
+		if time.Since(j.saved) >= progressSave {
+			r.store(j)
+		}
+	}), true
+}
+
+// store writes the info of a job of this instance to the store, as the job can go on without it failures are only
+// logged. The lock must be held
+func (r *Registry) store(j *job) {
+	j.saved = time.Now()
+	err := r.save(j.info)
+	if err != nil {
+		logger.Error("Failed to save job "+j.info.ID, err)
+	}
+}
+
+// watch cancels a job of this instance if it's cancelled through another one, until it's done
+func (r *Registry) watch(j *job) {
+	ticker := time.NewTicker(r.poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-ticker.C:
+		}
+		if r.cancelRequested(j.info.ID) {
+			r.mu.Lock()
+			j.cancelled = true
+			r.mu.Unlock()
+			j.cancel()
+			return
+		}
+	}
+}
+
+// markFinished marks the given job info as finished, err is the error that stopped it (if any)
+func markFinished(info *types.Job, cancelled bool, err error) {
+	if err != nil {
+		info.Errors = append(info.Errors, err.Error())
+	}
+	switch {
+	case cancelled:
+		info.Status = types.JobCancelled
+	case err != nil, len(info.Errors) > 0 && len(info.Nets) == 0:
+		info.Status = types.JobFailed
+	default:
+		info.Status = types.JobSucceeded
+	}
+	info.Finished = time.Now().Unix()
+}
+
+// newInfo returns the state of a request that hasn't started yet
+func newInfo(tr types.TrainRequest) types.Job {
+	return types.Job{
+		Created:  time.Now().Unix(),
+		Errors:   []string{},
+		ID:       tr.ID,
+		Nets:     []types.BriefNet{},
+		Priority: tr.Priority,
+		SeriesID: tr.SeriesID,
+		Status:   types.JobQueued,
+	}
+}
+
+// report applies the given change to the progress of the job that the context belongs to (if any)
+func report(ctx context.Context, update func(*types.Progress)) {
+	if progress, ok := ctx.Value(progressKey{}).(func(func(*types.Progress))); ok {
+		progress(update)
+	}
+}
+
+// budget returns the maximum amount of time that the given request can take considering the global budget too
+func budget(tr types.TrainRequest, params config.MLParams) time.Duration {
+	seconds := params.Budget
+	if tr.Budget > 0 && (seconds == 0 || tr.Budget < seconds) {
+		seconds = tr.Budget
+	}
+	return time.Duration(seconds) * time.Second
+}
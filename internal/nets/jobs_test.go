@@ -0,0 +1,267 @@
+package nets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/nets/queues"
+	"github.com/qvantel/nerd/internal/resilience"
+)
+
+// newTestRegistry returns a job registry that keeps its jobs in a temporary directory
+func newTestRegistry(t *testing.T) *Registry {
+	nps, err := paramstores.NewFileAdapter(map[string]interface{}{"Path": t.TempDir()}, 0)
+	if err != nil {
+		t.Fatalf("Failed to initialize job store (%s)", err.Error())
+	}
+	return NewRegistry(nps)
+}
+
+func TestRegistry(t *testing.T) {
+	jobs := newTestRegistry(t)
+	tr := types.TrainRequest{ID: "job-1", SeriesID: "test"}
+	ctx, _ := jobs.start(tr, 0)
+	jobs.searched(tr.ID, "size", []types.Trial{{Fitness: 0.9}, {Error: "invalid normalization scheme"}})
+	jobs.record(tr.ID, types.BriefNet{ID: "net-1"})
+
+	go func() {
+		<-ctx.Done()
+		jobs.record(tr.ID, types.BriefNet{ID: "net-2"})
+		jobs.finish(tr.ID, nil)
+	}()
+	job, ok, err := jobs.Cancel(tr.ID, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to cancel job (%s)", err.Error())
+	}
+	if !ok {
+		t.Fatal("Failed to cancel a running job")
+	}
+	if ctx.Err() == nil {
+		t.Error("Cancelling a job should cancel its context")
+	}
+	if job.ID != tr.ID || job.SeriesID != tr.SeriesID || len(job.Nets) != 2 || job.Status != types.JobCancelled {
+		t.Errorf("Expected cancelled job %s of series %s with 2 nets, got %+v", tr.ID, tr.SeriesID, job)
+	}
+	_, ok, _ = jobs.Cancel(tr.ID, time.Second)
+	if ok {
+		t.Error("Finished jobs shouldn't be cancellable")
+	}
+
+	// Jobs are kept in the store so they survive restarts
+	restarted := NewRegistry(jobs.nps)
+	if job, ok, _ := restarted.Get(tr.ID); !ok || job.Status != types.JobCancelled || len(job.Nets) != 2 || len(job.Trials["size"]) != 2 {
+		t.Errorf("Expected job %s to still be cancelled with 2 nets and the trials of size after a restart, got %+v", tr.ID, job)
+	}
+}
+
+func TestRemoteCancel(t *testing.T) {
+	running := newTestRegistry(t)
+	running.poll = 10 * time.Millisecond
+	other := NewRegistry(running.nps)
+	other.poll = 10 * time.Millisecond
+	tr := types.TrainRequest{ID: "job-1", SeriesID: "test"}
+	ctx, _ := running.start(tr, 0)
+
+	go func() {
+		<-ctx.Done()
+		running.record(tr.ID, types.BriefNet{ID: "net-1"})
+		running.finish(tr.ID, nil)
+	}()
+	job, ok, err := other.Cancel(tr.ID, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to cancel job (%s)", err.Error())
+	}
+	if !ok {
+		t.Fatal("Failed to cancel a job running in another instance")
+	}
+	if ctx.Err() == nil {
+		t.Error("Cancelling a job through another instance should cancel its context")
+	}
+	if job.Status != types.JobCancelled || len(job.Nets) != 1 {
+		t.Errorf("Expected job %s to be cancelled with 1 net, got %+v", tr.ID, job)
+	}
+
+	// A queued job cancelled through one instance shouldn't start in another
+	queued := types.TrainRequest{ID: "job-2", SeriesID: "test"}
+	_, err = running.Queue(queued)
+	if err != nil {
+		t.Fatalf("Failed to queue job (%s)", err.Error())
+	}
+	if _, ok, _ := other.Cancel(queued.ID, time.Second); !ok {
+		t.Fatal("Failed to cancel a queued job")
+	}
+	if _, ok := running.start(queued, 0); ok {
+		t.Error("Jobs cancelled while queued shouldn't start")
+	}
+}
+
+func TestJobStates(t *testing.T) {
+	jobs := newTestRegistry(t)
+	queued := types.TrainRequest{ID: "job-1", SeriesID: "test"}
+	_, err := jobs.Queue(queued)
+	if err != nil {
+		t.Fatalf("Failed to queue job (%s)", err.Error())
+	}
+	if job, ok, _ := jobs.Get(queued.ID); !ok || job.Status != types.JobQueued {
+		t.Fatalf("Expected job %s to be queued, got %+v", queued.ID, job)
+	}
+	if _, ok, _ := jobs.Cancel(queued.ID, time.Second); !ok {
+		t.Fatal("Failed to cancel a queued job")
+	}
+	if _, ok := jobs.start(queued, 0); ok {
+		t.Error("Jobs cancelled while queued shouldn't start")
+	}
+	if cancels, _ := jobs.nps.ListAux(cancelRecord); len(cancels) != 0 {
+		t.Errorf("Expected the cancellation of job %s to be deleted once it was skipped, got %v", queued.ID, cancels)
+	}
+
+	failed := types.TrainRequest{ID: "job-2", SeriesID: "test"}
+	ctx, ok := jobs.start(failed, 0)
+	if !ok {
+		t.Fatal("Failed to start a job that wasn't queued")
+	}
+	report(ctx, func(p *types.Progress) {
+		p.Output = "size"
+		p.Epoch = 3
+	})
+	if job, _, _ := jobs.Get(failed.ID); job.Status != types.JobRunning || job.Progress.Output != "size" || job.Progress.Epoch != 3 {
+		t.Errorf("Expected job %s to be running epoch 3 of size, got %+v", failed.ID, job)
+	}
+	jobs.fail(failed.ID, errors.New("no points"))
+	jobs.finish(failed.ID, nil)
+	if job, _, _ := jobs.Get(failed.ID); job.Status != types.JobFailed || len(job.Errors) != 1 || job.Finished == 0 {
+		t.Errorf("Expected job %s to have failed with 1 error, got %+v", failed.ID, job)
+	}
+
+	succeeded := types.TrainRequest{ID: "job-3", SeriesID: "test"}
+	jobs.start(succeeded, 0)
+	jobs.record(succeeded.ID, types.BriefNet{ID: "net-1"})
+	jobs.finish(succeeded.ID, nil)
+	list, err := jobs.List(types.JobSucceeded)
+	if err != nil {
+		t.Fatalf("Failed to list jobs (%s)", err.Error())
+	}
+	if len(list) != 1 || list[0].ID != succeeded.ID {
+		t.Errorf("Expected only job %s to have succeeded, got %+v", succeeded.ID, list)
+	}
+	if list, _ := jobs.List(""); len(list) != 3 {
+		t.Errorf("Expected 3 jobs, got %d", len(list))
+	}
+
+	// Only the latest finished jobs are remembered (the timestamps have a precision of seconds so it's backdated)
+	job, _, _ := jobs.Get(succeeded.ID)
+	job.Finished -= 60
+	err = jobs.save(job)
+	if err != nil {
+		t.Fatalf("Failed to save job (%s)", err.Error())
+	}
+	for i := 0; i < maxFinished; i++ {
+		tr := types.TrainRequest{ID: "extra-" + strconv.Itoa(i)}
+		jobs.start(tr, 0)
+		jobs.finish(tr.ID, nil)
+	}
+	if _, ok, _ := jobs.Get(succeeded.ID); ok {
+		t.Errorf("Expected job %s to have been forgotten", succeeded.ID)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	tests := []struct {
+		global, request int
+		expected        time.Duration
+	}{
+		{0, 0, 0},
+		{10, 0, 10 * time.Second},
+		{0, 5, 5 * time.Second},
+		{10, 5, 5 * time.Second},
+		{5, 10, 5 * time.Second},
+	}
+	for _, test := range tests {
+		got := budget(types.TrainRequest{Budget: test.request}, config.MLParams{Budget: test.global})
+		if got != test.expected {
+			t.Errorf("Expected a budget of %s for %d (global) and %d (request), got %s", test.expected, test.global, test.request, got)
+		}
+	}
+}
+
+func TestSettle(t *testing.T) {
+	q, err := queues.NewFileAdapter(map[string]interface{}{"Path": "."}, 3)
+	if err != nil {
+		t.Fatalf("Failed to initialize training queue (%s)", err.Error())
+	}
+	defer os.RemoveAll(q.Path)
+	params := config.MLParams{Attempts: 2, Backoff: 0}
+	jobs := newTestRegistry(t)
+	tr := types.TrainRequest{ID: "job-1", SeriesID: "test"}
+	err = q.Push(tr)
+	if err != nil {
+		t.Fatalf("Failed to push request (%s)", err.Error())
+	}
+
+	for attempt := 1; attempt <= params.Attempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		item, err := q.Pop(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("Failed to pop attempt %d (%s)", attempt, err.Error())
+		}
+		jobs.start(item.Request, 0)
+		err = settle(q, item, errors.New("no points"), jobs, params)
+		if err != nil {
+			t.Fatalf("Failed to settle attempt %d (%s)", attempt, err.Error())
+		}
+	}
+	if job, _, _ := jobs.Get(tr.ID); job.Status != types.JobFailed || len(job.Errors) != 2 {
+		t.Errorf("Expected job %s to have failed with 2 errors, got %+v", tr.ID, job)
+	}
+	dls, err := q.DeadLetters()
+	if err != nil {
+		t.Fatalf("Failed to list dead letters (%s)", err.Error())
+	}
+	if len(dls) != 1 || dls[0].Request.ID != tr.ID || dls[0].Attempts != 2 {
+		t.Errorf("Expected request %s to be buried after 2 attempts, got %+v", tr.ID, dls)
+	}
+}
+
+func TestSettleUnavailable(t *testing.T) {
+	q, err := queues.NewFileAdapter(map[string]interface{}{"Path": "."}, 3)
+	if err != nil {
+		t.Fatalf("Failed to initialize training queue (%s)", err.Error())
+	}
+	defer os.RemoveAll(q.Path)
+	params := config.MLParams{Attempts: 1, BreakerCool: 0}
+	jobs := newTestRegistry(t)
+	tr := types.TrainRequest{ID: "job-1", SeriesID: "test"}
+	err = q.Push(tr)
+	if err != nil {
+		t.Fatalf("Failed to push request (%s)", err.Error())
+	}
+
+	item, err := pop(q)
+	if err != nil {
+		t.Fatalf("Failed to pop request (%s)", err.Error())
+	}
+	jobs.start(item.Request, 0)
+	err = settle(q, item, fmt.Errorf("points: %w", resilience.ErrOpen), jobs, params)
+	if err != nil {
+		t.Fatalf("Failed to settle request (%s)", err.Error())
+	}
+	if job, _, _ := jobs.Get(tr.ID); job.Status != types.JobQueued {
+		t.Errorf("Expected job %s to be queued again while the store is unavailable, got %+v", tr.ID, job)
+	}
+	item, err = pop(q)
+	if err != nil {
+		t.Fatalf("Failed to pop the deferred request (%s)", err.Error())
+	}
+	if item.Attempts != 0 {
+		t.Errorf("Expected the store being unavailable not to count as an attempt, got %d", item.Attempts)
+	}
+}
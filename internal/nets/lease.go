@@ -0,0 +1,93 @@
+package nets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/logger"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/nets/queues"
+)
+
+// ErrLeaseLost is returned when a job is stopped because its instance lost the lease of the training group, the nets it
+// was training are discarded as another instance might be training the same group
+var ErrLeaseLost = errors.New("lost the lease of the training group")
+
+// leaseContext is the context of a job while the lease of its group is kept, once the lease is lost it's cancelled and
+// its error is ErrLeaseLost instead of the usual one
+type leaseContext struct {
+	context.Context
+	lost *int32
+}
+
+func (lc leaseContext) Err() error {
+	if atomic.LoadInt32(lc.lost) == 1 {
+		return ErrLeaseLost
+	}
+	return lc.Context.Err()
+}
+
+// instanceID returns a name that identifies this instance when taking training leases
+func instanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "nerd"
+	}
+	return host + "-" + uuid.New().String()
+}
+
+// trainingGroup returns the ID shared by the nets trained from the same series, inputs and selection, which is the unit
+// that instances take leases for
+func trainingGroup(tr types.TrainRequest) string {
+	_, inputs, _ := deriveFeatures(tr, nil)
+	return tr.SeriesID + "-" + inputsHash(inputs, tr.Selection)
+}
+
+// keepLease renews the lease of a training group (and extends the delivery of its request) every third of the TTL
+// until the returned function is called. The returned context is cancelled (with ErrLeaseLost as its error) if the lease
+// is lost, which happens when another instance takes it or when it can't be renewed before it expires
+func keepLease(ctx context.Context, q queues.Queue, item queues.Item, nps paramstores.NetParamStore, group, owner string, ttl time.Duration) (context.Context, func()) {
+	cCtx, cancel := context.WithCancel(ctx)
+	lCtx := leaseContext{Context: cCtx, lost: new(int32)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		renewed := time.Now()
+		for {
+			select {
+			case <-lCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			taken, err := nps.Lease(group, owner, ttl)
+			if err != nil {
+				logger.Error("Failed to renew the lease of training group "+group, err)
+			}
+			if (err == nil && !taken) || time.Since(renewed) >= ttl {
+				logger.Warning("Lost the lease of training group " + group + ", stopping job " + item.Request.ID)
+				atomic.StoreInt32(lCtx.lost, 1)
+				cancel()
+				return
+			}
+			if err != nil {
+				continue
+			}
+			renewed = time.Now()
+			err = q.Extend(item)
+			if err != nil {
+				logger.Error("Failed to extend the delivery of job "+item.Request.ID, err)
+			}
+		}
+	}()
+	return lCtx, func() {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,191 @@
+package nets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/nets/queues"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+func TestTrainingGroup(t *testing.T) {
+	tr := types.TrainRequest{Inputs: []string{"a", "b"}, SeriesID: "test"}
+	if group := trainingGroup(tr); group != "test-"+hash(tr.Inputs) {
+		t.Errorf("Expected the group to be made of the series and the hash of the inputs, got %s", group)
+	}
+	tr.TimeFeatures = []string{types.Hour}
+	if group := trainingGroup(tr); group != "test-"+hash([]string{types.Hour, "a", "b"}) {
+		t.Errorf("Expected the derived features to be part of the group, got %s", group)
+	}
+	sel := types.Selection{Labels: map[string]string{"env": "a"}}
+	tr.Selection = sel
+	if group := trainingGroup(tr); group != "test-"+inputsHash([]string{types.Hour, "a", "b"}, sel) || group == "test-"+hash([]string{types.Hour, "a", "b"}) {
+		t.Errorf("Expected the selection to be part of the group, got %s", group)
+	}
+	ranged := tr
+	ranged.Selection = types.Selection{From: 1612706310, Labels: sel.Labels, To: 1612706320}
+	if group := trainingGroup(ranged); group != trainingGroup(tr) {
+		t.Errorf("Expected the range of the selection not to be part of the group, got %s", group)
+	}
+}
+
+func TestProcessLeased(t *testing.T) {
+	q, err := queues.NewFileAdapter(map[string]interface{}{"Path": "."}, 3)
+	if err != nil {
+		t.Fatalf("Failed to initialize training queue (%s)", err.Error())
+	}
+	defer os.RemoveAll(q.Path)
+	nps := paramstores.FileAdapter{Path: "."}
+	conf := config.Config{ML: config.MLParams{Attempts: 1, Lease: 60}}
+	jobs := newTestRegistry(t)
+	tr := types.TrainRequest{ID: "job-1", Inputs: []string{"a"}, Outputs: []string{"b"}, SeriesID: "test"}
+	group := trainingGroup(tr)
+	taken, err := nps.Lease(group, "other", time.Minute)
+	if err != nil || !taken {
+		t.Fatalf("Failed to lease training group (%v)", err)
+	}
+	defer nps.Release(group, "other")
+	jobs.Queue(tr)
+	err = q.Push(tr)
+	if err != nil {
+		t.Fatalf("Failed to push request (%s)", err.Error())
+	}
+
+	item, err := pop(q)
+	if err != nil {
+		t.Fatalf("Failed to pop request (%s)", err.Error())
+	}
+	err = process(q, item, nil, nps, jobs, "self", conf)
+	if err != nil {
+		t.Fatalf("Failed to process request (%s)", err.Error())
+	}
+	if job, _, _ := jobs.Get(tr.ID); job.Status != types.JobQueued {
+		t.Errorf("Expected job %s to stay queued while another instance holds the lease, got %+v", tr.ID, job)
+	}
+	dls, err := q.DeadLetters()
+	if err != nil || len(dls) != 0 {
+		t.Errorf("Expected deferring a request not to count as a failed attempt, got %+v (%v)", dls, err)
+	}
+}
+
+func TestKeepLease(t *testing.T) {
+	q, err := queues.NewFileAdapter(map[string]interface{}{"Path": "."}, 3)
+	if err != nil {
+		t.Fatalf("Failed to initialize training queue (%s)", err.Error())
+	}
+	defer os.RemoveAll(q.Path)
+	nps := paramstores.FileAdapter{Path: "."}
+	group := "test-keep-lease"
+	ttl := 150 * time.Millisecond
+	defer nps.Release(group, "self")
+	defer nps.Release(group, "other")
+	_, err = nps.Lease(group, "self", ttl)
+	if err != nil {
+		t.Fatalf("Failed to lease training group (%s)", err.Error())
+	}
+
+	ctx, stop := keepLease(context.Background(), q, queues.Item{}, nps, group, "self", ttl)
+	time.Sleep(2 * ttl)
+	if taken, _ := nps.Lease(group, "other", ttl); taken {
+		t.Error("Expected the lease to be renewed while it's kept")
+	}
+	if ctx.Err() != nil {
+		t.Error("The context shouldn't be cancelled while the lease is held")
+	}
+	stop()
+	if err := ctx.Err(); err != context.Canceled {
+		t.Errorf("Expected the context to be cancelled as usual once the lease is no longer kept, got %v", err)
+	}
+
+	ctx, stop = keepLease(context.Background(), q, queues.Item{}, nps, group, "self", ttl)
+	defer stop()
+	time.Sleep(2 * ttl)
+	err = nps.Release(group, "self")
+	if err != nil {
+		t.Fatalf("Failed to release training group (%s)", err.Error())
+	}
+	_, err = nps.Lease(group, "other", ttl)
+	if err != nil {
+		t.Fatalf("Failed to lease training group (%s)", err.Error())
+	}
+	select {
+	case <-ctx.Done():
+		if !errors.Is(ctx.Err(), ErrLeaseLost) {
+			t.Errorf("Expected the context to report that the lease was lost, got %v", ctx.Err())
+		}
+	case <-time.After(ttl):
+		t.Error("Expected the context to be cancelled once the lease was taken by another instance")
+	}
+}
+
+func TestLeaseLost(t *testing.T) {
+	q, err := queues.NewFileAdapter(map[string]interface{}{"Path": "."}, 3)
+	if err != nil {
+		t.Fatalf("Failed to initialize training queue (%s)", err.Error())
+	}
+	defer os.RemoveAll(q.Path)
+	ps, _ := pointstores.NewFileAdapter(map[string]interface{}{"Path": "."})
+	seriesID := "test-lease-lost"
+	defer ps.DeleteSeries(seriesID)
+	for i := 0; i < 10; i++ {
+		err := ps.AddPoint(seriesID, pointstores.Point{TimeStamp: int64(1612706310 + i), Values: map[string]float32{"a": float32(i), "b": float32(i)}})
+		if err != nil {
+			t.Fatalf("Failed to add point (%s)", err.Error())
+		}
+	}
+	nps, _ := paramstores.NewFileAdapter(map[string]interface{}{"Path": t.TempDir()}, 0)
+	conf := config.Config{ML: config.MLParams{Attempts: 1, MaxEpoch: 10, MaxHLayers: 1, MinHLayers: 1, TestSet: 0.4, Trials: 1}}
+	jobs := newTestRegistry(t)
+	tr := types.TrainRequest{ID: "job-1", Inputs: []string{"a"}, Outputs: []string{"b"}, Required: 10, SeriesID: seriesID, Strategy: types.RandomSearch}
+	jobs.Queue(tr)
+	err = q.Push(tr)
+	if err != nil {
+		t.Fatalf("Failed to push request (%s)", err.Error())
+	}
+	item, err := pop(q)
+	if err != nil {
+		t.Fatalf("Failed to pop request (%s)", err.Error())
+	}
+
+	// The nets of a job whose lease was lost are discarded and the request is delivered again later
+	jCtx, _ := jobs.start(tr, 0)
+	cCtx, cancel := context.WithCancel(jCtx)
+	lCtx := leaseContext{Context: cCtx, lost: new(int32)}
+	*lCtx.lost = 1
+	cancel()
+	err = train(lCtx, tr, ps, nps, jobs, conf)
+	if !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("Expected training to stop because the lease was lost, got %v", err)
+	}
+	nets, _, err := List(0, 10, "*", nps)
+	if err != nil || len(nets) != 0 {
+		t.Errorf("Expected no nets to be saved after losing the lease, got %+v (%v)", nets, err)
+	}
+	err = settle(q, item, ErrLeaseLost, jobs, conf.ML)
+	if err != nil {
+		t.Fatalf("Failed to settle request (%s)", err.Error())
+	}
+	if job, _, _ := jobs.Get(tr.ID); job.Status != types.JobQueued {
+		t.Errorf("Expected job %s to be queued again after losing the lease, got %+v", tr.ID, job)
+	}
+	item, err = pop(q)
+	if err != nil {
+		t.Fatalf("Failed to pop the deferred request (%s)", err.Error())
+	}
+	if item.Attempts != 0 {
+		t.Errorf("Expected losing the lease not to count as an attempt, got %d", item.Attempts)
+	}
+}
+
+// pop returns the next ready request in the queue or an error if there isn't one within a second
+func pop(q queues.Queue) (queues.Item, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return q.Pop(ctx)
+}
@@ -1,12 +1,14 @@
 package nets
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
 	"math/rand"
-	"time"
 
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
 	"github.com/qvantel/nerd/internal/logger"
 	"github.com/qvantel/nerd/internal/nets/paramstores"
 	"github.com/qvantel/nerd/internal/series/pointstores"
@@ -31,8 +33,20 @@ func MLPTopology(inputs, outputs, hLayers int) []int {
 	return topology
 }
 
-// NewMLP returns a multilayer perceptron net built from scratch with the requested inputs, outputs and hidden layers
-func NewMLP(id string, inputs, outputs []string, chromosome Chromosome) (*MLP, error) {
+// NewMLP returns a multilayer perceptron net built from scratch with the requested inputs, outputs and hidden layers.
+// The norms map can be used to select the normalization scheme of each value (z-score is used when not present)
+func NewMLP(id string, inputs, outputs []string, norms map[string]string, chromosome Chromosome) (*MLP, error) {
+	normalization := map[string]paramstores.NormParams{}
+	for _, label := range append(append([]string{}, inputs...), outputs...) {
+		scheme, ok := norms[label]
+		if !ok {
+			scheme = types.ZScore
+		}
+		if !config.Present(types.Normalizations(), scheme) {
+			return nil, errors.New(scheme + " is not a valid normalization scheme")
+		}
+		normalization[label] = paramstores.NormParams{Center: 0, Scale: 1, Scheme: scheme}
+	}
 	params := paramstores.MLPParams{
 		Accuracy:       -1,
 		ActivationFunc: chromosome.ActivationFunc,
@@ -40,6 +54,7 @@ func NewMLP(id string, inputs, outputs []string, chromosome Chromosome) (*MLP, e
 		ErrMargin:      0,
 		Inputs:         inputs,
 		LearningRate:   chromosome.LearningRate,
+		Normalization:  normalization,
 		Topology:       MLPTopology(len(inputs), len(outputs), chromosome.HLayers),
 		Outputs:        outputs,
 		Weights:        nil,
@@ -87,6 +102,10 @@ func rmse(pairs, neurons int, diffc float32) float32 {
 }
 
 func (net *MLP) normalize(label string, value float32) float32 {
+	if norm, ok := net.params.Normalization[label]; ok {
+		return normalize(norm, value)
+	}
+	// Nets trained before normalization schemes were introduced only have the z-score params
 	avg, ok := net.params.Averages[label]
 	if !ok {
 		return value
@@ -99,6 +118,9 @@ func (net *MLP) normalize(label string, value float32) float32 {
 }
 
 func (net *MLP) denormalize(label string, nValue float32) float32 {
+	if norm, ok := net.params.Normalization[label]; ok {
+		return denormalize(norm, nValue)
+	}
 	avg, ok := net.params.Averages[label]
 	if !ok {
 		return nValue
@@ -117,48 +139,59 @@ func (net *MLP) ID() string {
 
 func (net *MLP) updateNormParams(points []pointstores.Point, tStart, tEnd int) error {
 	// TODO: There should be a check somewhere to ensure these aren't updated when the new data set is smaller
-	nTrain := float32(len(points))
+	nTrain := len(points)
 	if tStart >= 0 {
-		nTrain -= float32(tEnd - tStart)
+		nTrain -= tEnd - tStart
 	}
 	if nTrain <= 1 {
 		logger.Warning("[MLP " + net.id + "] There are not enough patterns to update the net's normalization parameters")
 		return nil // Not strictly an error because this alone would mean no normalization at worst
 	}
-	net.params.Averages = map[string]float32{}
-	net.params.Deviations = map[string]float32{}
-	// Calculate averages
+	// Gather the values of the points that aren't earmarked for testing
+	labels := append(append([]string{}, net.params.Inputs...), net.params.Outputs...)
 	for label := range points[0].Values {
-		net.params.Averages[label] = 0
+		labels = append(labels, label)
+	}
+	values := map[string][]float32{}
+	for _, label := range labels {
+		if _, done := values[label]; done {
+			continue
+		}
+		values[label] = make([]float32, 0, nTrain)
 		for i := 0; i < len(points); i++ {
-			// Skip the points earmarked for testing
 			if i == tStart {
 				i = tEnd - 1 // -1 because i will get a +1 before the next iteration
 				continue
 			}
-			net.params.Averages[label] += points[i].Values[label]
+			values[label] = append(values[label], points[i].Values[label])
 		}
 	}
-	for label := range net.params.Averages {
-		net.params.Averages[label] /= nTrain
+	net.params.Averages = map[string]float32{}
+	net.params.Deviations = map[string]float32{}
+	for label := range values {
+		net.params.Averages[label], net.params.Deviations[label] = meanDev(values[label])
 	}
-	// Calculate standard deviations
-	for label := range points[0].Values {
-		net.params.Deviations[label] = 0
-		for i := 0; i < len(points); i++ {
-			// Skip the points earmarked for testing
-			if i == tStart {
-				i = tEnd - 1 // -1 because i will get a +1 before the next iteration
-				continue
-			}
-			net.params.Deviations[label] += (points[i].Values[label] - net.params.Averages[label]) * (points[i].Values[label] - net.params.Averages[label])
-		}
+	if net.params.Normalization == nil {
+		net.params.Normalization = map[string]paramstores.NormParams{}
 	}
-	for label := range net.params.Deviations {
-		net.params.Deviations[label] = float32(math.Sqrt(float64(net.params.Deviations[label] / (nTrain - 1))))
-		if net.params.Deviations[label] == 0 {
-			return errors.New("the param " + label + " never changes in the training set, normalization won't work")
+	for _, label := range labels[:len(net.params.Inputs)+len(net.params.Outputs)] {
+		scheme := net.params.Normalization[label].Scheme
+		if scheme == types.Excluded {
+			scheme = "" // It might not be constant anymore so reset it to the default
 		}
+		norm, err := newNormParams(scheme, values[label])
+		if err != nil {
+			return err
+		}
+		if norm.Scheme == types.Excluded {
+			logger.Debug("[MLP " + net.id + "] The value " + label + " never changes in the training set, excluding it")
+		}
+		net.params.Normalization[label] = norm
+	}
+	// Kept so that the points the net is used with later on can be compared with the ones it was trained with
+	net.params.Distributions = map[string]types.Distribution{}
+	for _, label := range net.params.Inputs {
+		net.params.Distributions[label] = newDistribution(values[label])
 	}
 	return nil
 }
@@ -170,7 +203,7 @@ func (net *MLP) Params() paramstores.NetParams {
 
 // Train will use the specified input/output pairs to modify the net so the behaviour of its connections is closer to
 // that of the unknown relationships it's intended to mimic
-func (net *MLP) Train(points []pointstores.Point, maxEpoch int, errMargin, testSet, tolerance float32) (float32, error) {
+func (net *MLP) Train(ctx context.Context, points []pointstores.Point, maxEpoch int, errMargin, testSet, tolerance float32) (float32, error) {
 	var diffc float32
 	rmseOld := float32(1.0)
 	rmseNew := float32(-1.0)
@@ -181,7 +214,6 @@ func (net *MLP) Train(points []pointstores.Point, maxEpoch int, errMargin, testS
 	nTest := int(math.Floor(float64(float32(nPoints) * testSet)))
 	logger.Debug(fmt.Sprintf("Training %s with %d patterns, %d of which will be used for testing", net.id, nPoints, nTest))
 	if testSet > 0 {
-		rand.Seed(time.Now().UnixNano())
 		max := nPoints - nTest
 		tStart = rand.Intn(max + 1)
 		tEnd = tStart + nTest
@@ -191,8 +223,18 @@ func (net *MLP) Train(points []pointstores.Point, maxEpoch int, errMargin, testS
 	if err != nil {
 		return 0, err
 	}
+	history := &types.History{Epochs: []types.Epoch{}, Generations: []float32{}}
+	net.params.History = history
 
 	for net.params.Epoch = 0; net.params.Epoch < maxEpoch && float32(math.Abs(1-float64(rmseNew/rmseOld))) >= tolerance; net.params.Epoch++ {
+		if ctx.Err() != nil {
+			logger.Debug(fmt.Sprintf("Training of %s interrupted at epoch %d (%s)", net.id, net.params.Epoch, ctx.Err().Error()))
+			break
+		}
+		report(ctx, func(p *types.Progress) {
+			p.Epoch = net.params.Epoch + 1
+		})
+		var sse float32 // Sum of squared errors over the training set, calculated before each update
 		for i := 0; i < nPoints; i++ {
 			// Skip the points earmarked for testing
 			if i == tStart {
@@ -212,9 +254,18 @@ func (net *MLP) Train(points []pointstores.Point, maxEpoch int, errMargin, testS
 			for label := range outputs {
 				diffc += (outputs[label] - points[i].Values[label]) * (outputs[label] - points[i].Values[label])
 			}
+			sse += diffc
 		}
 		rmseOld = rmseNew
 		rmseNew = rmse(nPoints, net.nCount, diffc)
+		epoch := types.Epoch{Training: finite(sse / float32((nPoints-(tEnd-tStart))*len(net.params.Outputs))), Validation: -1}
+		if tEnd > tStart {
+			epoch.Validation, err = net.mse(points[tStart:tEnd])
+			if err != nil {
+				return 0, err
+			}
+		}
+		history.Epochs = append(history.Epochs, epoch)
 	}
 	net.params.Epoch = 0
 	if testSet <= 0 {
@@ -239,6 +290,30 @@ func (net *MLP) Train(points []pointstores.Point, maxEpoch int, errMargin, testS
 	return net.params.Accuracy, nil
 }
 
+// mse returns the mean squared error of the net's outputs for the given points
+func (net *MLP) mse(points []pointstores.Point) (float32, error) {
+	var sse float32
+	for _, point := range points {
+		outputs, err := net.Evaluate(point.Values)
+		if err != nil {
+			return 0, err
+		}
+		for _, label := range net.params.Outputs {
+			sse += (outputs[label] - point.Values[label]) * (outputs[label] - point.Values[label])
+		}
+	}
+	return finite(sse / float32(len(points)*len(net.params.Outputs))), nil
+}
+
+// finite replaces the NaN and infinite values that a diverging net can produce with the max float so that they can
+// still be stored
+func finite(value float32) float32 {
+	if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+		return math.MaxFloat32
+	}
+	return value
+}
+
 func (net *MLP) addWeight(iL, iN, oN int, weight float32) {
 	section := len(net.neurons[iL]) * oN
 	if iL+1 != len(net.neurons)-1 {
@@ -308,7 +383,6 @@ func generateWeights(neurons []int) [][]float32 {
 		size := (neurons[i] + 1) * neurons[i+1]
 		weights[i] = make([]float32, size)
 		for j := range weights[i] {
-			rand.Seed(time.Now().UnixNano())
 			weights[i][j] = rand.Float32() - 0.5
 		}
 	}
@@ -1,6 +1,7 @@
 package nets
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 
@@ -81,10 +82,14 @@ func TestTrain(t *testing.T) {
 	}
 	want := paramstores.MLPParams{
 		ActivationFunc: types.BipolarSigmoid,
-		Inputs:         []string{"subs", "events"},
-		LearningRate:   0.25,
-		Topology:       []int{2, 2, 1},
-		Outputs:        []string{"size"},
+		History: &types.History{
+			Epochs:      []types.Epoch{{Training: 1.3939153, Validation: -1}},
+			Generations: []float32{},
+		},
+		Inputs:       []string{"subs", "events"},
+		LearningRate: 0.25,
+		Topology:     []int{2, 2, 1},
+		Outputs:      []string{"size"},
 		Weights: [][]float32{
 			{0.43355018, 0.6664498, -0.16644982, 0.6048054, -0.40480542, 0.30480543},
 			{-0.15723555, 0.4650343, 0.18161416},
@@ -95,7 +100,7 @@ func TestTrain(t *testing.T) {
 
 	net, _ := MLPFromParams(t.Name(), params)
 
-	_, err := net.Train([]pointstores.Point{{Values: map[string]float32{"subs": -1, "events": 1, "size": 1}}}, 1, net.params.ErrMargin, 0, 0.1)
+	_, err := net.Train(context.Background(), []pointstores.Point{{Values: map[string]float32{"subs": -1, "events": 1, "size": 1}}}, 1, net.params.ErrMargin, 0, 0.1)
 	if err != nil {
 		t.Fatalf("Failed to execute the training test scenario (%s)", err.Error())
 	}
@@ -113,6 +118,7 @@ func TestTrain2(t *testing.T) {
 		t.Name(),
 		[]string{"value-0", "value-1", "value-2", "value-3", "value-4", "value-5", "value-6", "value-7", "value-8"},
 		[]string{"value-9", "value-10"},
+		nil,
 		Chromosome{
 			ActivationFunc: types.BipolarSigmoid,
 			HLayers:        1,
@@ -128,8 +134,54 @@ func TestTrain2(t *testing.T) {
 		t.Fatalf("Failed to load test data (%s)", err.Error())
 	}
 
-	net.Train(points, 1000, 0.49999999, 0.4, 0.1)
+	net.Train(context.Background(), points, 1000, 0.49999999, 0.4, 0.1)
 	if net.params.Accuracy < 0.9 {
 		t.Errorf("Expected at least 90 percent accuracy for this test data, got: %f", net.params.Accuracy)
 	}
+	epochs := net.params.History.Epochs
+	if len(epochs) == 0 {
+		t.Fatal("Expected the loss of each epoch to be recorded")
+	}
+	for i, epoch := range epochs {
+		if epoch.Training < 0 || epoch.Validation < 0 {
+			t.Errorf("Expected positive training and validation losses, got %f and %f in epoch %d", epoch.Training, epoch.Validation, i)
+		}
+	}
+	if len(epochs) > 1 && epochs[len(epochs)-1].Training >= epochs[0].Training {
+		t.Errorf("Expected the training loss to go down, got %f in the first epoch and %f in the last", epochs[0].Training, epochs[len(epochs)-1].Training)
+	}
+}
+
+func TestTrainConstant(t *testing.T) {
+	net, _ := NewMLP(
+		t.Name(),
+		[]string{"subs", "static"},
+		[]string{"size"},
+		map[string]string{"subs": types.MinMax, "size": types.Robust},
+		Chromosome{
+			ActivationFunc: types.BipolarSigmoid,
+			HLayers:        1,
+			LearningRate:   0.01,
+		},
+	)
+
+	points := []pointstores.Point{}
+	for i := 0; i < 20; i++ {
+		points = append(points, pointstores.Point{Values: map[string]float32{
+			"subs":   float32(i),
+			"static": 42,
+			"size":   float32(i * 2),
+		}})
+	}
+	_, err := net.Train(context.Background(), points, 100, 1, 0.2, 0.1)
+	if err != nil {
+		t.Fatalf("Training with a constant value shouldn't fail (%s)", err.Error())
+	}
+	schemes := net.Params().Brief().Normalization
+	if schemes["static"] != types.Excluded {
+		t.Errorf("Expected static to be excluded, got %s instead", schemes["static"])
+	}
+	if schemes["subs"] != types.MinMax || schemes["size"] != types.Robust {
+		t.Errorf("Expected the requested schemes to be kept, got %v instead", schemes)
+	}
 }
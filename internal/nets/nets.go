@@ -2,19 +2,30 @@
 package nets
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"math"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/qvantel/nerd/api/types"
 	"github.com/qvantel/nerd/internal/config"
 	"github.com/qvantel/nerd/internal/logger"
 	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/nets/queues"
+	"github.com/qvantel/nerd/internal/resilience"
 	"github.com/qvantel/nerd/internal/series/pointstores"
 )
 
+// DriftRecord is the kind of auxiliary record that holds the drift scores of a net
+const DriftRecord = "drift"
+
 // Network represents the neural net implementation that is being used
 type Network interface {
 	// This method should return the outputs of feeding the specified inputs into the net
@@ -23,20 +34,44 @@ type Network interface {
 	// This method should return the net's params
 	Params() paramstores.NetParams
 	// This method should feed the training pairs into the net and update its params in the net param storage when
-	// it's done
-	Train(points []pointstores.Point, maxEpoch int, errMargin, testSet, tolerance float32) (float32, error)
+	// it's done. If the context is cancelled, training should stop early but the net should still be tested
+	Train(ctx context.Context, points []pointstores.Point, maxEpoch int, errMargin, testSet, tolerance float32) (float32, error)
 }
 
 // NewNetwork returns an initialized neural network of the type specified in the configuration
-func NewNetwork(id string, inputs, outputs []string, chromosome Chromosome) (Network, error) {
+func NewNetwork(id string, inputs, outputs []string, norms map[string]string, chromosome Chromosome) (Network, error) {
 	switch chromosome.Type {
 	case types.MultilayerPerceptron:
-		return NewMLP(id, inputs, outputs, chromosome)
+		return NewMLP(id, inputs, outputs, norms, chromosome)
 	default:
 		return nil, errors.New(chromosome.Type + " is not a valid net type")
 	}
 }
 
+// Delete removes a net from the store along with all its auxiliary records and the aliases that point to it
+func Delete(id string, nps paramstores.NetParamStore) error {
+	err := nps.Delete(id)
+	if err != nil {
+		return err
+	}
+	err = deleteNetAliases(id, nps)
+	if err != nil {
+		return err
+	}
+	for _, kind := range []string{ChallengerRecord, DriftRecord, PromotionsRecord} {
+		err = nps.Delete(paramstores.AuxID(id, kind))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SeriesPattern returns the glob pattern that matches the IDs of all the nets created from the given series
+func SeriesPattern(seriesID string) string {
+	return seriesID + "-????????????????????????????????????????-????????????????????????????????????????-*"
+}
+
 // List encapsulates the logic required to fill in BriefNet objects from the IDs of nets in the store
 func List(offset, limit int, pattern string, nps paramstores.NetParamStore) ([]types.BriefNet, int, error) {
 	nets := []types.BriefNet{}
@@ -89,6 +124,61 @@ func LoadNetwork(id, nType string, nps paramstores.NetParamStore) (Network, erro
 	}
 }
 
+// LoadVersion works like LoadNetwork but retrieves a specific version of the net
+func LoadVersion(id, nType string, version int, nps paramstores.NetParamStore) (Network, error) {
+	switch nType {
+	case types.MultilayerPerceptron:
+		var np paramstores.MLPParams
+		found, err := nps.LoadVersion(id, version, &np)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, nil
+		}
+		return MLPFromParams(id, np)
+	default:
+		return nil, errors.New(nType + " is not a valid net type")
+	}
+}
+
+// ListVersions returns the stored versions of a net, oldest first
+func ListVersions(id, nType string, nps paramstores.NetParamStore) ([]types.NetVersion, error) {
+	versions, err := nps.ListVersions(id)
+	if err != nil {
+		return nil, err
+	}
+	res := []types.NetVersion{}
+	for _, version := range versions {
+		net, err := LoadVersion(id, nType, version, nps)
+		if err != nil {
+			return nil, err
+		}
+		if net == nil {
+			continue // Removed in the meantime
+		}
+		brief := net.Params().Brief()
+		brief.ID = id
+		res = append(res, types.NetVersion{BriefNet: *brief, Version: version})
+	}
+	return res, nil
+}
+
+// Rollback replaces the current params of a net with those of one of its versions, returns false if the version
+// can't be found
+func Rollback(id, nType string, version int, nps paramstores.NetParamStore) (bool, error) {
+	net, err := LoadVersion(id, nType, version, nps)
+	if err != nil || net == nil {
+		return false, err
+	}
+	err = nps.Save(id, net.Params())
+	if err != nil {
+		return false, err
+	}
+	// The drift scores of the replaced params don't apply anymore
+	return true, nps.Delete(paramstores.AuxID(id, DriftRecord))
+}
+
 // Required returns the number of patterns required to train a net (of the most demanding type) with the specified
 // topology
 func Required(inputs, outputs, hLayers int, conf config.Config) int {
@@ -115,46 +205,270 @@ func Required(inputs, outputs, hLayers int, conf config.Config) int {
 	return int(math.Ceil(float64(w) / 0.1))
 }
 
-// Trainer listens for requests to train neural nets with new points
-func Trainer(c chan types.TrainRequest, conf config.Config) error {
-	// Set up net param store
-	nps, err := paramstores.New(conf)
+// Trainer takes the requests in the training queue and trains them on the workers of the pool until the context is
+// done, after which the requests that haven't started are put back in the queue. Requests that fail are retried with
+// an increasing delay and, after the configured number of attempts, moved to the dead-letter list. The calls to the
+// stores go through the given breakers so that store errors only affect the jobs that run into them
+func Trainer(ctx context.Context, q queues.Queue, jobs *Registry, pool *Pool, bs *resilience.Breakers, conf config.Config) error {
+	nps, ps, ok := connect(ctx, bs, conf)
+	if !ok {
+		return nil
+	}
+	q = resilience.TrainingQueue(q, bs)
+	logger.Info(fmt.Sprintf("Training service initialized with %d workers", len(pool.workers)))
+	owner := instanceID()
+	var wg sync.WaitGroup
+	for worker := range pool.workers {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for {
+				item, ok := pool.next(ctx, worker)
+				if !ok {
+					return
+				}
+				err := process(q, item, ps, nps, jobs, owner, conf)
+				pool.done(worker)
+				if err != nil {
+					// The request will be delivered again
+					logger.Error("Failed to update the training queue for job "+item.Request.ID, err)
+				}
+			}
+		}(worker)
+	}
+	// Requests waiting for a worker are still in-flight as far as the queue is concerned
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(time.Duration(conf.ML.Lease) * time.Second / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, item := range pool.waiting() {
+				err := q.Extend(item)
+				if err != nil {
+					logger.Error("Failed to extend the delivery of job "+item.Request.ID, err)
+				}
+			}
+		}
+	}()
+	for pool.room(ctx) {
+		item, err := q.Pop(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			logger.Error("Failed to get the next request from the training queue", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		if item.Request.ID == "" {
+			item.Request.ID = uuid.New().String()
+		}
+		pool.add(item)
+	}
+	wg.Wait()
+	for _, item := range pool.drain() {
+		err := q.Defer(item, 0)
+		if err != nil {
+			logger.Error("Failed to put job "+item.Request.ID+" back in the training queue", err)
+		}
+	}
+	logger.Info("Training service stopped")
+	return nil
+}
+
+// connect sets up the stores used by the training service, retrying until it succeeds or the context is done (in
+// which case false is returned)
+func connect(ctx context.Context, bs *resilience.Breakers, conf config.Config) (paramstores.NetParamStore, pointstores.PointStore, bool) {
+	var (
+		nps paramstores.NetParamStore
+		ps  pointstores.PointStore
+	)
+	for {
+		if nps == nil {
+			err := bs.Do(resilience.Params, func() error {
+				store, err := paramstores.New(conf)
+				if err == nil {
+					nps = store
+				}
+				return err
+			})
+			if err != nil {
+				logger.Error("Failed to initialize net param store for the training service", err)
+			}
+		}
+		if ps == nil {
+			err := bs.Do(resilience.Points, func() error {
+				store, err := pointstores.New(conf)
+				if err == nil {
+					ps = store
+				}
+				return err
+			})
+			if err != nil {
+				logger.Error("Failed to initialize point store for the training service", err)
+			}
+		}
+		if nps != nil && ps != nil {
+			return resilience.NetParamStore(nps, bs), resilience.PointStore(ps, bs), true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, false
+		case <-time.After(time.Duration(conf.ML.BreakerCool) * time.Second):
+		}
+	}
+}
+
+// process trains the nets of a delivered request while holding the lease of its group, so that no other instance
+// trains the same group at the same time. If another instance holds it, the request is put back in the queue until
+// the lease would have expired
+func process(q queues.Queue, item queues.Item, ps pointstores.PointStore, nps paramstores.NetParamStore, jobs *Registry, owner string, conf config.Config) error {
+	tr := item.Request
+	ttl := time.Duration(conf.ML.Lease) * time.Second
+	group := trainingGroup(tr)
+	taken, err := nps.Lease(group, owner, ttl)
 	if err != nil {
-		logger.Error("Failed to initialize net param store for the training service", err)
-		return err
+		logger.Error("Failed to lease training group "+group, err)
+		return settle(q, item, err, jobs, conf.ML)
+	}
+	if !taken {
+		logger.Info("Group " + group + " is being trained by another instance, deferring job " + tr.ID)
+		return q.Defer(item, ttl)
+	}
+	defer func() {
+		err := nps.Release(group, owner)
+		if err != nil {
+			logger.Error("Failed to release the lease of training group "+group, err)
+		}
+	}()
+	jCtx, ok := jobs.start(tr, budget(tr, conf.ML))
+	if !ok {
+		logger.Info("Skipping job " + tr.ID + " as it was cancelled before it started")
+		return q.Ack(item)
 	}
-	// Set up point store
-	ps, err := pointstores.New(conf)
+	lCtx, stop := keepLease(jCtx, q, item, nps, group, owner, ttl)
+	err = train(lCtx, tr, ps, nps, jobs, conf)
+	stop()
+	return settle(q, item, err, jobs, conf.ML)
+}
+
+// settle acknowledges, retries or buries a delivered request depending on the error returned by its processing
+func settle(q queues.Queue, item queues.Item, err error, jobs *Registry, params config.MLParams) error {
+	id := item.Request.ID
+	if err == nil {
+		jobs.finish(id, nil)
+		return q.Ack(item)
+	}
+	if errors.Is(err, ErrLeaseLost) && jobs.requeue(id, err) {
+		// Whoever took the lease might be training the same request, so it waits for the lease like any other
+		delay := time.Duration(params.Lease) * time.Second
+		logger.Warning(fmt.Sprintf("Job %s lost the lease of its group, trying again in %s", id, delay))
+		return q.Defer(item, delay)
+	}
+	if errors.Is(err, resilience.ErrOpen) && jobs.requeue(id, err) {
+		// Not the request's fault, so it doesn't count as an attempt
+		delay := time.Duration(params.BreakerCool) * time.Second
+		logger.Warning(fmt.Sprintf("Job %s couldn't reach a store (%s), trying again in %s", id, err.Error(), delay))
+		return q.Defer(item, delay)
+	}
+	if item.Attempts+1 < params.Attempts && jobs.requeue(id, err) {
+		delay := queues.Backoff(time.Duration(params.Backoff)*time.Second, item.Attempts)
+		logger.Warning(fmt.Sprintf("Job %s failed (%s), retrying in %s", id, err.Error(), delay))
+		return q.Retry(item, delay)
+	}
+	logger.Error(fmt.Sprintf("Job %s failed %d times, moving it to the dead-letter list", id, item.Attempts+1), err)
+	jobs.finish(id, err)
+	return q.Bury(item, err.Error())
+}
+
+// train processes a single training request, if the context is cancelled the best nets found so far will be saved
+// and the rest of the outputs will be skipped. If it's cancelled because the lease of the group was lost, nothing is
+// saved and ErrLeaseLost is returned
+func train(ctx context.Context, tr types.TrainRequest, ps pointstores.PointStore, nps paramstores.NetParamStore, jobs *Registry, conf config.Config) error {
+	// Get points (plus the ones that only serve as history for the lags and windows)
+	points, err := ps.GetLastNSelected(tr.SeriesID, tr.Selection, tr.Required+historyDepth(tr.Lags, tr.Windows))
 	if err != nil {
-		logger.Error("Failed to initialize point store for the training service", err)
+		logger.Error("Error retrieving points from store for series "+tr.SeriesID, err)
 		return err
 	}
-	logger.Info("Training service initialized")
-	for tr := range c {
-		group := tr.SeriesID + "-" + hash(tr.Inputs)
-		logger.Info("Training group " + group)
-		// Get points
-		points, err := ps.GetLastN(tr.SeriesID, nil, tr.Required)
+	// Derived features are treated as any other input from here on
+	var features *types.Features
+	points, tr.Inputs, features = deriveFeatures(tr, points)
+	group := tr.SeriesID + "-" + inputsHash(tr.Inputs, tr.Selection)
+	logger.Info("Training group " + group + " (job " + tr.ID + ")")
+	// Build and train nets (1 per output)
+	for index := range tr.Outputs {
+		if errors.Is(ctx.Err(), ErrLeaseLost) {
+			return ctx.Err()
+		}
+		if ctx.Err() != nil {
+			logger.Info("Job " + tr.ID + " stopped (" + ctx.Err().Error() + "), skipping the remaining outputs of group " + group)
+			return nil
+		}
+		strategy, err := NewStrategy(tr.Strategy, conf.ML)
+		if err != nil {
+			logger.Error("Error initializing search strategy for "+tr.SeriesID, err)
+			jobs.fail(tr.ID, err)
+			return nil
+		}
+		outputs := tr.Outputs[index : index+1]
+		report(ctx, func(p *types.Progress) {
+			*p = types.Progress{Output: tr.Outputs[index]}
+		})
+		champ, err := champion(tr, outputs, nps)
 		if err != nil {
-			logger.Error("Error retrieving points from store for series "+tr.SeriesID, err)
+			logger.Error("Error loading the current net from "+tr.SeriesID+" for "+tr.Outputs[index], err)
 			return err
 		}
-		// Build and train nets (1 per output)
-		for index := range tr.Outputs {
-			pop := NewPopulation(conf.ML)
-			net, err := pop.Optimal(tr, tr.Outputs[index:index+1], points)
-			if err != nil {
-				logger.Error("Error training net from "+tr.SeriesID+" for "+tr.Outputs[index], err)
-				continue // We can't kill the whole service every time training fails
-			}
-			err = nps.Save(net.ID(), net.Params())
+		// When retraining, the most recent points are kept out of training so both nets can be compared fairly
+		tPoints, holdout := points, []pointstores.Point{}
+		if champ != nil && conf.ML.Holdout > 0 {
+			tPoints, holdout = splitHoldout(points, conf.ML.Holdout)
+		}
+		net, trials, err := strategy.Search(ctx, tr, outputs, tPoints)
+		jobs.searched(tr.ID, tr.Outputs[index], trials)
+		if errors.Is(ctx.Err(), ErrLeaseLost) {
+			logger.Warning("Job " + tr.ID + " lost the lease of group " + group + ", discarding the net trained for " + tr.Outputs[index])
+			return ctx.Err()
+		}
+		if err != nil {
+			logger.Error("Error training net from "+tr.SeriesID+" for "+tr.Outputs[index], err)
+			jobs.fail(tr.ID, errors.New(tr.Outputs[index]+": "+err.Error()))
+			continue // We can't kill the whole service every time training fails
+		}
+		logTrials(net.ID(), trials)
+		if features != nil {
+			*net.Params().DerivedFeatures() = *features
+		}
+		if !tr.Selection.Empty() {
+			*net.Params().PointSelection() = tr.Selection
+		}
+		promoted, err := promote(net, champ, holdout, tr.ErrMargin, nps, conf)
+		if err != nil {
+			logger.Error("Error saving net", err)
+			return err
+		}
+		if promoted {
+			// The previous drift scores don't apply to the new version of the net
+			err = nps.Delete(paramstores.AuxID(net.ID(), DriftRecord))
 			if err != nil {
-				logger.Error("Error saving net", err)
-				return err
+				logger.Error("Error deleting the drift record of "+net.ID(), err)
 			}
 		}
-		logger.Info("Training for group " + group + " completed")
+		brief := net.Params().Brief()
+		brief.ID = net.ID()
+		jobs.record(tr.ID, *brief)
 	}
+	logger.Info("Training for group " + group + " completed")
 	return nil
 }
 
@@ -168,6 +482,49 @@ func ID2Type(id string) (string, error) {
 	return parts[len(parts)-1], nil
 }
 
+// logTrials reports the history of configurations that were evaluated in the search for the given net
+func logTrials(id string, trials []types.Trial) {
+	best := -1
+	for i, trial := range trials {
+		if trial.Error != "" {
+			logger.Debug(fmt.Sprintf(
+				"[%s] Trial %d: type = %s, activation = %s, hidden layers = %d, learning rate = %f, failed (%s)",
+				id, i, trial.Type, trial.ActivationFunc, trial.HLayers, trial.LearningRate, trial.Error,
+			))
+			continue
+		}
+		logger.Debug(fmt.Sprintf(
+			"[%s] Trial %d: type = %s, activation = %s, hidden layers = %d, learning rate = %f, fitness = %f",
+			id, i, trial.Type, trial.ActivationFunc, trial.HLayers, trial.LearningRate, trial.Fitness,
+		))
+		if best == -1 || trial.Fitness > trials[best].Fitness {
+			best = i
+		}
+	}
+	if best == -1 {
+		return
+	}
+	logger.Info(fmt.Sprintf("[%s] Evaluated %d configurations, best fitness %f (trial %d)", id, len(trials), trials[best].Fitness, best))
+}
+
+// inputsHash returns the part of the net IDs (and training groups) that identifies the inputs of a request, which
+// also covers the labels of the selection (if any) so that the nets trained on different slices of a series don't
+// replace each other. The range is left out, training the same slice on newer points replaces the net like any other
+// retraining
+func inputsHash(inputs []string, sel types.Selection) string {
+	if len(sel.Labels) == 0 {
+		return hash(inputs)
+	}
+	raw, _ := json.Marshal(sel.Labels) // The keys are sorted so the result is stable
+	return hash([]string{hash(inputs), "@", string(raw)})
+}
+
+// NetID returns the ID of the net of the given type that a training request produces for one of its outputs
+func NetID(tr types.TrainRequest, output, nType string) string {
+	_, inputs, _ := deriveFeatures(tr, nil)
+	return tr.SeriesID + "-" + inputsHash(inputs, tr.Selection) + "-" + hash([]string{output}) + "-" + nType
+}
+
 // hash takes a list of SORTED strings and returns its hash
 func hash(keys []string) string {
 	hash := sha1.New()
@@ -0,0 +1,147 @@
+package nets
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+)
+
+// distributionBins is the number of bins used for describing how the inputs of a net were spread in its training set
+const distributionBins = 10
+
+// newDistribution splits the given values into bins that hold the same number of them (roughly, as repeated values
+// always end up in the same bin)
+func newDistribution(values []float32) types.Distribution {
+	dist := types.Distribution{Edges: []float32{}, Fractions: []float32{}}
+	if len(values) == 0 {
+		return dist
+	}
+	sorted := make([]float32, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for i := 1; i < distributionBins; i++ {
+		edge := sorted[i*len(sorted)/distributionBins]
+		if edge > sorted[0] && (len(dist.Edges) == 0 || edge > dist.Edges[len(dist.Edges)-1]) {
+			dist.Edges = append(dist.Edges, edge)
+		}
+	}
+	counts := make([]int, len(dist.Edges)+1)
+	for _, value := range values {
+		counts[dist.Bin(value)]++
+	}
+	for _, count := range counts {
+		dist.Fractions = append(dist.Fractions, float32(count)/float32(len(values)))
+	}
+	return dist
+}
+
+// newNormParams calculates the coefficients that the given scheme requires to normalize the provided set of values. If
+// all the values are the same, the scheme will be replaced with types.Excluded as they carry no information
+func newNormParams(scheme string, values []float32) (paramstores.NormParams, error) {
+	if scheme == "" {
+		scheme = types.ZScore
+	}
+	np := paramstores.NormParams{Center: 0, Scale: 1, Scheme: scheme}
+	if len(values) == 0 {
+		return np, errors.New("at least one value is required to calculate the normalization params")
+	}
+	min, max := values[0], values[0]
+	for _, value := range values[1:] {
+		min = float32(math.Min(float64(min), float64(value)))
+		max = float32(math.Max(float64(max), float64(value)))
+	}
+	if min == max {
+		np.Center, np.Scheme = min, types.Excluded
+		return np, nil
+	}
+
+	switch scheme {
+	case types.ZScore:
+		np.Center, np.Scale = meanDev(values)
+	case types.MinMax:
+		// The bipolar sigmoid ranges from -1 to 1 so the values are mapped to that range instead of the usual 0 to 1
+		np.Center, np.Scale = (max+min)/2, (max-min)/2
+	case types.Robust:
+		sorted := make([]float32, len(values))
+		copy(sorted, values)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		np.Center = quantile(sorted, 0.5)
+		np.Scale = quantile(sorted, 0.75) - quantile(sorted, 0.25)
+		if np.Scale == 0 {
+			// Most values are the same so the IQR is useless, fall back to the range to avoid dividing by 0
+			np.Scale = (max - min) / 2
+		}
+	case types.LogNorm:
+		logs := make([]float32, len(values))
+		for i, value := range values {
+			logs[i] = logTransform(value)
+		}
+		np.Center, np.Scale = meanDev(logs)
+	case types.NoNorm:
+	default:
+		return np, errors.New(scheme + " is not a valid normalization scheme")
+	}
+	return np, nil
+}
+
+// normalize applies the given normalization params to a value
+func normalize(np paramstores.NormParams, value float32) float32 {
+	switch np.Scheme {
+	case types.Excluded:
+		return 0
+	case types.LogNorm:
+		value = logTransform(value)
+	}
+	return (value - np.Center) / np.Scale
+}
+
+// denormalize reverts the normalization of a value
+func denormalize(np paramstores.NormParams, nValue float32) float32 {
+	switch np.Scheme {
+	case types.Excluded:
+		return np.Center
+	case types.LogNorm:
+		y := float64(nValue*np.Scale + np.Center)
+		if y < 0 {
+			return float32(-math.Expm1(-y))
+		}
+		return float32(math.Expm1(y))
+	}
+	return nValue*np.Scale + np.Center
+}
+
+// logTransform is a log that is defined for the whole real line and symmetric around 0
+func logTransform(value float32) float32 {
+	if value < 0 {
+		return float32(-math.Log1p(float64(-value)))
+	}
+	return float32(math.Log1p(float64(value)))
+}
+
+// meanDev returns the mean and (sample) standard deviation of a set of values
+func meanDev(values []float32) (float32, float32) {
+	var mean, dev float32
+	for _, value := range values {
+		mean += value
+	}
+	mean /= float32(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+	for _, value := range values {
+		dev += (value - mean) * (value - mean)
+	}
+	return mean, float32(math.Sqrt(float64(dev / float32(len(values)-1))))
+}
+
+// quantile returns the q quantile of an already sorted set of values using linear interpolation
+func quantile(sorted []float32, q float64) float32 {
+	pos := q * float64(len(sorted)-1)
+	low := int(math.Floor(pos))
+	high := int(math.Ceil(pos))
+	frac := float32(pos - float64(low))
+	return sorted[low] + (sorted[high]-sorted[low])*frac
+}
@@ -0,0 +1,82 @@
+package nets
+
+import (
+	"math"
+	"testing"
+
+	"github.com/qvantel/nerd/api/types"
+)
+
+func TestNewNormParams(t *testing.T) {
+	values := []float32{1, 2, 3, 4, 100}
+	for _, scheme := range types.Normalizations() {
+		np, err := newNormParams(scheme, values)
+		if err != nil {
+			t.Fatalf("Failed to calculate %s normalization params (%s)", scheme, err.Error())
+		}
+		if np.Scheme != scheme {
+			t.Errorf("Expected the %s scheme to be kept, got %s instead", scheme, np.Scheme)
+		}
+		for _, value := range values {
+			got := denormalize(np, normalize(np, value))
+			if math.Abs(float64(got-value)) > 0.001*math.Max(1, math.Abs(float64(value))) {
+				t.Errorf("Denormalizing a %s normalized value should return the original, expected %f got %f", scheme, value, got)
+			}
+		}
+	}
+
+	np, _ := newNormParams(types.MinMax, values)
+	if normalize(np, 1) != -1 || normalize(np, 100) != 1 {
+		t.Errorf("Min-max should map values to [-1, 1], got %f and %f", normalize(np, 1), normalize(np, 100))
+	}
+	np, _ = newNormParams(types.Robust, values)
+	if np.Center != 3 || np.Scale != 2 {
+		t.Errorf("Robust normalization should use the median (3) and IQR (2), got %f and %f", np.Center, np.Scale)
+	}
+
+	_, err := newNormParams("invalid-scheme", values)
+	if err == nil {
+		t.Error("An invalid normalization scheme didn't return an error")
+	}
+}
+
+func TestNewNormParamsConstant(t *testing.T) {
+	np, err := newNormParams(types.ZScore, []float32{5, 5, 5})
+	if err != nil {
+		t.Fatalf("Constant values shouldn't result in an error (%s)", err.Error())
+	}
+	if np.Scheme != types.Excluded {
+		t.Fatalf("Constant values should be excluded, got scheme %s instead", np.Scheme)
+	}
+	if normalize(np, 7) != 0 {
+		t.Errorf("Excluded values should always be normalized to 0, got %f", normalize(np, 7))
+	}
+	if denormalize(np, 0.3) != 5 {
+		t.Errorf("Excluded values should always be denormalized to the constant, got %f", denormalize(np, 0.3))
+	}
+}
+
+func TestNewDistribution(t *testing.T) {
+	values := []float32{}
+	for i := 0; i < 100; i++ {
+		values = append(values, float32(i))
+	}
+	dist := newDistribution(values)
+	if len(dist.Edges) != distributionBins-1 || len(dist.Fractions) != distributionBins {
+		t.Fatalf("Expected %d bins, got %+v", distributionBins, dist)
+	}
+	for i, fraction := range dist.Fractions {
+		if fraction != 0.1 {
+			t.Errorf("Expected bin %d to hold a tenth of the values, got %f", i, fraction)
+		}
+	}
+
+	// Repeated values can't be split so they end up in fewer, uneven bins
+	dist = newDistribution([]float32{1, 1, 1, 1, 1, 1, 1, 1, 2, 3})
+	if len(dist.Edges) != 2 || dist.Fractions[0] != 0.8 || dist.Fractions[1] != 0.1 || dist.Fractions[2] != 0.1 {
+		t.Errorf("Expected the repeated values to share a bin, got %+v", dist)
+	}
+	if dist = newDistribution([]float32{5, 5, 5}); len(dist.Edges) != 0 || len(dist.Fractions) != 1 {
+		t.Errorf("Expected constant values to have a single bin, got %+v", dist)
+	}
+}
@@ -4,23 +4,52 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// leaseMu serializes the changes to the leases of every file store in the process
+var leaseMu sync.Mutex
+
+// fileLease is the content of a lease file
+type fileLease struct {
+	Expires int64  `json:"expires"` // Unix timestamp in milliseconds
+	Owner   string `json:"owner"`
+}
+
 // FileAdapter is a neural net param store implementation that uses the filesystem, its main purpose is to facilitate
 // testing. Given its low performance it is strongly discouraged for production use
 type FileAdapter struct {
-	Path string
+	Path     string
+	Versions int // Number of versions to keep for each net (0 means all of them)
 }
 
 // NewFileAdapter returns an initialized file net param store object
-func NewFileAdapter(conf map[string]interface{}) (*FileAdapter, error) {
-	return &FileAdapter{Path: conf["Path"].(string)}, nil
+func NewFileAdapter(conf map[string]interface{}, versions int) (*FileAdapter, error) {
+	return &FileAdapter{Path: conf["Path"].(string), Versions: versions}, nil
 }
 
-// Delete can be used to delete the state of a specific neural net from a file on disk
+// Delete can be used to delete the state of a specific neural net (and all its versions) from the disk
 func (fa FileAdapter) Delete(id string) error {
-	err := os.Remove(fa.Path + "/" + id)
+	versions, err := fa.ListVersions(id)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		err = fa.remove(versionID(id, version))
+		if err != nil {
+			return err
+		}
+	}
+	return fa.remove(id)
+}
+
+// remove deletes a single file, it's not considered an error if it doesn't exist
+func (fa FileAdapter) remove(name string) error {
+	err := os.Remove(fa.Path + "/" + name)
 	if os.IsNotExist(err) {
 		return nil
 	} else if err != nil {
@@ -29,6 +58,24 @@ func (fa FileAdapter) Delete(id string) error {
 	return nil
 }
 
+// Lease can be used to take or renew the lease of a training group, which is kept in a file along with its expiry.
+// Only instances sharing the same process are coordinated
+func (fa FileAdapter) Lease(group, owner string, ttl time.Duration) (bool, error) {
+	leaseMu.Lock()
+	defer leaseMu.Unlock()
+	var current fileLease
+	found, err := fa.Load(leaseID(group), JSON{Value: &current})
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if found && current.Owner != owner && current.Expires > now.UnixNano()/int64(time.Millisecond) {
+		return false, nil
+	}
+	expires := now.Add(ttl).UnixNano() / int64(time.Millisecond)
+	return true, fa.Save(leaseID(group), JSON{Value: fileLease{Expires: expires, Owner: owner}})
+}
+
 // List can be used to get the IDs of the stored nets
 func (fa FileAdapter) List(offset, limit int, pattern string) ([]string, int, error) {
 	files, err := ioutil.ReadDir(fa.Path)
@@ -44,7 +91,7 @@ func (fa FileAdapter) List(offset, limit int, pattern string) ([]string, int, er
 		if err != nil {
 			return nil, 0, err
 		}
-		if !match {
+		if !match || isAux(file.Name()) {
 			continue
 		}
 		parts := strings.Split(file.Name(), "-")
@@ -56,8 +103,46 @@ func (fa FileAdapter) List(offset, limit int, pattern string) ([]string, int, er
 	return ids, 0, nil
 }
 
+// ListAux can be used to get the IDs that have an auxiliary record of the given kind
+func (fa FileAdapter) ListAux(kind string) ([]string, error) {
+	files, err := ioutil.ReadDir(fa.Path)
+	if err != nil {
+		return nil, err
+	}
+	suffix := AuxID("", kind)
+	ids := []string{}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), suffix) {
+			ids = append(ids, strings.TrimSuffix(file.Name(), suffix))
+		}
+	}
+	return ids, nil
+}
+
+// ListVersions can be used to get the numbers of the stored versions of a net
+func (fa FileAdapter) ListVersions(id string) ([]int, error) {
+	files, err := ioutil.ReadDir(fa.Path)
+	if err != nil {
+		return nil, err
+	}
+	prefix := AuxID(id, "v")
+	versions := []int{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasPrefix(file.Name(), prefix) {
+			continue
+		}
+		version, err := strconv.Atoi(file.Name()[len(prefix):])
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
 // Load can be used to retrieve the state of a specific neural net from a file on disk
-func (fa FileAdapter) Load(id string, np NetParams) (bool, error) {
+func (fa FileAdapter) Load(id string, np Storable) (bool, error) {
 	value, err := ioutil.ReadFile(fa.Path + "/" + id)
 	if os.IsNotExist(err) {
 		return false, nil
@@ -68,21 +153,69 @@ func (fa FileAdapter) Load(id string, np NetParams) (bool, error) {
 	return true, err
 }
 
-// Save can be used to upsert the state of a specific neural net to a file on disk
-func (fa FileAdapter) Save(id string, np NetParams) error {
+// LoadVersion can be used to retrieve a specific version of a neural net from a file on disk
+func (fa FileAdapter) LoadVersion(id string, version int, np Storable) (bool, error) {
+	return fa.Load(versionID(id, version), np)
+}
+
+// Release can be used to give up the lease of a training group
+func (fa FileAdapter) Release(group, owner string) error {
+	leaseMu.Lock()
+	defer leaseMu.Unlock()
+	var current fileLease
+	found, err := fa.Load(leaseID(group), JSON{Value: &current})
+	if err != nil || !found || current.Owner != owner {
+		return err
+	}
+	return fa.remove(leaseID(group))
+}
+
+// Save can be used to upsert the state of a specific neural net to a file on disk, through a temporary file so that
+// it's never read half written
+func (fa FileAdapter) Save(id string, np Storable) error {
 	value, err := np.Marshal()
 	if err != nil {
 		return err
 	}
-	f, err := os.Create(fa.Path + "/" + id)
+	f, err := ioutil.TempFile(fa.Path, ".tmp-*")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer os.Remove(f.Name())
 	_, err = f.WriteString(string(value))
 	if err != nil {
+		f.Close()
 		return err
 	}
 	f.Sync()
-	return nil
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fa.Path+"/"+id)
+}
+
+// SaveVersion can be used to store the state of a neural net as a new version in a file on disk
+func (fa FileAdapter) SaveVersion(id string, np Storable) (int, error) {
+	versions, err := fa.ListVersions(id)
+	if err != nil {
+		return 0, err
+	}
+	version := 1
+	if len(versions) > 0 {
+		version = versions[len(versions)-1] + 1
+	}
+	err = fa.Save(versionID(id, version), np)
+	if err != nil {
+		return 0, err
+	}
+	versions = append(versions, version)
+	for fa.Versions > 0 && len(versions) > fa.Versions {
+		err = fa.remove(versionID(id, versions[0]))
+		if err != nil {
+			return 0, err
+		}
+		versions = versions[1:]
+	}
+	return version, nil
 }
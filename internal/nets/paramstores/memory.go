@@ -0,0 +1,261 @@
+package paramstores
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStores holds the memory stores of the process by snapshot path, so that every part of the service that creates
+// a store gets the same one
+var (
+	memoryStores   = map[string]*MemoryAdapter{}
+	memoryStoresMu sync.Mutex
+)
+
+// MemoryAdapter is a neural net param store implementation that keeps every record in memory, its main purpose is to
+// facilitate local development and testing. If a snapshot path is configured, the records are loaded from it when the
+// store is created and written back to it by SaveSnapshots (which is called when the service shuts down)
+type MemoryAdapter struct {
+	mu       sync.RWMutex
+	records  map[string][]byte
+	snapshot string
+	versions int // Number of versions to keep for each net (0 means all of them)
+}
+
+// NewMemoryAdapter returns the memory net param store of the process for the configured snapshot path (if any),
+// creating it the first time
+func NewMemoryAdapter(conf map[string]interface{}, versions int) (*MemoryAdapter, error) {
+	snapshot, _ := conf["Snapshot"].(string)
+	memoryStoresMu.Lock()
+	defer memoryStoresMu.Unlock()
+	ma, ok := memoryStores[snapshot]
+	if !ok {
+		var err error
+		ma, err = newMemoryAdapter(snapshot, versions)
+		if err != nil {
+			return nil, err
+		}
+		memoryStores[snapshot] = ma
+	}
+	return ma, nil
+}
+
+// newMemoryAdapter returns a new memory store with the records of the given snapshot, if it exists
+func newMemoryAdapter(snapshot string, versions int) (*MemoryAdapter, error) {
+	ma := &MemoryAdapter{records: map[string][]byte{}, snapshot: snapshot, versions: versions}
+	if snapshot == "" {
+		return ma, nil
+	}
+	data, err := ioutil.ReadFile(snapshot)
+	if os.IsNotExist(err) {
+		return ma, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &ma.records)
+	if err != nil {
+		return nil, err
+	}
+	return ma, nil
+}
+
+// SaveSnapshots writes the records of every memory store of the process that has a snapshot path to it
+func SaveSnapshots() error {
+	memoryStoresMu.Lock()
+	defer memoryStoresMu.Unlock()
+	for _, ma := range memoryStores {
+		err := ma.SaveSnapshot()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete can be used to delete a record (and, if it's a net, all its versions) from memory
+func (ma *MemoryAdapter) Delete(id string) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	for _, version := range ma.listVersions(id) {
+		delete(ma.records, versionID(id, version))
+	}
+	delete(ma.records, id)
+	return nil
+}
+
+// Lease can be used to take or renew the lease of a training group, which is kept as a record along with its expiry.
+// Only instances sharing the same process are coordinated
+func (ma *MemoryAdapter) Lease(group, owner string, ttl time.Duration) (bool, error) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	var current fileLease
+	if raw, found := ma.records[leaseID(group)]; found {
+		err := json.Unmarshal(raw, &current)
+		if err != nil {
+			return false, err
+		}
+	}
+	now := time.Now()
+	if current.Owner != "" && current.Owner != owner && current.Expires > now.UnixNano()/int64(time.Millisecond) {
+		return false, nil
+	}
+	expires := now.Add(ttl).UnixNano() / int64(time.Millisecond)
+	raw, err := json.Marshal(fileLease{Expires: expires, Owner: owner})
+	if err != nil {
+		return false, err
+	}
+	ma.records[leaseID(group)] = raw
+	return true, nil
+}
+
+// List can be used to get the IDs of the stored nets, sorted alphabetically
+func (ma *MemoryAdapter) List(offset, limit int, pattern string) ([]string, int, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+	ids := []string{}
+	for id := range ma.records {
+		match, err := filepath.Match(pattern, id)
+		if err != nil {
+			return nil, 0, err
+		}
+		if match && !isAux(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, 0, nil
+}
+
+// ListAux can be used to get the IDs that have an auxiliary record of the given kind, sorted alphabetically
+func (ma *MemoryAdapter) ListAux(kind string) ([]string, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+	suffix := AuxID("", kind)
+	ids := []string{}
+	for id := range ma.records {
+		if strings.HasSuffix(id, suffix) {
+			ids = append(ids, strings.TrimSuffix(id, suffix))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// ListVersions can be used to get the numbers of the stored versions of a net
+func (ma *MemoryAdapter) ListVersions(id string) ([]int, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+	return ma.listVersions(id), nil
+}
+
+// listVersions returns the sorted numbers of the versions of a net, the caller must hold the lock
+func (ma *MemoryAdapter) listVersions(id string) []int {
+	prefix := AuxID(id, "v")
+	versions := []int{}
+	for key := range ma.records {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		version, err := strconv.Atoi(key[len(prefix):])
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// Load can be used to retrieve a record from memory
+func (ma *MemoryAdapter) Load(id string, np Storable) (bool, error) {
+	ma.mu.RLock()
+	raw, found := ma.records[id]
+	ma.mu.RUnlock()
+	if !found {
+		return false, nil
+	}
+	return true, np.Unmarshal(raw)
+}
+
+// LoadVersion can be used to retrieve a specific version of a neural net from memory
+func (ma *MemoryAdapter) LoadVersion(id string, version int, np Storable) (bool, error) {
+	return ma.Load(versionID(id, version), np)
+}
+
+// Release can be used to give up the lease of a training group
+func (ma *MemoryAdapter) Release(group, owner string) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	raw, found := ma.records[leaseID(group)]
+	if !found {
+		return nil
+	}
+	var current fileLease
+	err := json.Unmarshal(raw, &current)
+	if err != nil || current.Owner != owner {
+		return err
+	}
+	delete(ma.records, leaseID(group))
+	return nil
+}
+
+// Save can be used to upsert a record in memory
+func (ma *MemoryAdapter) Save(id string, np Storable) error {
+	raw, err := np.Marshal()
+	if err != nil {
+		return err
+	}
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	ma.records[id] = raw
+	return nil
+}
+
+// SaveSnapshot writes every record to the snapshot path of the store (if it has one), through a temporary file so
+// that a previous snapshot is never left half written
+func (ma *MemoryAdapter) SaveSnapshot() error {
+	if ma.snapshot == "" {
+		return nil
+	}
+	ma.mu.RLock()
+	data, err := json.Marshal(ma.records)
+	ma.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(ma.snapshot+".tmp", data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(ma.snapshot+".tmp", ma.snapshot)
+}
+
+// SaveVersion can be used to store a neural net as a new version in memory
+func (ma *MemoryAdapter) SaveVersion(id string, np Storable) (int, error) {
+	raw, err := np.Marshal()
+	if err != nil {
+		return 0, err
+	}
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	versions := ma.listVersions(id)
+	version := 1
+	if len(versions) > 0 {
+		version = versions[len(versions)-1] + 1
+	}
+	ma.records[versionID(id, version)] = raw
+	versions = append(versions, version)
+	for ma.versions > 0 && len(versions) > ma.versions {
+		delete(ma.records, versionID(id, versions[0]))
+		versions = versions[1:]
+	}
+	return version, nil
+}
@@ -0,0 +1,74 @@
+package paramstores
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/qvantel/nerd/internal/config"
+)
+
+func TestConformanceMemory(t *testing.T) {
+	ma, err := newMemoryAdapter("", 2)
+	if err != nil {
+		t.Fatalf("Failed to get net param store (%s)", err.Error())
+	}
+	testConformance(t, ma)
+}
+
+func TestSnapshotMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nerd-memory")
+	if err != nil {
+		t.Fatalf("Failed to create snapshot directory (%s)", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	conf := config.Config{
+		ML: config.MLParams{
+			StoreType:   config.MemoryParamStore,
+			StoreParams: map[string]interface{}{"Snapshot": dir + "/params.json"},
+		},
+	}
+	nps, err := New(conf)
+	if err != nil {
+		t.Fatalf("Failed to get net param store (%s)", err.Error())
+	}
+	id, err := initTest(nps)
+	if err != nil {
+		t.Fatalf("Failed to initialize net param store (%s)", err.Error())
+	}
+	_, err = nps.SaveVersion(id, &MLPParams{Accuracy: 0.5})
+	if err != nil {
+		t.Fatalf("Failed to save version (%s)", err.Error())
+	}
+	same, err := New(conf)
+	if err != nil {
+		t.Fatalf("Failed to get net param store (%s)", err.Error())
+	}
+	if same != nps {
+		t.Error("Expected the stores created with the same configuration to be shared")
+	}
+	err = SaveSnapshots()
+	if err != nil {
+		t.Fatalf("Failed to save snapshots (%s)", err.Error())
+	}
+
+	reloaded, err := newMemoryAdapter(dir+"/params.json", 2)
+	if err != nil {
+		t.Fatalf("Failed to reload net param store (%s)", err.Error())
+	}
+	var params MLPParams
+	found, err := reloaded.Load(id, &params)
+	if err != nil {
+		t.Fatalf("Failed to load net params from store (%s)", err.Error())
+	}
+	if !found || params.LearningRate != 0.25 {
+		t.Errorf("Expected the reloaded net to have a learning rate of 0.25, got %f (found: %t)", params.LearningRate, found)
+	}
+	found, err = reloaded.LoadVersion(id, 1, &params)
+	if err != nil {
+		t.Fatalf("Failed to load version (%s)", err.Error())
+	}
+	if !found || params.Accuracy != 0.5 {
+		t.Errorf("Expected the reloaded version to have an accuracy of 0.5, got %f (found: %t)", params.Accuracy, found)
+	}
+}
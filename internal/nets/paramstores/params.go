@@ -14,31 +14,79 @@ type MLPParams struct {
 	ActivationFunc string
 	Averages       map[string]float32
 	Deviations     map[string]float32
+	Distributions  map[string]types.Distribution `json:",omitempty"`
 	Epoch          int
 	ErrMargin      float32
+	Features       *types.Features `json:",omitempty"`
+	History        *types.History  `json:",omitempty"`
 	Inputs         []string
 	LearningRate   float32
+	Normalization  map[string]NormParams
 	Topology       []int
 	Outputs        []string
+	Selection      *types.Selection `json:",omitempty"`
 	Weights        [][]float32
 }
 
+// NormParams holds the scheme and coefficients used to normalize a value, which is calculated as (f(x) - Center) /
+// Scale where f is the identity for every scheme except the log transform
+type NormParams struct {
+	Center float32
+	Scale  float32
+	Scheme string
+}
+
 // Brief returns a standard summarized version of the net's params (not enough to rebuild it but enough to compare it)
 func (np MLPParams) Brief() *types.BriefNet {
+	var schemes map[string]string
+	if np.Normalization != nil {
+		schemes = make(map[string]string, len(np.Normalization))
+		for label, norm := range np.Normalization {
+			schemes[label] = norm.Scheme
+		}
+	}
 	return &types.BriefNet{
 		Accuracy:       np.Accuracy,
 		ActivationFunc: np.ActivationFunc,
 		Averages:       np.Averages,
 		Deviations:     np.Deviations,
+		Distributions:  np.Distributions,
 		ErrMargin:      np.ErrMargin,
+		Features:       np.Features,
 		HLayers:        len(np.Topology) - 2,
 		Inputs:         np.Inputs,
 		LearningRate:   np.LearningRate,
+		Normalization:  schemes,
 		Outputs:        np.Outputs,
+		Selection:      np.Selection,
 		Type:           types.MultilayerPerceptron,
 	}
 }
 
+// DerivedFeatures returns the description of the inputs that are derived from the points of the series
+func (np *MLPParams) DerivedFeatures() *types.Features {
+	if np.Features == nil {
+		np.Features = &types.Features{Lags: []types.Lag{}, Time: []string{}, Windows: []types.Window{}}
+	}
+	return np.Features
+}
+
+// PointSelection returns the description of the points of the series that the net was trained with
+func (np *MLPParams) PointSelection() *types.Selection {
+	if np.Selection == nil {
+		np.Selection = &types.Selection{Labels: map[string]string{}}
+	}
+	return np.Selection
+}
+
+// TrainingHistory returns the learning curves recorded while training the net
+func (np *MLPParams) TrainingHistory() *types.History {
+	if np.History == nil {
+		np.History = &types.History{Epochs: []types.Epoch{}, Generations: []float32{}}
+	}
+	return np.History
+}
+
 // Unmarshal is used to tell the param store how to read a NetParams object for an MLP net
 func (np *MLPParams) Unmarshal(b []byte) error {
 	return json.Unmarshal(b, np)
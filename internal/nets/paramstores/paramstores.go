@@ -2,38 +2,111 @@
 package paramstores
 
 import (
+	"encoding/json"
 	"errors"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/qvantel/nerd/api/types"
 	"github.com/qvantel/nerd/internal/config"
 )
 
+// Storable is anything that knows how to write and read itself to and from a param store
+type Storable interface {
+	Marshal() ([]byte, error)
+	Unmarshal(b []byte) error
+}
+
 // NetParams serves to ensure that any new net implementation will come with instructions for writing and reading it
 // to and from the store as well as a way to get a standard representation of it that the API can use
 type NetParams interface {
+	Storable
 	Brief() *types.BriefNet
-	Marshal() ([]byte, error)
-	Unmarshal(b []byte) error
+	// Returns the description of the inputs that are derived from the points, which can be modified in place
+	DerivedFeatures() *types.Features
+	// Returns the description of the points the net was trained with, which can be modified in place
+	PointSelection() *types.Selection
+	// Returns the learning curves of the net, which can be modified in place
+	TrainingHistory() *types.History
+}
+
+// JSON allows keeping any value that can be handled by encoding/json in a param store, Value must be a pointer when
+// loading
+type JSON struct {
+	Value interface{}
+}
+
+// Marshal writes the wrapped value as JSON
+func (j JSON) Marshal() ([]byte, error) {
+	return json.Marshal(j.Value)
+}
+
+// Unmarshal reads the wrapped value from JSON
+func (j JSON) Unmarshal(b []byte) error {
+	return json.Unmarshal(b, j.Value)
+}
+
+// auxSeparator divides the ID of a net from the kind of auxiliary record that is kept next to it
+const auxSeparator = "@"
+
+// AuxID returns the key under which the given kind of auxiliary record is kept for a net. These records are never
+// returned when listing the nets in a store
+func AuxID(id, kind string) string {
+	return id + auxSeparator + kind
+}
+
+// leaseID returns the key under which the lease of the given training group is kept
+func leaseID(group string) string {
+	return AuxID(group, "lease")
+}
+
+// versionID returns the key under which the given version of a net is kept
+func versionID(id string, version int) string {
+	return AuxID(id, "v"+strconv.Itoa(version))
+}
+
+// isAux returns true if the given key belongs to an auxiliary record instead of a net
+func isAux(id string) bool {
+	return strings.Contains(id, auxSeparator)
 }
 
 // NetParamStore is an abstraction over the storage service that will be used to share the nets between instances, it
 // should allow queries by ID and be fairly performant (Redis is a good option here but it's good to have flexibility)
 type NetParamStore interface {
+	// Deletes a net (or any other record) along with all its versions
 	Delete(id string) error
+	// Takes the lease of a training group for the given owner (or renews it if they already hold it) until the TTL runs
+	// out, will return false and a nil error if someone else holds it
+	Lease(group, owner string, ttl time.Duration) (bool, error)
 	// Returns an array of net IDs matching a glob pattern, use * to retrieve all
 	List(offset, limit int, pattern string) ([]string, int, error)
-	// Load retrieves the NetParams for a net from a store, will return true and a nil error if found
-	Load(id string, np NetParams) (bool, error)
-	Save(id string, np NetParams) error
+	// Returns the IDs that have an auxiliary record of the given kind
+	ListAux(kind string) ([]string, error)
+	// Returns the numbers of the stored versions of a net, oldest first
+	ListVersions(id string) ([]int, error)
+	// Load retrieves the NetParams for a net (or any other record) from a store, will return true and a nil error if
+	// found
+	Load(id string, np Storable) (bool, error)
+	// Works like Load but retrieves a specific version of the net
+	LoadVersion(id string, version int, np Storable) (bool, error)
+	// Gives up the lease of a training group if it's held by the given owner
+	Release(group, owner string) error
+	Save(id string, np Storable) error
+	// Stores the params as the next version of the net and returns its number, removing the oldest versions beyond the
+	// configured retention
+	SaveVersion(id string, np Storable) (int, error)
 }
 
 // New creates and returns the corresponding type of param store for the given configuration
 func New(conf config.Config) (NetParamStore, error) {
 	switch conf.ML.StoreType {
 	case config.FileParamStore:
-		return NewFileAdapter(conf.ML.StoreParams)
+		return NewFileAdapter(conf.ML.StoreParams, conf.ML.Versions)
+	case config.MemoryParamStore:
+		return NewMemoryAdapter(conf.ML.StoreParams, conf.ML.Versions)
 	case config.RedisParamStore:
-		return NewRedisAdapter(conf.ML.StoreParams)
+		return NewRedisAdapter(conf.ML.StoreParams, conf.ML.Versions)
 	default:
 		return nil, errors.New(conf.ML.StoreType + " is not a valid net param store type")
 	}
@@ -1,6 +1,11 @@
 package paramstores
 
-import "github.com/qvantel/nerd/api/types"
+import (
+	"testing"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+)
 
 func initTest(nps NetParamStore) (string, error) {
 	id := "test-evaluate-51e1890284194a8e4bb9923994e46cf59cfdd90d-89368e1d68015693ab48ee189d0632cb5d6edfb3-" + types.MultilayerPerceptron
@@ -17,3 +22,186 @@ func initTest(nps NetParamStore) (string, error) {
 	}
 	return id, nps.Save(id, &params)
 }
+
+// testConformance runs the checks that every net param store has to pass, the store must keep 2 versions of each net
+func testConformance(t *testing.T, nps NetParamStore) {
+	t.Run("List", func(t *testing.T) { testList(t, nps) })
+	t.Run("Load", func(t *testing.T) { testLoad(t, nps) })
+	t.Run("Versions", func(t *testing.T) { testVersions(t, nps) })
+	t.Run("Lease", func(t *testing.T) { testLease(t, nps) })
+}
+
+// testList checks that the nets in a store are listed while the auxiliary records are not
+func testList(t *testing.T, nps NetParamStore) {
+	id, err := initTest(nps)
+	if err != nil {
+		t.Fatalf("Failed to initialize net param store (%s)", err.Error())
+	}
+	defer nps.Delete(id)
+	// Auxiliary records shouldn't be listed
+	err = nps.Save(AuxID(id, "test"), JSON{Value: map[string]int{"value": 1}})
+	if err != nil {
+		t.Fatalf("Failed to save auxiliary record (%s)", err.Error())
+	}
+	defer nps.Delete(AuxID(id, "test"))
+	aux, err := nps.ListAux("test")
+	if err != nil {
+		t.Fatalf("Failed to list auxiliary records (%s)", err.Error())
+	}
+	if len(aux) != 1 || aux[0] != id {
+		t.Errorf("Expected ListAux to return ID %s, got %v instead", id, aux)
+	}
+
+	var res []string
+	cursor := 0
+	ids := []string{}
+	for {
+		res, cursor, err = nps.List(cursor, 10, "*")
+		ids = append(ids, res...)
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if len(ids) != 1 {
+		t.Fatalf("Expected List to return one ID, got %d instead", len(ids))
+	}
+	if ids[0] != id {
+		t.Fatalf("Expected List to return ID %s, got %s instead", id, ids[0])
+	}
+}
+
+// testLoad checks that a saved net can be loaded back and that missing ones aren't found
+func testLoad(t *testing.T, nps NetParamStore) {
+	id, err := initTest(nps)
+	if err != nil {
+		t.Fatalf("Failed to initialize net param store (%s)", err.Error())
+	}
+	defer nps.Delete(id)
+
+	var params MLPParams
+	found, err := nps.Load("does-not-exist-mlp", &params)
+	if err != nil {
+		t.Fatalf("Failed to load net params from store (%s)", err.Error())
+	}
+	if found {
+		t.Error("Found non-existent net")
+	}
+
+	found, err = nps.Load(id, &params)
+	if err != nil {
+		t.Fatalf("Failed to load net params from store (%s)", err.Error())
+	}
+	if !found {
+		t.Fatal("Failed to find existing net")
+	}
+	if params.LearningRate != 0.25 {
+		t.Errorf("Incorrect learning rate for retrieved params, expected 0.25, got %f", params.LearningRate)
+	}
+}
+
+// testVersions checks the versioning logic of a store configured to keep 2 versions of each net
+func testVersions(t *testing.T, nps NetParamStore) {
+	id, err := initTest(nps)
+	if err != nil {
+		t.Fatalf("Failed to initialize net param store (%s)", err.Error())
+	}
+	defer nps.Delete(id)
+
+	for i := 1; i <= 3; i++ {
+		params := MLPParams{Accuracy: float32(i) / 10}
+		version, err := nps.SaveVersion(id, &params)
+		if err != nil {
+			t.Fatalf("Failed to save version %d (%s)", i, err.Error())
+		}
+		if version != i {
+			t.Errorf("Expected version %d to be returned, got %d", i, version)
+		}
+	}
+	versions, err := nps.ListVersions(id)
+	if err != nil {
+		t.Fatalf("Failed to list versions (%s)", err.Error())
+	}
+	if len(versions) != 2 || versions[0] != 2 || versions[1] != 3 {
+		t.Fatalf("Expected only the last 2 versions to be kept, got %v", versions)
+	}
+
+	var params MLPParams
+	found, err := nps.LoadVersion(id, 1, &params)
+	if err != nil {
+		t.Fatalf("Failed to load version (%s)", err.Error())
+	}
+	if found {
+		t.Error("Found a version that should have been removed")
+	}
+	found, err = nps.LoadVersion(id, 2, &params)
+	if err != nil {
+		t.Fatalf("Failed to load version (%s)", err.Error())
+	}
+	if !found || params.Accuracy != 0.2 {
+		t.Errorf("Expected version 2 to have an accuracy of 0.2, got %f (found: %t)", params.Accuracy, found)
+	}
+
+	ids, _, err := nps.List(0, 10, "*")
+	if err != nil {
+		t.Fatalf("Failed to list nets (%s)", err.Error())
+	}
+	if len(ids) != 1 {
+		t.Errorf("Expected versions not to be listed as nets, got %v", ids)
+	}
+
+	err = nps.Delete(id)
+	if err != nil {
+		t.Fatalf("Failed to delete net (%s)", err.Error())
+	}
+	versions, err = nps.ListVersions(id)
+	if err != nil {
+		t.Fatalf("Failed to list versions (%s)", err.Error())
+	}
+	if len(versions) != 0 {
+		t.Errorf("Expected the versions of a deleted net to be removed too, got %v", versions)
+	}
+}
+
+// testLease checks that a training group can only be leased by one owner at a time until the lease expires
+func testLease(t *testing.T, nps NetParamStore) {
+	group := "test-lease-51e1890284194a8e4bb9923994e46cf59cfdd90d"
+	ttl := 200 * time.Millisecond
+	defer nps.Release(group, "a")
+	defer nps.Release(group, "b")
+
+	taken, err := nps.Lease(group, "a", ttl)
+	if err != nil || !taken {
+		t.Fatalf("Failed to take a free lease (%v)", err)
+	}
+	taken, err = nps.Lease(group, "b", ttl)
+	if err != nil || taken {
+		t.Fatalf("Expected a held lease not to be taken by someone else (%v)", err)
+	}
+	taken, err = nps.Lease(group, "a", ttl)
+	if err != nil || !taken {
+		t.Fatalf("Failed to renew a lease (%v)", err)
+	}
+	err = nps.Release(group, "b")
+	if err != nil {
+		t.Fatalf("Failed to release a lease (%s)", err.Error())
+	}
+	taken, err = nps.Lease(group, "b", ttl)
+	if err != nil || taken {
+		t.Fatalf("Expected a lease not to be released by someone else (%v)", err)
+	}
+
+	time.Sleep(ttl + 50*time.Millisecond)
+	taken, err = nps.Lease(group, "b", ttl)
+	if err != nil || !taken {
+		t.Fatalf("Failed to take an expired lease (%v)", err)
+	}
+	err = nps.Release(group, "b")
+	if err != nil {
+		t.Fatalf("Failed to release a lease (%s)", err.Error())
+	}
+	taken, err = nps.Lease(group, "a", ttl)
+	if err != nil || !taken {
+		t.Fatalf("Failed to take a released lease (%v)", err)
+	}
+}
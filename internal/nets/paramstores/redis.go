@@ -13,10 +13,30 @@ import (
 	"github.com/qvantel/nerd/internal/logger"
 )
 
+// lease atomically sets the holder of a lease (ARGV[1]) with an expiry (ARGV[2], in milliseconds) if it's free or
+// already theirs, returning 1 if it was set
+var lease = redis.NewEvalScript(1, `
+local holder = redis.call('GET', KEYS[1])
+if holder and holder ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+// release atomically deletes a lease if it's held by the given owner (ARGV[1])
+var release = redis.NewEvalScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
 // RedisAdapter is the neural net param store implementation for Redis
 type RedisAdapter struct {
 	client   redis.Client
 	sentinel *redis.Sentinel
+	versions int // Number of versions to keep for each net (0 means all of them)
 }
 
 // readClient returns a client for a random Redis instance, as reads can be handled by secondary replicas too
@@ -43,27 +63,35 @@ func (ra *RedisAdapter) writeClient() (redis.Client, error) {
 }
 
 // NewRedisAdapter returns an initialized Redis param store object
-func NewRedisAdapter(conf map[string]interface{}) (*RedisAdapter, error) {
+func NewRedisAdapter(conf map[string]interface{}, versions int) (*RedisAdapter, error) {
 	group, found := conf["group"]
 	if found {
 		sentinel, err := redis.NewSentinel(group.(string), strings.Split(conf["URLs"].(string), ","))
-		return &RedisAdapter{sentinel: sentinel}, err
+		return &RedisAdapter{sentinel: sentinel, versions: versions}, err
 	}
 	pool, err := redis.NewPool("tcp", conf["URL"].(string), 10)
-	return &RedisAdapter{client: pool}, err
+	return &RedisAdapter{client: pool, versions: versions}, err
 }
 
-// Delete can be used to delete the state of a specific neural net from Redis
+// Delete can be used to delete the state of a specific neural net (and all its versions) from Redis
 func (ra *RedisAdapter) Delete(id string) error {
 	var (
 		value    int
 		redisErr resp2.Error
 	)
+	versions, err := ra.ListVersions(id)
+	if err != nil {
+		return err
+	}
+	keys := []string{id, AuxID(id, "version"), AuxID(id, "versions")}
+	for _, version := range versions {
+		keys = append(keys, versionID(id, version))
+	}
 	client, err := ra.writeClient()
 	if err != nil {
 		return err
 	}
-	err = client.Do(redis.Cmd(&value, "DEL", id))
+	err = client.Do(redis.Cmd(&value, "DEL", keys...))
 	if errors.As(err, &redisErr) {
 		logger.Error("Redis error returned while deletening a net", redisErr.E)
 		return redisErr.E
@@ -71,6 +99,25 @@ func (ra *RedisAdapter) Delete(id string) error {
 	return err
 }
 
+// Lease can be used to take or renew the lease of a training group, Redis takes care of expiring it
+func (ra *RedisAdapter) Lease(group, owner string, ttl time.Duration) (bool, error) {
+	var (
+		taken    int
+		redisErr resp2.Error
+	)
+	client, err := ra.writeClient()
+	if err != nil {
+		return false, err
+	}
+	ms := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	err = client.Do(lease.Cmd(&taken, leaseID(group), owner, ms))
+	if errors.As(err, &redisErr) {
+		logger.Error("Redis error returned while leasing a training group", redisErr.E)
+		return false, redisErr.E
+	}
+	return taken == 1, err
+}
+
 type scanResult struct {
 	cur  int
 	keys []string
@@ -125,11 +172,77 @@ func (ra *RedisAdapter) List(offset, limit int, pattern string) ([]string, int,
 		return nil, 0, redisErr.E
 	}
 
-	return res.keys, res.cur, nil
+	ids := make([]string, 0, len(res.keys))
+	for _, key := range res.keys {
+		if !isAux(key) {
+			ids = append(ids, key)
+		}
+	}
+	return ids, res.cur, nil
+}
+
+// ListAux can be used to get the IDs that have an auxiliary record of the given kind in Redis, which takes a full scan
+// of the keyspace
+func (ra *RedisAdapter) ListAux(kind string) ([]string, error) {
+	var (
+		res      scanResult
+		redisErr resp2.Error
+	)
+	client, err := ra.readClient()
+	if err != nil {
+		return nil, err
+	}
+	suffix := AuxID("", kind)
+	ids := []string{}
+	cursor := 0
+	for {
+		err = client.Do(redis.Cmd(&res, "SCAN", strconv.Itoa(cursor), "COUNT", "100", "MATCH", "*"+suffix))
+		if errors.As(err, &redisErr) {
+			logger.Error("Redis error returned while listing auxiliary records", redisErr.E)
+			return nil, redisErr.E
+		} else if err != nil {
+			return nil, err
+		}
+		for _, key := range res.keys {
+			ids = append(ids, strings.TrimSuffix(key, suffix))
+		}
+		cursor = res.cur
+		if cursor == 0 {
+			return ids, nil
+		}
+	}
+}
+
+// ListVersions can be used to get the numbers of the versions of a net that are stored in Redis
+func (ra *RedisAdapter) ListVersions(id string) ([]int, error) {
+	var (
+		values   []string
+		redisErr resp2.Error
+	)
+	client, err := ra.readClient()
+	if err != nil {
+		return nil, err
+	}
+	err = client.Do(redis.Cmd(&values, "LRANGE", AuxID(id, "versions"), "0", "-1"))
+	if errors.As(err, &redisErr) {
+		logger.Error("Redis error returned while listing the versions of a net", redisErr.E)
+		return nil, redisErr.E
+	} else if err != nil {
+		return nil, err
+	}
+	versions := make([]int, 0, len(values))
+	for _, value := range values {
+		version, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
 }
 
 // Load can be used to retrieve the state of a specific neural net from Redis
-func (ra *RedisAdapter) Load(id string, np NetParams) (bool, error) {
+func (ra *RedisAdapter) Load(id string, np Storable) (bool, error) {
 	var (
 		value    string
 		redisErr resp2.Error
@@ -150,8 +263,28 @@ func (ra *RedisAdapter) Load(id string, np NetParams) (bool, error) {
 	return true, err
 }
 
+// LoadVersion can be used to retrieve a specific version of a neural net from Redis
+func (ra *RedisAdapter) LoadVersion(id string, version int, np Storable) (bool, error) {
+	return ra.Load(versionID(id, version), np)
+}
+
+// Release can be used to give up the lease of a training group
+func (ra *RedisAdapter) Release(group, owner string) error {
+	var redisErr resp2.Error
+	client, err := ra.writeClient()
+	if err != nil {
+		return err
+	}
+	err = client.Do(release.Cmd(nil, leaseID(group), owner))
+	if errors.As(err, &redisErr) {
+		logger.Error("Redis error returned while releasing a training group", redisErr.E)
+		return redisErr.E
+	}
+	return err
+}
+
 // Save can be used to upsert the state of a specific neural net to Redis
-func (ra *RedisAdapter) Save(id string, np NetParams) error {
+func (ra *RedisAdapter) Save(id string, np Storable) error {
 	value, err := np.Marshal()
 	if err != nil {
 		return err
@@ -162,3 +295,48 @@ func (ra *RedisAdapter) Save(id string, np NetParams) error {
 	}
 	return client.Do(redis.Cmd(nil, "SET", id, string(value)))
 }
+
+// SaveVersion can be used to store the state of a neural net as a new version in Redis. The version numbers are kept
+// in a list (oldest first) next to a counter that is used for generating them
+func (ra *RedisAdapter) SaveVersion(id string, np Storable) (int, error) {
+	var (
+		version  int
+		old      []string
+		redisErr resp2.Error
+	)
+	value, err := np.Marshal()
+	if err != nil {
+		return 0, err
+	}
+	client, err := ra.writeClient()
+	if err != nil {
+		return 0, err
+	}
+	list := AuxID(id, "versions")
+	err = client.Do(redis.Cmd(&version, "INCR", AuxID(id, "version")))
+	if err == nil {
+		err = client.Do(redis.Cmd(nil, "SET", versionID(id, version), string(value)))
+	}
+	if err == nil {
+		err = client.Do(redis.Cmd(nil, "RPUSH", list, strconv.Itoa(version)))
+	}
+	if err == nil && ra.versions > 0 {
+		// Everything but the last n versions has to go
+		err = client.Do(redis.Cmd(&old, "LRANGE", list, "0", strconv.Itoa(-ra.versions-1)))
+		if err == nil && len(old) > 0 {
+			keys := make([]string, len(old))
+			for i := range old {
+				keys[i] = AuxID(id, "v"+old[i])
+			}
+			err = client.Do(redis.Cmd(nil, "DEL", keys...))
+			if err == nil {
+				err = client.Do(redis.Cmd(nil, "LTRIM", list, strconv.Itoa(-ra.versions), "-1"))
+			}
+		}
+	}
+	if errors.As(err, &redisErr) {
+		logger.Error("Redis error returned while saving a version of a net", redisErr.E)
+		return 0, redisErr.E
+	}
+	return version, err
+}
@@ -46,30 +46,10 @@ func startRedis(ctx context.Context) (redis testcontainers.Container, url string
 	return redis, endpoint, nil
 }
 
-func TestList(t *testing.T) {
-	id, err := initTest(testRedisStore)
-	if err != nil {
-		t.Fatalf("Failed to initialize net param store (%s)", err.Error())
-	}
-	defer testRedisStore.Delete(id)
-
-	var res []string
-	cursor := 0
-	ids := []string{}
-	for {
-		res, cursor, err = testRedisStore.List(cursor, 10, "*")
-		ids = append(ids, res...)
-		if cursor == 0 {
-			break
-		}
-	}
-
-	if len(ids) != 1 {
-		t.Fatalf("Expected List to return one ID, got %d instead", len(ids))
-	}
-	if ids[0] != id {
-		t.Fatalf("Expected List to return ID %s, got %s instead", id, ids[0])
-	}
+func TestConformance(t *testing.T) {
+	ra := *testRedisStore.(*RedisAdapter)
+	ra.versions = 2
+	testConformance(t, &ra)
 }
 
 func TestMain(m *testing.M) {
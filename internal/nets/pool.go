@@ -0,0 +1,210 @@
+package nets
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/nets/queues"
+)
+
+// entry is a request that has been taken from the queue and is waiting for a worker
+type entry struct {
+	item queues.Item
+	seq  uint64 // Order of arrival
+}
+
+// Pool decides which of the requests taken from the training queue each worker should train next. Requests with a
+// higher priority go first and, among those with the same priority, series that aren't being trained and have waited
+// the longest since their last turn are preferred so that a series with many requests can't hold back the rest
+type Pool struct {
+	changed   chan struct{} // Closed (and replaced) whenever the state changes
+	cpus      int           // Maximum number of requests trained at the same time
+	maxMemory uint64        // Bytes in use above which no new requests are started (0 means no limit)
+	mu        sync.Mutex
+	pending   []entry
+	running   map[string]int    // Number of requests being trained for each series
+	seq       uint64            // Number of requests that have been added
+	served    map[string]uint64 // Turn in which each series was last picked
+	turn      uint64            // Number of requests that have been picked
+	workers   []types.PoolWorker
+}
+
+// NewPool returns an idle pool with the number of workers and budget set in the configuration
+func NewPool(params config.MLParams) *Pool {
+	cpus := params.CPUBudget
+	if cpus == 0 {
+		cpus = runtime.NumCPU()
+	}
+	return &Pool{
+		changed:   make(chan struct{}),
+		cpus:      cpus,
+		maxMemory: uint64(params.MaxMemory) * 1024 * 1024,
+		pending:   []entry{},
+		running:   map[string]int{},
+		served:    map[string]uint64{},
+		workers:   make([]types.PoolWorker, params.Workers),
+	}
+}
+
+// State returns a description of the workers of the pool and the requests waiting for them
+func (p *Pool) State() types.Pool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state := types.Pool{
+		CPUBudget:    p.cpus,
+		MemoryBudget: int(p.maxMemory / 1024 / 1024),
+		MemoryInUse:  int(memoryInUse() / 1024 / 1024),
+		Pending:      make([]types.PoolEntry, len(p.pending)),
+		Workers:      append([]types.PoolWorker{}, p.workers...),
+	}
+	pending := append([]entry{}, p.pending...)
+	sort.SliceStable(pending, func(i, j int) bool {
+		return p.before(pending[i], pending[j])
+	})
+	for i, e := range pending {
+		state.Pending[i] = types.PoolEntry{ID: e.item.Request.ID, Priority: e.item.Request.Priority, SeriesID: e.item.Request.SeriesID}
+	}
+	return state
+}
+
+// add puts a request in line for the workers
+func (p *Pool) add(item queues.Item) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seq++
+	p.pending = append(p.pending, entry{item: item, seq: p.seq})
+	p.notify()
+}
+
+// before returns true if the first request should be trained before the second one. The lock must be held
+func (p *Pool) before(a, b entry) bool {
+	ta, tb := a.item.Request, b.item.Request
+	if ta.Priority != tb.Priority {
+		return ta.Priority > tb.Priority
+	}
+	if p.running[ta.SeriesID] != p.running[tb.SeriesID] {
+		return p.running[ta.SeriesID] < p.running[tb.SeriesID]
+	}
+	if p.served[ta.SeriesID] != p.served[tb.SeriesID] {
+		return p.served[ta.SeriesID] < p.served[tb.SeriesID]
+	}
+	return a.seq < b.seq
+}
+
+// busy returns the number of workers that are training a request. The lock must be held
+func (p *Pool) busy() int {
+	busy := 0
+	for _, worker := range p.workers {
+		if worker.Job != "" {
+			busy++
+		}
+	}
+	return busy
+}
+
+// done frees a worker once it has finished with its request
+func (p *Pool) done(worker int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	series := p.workers[worker].SeriesID
+	p.running[series]--
+	if p.running[series] <= 0 {
+		delete(p.running, series)
+	}
+	p.workers[worker] = types.PoolWorker{}
+	p.notify()
+}
+
+// drain removes and returns the requests that are still waiting for a worker
+func (p *Pool) drain() []queues.Item {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	items := make([]queues.Item, len(p.pending))
+	for i, e := range p.pending {
+		items[i] = e.item
+	}
+	p.pending = []entry{}
+	p.notify()
+	return items
+}
+
+// next waits until the given worker can start a request and returns it, false will be returned if the context is done
+// first. Requests are only started while there is room in the CPU and memory budgets
+func (p *Pool) next(ctx context.Context, worker int) (queues.Item, bool) {
+	for {
+		p.mu.Lock()
+		if len(p.pending) > 0 && p.busy() < p.cpus && (p.maxMemory == 0 || memoryInUse() < p.maxMemory) {
+			best := 0
+			for i := range p.pending {
+				if p.before(p.pending[i], p.pending[best]) {
+					best = i
+				}
+			}
+			e := p.pending[best]
+			p.pending = append(p.pending[:best], p.pending[best+1:]...)
+			p.turn++
+			p.served[e.item.Request.SeriesID] = p.turn
+			p.running[e.item.Request.SeriesID]++
+			p.workers[worker] = types.PoolWorker{Job: e.item.Request.ID, SeriesID: e.item.Request.SeriesID, Since: time.Now().Unix()}
+			p.notify()
+			p.mu.Unlock()
+			return e.item, true
+		}
+		changed := p.changed
+		p.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return queues.Item{}, false
+		case <-changed:
+		case <-time.After(time.Second): // Memory can be freed without anything else changing
+		}
+	}
+}
+
+// notify wakes up everyone waiting for a change in the pool. The lock must be held
+func (p *Pool) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// waiting returns the requests that are waiting for a worker
+func (p *Pool) waiting() []queues.Item {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	items := make([]queues.Item, len(p.pending))
+	for i, e := range p.pending {
+		items[i] = e.item
+	}
+	return items
+}
+
+// room waits until fewer requests than workers are waiting so that the pool has a few to choose from without taking
+// more from a shared queue than it can handle soon, false will be returned if the context is done first
+func (p *Pool) room(ctx context.Context) bool {
+	for {
+		p.mu.Lock()
+		if len(p.pending) < len(p.workers) {
+			p.mu.Unlock()
+			return true
+		}
+		changed := p.changed
+		p.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// memoryInUse returns the number of bytes taken by the objects in the heap
+func memoryInUse() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
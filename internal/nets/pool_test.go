@@ -0,0 +1,57 @@
+package nets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/nets/queues"
+)
+
+func TestPoolOrder(t *testing.T) {
+	pool := NewPool(config.MLParams{CPUBudget: 1, Workers: 2})
+	requests := []types.TrainRequest{
+		{ID: "big-1", SeriesID: "big"},
+		{ID: "big-2", SeriesID: "big"},
+		{ID: "big-3", SeriesID: "big"},
+		{ID: "small-1", SeriesID: "small"},
+		{ID: "manual-1", Priority: types.PriorityManual, SeriesID: "big"},
+	}
+	for _, tr := range requests {
+		pool.add(queues.Item{Request: tr})
+	}
+	state := pool.State()
+	if len(state.Pending) != 5 || state.Pending[0].ID != "manual-1" || state.CPUBudget != 1 || len(state.Workers) != 2 {
+		t.Errorf("Expected 5 pending requests with the manual one first, 2 workers and 1 CPU, got %+v", state)
+	}
+
+	expected := []string{"manual-1", "small-1", "big-1", "big-2", "big-3"}
+	for _, id := range expected {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		item, ok := pool.next(ctx, 0)
+		cancel()
+		if !ok {
+			t.Fatalf("Expected request %s to be picked, got none", id)
+		}
+		if item.Request.ID != id {
+			t.Errorf("Expected request %s to be picked, got %s", id, item.Request.ID)
+		}
+		if state := pool.State(); state.Workers[0].Job != id {
+			t.Errorf("Expected worker 0 to be training %s, got %+v", id, state.Workers[0])
+		}
+		if id == "manual-1" {
+			// The second worker shouldn't start anything while the CPU budget is used up
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			if item, ok := pool.next(ctx, 1); ok {
+				t.Errorf("Expected no request to start beyond the CPU budget, got %s", item.Request.ID)
+			}
+			cancel()
+		}
+		pool.done(0)
+	}
+	if state := pool.State(); len(state.Pending) != 0 || state.Workers[0].Job != "" {
+		t.Errorf("Expected an idle pool, got %+v", state)
+	}
+}
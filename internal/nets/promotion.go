@@ -0,0 +1,129 @@
+package nets
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/logger"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+// Kinds of auxiliary records used for deciding whether retrained nets should replace the current ones
+const (
+	ChallengerRecord = "challenger"
+	PromotionsRecord = "promotions"
+)
+
+// maxPromotions is the number of promotion decisions that are kept for each net
+const maxPromotions = 10
+
+// Accuracy returns the fraction of the given points for which all the outputs of the net are within the error margin
+func Accuracy(net Network, points []pointstores.Point, errMargin float32) (float32, error) {
+	if len(points) == 0 {
+		return -1, nil
+	}
+	hits := 0
+	for _, point := range points {
+		outputs, err := net.Evaluate(point.Values)
+		if err != nil {
+			return 0, err
+		}
+		hit := true
+		for label, value := range outputs {
+			if math.Abs(float64(value-point.Values[label])) > float64(errMargin) {
+				hit = false
+				break
+			}
+		}
+		if hit {
+			hits++
+		}
+	}
+	return float32(hits) / float32(len(points)), nil
+}
+
+// champion returns the current net for the given inputs, outputs and selection of a series, or nil if there isn't one
+// yet
+func champion(tr types.TrainRequest, outputs []string, nps paramstores.NetParamStore) (Network, error) {
+	for _, nType := range types.Nets() {
+		net, err := LoadNetwork(tr.SeriesID+"-"+inputsHash(tr.Inputs, tr.Selection)+"-"+hash(outputs)+"-"+nType, nType, nps)
+		if err != nil || net != nil {
+			return net, err
+		}
+	}
+	return nil, nil
+}
+
+// splitHoldout sets aside the given fraction of the most recent points so that they can be used for comparing nets
+// that haven't seen them during training
+func splitHoldout(points []pointstores.Point, fraction float32) ([]pointstores.Point, []pointstores.Point) {
+	sorted := make([]pointstores.Point, len(points))
+	copy(sorted, points)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].TimeStamp > sorted[j].TimeStamp })
+	n := int(float32(len(sorted)) * fraction)
+	return sorted[n:], sorted[:n]
+}
+
+// promote saves the challenger in place of the champion if it's more accurate on the holdout set by at least the
+// configured margin, otherwise it's kept as a challenger. The decision is added to the promotions record of the net
+func promote(challenger, champ Network, holdout []pointstores.Point, errMargin float32, nps paramstores.NetParamStore, conf config.Config) (bool, error) {
+	id := challenger.ID()
+	promotion := types.Promotion{Champion: -1, Decided: time.Now().Unix(), Holdout: len(holdout), Promoted: true}
+	var err error
+	promotion.Challenger, err = Accuracy(challenger, holdout, errMargin)
+	if err != nil {
+		return false, err
+	}
+	if champ != nil && len(holdout) > 0 {
+		promotion.Champion, err = Accuracy(champ, holdout, errMargin)
+		if err != nil {
+			return false, err
+		}
+		promotion.Promoted = promotion.Challenger >= promotion.Champion+conf.ML.Margin
+	}
+	logger.Info(fmt.Sprintf(
+		"Challenger for %s scored %f against the champion's %f on %d holdout points, promoted: %t",
+		id,
+		promotion.Challenger,
+		promotion.Champion,
+		promotion.Holdout,
+		promotion.Promoted,
+	))
+
+	if promotion.Promoted {
+		err = nps.Save(id, challenger.Params())
+		if err == nil {
+			_, err = nps.SaveVersion(id, challenger.Params())
+		}
+		if err == nil {
+			err = nps.Delete(paramstores.AuxID(id, ChallengerRecord))
+		}
+	} else {
+		err = nps.Save(paramstores.AuxID(id, ChallengerRecord), challenger.Params())
+	}
+	if err != nil {
+		return false, err
+	}
+
+	promotions, err := Promotions(id, nps)
+	if err != nil {
+		return false, err
+	}
+	promotions = append(promotions, promotion)
+	if len(promotions) > maxPromotions {
+		promotions = promotions[len(promotions)-maxPromotions:]
+	}
+	return promotion.Promoted, nps.Save(paramstores.AuxID(id, PromotionsRecord), paramstores.JSON{Value: promotions})
+}
+
+// Promotions returns the latest promotion decisions made for the net with the given ID (oldest first)
+func Promotions(id string, nps paramstores.NetParamStore) ([]types.Promotion, error) {
+	promotions := []types.Promotion{}
+	_, err := nps.Load(paramstores.AuxID(id, PromotionsRecord), paramstores.JSON{Value: &promotions})
+	return promotions, err
+}
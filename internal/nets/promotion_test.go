@@ -0,0 +1,160 @@
+package nets
+
+import (
+	"testing"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+func TestSplitHoldout(t *testing.T) {
+	points := []pointstores.Point{{TimeStamp: 3}, {TimeStamp: 1}, {TimeStamp: 4}, {TimeStamp: 2}, {TimeStamp: 5}}
+	train, holdout := splitHoldout(points, 0.4)
+	if len(train) != 3 || len(holdout) != 2 {
+		t.Fatalf("Expected 3 training and 2 holdout points, got %d and %d", len(train), len(holdout))
+	}
+	if holdout[0].TimeStamp != 5 || holdout[1].TimeStamp != 4 {
+		t.Errorf("Expected the most recent points to be held out, got %+v", holdout)
+	}
+}
+
+func TestPromote(t *testing.T) {
+	nps := paramstores.FileAdapter{Path: "."}
+	id := "test-promote-51e1890284194a8e4bb9923994e46cf59cfdd90d-89368e1d68015693ab48ee189d0632cb5d6edfb3-" + types.MultilayerPerceptron
+	defer Delete(id, nps)
+	good := paramstores.MLPParams{
+		ActivationFunc: types.BipolarSigmoid,
+		Inputs:         []string{"subs", "events"},
+		LearningRate:   0.25,
+		Topology:       []int{2, 2, 1},
+		Outputs:        []string{"size"},
+		Weights: [][]float32{
+			{0.4, 0.7, -0.2, 0.6, -0.4, 0.3},
+			{-0.3, 0.5, 0.1},
+		},
+	}
+	bad := good
+	bad.Weights = [][]float32{
+		{-0.4, -0.7, 0.2, -0.6, 0.4, -0.3},
+		{0.3, -0.5, -0.1},
+	}
+	champ, _ := MLPFromParams(id, good)
+	challenger, _ := MLPFromParams(id, bad)
+	// The holdout set is generated with the champion so that it's always right
+	holdout := []pointstores.Point{}
+	for i := 0; i < 10; i++ {
+		values := map[string]float32{"subs": float32(i) / 10, "events": 1 - float32(i)/10}
+		outputs, _ := champ.Evaluate(values)
+		values["size"] = outputs["size"]
+		holdout = append(holdout, pointstores.Point{Values: values, TimeStamp: int64(i)})
+	}
+	conf := config.Config{ML: config.MLParams{Margin: 0.05}}
+
+	promoted, err := promote(challenger, champ, holdout, 0.01, nps, conf)
+	if err != nil {
+		t.Fatalf("Failed to compare challenger with champion (%s)", err.Error())
+	}
+	if promoted {
+		t.Error("A less accurate challenger was promoted")
+	}
+	var stored paramstores.MLPParams
+	found, _ := nps.Load(paramstores.AuxID(id, ChallengerRecord), &stored)
+	if !found {
+		t.Error("Expected the rejected challenger to be stored")
+	}
+
+	promoted, err = promote(champ, challenger, holdout, 0.01, nps, conf)
+	if err != nil {
+		t.Fatalf("Failed to compare challenger with champion (%s)", err.Error())
+	}
+	if !promoted {
+		t.Error("A more accurate challenger wasn't promoted")
+	}
+	found, _ = nps.Load(id, &stored)
+	if !found || stored.String() != good.String() {
+		t.Errorf("Expected the promoted challenger to be saved as the champion, got %s", stored.String())
+	}
+	versions, _ := nps.ListVersions(id)
+	if len(versions) != 1 {
+		t.Errorf("Expected the promoted challenger to be saved as a new version, got versions %v", versions)
+	}
+	found, _ = nps.Load(paramstores.AuxID(id, ChallengerRecord), &stored)
+	if found {
+		t.Error("Expected the challenger record to be removed after a promotion")
+	}
+
+	promotions, err := Promotions(id, nps)
+	if err != nil {
+		t.Fatalf("Failed to load promotion decisions (%s)", err.Error())
+	}
+	if len(promotions) != 2 || promotions[0].Promoted || !promotions[1].Promoted || promotions[1].Challenger != 1 {
+		t.Errorf("Expected a rejection followed by a promotion, got %+v", promotions)
+	}
+}
+
+func TestSelectionChampions(t *testing.T) {
+	ps, _ := pointstores.NewFileAdapter(map[string]interface{}{"Path": "."})
+	seriesID := "test-selection-champions"
+	defer ps.DeleteSeries(seriesID)
+	for i := 0; i < 20; i++ {
+		for env, sign := range map[string]float32{"a": 1, "b": -1} {
+			value := float32(i) / 20
+			point := pointstores.Point{
+				Labels:    map[string]string{"env": env},
+				TimeStamp: int64(1612706310 + i),
+				Values:    map[string]float32{"subs": value, "size": sign * value},
+			}
+			err := ps.AddPoint(seriesID, point)
+			if err != nil {
+				t.Fatalf("Failed to add point (%s)", err.Error())
+			}
+		}
+	}
+	nps, _ := paramstores.NewFileAdapter(map[string]interface{}{"Path": t.TempDir()}, 0)
+	conf := config.Config{ML: config.MLParams{MaxEpoch: 10, MaxHLayers: 1, MinHLayers: 1, TestSet: 0.4, Trials: 1}}
+	jobs := newTestRegistry(t)
+
+	// Nets trained on different slices of the same series shouldn't replace each other
+	requests := []types.TrainRequest{}
+	for _, env := range []string{"a", "b"} {
+		tr := types.TrainRequest{
+			ErrMargin: 0.1,
+			ID:        "job-" + env,
+			Inputs:    []string{"subs"},
+			Outputs:   []string{"size"},
+			Required:  20,
+			Selection: types.Selection{Labels: map[string]string{"env": env}},
+			SeriesID:  seriesID,
+			Strategy:  types.RandomSearch,
+		}
+		ctx, _ := jobs.start(tr, 0)
+		err := train(ctx, tr, ps, nps, jobs, conf)
+		if err != nil {
+			t.Fatalf("Failed to train net for env %s (%s)", env, err.Error())
+		}
+		jobs.finish(tr.ID, nil)
+		requests = append(requests, tr)
+	}
+	nets, _, err := List(0, 10, "*", nps)
+	if err != nil {
+		t.Fatalf("Failed to list nets (%s)", err.Error())
+	}
+	if len(nets) != 2 {
+		t.Fatalf("Expected a net for each selection, got %+v", nets)
+	}
+	for _, tr := range requests {
+		champ, err := champion(tr, tr.Outputs, nps)
+		if err != nil {
+			t.Fatalf("Failed to load champion (%s)", err.Error())
+		}
+		if champ == nil {
+			t.Errorf("Expected a champion for selection %v", tr.Selection.Labels)
+			continue
+		}
+		if sel := champ.Params().PointSelection(); sel.Labels["env"] != tr.Selection.Labels["env"] {
+			t.Errorf("Expected the champion of selection %v to have been trained on it, got %v", tr.Selection.Labels, sel.Labels)
+		}
+	}
+}
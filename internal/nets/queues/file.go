@@ -0,0 +1,288 @@
+package queues
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/logger"
+)
+
+// Subdirectories of the queue, a request is moved from one to the next as it's processed
+const (
+	readyDir    = "ready"
+	inflightDir = "inflight"
+	deadDir     = "dead"
+)
+
+// FileAdapter is a training queue implementation that uses the filesystem, each request is kept in its own file and
+// renamed into a different subdirectory depending on its state. It's only meant to be used by a single instance
+type FileAdapter struct {
+	Path     string
+	attempts int // Number of deliveries after which a request that keeps being interrupted is buried
+	mu       sync.Mutex
+	notify   chan struct{}
+}
+
+// NewFileAdapter returns an initialized file training queue, requests that were being processed when the previous
+// instance stopped are put back in the queue, counting it as a failed attempt (as they might be what made it stop)
+func NewFileAdapter(conf map[string]interface{}, attempts int) (*FileAdapter, error) {
+	path, ok := conf["Path"].(string)
+	if !ok {
+		return nil, errors.New("the file training queue needs a Path in its params")
+	}
+	fa := &FileAdapter{Path: path + "/training-queue", attempts: attempts, notify: make(chan struct{}, 1)}
+	for _, dir := range []string{readyDir, inflightDir, deadDir} {
+		err := os.MkdirAll(fa.Path+"/"+dir, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	files, err := fa.list(inflightDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		var item Item
+		found, err := fa.read(inflightDir, file, &item)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		item.Key = file
+		if item.Attempts+1 >= fa.attempts {
+			logger.Warning("Training request " + item.Request.ID + " wasn't finished before the restart and ran out of attempts, burying it")
+			err = fa.write(deadDir, item.Key, expiredLetter(item))
+			if err == nil {
+				err = fa.Ack(item)
+			}
+		} else {
+			logger.Warning("Training request " + item.Request.ID + " wasn't finished before the restart, it will be delivered again")
+			err = fa.requeue(item, item.Attempts+1, 0)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fa, nil
+}
+
+// Ack deletes the file of a delivered request
+func (fa *FileAdapter) Ack(item Item) error {
+	err := os.Remove(fa.Path + "/" + inflightDir + "/" + item.Key)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Bury moves a delivered request to the dead-letter directory
+func (fa *FileAdapter) Bury(item Item, reason string) error {
+	dl := types.DeadLetter{Attempts: item.Attempts + 1, Buried: time.Now().Unix(), Reason: reason, Request: item.Request}
+	err := fa.write(deadDir, item.Key, dl)
+	if err != nil {
+		return err
+	}
+	return fa.Ack(item)
+}
+
+// DeadLetters reads the requests in the dead-letter directory
+func (fa *FileAdapter) DeadLetters() ([]types.DeadLetter, error) {
+	files, err := fa.list(deadDir)
+	if err != nil {
+		return nil, err
+	}
+	dls := []types.DeadLetter{}
+	for _, file := range files {
+		var dl types.DeadLetter
+		found, err := fa.read(deadDir, file, &dl)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			dls = append(dls, dl)
+		}
+	}
+	sort.SliceStable(dls, func(i, j int) bool {
+		return dls[i].Buried < dls[j].Buried
+	})
+	return dls, nil
+}
+
+// Defer writes a delivered request back to the ready directory (as a new file) and deletes the in-flight one
+func (fa *FileAdapter) Defer(item Item, delay time.Duration) error {
+	return fa.requeue(item, item.Attempts, delay)
+}
+
+// Extend does nothing as in-flight requests are only delivered again after a restart
+func (fa *FileAdapter) Extend(item Item) error {
+	return nil
+}
+
+// Pop moves the ready request with the highest priority (the oldest one among those with the same priority) to the
+// in-flight directory and returns it
+func (fa *FileAdapter) Pop(ctx context.Context) (Item, error) {
+	for {
+		item, found, err := fa.next()
+		if err != nil || found {
+			return item, err
+		}
+		select {
+		case <-ctx.Done():
+			return Item{}, ctx.Err()
+		case <-fa.notify:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Push writes a request to the ready directory
+func (fa *FileAdapter) Push(tr types.TrainRequest) error {
+	return fa.add(Item{Ready: millis(time.Now()), Request: tr})
+}
+
+// Retry writes a delivered request back to the ready directory (as a new file) and deletes the in-flight one
+func (fa *FileAdapter) Retry(item Item, delay time.Duration) error {
+	return fa.requeue(item, item.Attempts+1, delay)
+}
+
+// add writes a new item to the ready directory, using a name that sorts the files by the time they become ready and
+// also holds the priority of the request so that it can be picked without reading every file
+func (fa *FileAdapter) add(item Item) error {
+	item.Key = fmt.Sprintf("%020d-%d.%s", item.Ready, item.Request.Priority, uuid.New().String())
+	err := fa.write(readyDir, item.Key, item)
+	if err != nil {
+		return err
+	}
+	select {
+	case fa.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// requeue replaces a delivered request with a new one that becomes ready after the given delay
+func (fa *FileAdapter) requeue(item Item, attempts int, delay time.Duration) error {
+	err := fa.add(Item{Attempts: attempts, Ready: millis(time.Now().Add(delay)), Request: item.Request})
+	if err != nil {
+		return err
+	}
+	return fa.Ack(item)
+}
+
+// list returns the names of the files in a subdirectory of the queue in alphabetical order
+func (fa *FileAdapter) list(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(fa.Path + "/" + dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), ".") {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// next moves the ready request with the highest priority (if any) to the in-flight directory
+func (fa *FileAdapter) next() (Item, bool, error) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	files, err := fa.list(readyDir)
+	if err != nil {
+		return Item{}, false, err
+	}
+	now := millis(time.Now())
+	ready := []string{}
+	priorities := map[string]int{}
+	for _, file := range files {
+		at, priority, ok := parseKey(file)
+		if !ok {
+			continue // Not one of ours
+		}
+		if at > now {
+			// Anything after this isn't ready either
+			break
+		}
+		ready = append(ready, file)
+		priorities[file] = priority
+	}
+	sort.SliceStable(ready, func(i, j int) bool {
+		return priorities[ready[i]] > priorities[ready[j]]
+	})
+	for _, file := range ready {
+		var item Item
+		found, err := fa.read(readyDir, file, &item)
+		if err != nil {
+			return Item{}, false, err
+		}
+		if !found {
+			continue
+		}
+		err = os.Rename(fa.Path+"/"+readyDir+"/"+file, fa.Path+"/"+inflightDir+"/"+file)
+		if err != nil {
+			return Item{}, false, err
+		}
+		item.Key = file
+		return item, true, nil
+	}
+	return Item{}, false, nil
+}
+
+// parseKey returns the time at which the request in a file of the ready directory becomes ready and its priority.
+// Files named before the priority was part of the name are taken as automatic requests
+func parseKey(file string) (int64, int, bool) {
+	parts := strings.SplitN(file, "-", 2)
+	ready, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) < 2 {
+		return 0, 0, false
+	}
+	dot := strings.Index(parts[1], ".")
+	if dot < 0 {
+		return ready, types.PriorityAutomatic, true
+	}
+	priority, err := strconv.Atoi(parts[1][:dot])
+	if err != nil {
+		return 0, 0, false
+	}
+	return ready, priority, true
+}
+
+// read loads a file of the queue, returns false if it doesn't exist
+func (fa *FileAdapter) read(dir, name string, v interface{}) (bool, error) {
+	data, err := ioutil.ReadFile(fa.Path + "/" + dir + "/" + name)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// write stores a value in a file of the queue, it's written to a hidden file first so that it's never read half done
+func (fa *FileAdapter) write(dir, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := fa.Path + "/" + dir + "/." + name
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, fa.Path+"/"+dir+"/"+name)
+}
@@ -0,0 +1,75 @@
+package queues
+
+import (
+	"os"
+	"testing"
+
+	"github.com/qvantel/nerd/api/types"
+)
+
+func TestFileQueue(t *testing.T) {
+	fa, err := NewFileAdapter(map[string]interface{}{"Path": "."}, 3)
+	if err != nil {
+		t.Fatalf("Failed to initialize file queue (%s)", err.Error())
+	}
+	defer os.RemoveAll(fa.Path)
+	testQueue(t, fa)
+}
+
+func TestFilePriority(t *testing.T) {
+	fa, err := NewFileAdapter(map[string]interface{}{"Path": "."}, 3)
+	if err != nil {
+		t.Fatalf("Failed to initialize file queue (%s)", err.Error())
+	}
+	defer os.RemoveAll(fa.Path)
+	testPriority(t, fa)
+}
+
+func TestFileParams(t *testing.T) {
+	_, err := NewFileAdapter(map[string]interface{}{"URL": "localhost:6379"}, 3)
+	if err == nil {
+		t.Error("Creating a file queue without a path should return an error")
+	}
+}
+
+func TestFileRedelivery(t *testing.T) {
+	fa, err := NewFileAdapter(map[string]interface{}{"Path": "."}, 3)
+	if err != nil {
+		t.Fatalf("Failed to initialize file queue (%s)", err.Error())
+	}
+	defer os.RemoveAll(fa.Path)
+	err = fa.Push(types.TrainRequest{ID: "unfinished", SeriesID: "test"})
+	if err != nil {
+		t.Fatalf("Failed to push request (%s)", err.Error())
+	}
+	_, err = pop(fa)
+	if err != nil {
+		t.Fatalf("Failed to pop request (%s)", err.Error())
+	}
+
+	// A new instance should deliver the request again as it was never acknowledged
+	fa, err = NewFileAdapter(map[string]interface{}{"Path": "."}, 3)
+	if err != nil {
+		t.Fatalf("Failed to initialize file queue (%s)", err.Error())
+	}
+	item, err := pop(fa)
+	if err != nil {
+		t.Fatalf("Failed to pop the unfinished request again (%s)", err.Error())
+	}
+	if item.Request.ID != "unfinished" || item.Attempts != 1 {
+		t.Errorf("Expected the unfinished request to be delivered again after 1 attempt, got %+v", item)
+	}
+
+	// The request could be what makes the instance stop so it's buried once it runs out of attempts
+	fa, err = NewFileAdapter(map[string]interface{}{"Path": "."}, 2)
+	if err != nil {
+		t.Fatalf("Failed to initialize file queue (%s)", err.Error())
+	}
+	dls, err := fa.DeadLetters()
+	if err != nil {
+		t.Fatalf("Failed to list dead letters (%s)", err.Error())
+	}
+	if len(dls) != 1 || dls[0].Request.ID != "unfinished" || dls[0].Attempts != 2 {
+		t.Errorf("Expected the unfinished request to be in the dead-letter list after 2 attempts, got %+v", dls)
+	}
+}
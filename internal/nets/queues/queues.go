@@ -0,0 +1,86 @@
+// Package queues contains the implementation of all the supported storage adapters for the training queue
+package queues
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+)
+
+// pollInterval is how often the queues check for requests that have become ready
+const pollInterval = time.Second
+
+// Item is a training request along with the information needed to deliver it
+type Item struct {
+	Attempts int    // Number of times the request has already failed
+	Key      string // Identifies this delivery of the request within the queue
+	Ready    int64  // Unix timestamp (in milliseconds) from which the request can be delivered
+	Request  types.TrainRequest
+}
+
+// Queue is an abstraction over the storage service that keeps the training requests until they are processed. It
+// should survive restarts and deliver every request at least once
+type Queue interface {
+	// Removes a delivered request from the queue for good
+	Ack(item Item) error
+	// Moves a delivered request to the dead-letter list along with the reason why it failed
+	Bury(item Item, reason string) error
+	// Returns the requests in the dead-letter list, oldest first
+	DeadLetters() ([]types.DeadLetter, error)
+	// Puts a delivered request back in the queue without counting it as an attempt so that it's delivered again after
+	// the given delay
+	Defer(item Item, delay time.Duration) error
+	// Signals that a delivered request is still being processed so that it isn't delivered again yet
+	Extend(item Item) error
+	// Returns the ready request with the highest priority (the oldest one among those with the same priority), waiting
+	// for one until the context is done. Requests that aren't acknowledged, retried or buried in time (or before a
+	// restart) will be delivered again
+	Pop(ctx context.Context) (Item, error)
+	// Adds a request to the end of the queue
+	Push(tr types.TrainRequest) error
+	// Puts a delivered request back in the queue so that it's delivered again after the given delay
+	Retry(item Item, delay time.Duration) error
+}
+
+// New creates and returns the corresponding type of queue for the given configuration
+func New(conf config.Config) (Queue, error) {
+	switch conf.ML.QueueType {
+	case config.FileQueue:
+		return NewFileAdapter(conf.ML.QueueParams, conf.ML.Attempts)
+	case config.RedisQueue:
+		return NewRedisAdapter(conf.ML.QueueParams, conf.ML.Attempts, time.Duration(conf.ML.Visibility)*time.Second)
+	default:
+		return nil, errors.New(conf.ML.QueueType + " is not a valid training queue type")
+	}
+}
+
+// Backoff returns how long to wait before retrying a request that had already failed the given number of times
+// before its last attempt, doubling the base delay after each one
+func Backoff(base time.Duration, attempts int) time.Duration {
+	if attempts < 0 {
+		attempts = 0
+	}
+	if attempts > 16 {
+		attempts = 16 // Keeps it from overflowing
+	}
+	return base * time.Duration(1<<attempts)
+}
+
+// expiredLetter returns the dead letter of a delivered request that ran out of attempts because it kept running out of
+// time
+func expiredLetter(item Item) types.DeadLetter {
+	return types.DeadLetter{
+		Attempts: item.Attempts + 1,
+		Buried:   time.Now().Unix(),
+		Reason:   "not finished in time, the instance processing it might have crashed",
+		Request:  item.Request,
+	}
+}
+
+// millis returns the given time as a Unix timestamp in milliseconds
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
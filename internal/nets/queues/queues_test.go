@@ -0,0 +1,146 @@
+package queues
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{-1, 30 * time.Second},
+		{0, 30 * time.Second},
+		{1, time.Minute},
+		{3, 4 * time.Minute},
+	}
+	for _, test := range tests {
+		if delay := Backoff(30*time.Second, test.attempts); delay != test.expected {
+			t.Errorf("Expected a delay of %s after %d attempts, got %s", test.expected, test.attempts, delay)
+		}
+	}
+}
+
+// pop returns the next ready request in the queue or an error if there isn't one within a second
+func pop(q Queue) (Item, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return q.Pop(ctx)
+}
+
+// testQueue checks the delivery, retry and dead-letter logic of an empty queue
+func testQueue(t *testing.T, q Queue) {
+	for _, id := range []string{"first", "second"} {
+		err := q.Push(types.TrainRequest{ID: id, SeriesID: "test"})
+		if err != nil {
+			t.Fatalf("Failed to push request %s (%s)", id, err.Error())
+		}
+		time.Sleep(2 * time.Millisecond) // Makes sure they're ready at different times
+	}
+
+	item, err := pop(q)
+	if err != nil {
+		t.Fatalf("Failed to pop the first request (%s)", err.Error())
+	}
+	if item.Request.ID != "first" || item.Attempts != 0 {
+		t.Errorf("Expected the first request to be delivered first, got %+v", item)
+	}
+	err = q.Ack(item)
+	if err != nil {
+		t.Fatalf("Failed to acknowledge the first request (%s)", err.Error())
+	}
+
+	item, err = pop(q)
+	if err != nil {
+		t.Fatalf("Failed to pop the second request (%s)", err.Error())
+	}
+	if item.Request.ID != "second" {
+		t.Errorf("Expected the second request to be delivered next, got %+v", item)
+	}
+	err = q.Defer(item, 0)
+	if err != nil {
+		t.Fatalf("Failed to defer the second request (%s)", err.Error())
+	}
+	item, err = pop(q)
+	if err != nil {
+		t.Fatalf("Failed to pop the deferred request (%s)", err.Error())
+	}
+	if item.Request.ID != "second" || item.Attempts != 0 {
+		t.Errorf("Expected the second request to be delivered again without counting an attempt, got %+v", item)
+	}
+	err = q.Retry(item, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to retry the second request (%s)", err.Error())
+	}
+	// Nothing should be ready until the delay is over
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = q.Pop(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected no requests to be ready during the retry delay, got %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	item, err = pop(q)
+	if err != nil {
+		t.Fatalf("Failed to pop the retried request (%s)", err.Error())
+	}
+	if item.Request.ID != "second" || item.Attempts != 1 {
+		t.Errorf("Expected the second request to be delivered again after 1 attempt, got %+v", item)
+	}
+
+	err = q.Bury(item, "test failure")
+	if err != nil {
+		t.Fatalf("Failed to bury the second request (%s)", err.Error())
+	}
+	dls, err := q.DeadLetters()
+	if err != nil {
+		t.Fatalf("Failed to list dead letters (%s)", err.Error())
+	}
+	if len(dls) != 1 || dls[0].Request.ID != "second" || dls[0].Attempts != 2 || dls[0].Reason != "test failure" {
+		t.Errorf("Expected the second request to be in the dead-letter list after 2 attempts, got %+v", dls)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = q.Pop(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected the queue to be empty, got %v", err)
+	}
+}
+
+// testPriority checks that manual requests pushed to an empty queue are delivered before the automatic ones that were
+// already waiting
+func testPriority(t *testing.T, q Queue) {
+	expected := []string{"manual"}
+	for i := 0; i < 5; i++ {
+		id := "automatic-" + strconv.Itoa(i)
+		err := q.Push(types.TrainRequest{ID: id, Priority: types.PriorityAutomatic, SeriesID: "test"})
+		if err != nil {
+			t.Fatalf("Failed to push request %s (%s)", id, err.Error())
+		}
+		expected = append(expected, id)
+		time.Sleep(2 * time.Millisecond) // Makes sure they're ready at different times
+	}
+	err := q.Push(types.TrainRequest{ID: "manual", Priority: types.PriorityManual, SeriesID: "test"})
+	if err != nil {
+		t.Fatalf("Failed to push request manual (%s)", err.Error())
+	}
+
+	for _, id := range expected {
+		item, err := pop(q)
+		if err != nil {
+			t.Fatalf("Failed to pop request %s (%s)", id, err.Error())
+		}
+		if item.Request.ID != id {
+			t.Errorf("Expected request %s to be delivered next, got %s", id, item.Request.ID)
+		}
+		err = q.Ack(item)
+		if err != nil {
+			t.Fatalf("Failed to acknowledge request %s (%s)", id, err.Error())
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package queues
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	redis "github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/logger"
+)
+
+// Keys used by the queue, the first two are sorted sets scored by the time (in milliseconds) at which their items
+// should be delivered (ready) or delivered again (in-flight), the last is a list
+const (
+	readyKey    = "nerd-queue@ready"
+	inflightKey = "nerd-queue@inflight"
+	deadKey     = "nerd-queue@dead"
+)
+
+// take atomically picks the item with the highest priority among those that are due (score <= ARGV[1]) in one sorted
+// set (the oldest one if several share it) and moves it to another with a new score (ARGV[2]), returning it
+var take = redis.NewEvalScript(2, `
+local items = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+local best, highest = nil, nil
+for _, item in ipairs(items) do
+	local priority = cjson.decode(item).Request.priority or 0
+	if best == nil or priority > highest then
+		best, highest = item, priority
+	end
+end
+if best == nil then
+	return false
+end
+redis.call('ZREM', KEYS[1], best)
+redis.call('ZADD', KEYS[2], ARGV[2], best)
+return best
+`)
+
+// replace atomically removes an item (ARGV[1]) from a sorted set and, only if it was still there, adds another
+// (ARGV[2]) to a sorted set with the given score (ARGV[3]) or, if there is no score, to the end of a list
+var replace = redis.NewEvalScript(2, `
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+if ARGV[3] == '' then
+	redis.call('RPUSH', KEYS[2], ARGV[2])
+else
+	redis.call('ZADD', KEYS[2], ARGV[3], ARGV[2])
+end
+return 1
+`)
+
+// RedisAdapter is the training queue implementation for Redis, which can be shared by multiple instances
+type RedisAdapter struct {
+	attempts   int // Number of deliveries after which a request that keeps running out of time is buried
+	client     redis.Client
+	sentinel   *redis.Sentinel
+	visibility time.Duration // How long a delivered request can go without being acknowledged before it's delivered again
+}
+
+// NewRedisAdapter returns an initialized Redis training queue
+func NewRedisAdapter(conf map[string]interface{}, attempts int, visibility time.Duration) (*RedisAdapter, error) {
+	if group, found := conf["group"]; found {
+		name, ok := group.(string)
+		urls, ok2 := conf["URLs"].(string)
+		if !ok || !ok2 {
+			return nil, errors.New("the Redis training queue needs a group and URLs in its params when using Sentinel")
+		}
+		sentinel, err := redis.NewSentinel(name, strings.Split(urls, ","))
+		return &RedisAdapter{attempts: attempts, sentinel: sentinel, visibility: visibility}, err
+	}
+	url, ok := conf["URL"].(string)
+	if !ok {
+		return nil, errors.New("the Redis training queue needs a URL in its params")
+	}
+	pool, err := redis.NewPool("tcp", url, 10)
+	return &RedisAdapter{attempts: attempts, client: pool, visibility: visibility}, err
+}
+
+// writeClient returns a client for the primary Redis instance, as that's the only one that can handle writes (and
+// every operation of the queue writes)
+func (ra *RedisAdapter) writeClient() (redis.Client, error) {
+	if ra.sentinel == nil {
+		return ra.client, nil
+	}
+	primary, _ := ra.sentinel.Addrs()
+	client, err := ra.sentinel.Client(primary)
+	return client, err
+}
+
+// Ack removes a delivered request from the in-flight set
+func (ra *RedisAdapter) Ack(item Item) error {
+	return ra.do("acknowledging a training request", redis.Cmd(nil, "ZREM", inflightKey, item.Key))
+}
+
+// Bury adds a delivered request to the dead-letter list and removes it from the in-flight set
+func (ra *RedisAdapter) Bury(item Item, reason string) error {
+	dl, err := json.Marshal(types.DeadLetter{Attempts: item.Attempts + 1, Buried: time.Now().Unix(), Reason: reason, Request: item.Request})
+	if err != nil {
+		return err
+	}
+	err = ra.do("burying a training request", redis.Cmd(nil, "RPUSH", deadKey, string(dl)))
+	if err != nil {
+		return err
+	}
+	return ra.Ack(item)
+}
+
+// DeadLetters reads the dead-letter list
+func (ra *RedisAdapter) DeadLetters() ([]types.DeadLetter, error) {
+	var values []string
+	err := ra.do("listing dead letters", redis.Cmd(&values, "LRANGE", deadKey, "0", "-1"))
+	if err != nil {
+		return nil, err
+	}
+	dls := make([]types.DeadLetter, len(values))
+	for i, value := range values {
+		err = json.Unmarshal([]byte(value), &dls[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dls, nil
+}
+
+// Defer adds a delivered request back to the ready set (as a new item) and removes it from the in-flight set
+func (ra *RedisAdapter) Defer(item Item, delay time.Duration) error {
+	return ra.requeue(item, item.Attempts, delay)
+}
+
+// Extend resets the time a delivered request can go without being acknowledged (if it's still in the in-flight set)
+func (ra *RedisAdapter) Extend(item Item) error {
+	deadline := strconv.FormatInt(millis(time.Now().Add(ra.visibility)), 10)
+	return ra.do("extending a training request", redis.Cmd(nil, "ZADD", inflightKey, "XX", deadline, item.Key))
+}
+
+// Pop moves the ready request with the highest priority (the oldest one among those with the same priority) to the
+// in-flight set (where it will be delivered again if not acknowledged in time) and returns it. Before that, the in-flight requests whose time has run out are reclaimed
+func (ra *RedisAdapter) Pop(ctx context.Context) (Item, error) {
+	for {
+		now := strconv.FormatInt(millis(time.Now()), 10)
+		err := ra.reclaim(now)
+		if err != nil {
+			return Item{}, err
+		}
+		var next redis.MaybeNil
+		var value string
+		next.Rcv = &value
+		deadline := strconv.FormatInt(millis(time.Now().Add(ra.visibility)), 10)
+		err = ra.do("popping a training request", take.Cmd(&next, readyKey, inflightKey, now, deadline))
+		if err != nil {
+			return Item{}, err
+		}
+		if !next.Nil {
+			var item Item
+			err = json.Unmarshal([]byte(value), &item)
+			item.Key = value
+			return item, err
+		}
+		select {
+		case <-ctx.Done():
+			return Item{}, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Push adds a request to the ready set
+func (ra *RedisAdapter) Push(tr types.TrainRequest) error {
+	return ra.add(Item{Ready: millis(time.Now()), Request: tr})
+}
+
+// Retry adds a delivered request back to the ready set (as a new item) and removes it from the in-flight set
+func (ra *RedisAdapter) Retry(item Item, delay time.Duration) error {
+	return ra.requeue(item, item.Attempts+1, delay)
+}
+
+// reclaim puts the in-flight requests whose time has run out (as of now) back in the ready set, counting it as a
+// failed attempt (as they might be what crashed the instance that took them), or buries them once they run out of
+// attempts. Only one instance gets to reclaim each of them
+func (ra *RedisAdapter) reclaim(now string) error {
+	for {
+		var expired []string
+		err := ra.do("listing expired training requests", redis.Cmd(&expired, "ZRANGEBYSCORE", inflightKey, "-inf", now, "LIMIT", "0", "10"))
+		if err != nil || len(expired) == 0 {
+			return err
+		}
+		for _, value := range expired {
+			var item Item
+			err = json.Unmarshal([]byte(value), &item)
+			if err != nil {
+				return err
+			}
+			dest, score := readyKey, now
+			var replacement []byte
+			if item.Attempts+1 >= ra.attempts {
+				logger.Warning("Training request " + item.Request.ID + " wasn't finished in time and ran out of attempts, burying it")
+				dest, score = deadKey, ""
+				replacement, err = json.Marshal(expiredLetter(item))
+			} else {
+				logger.Warning("Training request " + item.Request.ID + " wasn't finished in time, it will be delivered again")
+				next := Item{Attempts: item.Attempts + 1, Key: uuid.New().String(), Request: item.Request}
+				next.Ready, _ = strconv.ParseInt(now, 10, 64)
+				replacement, err = json.Marshal(next)
+			}
+			if err != nil {
+				return err
+			}
+			err = ra.do("reclaiming a training request", replace.Cmd(nil, inflightKey, dest, value, string(replacement), score))
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// requeue replaces a delivered request with a new item that becomes ready after the given delay
+func (ra *RedisAdapter) requeue(item Item, attempts int, delay time.Duration) error {
+	err := ra.add(Item{Attempts: attempts, Ready: millis(time.Now().Add(delay)), Request: item.Request})
+	if err != nil {
+		return err
+	}
+	return ra.Ack(item)
+}
+
+// add stores a new item in the ready set, the key is only there to make each member unique
+func (ra *RedisAdapter) add(item Item) error {
+	item.Key = uuid.New().String()
+	value, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return ra.do("pushing a training request", redis.Cmd(nil, "ZADD", readyKey, strconv.FormatInt(item.Ready, 10), string(value)))
+}
+
+// do runs an action on the primary instance, logging the errors returned by Redis
+func (ra *RedisAdapter) do(action string, a redis.Action) error {
+	var redisErr resp2.Error
+	client, err := ra.writeClient()
+	if err != nil {
+		return err
+	}
+	err = client.Do(a)
+	if errors.As(err, &redisErr) {
+		logger.Error("Redis error returned while "+action, redisErr.E)
+		return redisErr.E
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package queues
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+var testRedisQueue *RedisAdapter
+
+func startRedis(ctx context.Context) (redis testcontainers.Container, url string, err error) {
+	req := testcontainers.ContainerRequest{
+		Image:        "redis:6.0.10-alpine3.13",
+		ExposedPorts: []string{"6379/tcp"},
+		WaitingFor:   wait.ForLog("Ready to accept connections"),
+	}
+	redis, err = testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	endpoint, err := redis.Endpoint(ctx, "")
+	if err != nil {
+		return nil, "", err
+	}
+	return redis, endpoint, nil
+}
+
+func TestRedisQueue(t *testing.T) {
+	testQueue(t, testRedisQueue)
+}
+
+func TestRedisPriority(t *testing.T) {
+	testPriority(t, testRedisQueue)
+}
+
+func TestRedisRedelivery(t *testing.T) {
+	ra := *testRedisQueue
+	ra.visibility = 100 * time.Millisecond
+	err := ra.Push(types.TrainRequest{ID: "unfinished", SeriesID: "test"})
+	if err != nil {
+		t.Fatalf("Failed to push request (%s)", err.Error())
+	}
+	item, err := pop(&ra)
+	if err != nil {
+		t.Fatalf("Failed to pop request (%s)", err.Error())
+	}
+
+	// Extending the request should keep it from being delivered again while it's still being processed
+	time.Sleep(60 * time.Millisecond)
+	err = ra.Extend(item)
+	if err != nil {
+		t.Fatalf("Failed to extend request (%s)", err.Error())
+	}
+	time.Sleep(60 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	_, err = ra.Pop(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected an extended request not to be delivered again, got %v", err)
+	}
+
+	// The request should be delivered again once its visibility timeout runs out
+	time.Sleep(200 * time.Millisecond)
+	item, err = pop(&ra)
+	if err != nil {
+		t.Fatalf("Failed to pop the unfinished request again (%s)", err.Error())
+	}
+	if item.Request.ID != "unfinished" || item.Attempts != 1 {
+		t.Errorf("Expected the unfinished request to be delivered again after 1 attempt, got %+v", item)
+	}
+
+	// The request could be what crashes the instances that take it so it's buried once it runs out of attempts
+	ra.attempts = 2
+	time.Sleep(200 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	_, err = ra.Pop(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected a request that ran out of attempts not to be delivered again, got %v", err)
+	}
+	dls, err := ra.DeadLetters()
+	if err != nil {
+		t.Fatalf("Failed to list dead letters (%s)", err.Error())
+	}
+	if len(dls) == 0 || dls[len(dls)-1].Request.ID != "unfinished" || dls[len(dls)-1].Attempts != 2 {
+		t.Errorf("Expected the unfinished request to be in the dead-letter list after 2 attempts, got %+v", dls)
+	}
+}
+
+func TestMain(m *testing.M) {
+	// Setup
+	ctx := context.Background()
+	redis, url, err := startRedis(ctx)
+	if err != nil {
+		fmt.Printf("Error starting test Redis container (%s)", err.Error())
+		os.Exit(1)
+	}
+	testRedisQueue, err = NewRedisAdapter(map[string]interface{}{"URL": url}, 3, time.Hour)
+	if err != nil {
+		fmt.Printf("Failed to get training queue (%s)", err.Error())
+		os.Exit(1)
+	}
+	// Run
+	code := m.Run()
+	// Teardown
+	if err == nil {
+		redis.Terminate(ctx)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,227 @@
+package nets
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/logger"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/nets/queues"
+	"github.com/qvantel/nerd/internal/resilience"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+const (
+	minInterval    = 60               // Minimum number of seconds between the runs of an interval schedule
+	schedulerGroup = "scheduler"      // Lease taken by the instance that runs the schedules
+	scheduleTick   = 15 * time.Second // How often the scheduler checks for schedules that are due
+)
+
+// schedulesID is the key of the record that holds all the schedules, as an auxiliary record it's never listed as a net
+var schedulesID = paramstores.AuxID("", "schedules")
+
+// schedulesMu serializes the changes made to the schedules record by this instance
+var schedulesMu sync.Mutex
+
+// Schedules returns every schedule in the store sorted by ID
+func Schedules(nps paramstores.NetParamStore) ([]types.Schedule, error) {
+	schedules, err := loadSchedules(nps)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]types.Schedule, 0, len(schedules))
+	for _, s := range schedules {
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+// GetSchedule returns the schedule with the given ID, false will be returned if it doesn't exist
+func GetSchedule(id string, nps paramstores.NetParamStore) (types.Schedule, bool, error) {
+	schedules, err := loadSchedules(nps)
+	if err != nil {
+		return types.Schedule{}, false, err
+	}
+	s, ok := schedules[id]
+	return s, ok, nil
+}
+
+// SaveSchedule creates or replaces the schedule with the ID of the one given. The record of its runs is kept when
+// replacing it and the next run is calculated from the current time. ValidateSchedule should be called first
+func SaveSchedule(s types.Schedule, nps paramstores.NetParamStore) (types.Schedule, error) {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	schedules, err := loadSchedules(nps)
+	if err != nil {
+		return types.Schedule{}, err
+	}
+	prev := schedules[s.ID]
+	s.LastJob, s.LastPoint, s.LastResult, s.LastRun = prev.LastJob, prev.LastPoint, prev.LastResult, prev.LastRun
+	s.NextRun = nextRun(s, time.Now()).Unix()
+	schedules[s.ID] = s
+	return s, nps.Save(schedulesID, paramstores.JSON{Value: schedules})
+}
+
+// DeleteSchedule removes a schedule, returns false if it didn't exist
+func DeleteSchedule(id string, nps paramstores.NetParamStore) (bool, error) {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	schedules, err := loadSchedules(nps)
+	if err != nil {
+		return false, err
+	}
+	if _, found := schedules[id]; !found {
+		return false, nil
+	}
+	delete(schedules, id)
+	return true, nps.Save(schedulesID, paramstores.JSON{Value: schedules})
+}
+
+// ValidateSchedule checks that a schedule has either a valid cron expression or a long enough interval and that its
+// request has outputs (the rest of the request is checked against its series with ValidateRequest)
+func ValidateSchedule(s types.Schedule) error {
+	switch {
+	case s.Cron != "" && s.Interval != 0:
+		return errors.New("schedules must have either a cron expression or an interval, not both")
+	case s.Cron != "":
+		cs, err := parseCron(s.Cron)
+		if err != nil {
+			return err
+		}
+		if cs.next(time.Now()).IsZero() {
+			return errors.New("cron expression " + s.Cron + " never matches")
+		}
+	case s.Interval < minInterval:
+		return errors.New("schedules must have a cron expression or an interval of at least " + strconv.Itoa(minInterval) + " seconds")
+	}
+	if len(s.Request.Outputs) == 0 {
+		return errors.New("the request of a schedule must have at least one output")
+	}
+	return nil
+}
+
+// Scheduler queues the training requests of the schedules that are due until the context is done. Only the instance
+// that holds the scheduler lease runs them, so each run happens once no matter how many instances there are
+func Scheduler(ctx context.Context, q queues.Queue, jobs *Registry, bs *resilience.Breakers, conf config.Config) error {
+	nps, ps, ok := connect(ctx, bs, conf)
+	if !ok {
+		return nil
+	}
+	q = resilience.TrainingQueue(q, bs)
+	owner := instanceID()
+	ttl := time.Duration(conf.ML.Lease) * time.Second
+	if ttl < 2*scheduleTick {
+		ttl = 2 * scheduleTick
+	}
+	logger.Info("Scheduler initialized")
+	ticker := time.NewTicker(scheduleTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Scheduler stopped")
+			return nil
+		case <-ticker.C:
+		}
+		taken, err := nps.Lease(schedulerGroup, owner, ttl)
+		if err != nil {
+			logger.Error("Failed to take the scheduler lease", err)
+			continue
+		}
+		if !taken {
+			continue // Another instance is running the schedules
+		}
+		err = runSchedules(time.Now(), q, ps, nps, jobs)
+		if err != nil {
+			logger.Error("Failed to run schedules", err)
+		}
+	}
+}
+
+// runSchedules runs the schedules that are due at the given time and records the result
+func runSchedules(now time.Time, q queues.Queue, ps pointstores.PointStore, nps paramstores.NetParamStore, jobs *Registry) error {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	schedules, err := loadSchedules(nps)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for id, s := range schedules {
+		if s.NextRun > now.Unix() {
+			continue
+		}
+		s, err = runSchedule(s, now, q, ps, jobs)
+		if err != nil {
+			// It will be tried again on the next tick
+			logger.Error("Failed to run schedule "+id+" for series "+s.Request.SeriesID, err)
+			continue
+		}
+		schedules[id] = s
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return nps.Save(schedulesID, paramstores.JSON{Value: schedules})
+}
+
+// runSchedule queues the request of a schedule unless no points have been added to its series since the last one and
+// returns the schedule with the result of the run
+func runSchedule(s types.Schedule, now time.Time, q queues.Queue, ps pointstores.PointStore, jobs *Registry) (types.Schedule, error) {
+	points, err := ps.GetLastNSelected(s.Request.SeriesID, s.Request.Selection, 1)
+	if err != nil {
+		return s, err
+	}
+	run := s
+	run.LastRun = now.Unix()
+	run.NextRun = nextRun(s, now).Unix()
+	if len(points) == 0 || points[0].TimeStamp <= s.LastPoint {
+		logger.Debug("Skipping schedule " + s.ID + " as there are no new points in " + s.Request.SeriesID)
+		run.LastResult = types.ScheduleSkipped
+		return run, nil
+	}
+	tr := s.Request
+	tr.ID = uuid.New().String()
+	_, err = jobs.Queue(tr)
+	if err != nil {
+		return s, err
+	}
+	err = q.Push(tr)
+	if err != nil {
+		dErr := jobs.Discard(tr.ID)
+		if dErr != nil {
+			logger.Error("Failed to discard job "+tr.ID, dErr)
+		}
+		return s, err
+	}
+	logger.Info("Schedule " + s.ID + " queued job " + tr.ID + " for series " + tr.SeriesID)
+	run.LastJob = tr.ID
+	run.LastPoint = points[0].TimeStamp
+	run.LastResult = types.ScheduleQueued
+	return run, nil
+}
+
+// nextRun returns when a (valid) schedule should run next after the given time
+func nextRun(s types.Schedule, after time.Time) time.Time {
+	if s.Cron == "" {
+		return after.Add(time.Duration(s.Interval) * time.Second)
+	}
+	cs, _ := parseCron(s.Cron)
+	return cs.next(after)
+}
+
+// loadSchedules returns the map of schedule IDs to schedules
+func loadSchedules(nps paramstores.NetParamStore) (map[string]types.Schedule, error) {
+	schedules := map[string]types.Schedule{}
+	_, err := nps.Load(schedulesID, paramstores.JSON{Value: &schedules})
+	return schedules, err
+}
@@ -0,0 +1,109 @@
+package nets
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/nets/queues"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+func TestValidateSchedule(t *testing.T) {
+	tr := types.TrainRequest{Outputs: []string{"b"}, SeriesID: "test"}
+	invalid := []types.Schedule{
+		{Request: tr},
+		{Interval: 30, Request: tr},
+		{Cron: "@daily", Interval: 3600, Request: tr},
+		{Cron: "0 0 31 4 *", Request: tr},
+		{Cron: "@daily"},
+	}
+	for _, s := range invalid {
+		if err := ValidateSchedule(s); err == nil {
+			t.Errorf("Expected %+v to be rejected", s)
+		}
+	}
+	for _, s := range []types.Schedule{{Cron: "@daily", Request: tr}, {Interval: 3600, Request: tr}} {
+		if err := ValidateSchedule(s); err != nil {
+			t.Errorf("Expected %+v to be accepted, got %s", s, err.Error())
+		}
+	}
+}
+
+func TestRunSchedules(t *testing.T) {
+	q, err := queues.NewFileAdapter(map[string]interface{}{"Path": "."}, 3)
+	if err != nil {
+		t.Fatalf("Failed to initialize training queue (%s)", err.Error())
+	}
+	defer os.RemoveAll(q.Path)
+	ps, _ := pointstores.NewFileAdapter(map[string]interface{}{"Path": "."})
+	nps := paramstores.FileAdapter{Path: "."}
+	seriesID := "test-schedules"
+	defer ps.DeleteSeries(seriesID)
+	defer nps.Delete(schedulesID)
+	jobs := newTestRegistry(t)
+
+	err = ps.AddPoint(seriesID, pointstores.Point{TimeStamp: 1612706310, Values: map[string]float32{"a": 1, "b": 2}})
+	if err != nil {
+		t.Fatalf("Failed to add point (%s)", err.Error())
+	}
+	s, err := SaveSchedule(types.Schedule{
+		ID:       "hourly",
+		Interval: 3600,
+		Request:  types.TrainRequest{Inputs: []string{"a"}, Outputs: []string{"b"}, SeriesID: seriesID},
+	}, nps)
+	if err != nil {
+		t.Fatalf("Failed to save schedule (%s)", err.Error())
+	}
+
+	// Not due yet
+	now := time.Now()
+	err = runSchedules(now, q, ps, nps, jobs)
+	if err != nil {
+		t.Fatalf("Failed to run schedules (%s)", err.Error())
+	}
+	if list, _ := jobs.List(""); len(list) != 0 {
+		t.Errorf("Expected no jobs before the schedule is due, got %+v", list)
+	}
+
+	// Due, with new points
+	now = time.Unix(s.NextRun, 0)
+	err = runSchedules(now, q, ps, nps, jobs)
+	if err != nil {
+		t.Fatalf("Failed to run schedules (%s)", err.Error())
+	}
+	s, _, err = GetSchedule("hourly", nps)
+	if err != nil {
+		t.Fatalf("Failed to get schedule (%s)", err.Error())
+	}
+	if s.LastResult != types.ScheduleQueued || s.LastRun != now.Unix() || s.LastPoint != 1612706310 || s.NextRun != now.Unix()+3600 {
+		t.Errorf("Expected the schedule to record a queued run, got %+v", s)
+	}
+	item, err := pop(q)
+	if err != nil {
+		t.Fatalf("Failed to pop request (%s)", err.Error())
+	}
+	if item.Request.ID != s.LastJob || item.Request.SeriesID != seriesID {
+		t.Errorf("Expected the request of job %s to be queued, got %+v", s.LastJob, item.Request)
+	}
+	if job, ok, _ := jobs.Get(s.LastJob); !ok || job.Status != types.JobQueued {
+		t.Errorf("Expected job %s to be registered as queued, got %+v", s.LastJob, job)
+	}
+
+	// Due, without new points
+	lastJob := s.LastJob
+	now = time.Unix(s.NextRun, 0)
+	err = runSchedules(now, q, ps, nps, jobs)
+	if err != nil {
+		t.Fatalf("Failed to run schedules (%s)", err.Error())
+	}
+	s, _, _ = GetSchedule("hourly", nps)
+	if s.LastResult != types.ScheduleSkipped || s.LastRun != now.Unix() || s.LastJob != lastJob {
+		t.Errorf("Expected the schedule to record a skipped run, got %+v", s)
+	}
+	if list, _ := jobs.List(""); len(list) != 1 {
+		t.Errorf("Expected no new jobs when there are no new points, got %+v", list)
+	}
+}
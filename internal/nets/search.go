@@ -0,0 +1,170 @@
+package nets
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+// Strategy represents a method for exploring the hyperparameter space in search of the most accurate net
+type Strategy interface {
+	// This method should return the most accurate net it could find for the given points along with the history of
+	// the configurations that were tried. If the context is cancelled, it should stop and return the best net so far
+	Search(ctx context.Context, tr types.TrainRequest, outputs []string, points []pointstores.Point) (Network, []types.Trial, error)
+}
+
+// NewStrategy returns an initialized search strategy of the given type, if empty, the configured default will be used
+func NewStrategy(name string, params config.MLParams) (Strategy, error) {
+	if name == "" {
+		name = params.Strategy
+	}
+	switch name {
+	case types.Genetic:
+		return NewPopulation(params), nil
+	case types.RandomSearch:
+		return &Random{params: params}, nil
+	case types.GridSearch:
+		return &Grid{params: params}, nil
+	case types.Bayesian:
+		return &TPE{params: params}, nil
+	default:
+		return nil, errors.New(name + " is not a valid search strategy")
+	}
+}
+
+// Trial returns the chromosome's configuration and fitness in the format used for reporting the search history
+func (c Chromosome) Trial() types.Trial {
+	return types.Trial{
+		ActivationFunc: c.ActivationFunc,
+		Fitness:        c.Fitness,
+		HLayers:        c.HLayers,
+		LearningRate:   c.LearningRate,
+		Type:           c.Type,
+	}
+}
+
+// trialRecorder keeps track of the configurations evaluated during a search
+type trialRecorder struct {
+	trials []types.Trial
+}
+
+// check works like Chromosome.Check but also adds an entry to the history whenever a net is actually trained (or fails
+// to be, unless the search was stopped). It will return the context's error without training anything if it has
+// already been cancelled
+func (rec *trialRecorder) check(ctx context.Context, c *Chromosome, tr types.TrainRequest, outputs []string, points []pointstores.Point, params config.MLParams) error {
+	if c.Net != nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	err := c.Check(ctx, tr, outputs, points, params)
+	if stopped(err) {
+		return err
+	}
+	trial := c.Trial()
+	if err != nil {
+		c.Net = nil
+		trial.Error = err.Error()
+	}
+	rec.trials = append(rec.trials, trial)
+	report(ctx, func(p *types.Progress) {
+		p.Trials++
+	})
+	return err
+}
+
+// stopped returns true if the error means that the search was interrupted through its context
+func stopped(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// interrupted returns the best net found so far when the search was stopped through its context
+func interrupted(best *Chromosome, trials []types.Trial, err error) (Network, []types.Trial, error) {
+	if best != nil && best.Net != nil && stopped(err) {
+		return best.Net, trials, nil
+	}
+	return nil, trials, err
+}
+
+// searched returns the best net found by a search that went through all of its configurations, or the error of the
+// last one if none of them could be trained
+func searched(best *Chromosome, trials []types.Trial, err error) (Network, []types.Trial, error) {
+	if best == nil {
+		return nil, trials, err
+	}
+	return best.Net, trials, nil
+}
+
+// randomChromosome returns a chromosome with random genes within the configured limits
+func randomChromosome(params config.MLParams) Chromosome {
+	return Chromosome{
+		ActivationFunc: randomString(types.ActivationFuncs()),
+		Fitness:        -1,
+		HLayers:        rand.Intn(params.MaxHLayers-params.MinHLayers+1) + params.MinHLayers,
+		LearningRate:   float32(rand.Intn(999)+1) / 1000,
+		Type:           randomString(types.Nets()),
+	}
+}
+
+// Random is a search strategy that evaluates a fixed number of random configurations
+type Random struct {
+	trialRecorder
+	params config.MLParams
+}
+
+// Search evaluates as many random configurations as trials are configured and returns the best net. Configurations
+// that fail to train are recorded and skipped, only if all of them fail is the last error returned
+func (r *Random) Search(ctx context.Context, tr types.TrainRequest, outputs []string, points []pointstores.Point) (Network, []types.Trial, error) {
+	var best *Chromosome
+	var err error
+	for i := 0; i < r.params.Trials; i++ {
+		c := randomChromosome(r.params)
+		err = r.check(ctx, &c, tr, outputs, points, r.params)
+		if stopped(err) {
+			return interrupted(best, r.trials, err)
+		}
+		if err == nil && (best == nil || c.Fitness > best.Fitness) {
+			best = &c
+		}
+	}
+	return searched(best, r.trials, err)
+}
+
+// gridRates holds the learning rates that are tried by the grid search strategy
+var gridRates = []float32{0.001, 0.003, 0.01, 0.03, 0.1, 0.3}
+
+// Grid is a search strategy that exhaustively evaluates every combination of net type, activation function, number
+// of hidden layers (between the configured min and max) and learning rate (from a fixed set)
+type Grid struct {
+	trialRecorder
+	params config.MLParams
+}
+
+// Search evaluates every configuration in the grid and returns the best net. Configurations that fail to train are
+// recorded and skipped, only if all of them fail is the last error returned
+func (g *Grid) Search(ctx context.Context, tr types.TrainRequest, outputs []string, points []pointstores.Point) (Network, []types.Trial, error) {
+	var best *Chromosome
+	var err error
+	for _, nType := range types.Nets() {
+		for _, af := range types.ActivationFuncs() {
+			for hl := g.params.MinHLayers; hl <= g.params.MaxHLayers; hl++ {
+				for _, lr := range gridRates {
+					c := Chromosome{ActivationFunc: af, Fitness: -1, HLayers: hl, LearningRate: lr, Type: nType}
+					err = g.check(ctx, &c, tr, outputs, points, g.params)
+					if stopped(err) {
+						return interrupted(best, g.trials, err)
+					}
+					if err == nil && (best == nil || c.Fitness > best.Fitness) {
+						best = &c
+					}
+				}
+			}
+		}
+	}
+	return searched(best, g.trials, err)
+}
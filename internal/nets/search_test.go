@@ -0,0 +1,111 @@
+package nets
+
+import (
+	"context"
+	"testing"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+func TestSearch(t *testing.T) {
+	mlConf := config.MLParams{
+		Generations: 2,
+		MaxEpoch:    1000,
+		MaxHLayers:  2,
+		MinHLayers:  1,
+		Strategy:    types.Genetic,
+		TestSet:     0.4,
+		Tolerance:   0.1,
+		Trials:      6,
+		Variations:  4,
+	}
+	ps := pointstores.FileAdapter{Path: "."}
+	points, err := ps.LoadTestSet("../../test/normalization_test_data.txt")
+	if err != nil {
+		t.Fatalf("Failed to load test data (%s)", err.Error())
+	}
+	tr := types.TrainRequest{
+		ErrMargin: 0.49999999,
+		Inputs:    []string{"value-0", "value-1", "value-2", "value-3", "value-4", "value-5", "value-6", "value-7", "value-8"},
+		Outputs:   []string{"value-9"},
+		SeriesID:  "file-test-set",
+	}
+	expected := map[string]int{
+		types.Genetic:      mlConf.Variations + mlConf.Generations*(mlConf.Variations-mlConf.Elitism),
+		types.RandomSearch: mlConf.Trials,
+		types.GridSearch:   len(gridRates) * (mlConf.MaxHLayers - mlConf.MinHLayers + 1),
+		types.Bayesian:     mlConf.Trials,
+	}
+
+	for _, name := range types.Strategies() {
+		strategy, err := NewStrategy(name, mlConf)
+		if err != nil {
+			t.Fatalf("Failed to initialize %s strategy (%s)", name, err.Error())
+		}
+		net, trials, err := strategy.Search(context.Background(), tr, tr.Outputs, points)
+		if err != nil {
+			t.Fatalf("%s search failed (%s)", name, err.Error())
+		}
+		if len(trials) > expected[name] || len(trials) == 0 {
+			t.Errorf("Expected %s search to report up to %d trials, got %d", name, expected[name], len(trials))
+		}
+		netAcc := net.Params().Brief().Accuracy
+		for _, trial := range trials {
+			if trial.Fitness > netAcc {
+				t.Errorf("Expected %s search to return the most accurate net (%f), got %f instead", name, trial.Fitness, netAcc)
+				break
+			}
+		}
+	}
+
+	_, err = NewStrategy("invalid-strategy", mlConf)
+	if err == nil {
+		t.Error("An invalid strategy name didn't return an error")
+	}
+}
+
+func TestHLayerLimits(t *testing.T) {
+	mlConf := config.MLParams{MaxHLayers: 3, MinHLayers: 2}
+	tpe := TPE{params: mlConf}
+	around := []Chromosome{{HLayers: 2, LearningRate: 0.1}, {HLayers: 3, LearningRate: 0.2}}
+	for i := 0; i < 100; i++ {
+		if c := randomChromosome(mlConf); c.HLayers < mlConf.MinHLayers || c.HLayers > mlConf.MaxHLayers {
+			t.Fatalf("Expected random chromosomes to have between %d and %d hidden layers, got %d", mlConf.MinHLayers, mlConf.MaxHLayers, c.HLayers)
+		}
+		if c := tpe.sample(around); c.HLayers < mlConf.MinHLayers || c.HLayers > mlConf.MaxHLayers {
+			t.Fatalf("Expected TPE samples to have between %d and %d hidden layers, got %d", mlConf.MinHLayers, mlConf.MaxHLayers, c.HLayers)
+		}
+	}
+}
+
+func TestFailedTrials(t *testing.T) {
+	mlConf := config.MLParams{MaxEpoch: 10, MaxHLayers: 1, MinHLayers: 1, TestSet: 0.4, Trials: 6}
+	tr := types.TrainRequest{ErrMargin: 0.1, Inputs: []string{"a"}, Normalization: map[string]string{"a": "invalid"}, Outputs: []string{"b"}, SeriesID: "test"}
+	expected := map[string]int{
+		types.RandomSearch: mlConf.Trials,
+		types.GridSearch:   len(types.Nets()) * len(types.ActivationFuncs()) * len(gridRates),
+		types.Bayesian:     mlConf.Trials,
+	}
+	for name, count := range expected {
+		strategy, err := NewStrategy(name, mlConf)
+		if err != nil {
+			t.Fatalf("Failed to initialize %s strategy (%s)", name, err.Error())
+		}
+		// With an invalid normalization scheme no configuration can be trained, but every one of them should still be tried
+		_, trials, err := strategy.Search(context.Background(), tr, tr.Outputs, []pointstores.Point{})
+		if err == nil {
+			t.Errorf("Expected %s search to fail when no configuration can be trained", name)
+		}
+		if len(trials) != count {
+			t.Errorf("Expected %s search to try %d configurations, got %d", name, count, len(trials))
+		}
+		for _, trial := range trials {
+			if trial.Error == "" {
+				t.Errorf("Expected the failed %s trials to have an error, got %+v", name, trial)
+				break
+			}
+		}
+	}
+}
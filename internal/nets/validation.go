@@ -0,0 +1,130 @@
+package nets
+
+import (
+	"fmt"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+// recentPoints is the number of points of a series that the values used by a training request are looked for in
+const recentPoints = 100
+
+// RequiredFor returns the number of points needed to train the nets of a request, considering its derived features
+func RequiredFor(tr types.TrainRequest, conf config.Config) int {
+	_, inputs, _ := deriveFeatures(tr, nil)
+	return Required(len(inputs), 1, conf.ML.MaxHLayers, conf) // 1 because individual nets are created for each output
+}
+
+// ValidateRequest checks a training request and compares it with the contents of its series (which must exist),
+// returning every problem found. An error is only returned if the series can't be read
+func ValidateRequest(tr types.TrainRequest, ps pointstores.PointStore) ([]string, error) {
+	problems := requestProblems(tr)
+
+	points, err := ps.GetLastNSelected(tr.SeriesID, tr.Selection, recentPoints)
+	if err != nil {
+		return nil, err
+	}
+	if len(points) == 0 && tr.Selection.Empty() {
+		return append(problems, tr.SeriesID+" has no points"), nil
+	} else if len(points) == 0 {
+		return append(problems, "no points of "+tr.SeriesID+" match the selection"), nil
+	}
+	values := map[string]bool{}
+	for _, p := range points {
+		for name := range p.Values {
+			values[name] = true
+		}
+	}
+	missing := func(kind, name string) {
+		if !values[name] {
+			problems = append(problems, fmt.Sprintf("%s %s isn't present in the latest points of %s", kind, name, tr.SeriesID))
+		}
+	}
+	for _, input := range tr.Inputs {
+		missing("input", input)
+	}
+	for _, output := range tr.Outputs {
+		missing("output", output)
+	}
+	for _, lag := range tr.Lags {
+		missing("lagged value", lag.Label)
+	}
+	for _, window := range tr.Windows {
+		missing("windowed value", window.Label)
+	}
+
+	if tr.Required > 0 {
+		needed := tr.Required + historyDepth(tr.Lags, tr.Windows)
+		var count int
+		if tr.Selection.Empty() {
+			count, err = ps.GetCount(tr.SeriesID, nil)
+		} else {
+			points, err = ps.GetLastNSelected(tr.SeriesID, tr.Selection, needed)
+			count = len(points)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if count < needed && tr.Selection.Empty() {
+			problems = append(problems, fmt.Sprintf("%s has %d points but %d are required", tr.SeriesID, count, needed))
+		} else if count < needed {
+			problems = append(problems, fmt.Sprintf("%s has %d points that match the selection but %d are required", tr.SeriesID, count, needed))
+		}
+	}
+	return problems, nil
+}
+
+// requestProblems returns the problems of a training request that don't depend on the contents of its series
+func requestProblems(tr types.TrainRequest) []string {
+	problems := []string{}
+	if len(tr.Outputs) == 0 {
+		problems = append(problems, "at least one output is required")
+	}
+	outputs := map[string]bool{}
+	for _, output := range tr.Outputs {
+		outputs[output] = true
+	}
+	for _, input := range tr.Inputs {
+		if outputs[input] {
+			problems = append(problems, input+" can't be both an input and an output")
+		}
+	}
+	if tr.ErrMargin <= 0 {
+		problems = append(problems, "errMargin must be positive")
+	}
+	if tr.Required < 1 {
+		problems = append(problems, "required must be positive")
+	}
+	if tr.Selection.From != 0 && tr.Selection.To != 0 && tr.Selection.From > tr.Selection.To {
+		problems = append(problems, "the selection must start before it ends")
+	}
+	for label, scheme := range tr.Normalization {
+		if !config.Present(types.Normalizations(), scheme) {
+			problems = append(problems, scheme+" is not a valid normalization scheme (used for "+label+")")
+		}
+	}
+	if tr.Strategy != "" && !config.Present(types.Strategies(), tr.Strategy) {
+		problems = append(problems, tr.Strategy+" is not a valid search strategy")
+	}
+	for _, feature := range tr.TimeFeatures {
+		if !config.Present(types.TimeFeatures(), feature) {
+			problems = append(problems, feature+" is not a valid time feature")
+		}
+	}
+	for _, lag := range tr.Lags {
+		if lag.Steps < 1 {
+			problems = append(problems, "the lags of "+lag.Label+" must have at least 1 step")
+		}
+	}
+	for _, window := range tr.Windows {
+		if !config.Present(types.Aggregates(), window.Aggregate) {
+			problems = append(problems, window.Aggregate+" is not a valid aggregate (used for "+window.Label+")")
+		}
+		if window.Size < 1 {
+			problems = append(problems, "the windows of "+window.Label+" must have a size of at least 1")
+		}
+	}
+	return problems
+}
@@ -0,0 +1,86 @@
+package nets
+
+import (
+	"testing"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+func TestValidateRequest(t *testing.T) {
+	ps, _ := pointstores.NewFileAdapter(map[string]interface{}{"Path": "."})
+	seriesID := "test-validate-request"
+	defer ps.DeleteSeries(seriesID)
+	for i := 0; i < 5; i++ {
+		err := ps.AddPoint(seriesID, pointstores.Point{TimeStamp: int64(1612706310 + i), Values: map[string]float32{"subs": float32(i), "size": float32(10 * i)}})
+		if err != nil {
+			t.Fatalf("Failed to add point (%s)", err.Error())
+		}
+	}
+
+	valid := types.TrainRequest{ErrMargin: 0.1, Inputs: []string{"subs"}, Outputs: []string{"size"}, Required: 5, SeriesID: seriesID}
+	problems, err := ValidateRequest(valid, ps)
+	if err != nil {
+		t.Fatalf("Failed to validate request (%s)", err.Error())
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected a valid request to have no problems, got %v", problems)
+	}
+
+	invalid := types.TrainRequest{
+		Inputs:   []string{"sbus", "size"},
+		Lags:     []types.Lag{{Label: "subs", Steps: 1}},
+		Outputs:  []string{"size"},
+		Required: 5,
+		SeriesID: seriesID,
+	}
+	problems, err = ValidateRequest(invalid, ps)
+	if err != nil {
+		t.Fatalf("Failed to validate request (%s)", err.Error())
+	}
+	expected := []string{
+		"size can't be both an input and an output",
+		"errMargin must be positive",
+		"input sbus isn't present in the latest points of " + seriesID,
+		seriesID + " has 5 points but 6 are required",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %v", len(expected), problems)
+	}
+	for i := range expected {
+		if problems[i] != expected[i] {
+			t.Errorf("Expected problem %d to be %q, got %q", i, expected[i], problems[i])
+		}
+	}
+
+	if problems := requestProblems(types.TrainRequest{ErrMargin: 0.1, Inputs: []string{"subs"}, Required: 5}); len(problems) != 1 || problems[0] != "at least one output is required" {
+		t.Errorf("Expected a request without outputs to be reported, got %v", problems)
+	}
+
+	selected := valid
+	selected.Selection = types.Selection{Labels: map[string]string{"stage": "production"}}
+	problems, err = ValidateRequest(selected, ps)
+	if err != nil {
+		t.Fatalf("Failed to validate request (%s)", err.Error())
+	}
+	if len(problems) != 1 || problems[0] != "no points of "+seriesID+" match the selection" {
+		t.Errorf("Expected a selection that matches nothing to be reported, got %v", problems)
+	}
+
+	// Series without points get a single problem instead of one for every value
+	ms, _ := pointstores.NewMemoryAdapter(map[string]interface{}{}, 0)
+	empty := valid
+	empty.SeriesID = "test-validate-request-empty"
+	err = ms.AddSeries(empty.SeriesID, pointstores.Point{}, 0)
+	if err != nil {
+		t.Fatalf("Failed to create series (%s)", err.Error())
+	}
+	defer ms.DeleteSeries(empty.SeriesID)
+	problems, err = ValidateRequest(empty, ms)
+	if err != nil {
+		t.Fatalf("Failed to validate request (%s)", err.Error())
+	}
+	if len(problems) != 1 || problems[0] != empty.SeriesID+" has no points" {
+		t.Errorf("Expected a series without points to be reported once, got %v", problems)
+	}
+}
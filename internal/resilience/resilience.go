@@ -0,0 +1,192 @@
+// Package resilience protects the calls to external stores with retries and circuit breakers so that a failing store
+// only affects the work that depends on it
+package resilience
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/logger"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+// ErrOpen is returned instead of calling a store while its circuit breaker is open
+var ErrOpen = errors.New("store unavailable, calls are paused while it recovers")
+
+// Breaker stops calls to a store after a number of consecutive failures, letting a single call through once the
+// cooldown is over to check whether it has recovered
+type Breaker struct {
+	cooldown  time.Duration
+	failures  int
+	lastError string
+	mu        sync.Mutex
+	name      string
+	since     time.Time
+	state     string
+	threshold int
+	trying    bool // Whether the call that checks if the store has recovered is in progress
+}
+
+// Allow returns true if a call to the store can be made now
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == types.BreakerOpen && time.Since(b.since) >= b.cooldown {
+		b.change(types.BreakerHalfOpen)
+	}
+	switch b.state {
+	case types.BreakerClosed:
+		return true
+	case types.BreakerHalfOpen:
+		if b.trying {
+			return false
+		}
+		b.trying = true
+		return true
+	default:
+		return false
+	}
+}
+
+// Record updates the state of the breaker with the result of a call that it allowed
+func (b *Breaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trying = false
+	if err == nil {
+		b.failures = 0
+		if b.state != types.BreakerClosed {
+			logger.Info("The " + b.name + " store has recovered")
+			b.change(types.BreakerClosed)
+		}
+		return
+	}
+	b.failures++
+	b.lastError = err.Error()
+	if b.state == types.BreakerHalfOpen || (b.state == types.BreakerClosed && b.failures >= b.threshold) {
+		logger.Warning("The " + b.name + " store keeps failing (" + b.lastError + "), pausing calls to it")
+		b.change(types.BreakerOpen)
+	}
+}
+
+// Skip ends a call that it allowed without changing the state of the breaker, for calls whose result says nothing
+// about the health of the store
+func (b *Breaker) Skip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trying = false
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() types.StoreHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return types.StoreHealth{
+		Failures:  b.failures,
+		LastError: b.lastError,
+		Name:      b.name,
+		Since:     b.since.Unix(),
+		State:     b.state,
+	}
+}
+
+// change moves the breaker to a new state. The lock must be held
+func (b *Breaker) change(state string) {
+	b.state = state
+	b.since = time.Now()
+}
+
+// Breakers keeps a circuit breaker for each of the stores used by the training service
+type Breakers struct {
+	breakers map[string]*Breaker
+	mu       sync.Mutex
+	params   config.MLParams
+}
+
+// NewBreakers returns an empty set of breakers that will use the thresholds and retry settings in the params
+func NewBreakers(params config.MLParams) *Breakers {
+	return &Breakers{breakers: map[string]*Breaker{}, params: params}
+}
+
+// Breaker returns the breaker of the named store, creating it (closed) if it didn't exist
+func (bs *Breakers) Breaker(name string) *Breaker {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.breakers[name]
+	if !ok {
+		b = &Breaker{
+			cooldown:  time.Duration(bs.params.BreakerCool) * time.Second,
+			name:      name,
+			since:     time.Now(),
+			state:     types.BreakerClosed,
+			threshold: bs.params.BreakerMax,
+		}
+		bs.breakers[name] = b
+	}
+	return b
+}
+
+// Do calls fn through the breaker of the named store, retrying it with an increasing delay while it fails and the
+// breaker allows it. Permanent errors are returned right away without counting them as failures of the store. ErrOpen
+// (wrapped) is returned if the breaker doesn't allow a call
+func (bs *Breakers) Do(name string, fn func() error) error {
+	b := bs.Breaker(name)
+	var err error
+	backoff := time.Duration(bs.params.StoreWait) * time.Millisecond
+	for attempt := 0; attempt < bs.params.StoreRetry; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if !b.Allow() {
+			return fmt.Errorf("%s: %w", name, ErrOpen)
+		}
+		err = fn()
+		if permanent(err) {
+			b.Skip()
+			return err
+		}
+		b.Record(err)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// permanent returns true if an error is caused by the call itself (like asking for a series that doesn't exist or
+// sending points that can't be stored) rather than by the store being unreachable or too slow
+func permanent(err error) bool {
+	var requestErr pointstores.RequestError
+	var batchErr pointstores.BatchError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return err != nil && (errors.As(err, &requestErr) || errors.As(err, &batchErr) || errors.As(err, &syntaxErr) ||
+		errors.As(err, &typeErr) || errors.Is(err, os.ErrNotExist))
+}
+
+// Health returns the state of every breaker, the status will be degraded if any of them isn't closed
+func (bs *Breakers) Health() types.Health {
+	bs.mu.Lock()
+	names := make([]string, 0, len(bs.breakers))
+	for name := range bs.breakers {
+		names = append(names, name)
+	}
+	bs.mu.Unlock()
+	sort.Strings(names)
+	health := types.Health{Status: types.HealthOK, Stores: make([]types.StoreHealth, len(names))}
+	for i, name := range names {
+		health.Stores[i] = bs.Breaker(name).State()
+		if health.Stores[i].State != types.BreakerClosed {
+			health.Status = types.HealthDegraded
+		}
+	}
+	return health
+}
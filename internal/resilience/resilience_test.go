@@ -0,0 +1,107 @@
+package resilience
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+func TestBreaker(t *testing.T) {
+	b := &Breaker{cooldown: 50 * time.Millisecond, name: "test", state: types.BreakerClosed, threshold: 2}
+	failure := errors.New("connection refused")
+	for i := 0; i < 2; i++ {
+		if !b.Allow() {
+			t.Fatalf("Expected call %d to be allowed", i+1)
+		}
+		b.Record(failure)
+	}
+	if state := b.State(); state.State != types.BreakerOpen || state.Failures != 2 || state.LastError != failure.Error() {
+		t.Errorf("Expected the breaker to open after 2 failures, got %+v", state)
+	}
+	if b.Allow() {
+		t.Error("Expected no calls to be allowed while the breaker is open")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("Expected a call to be allowed after the cooldown")
+	}
+	if b.Allow() {
+		t.Error("Expected only one call to be allowed while checking if the store has recovered")
+	}
+	b.Record(failure)
+	if state := b.State(); state.State != types.BreakerOpen {
+		t.Errorf("Expected the breaker to open again after the check failed, got %+v", state)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("Expected a call to be allowed after the cooldown")
+	}
+	b.Record(nil)
+	if state := b.State(); state.State != types.BreakerClosed || state.Failures != 0 {
+		t.Errorf("Expected the breaker to close after the check succeeded, got %+v", state)
+	}
+}
+
+func TestDo(t *testing.T) {
+	bs := NewBreakers(config.MLParams{BreakerCool: 30, BreakerMax: 3, StoreRetry: 3, StoreWait: 1})
+	calls := 0
+	err := bs.Do(Points, func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("timeout")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Expected the call to succeed on the third attempt, got %v after %d attempts", err, calls)
+	}
+	if health := bs.Health(); health.Status != types.HealthOK || len(health.Stores) != 1 {
+		t.Errorf("Expected the training to be healthy after the call succeeded, got %+v", health)
+	}
+
+	calls = 0
+	for i := 0; i < 2; i++ {
+		err = bs.Do(Params, func() error {
+			calls++
+			return errors.New("connection refused")
+		})
+	}
+	if !errors.Is(err, ErrOpen) || calls != 3 {
+		t.Errorf("Expected the breaker to stop the calls after 3 failures, got %v after %d attempts", err, calls)
+	}
+	health := bs.Health()
+	if health.Status != types.HealthDegraded || len(health.Stores) != 2 || health.Stores[0].Name != Params || health.Stores[0].State != types.BreakerOpen {
+		t.Errorf("Expected the training to be degraded by the params store, got %+v", health)
+	}
+}
+
+func TestDoPermanent(t *testing.T) {
+	bs := NewBreakers(config.MLParams{BreakerCool: 30, BreakerMax: 3, StoreRetry: 3, StoreWait: 1})
+	ps, err := pointstores.NewMemoryAdapter(nil, 0)
+	if err != nil {
+		t.Fatalf("Failed to initialize memory point store (%s)", err.Error())
+	}
+	calls := 0
+	for i := 0; i < 5; i++ {
+		err := bs.Do(Points, func() error {
+			calls++
+			_, err := ps.GetLatest("missing", nil)
+			return err
+		})
+		if err == nil || errors.Is(err, ErrOpen) {
+			t.Fatalf("Expected getting the points of a missing series to return its own error, got %v", err)
+		}
+	}
+	if calls != 5 {
+		t.Errorf("Expected permanent errors not to be retried, got %d attempts for 5 calls", calls)
+	}
+	if state := bs.Breaker(Points).State(); state.State != types.BreakerClosed || state.Failures != 0 {
+		t.Errorf("Expected permanent errors to leave the breaker closed, got %+v", state)
+	}
+}
@@ -0,0 +1,242 @@
+package resilience
+
+import (
+	"context"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/nets/queues"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+// Names of the breakers that protect each kind of store
+const (
+	Params = "params"
+	Points = "points"
+	Queue  = "queue"
+)
+
+// paramStore is a net param store whose calls go through a breaker
+type paramStore struct {
+	bs  *Breakers
+	nps paramstores.NetParamStore
+}
+
+// NetParamStore wraps a net param store so that its calls are retried and go through the params breaker
+func NetParamStore(nps paramstores.NetParamStore, bs *Breakers) paramstores.NetParamStore {
+	return paramStore{bs: bs, nps: nps}
+}
+
+func (ps paramStore) Delete(id string) error {
+	return ps.bs.Do(Params, func() error { return ps.nps.Delete(id) })
+}
+
+func (ps paramStore) Lease(group, owner string, ttl time.Duration) (taken bool, err error) {
+	err = ps.bs.Do(Params, func() error {
+		taken, err = ps.nps.Lease(group, owner, ttl)
+		return err
+	})
+	return taken, err
+}
+
+func (ps paramStore) List(offset, limit int, pattern string) (ids []string, cursor int, err error) {
+	err = ps.bs.Do(Params, func() error {
+		ids, cursor, err = ps.nps.List(offset, limit, pattern)
+		return err
+	})
+	return ids, cursor, err
+}
+
+func (ps paramStore) ListAux(kind string) (ids []string, err error) {
+	err = ps.bs.Do(Params, func() error {
+		ids, err = ps.nps.ListAux(kind)
+		return err
+	})
+	return ids, err
+}
+
+func (ps paramStore) ListVersions(id string) (versions []int, err error) {
+	err = ps.bs.Do(Params, func() error {
+		versions, err = ps.nps.ListVersions(id)
+		return err
+	})
+	return versions, err
+}
+
+func (ps paramStore) Load(id string, np paramstores.Storable) (found bool, err error) {
+	err = ps.bs.Do(Params, func() error {
+		found, err = ps.nps.Load(id, np)
+		return err
+	})
+	return found, err
+}
+
+func (ps paramStore) LoadVersion(id string, version int, np paramstores.Storable) (found bool, err error) {
+	err = ps.bs.Do(Params, func() error {
+		found, err = ps.nps.LoadVersion(id, version, np)
+		return err
+	})
+	return found, err
+}
+
+func (ps paramStore) Release(group, owner string) error {
+	return ps.bs.Do(Params, func() error { return ps.nps.Release(group, owner) })
+}
+
+func (ps paramStore) Save(id string, np paramstores.Storable) error {
+	return ps.bs.Do(Params, func() error { return ps.nps.Save(id, np) })
+}
+
+func (ps paramStore) SaveVersion(id string, np paramstores.Storable) (version int, err error) {
+	err = ps.bs.Do(Params, func() error {
+		version, err = ps.nps.SaveVersion(id, np)
+		return err
+	})
+	return version, err
+}
+
+// pointStore is a point store whose calls go through a breaker
+type pointStore struct {
+	bs *Breakers
+	ps pointstores.PointStore
+}
+
+// PointStore wraps a point store so that its calls are retried and go through the points breaker
+func PointStore(ps pointstores.PointStore, bs *Breakers) pointstores.PointStore {
+	return pointStore{bs: bs, ps: ps}
+}
+
+func (ps pointStore) AddPoint(name string, p pointstores.Point) error {
+	return ps.bs.Do(Points, func() error { return ps.ps.AddPoint(name, p) })
+}
+
+func (ps pointStore) AddPoints(name string, points []pointstores.Point) error {
+	return ps.bs.Do(Points, func() error { return ps.ps.AddPoints(name, points) })
+}
+
+func (ps pointStore) AddSeries(name string, sample pointstores.Point, retentionDays int) error {
+	return ps.bs.Do(Points, func() error { return ps.ps.AddSeries(name, sample, retentionDays) })
+}
+
+func (ps pointStore) DeleteSeries(name string) error {
+	return ps.bs.Do(Points, func() error { return ps.ps.DeleteSeries(name) })
+}
+
+func (ps pointStore) Exists(name string) (exists bool, err error) {
+	err = ps.bs.Do(Points, func() error {
+		exists, err = ps.ps.Exists(name)
+		return err
+	})
+	return exists, err
+}
+
+func (ps pointStore) GetCount(name string, labels map[string]string) (count int, err error) {
+	err = ps.bs.Do(Points, func() error {
+		count, err = ps.ps.GetCount(name, labels)
+		return err
+	})
+	return count, err
+}
+
+func (ps pointStore) GetLatest(name string, labels map[string]string) (point pointstores.Point, err error) {
+	err = ps.bs.Do(Points, func() error {
+		point, err = ps.ps.GetLatest(name, labels)
+		return err
+	})
+	return point, err
+}
+
+func (ps pointStore) GetLastN(name string, labels map[string]string, n int) (points []pointstores.Point, err error) {
+	err = ps.bs.Do(Points, func() error {
+		points, err = ps.ps.GetLastN(name, labels, n)
+		return err
+	})
+	return points, err
+}
+
+func (ps pointStore) GetLastNSelected(name string, sel types.Selection, n int) (points []pointstores.Point, err error) {
+	err = ps.bs.Do(Points, func() error {
+		points, err = ps.ps.GetLastNSelected(name, sel, n)
+		return err
+	})
+	return points, err
+}
+
+func (ps pointStore) GetRetention(name string) (days int, err error) {
+	err = ps.bs.Do(Points, func() error {
+		days, err = ps.ps.GetRetention(name)
+		return err
+	})
+	return days, err
+}
+
+func (ps pointStore) ListSeries() (series []types.BriefSeries, err error) {
+	err = ps.bs.Do(Points, func() error {
+		series, err = ps.ps.ListSeries()
+		return err
+	})
+	return series, err
+}
+
+func (ps pointStore) SetRetention(name string, retentionDays int) error {
+	return ps.bs.Do(Points, func() error { return ps.ps.SetRetention(name, retentionDays) })
+}
+
+// queue is a training queue whose calls go through a breaker
+type queue struct {
+	bs *Breakers
+	q  queues.Queue
+}
+
+// TrainingQueue wraps a training queue so that its calls are retried and go through the queue breaker
+func TrainingQueue(q queues.Queue, bs *Breakers) queues.Queue {
+	return queue{bs: bs, q: q}
+}
+
+func (q queue) Ack(item queues.Item) error {
+	return q.bs.Do(Queue, func() error { return q.q.Ack(item) })
+}
+
+func (q queue) Bury(item queues.Item, reason string) error {
+	return q.bs.Do(Queue, func() error { return q.q.Bury(item, reason) })
+}
+
+func (q queue) DeadLetters() (dls []types.DeadLetter, err error) {
+	err = q.bs.Do(Queue, func() error {
+		dls, err = q.q.DeadLetters()
+		return err
+	})
+	return dls, err
+}
+
+func (q queue) Defer(item queues.Item, delay time.Duration) error {
+	return q.bs.Do(Queue, func() error { return q.q.Defer(item, delay) })
+}
+
+func (q queue) Extend(item queues.Item) error {
+	return q.bs.Do(Queue, func() error { return q.q.Extend(item) })
+}
+
+// Pop doesn't count the context being done as a failure of the queue
+func (q queue) Pop(ctx context.Context) (item queues.Item, err error) {
+	err = q.bs.Do(Queue, func() error {
+		item, err = q.q.Pop(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	})
+	if err == nil && ctx.Err() != nil {
+		return item, ctx.Err()
+	}
+	return item, err
+}
+
+func (q queue) Push(tr types.TrainRequest) error {
+	return q.bs.Do(Queue, func() error { return q.q.Push(tr) })
+}
+
+func (q queue) Retry(item queues.Item, delay time.Duration) error {
+	return q.bs.Do(Queue, func() error { return q.q.Retry(item, delay) })
+}
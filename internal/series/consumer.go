@@ -7,15 +7,16 @@ import (
 	"fmt"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
-	"github.com/qvantel/nerd/api/types"
 	"github.com/qvantel/nerd/internal/config"
 	"github.com/qvantel/nerd/internal/logger"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/nets/queues"
 	"github.com/qvantel/nerd/internal/series/pointstores"
 	kafka "github.com/segmentio/kafka-go"
 )
 
 // Consumer starts a Kafka consumer that'll ingest metrics updates
-func Consumer(consumer *kafka.Reader, tServ chan types.TrainRequest, conf config.Config) (e error) {
+func Consumer(consumer *kafka.Reader, tServ queues.Queue, conf config.Config) (e error) {
 	defer func() {
 		if r := recover(); r != nil {
 			switch x := r.(type) {
@@ -36,6 +37,13 @@ func Consumer(consumer *kafka.Reader, tServ chan types.TrainRequest, conf config
 		return err
 	}
 
+	// Set up net param store
+	nps, err := paramstores.New(conf)
+	if err != nil {
+		logger.Error("Failed to initialize net param store", err)
+		return err
+	}
+
 	pFailures := 0
 	ctx := context.Background()
 	logger.Info("Consumer initialized, now reading from " + consumer.Config().Topic)
@@ -65,7 +73,7 @@ func Consumer(consumer *kafka.Reader, tServ chan types.TrainRequest, conf config
 			continue
 		}
 
-		err = ProcessUpdate(event, ps, tServ, conf)
+		err = ProcessUpdate(event, ps, nps, tServ, conf)
 		if err != nil {
 			logger.Error("Failed to process message", err)
 			pFailures++
@@ -0,0 +1,149 @@
+package series
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/logger"
+	"github.com/qvantel/nerd/internal/nets"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/nets/queues"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+// CheckDrift compares the latest window of points of the series with the distribution that each of its nets was trained
+// with, stores the resulting scores and requests the retraining of the nets with an input that drifted beyond the
+// threshold (at most once per cooldown). Nets trained with a selection are only compared with the points that match its
+// labels
+func CheckDrift(seriesID string, ps pointstores.PointStore, nps paramstores.NetParamStore, tServ queues.Queue, conf config.Config) error {
+	windows := map[string][]pointstores.Point{} // Nets with the same labels share the same points
+	cursor := 0
+	for {
+		list, next, err := nets.List(cursor, 50, nets.SeriesPattern(seriesID), nps)
+		if err != nil {
+			return err
+		}
+		for _, net := range list {
+			sel := driftSelection(net)
+			key, err := json.Marshal(sel.Labels)
+			if err != nil {
+				return err
+			}
+			points, ok := windows[string(key)]
+			if !ok {
+				points, err = ps.GetLastNSelected(seriesID, sel, conf.Series.DriftWindow)
+				if err != nil {
+					return err
+				}
+				windows[string(key)] = points
+			}
+			err = checkNetDrift(seriesID, net, points, nps, tServ, conf)
+			if err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// driftSelection returns the selection of the new points that should be compared with what the given net was trained
+// with, which keeps its labels but not its range as that would leave the new points out
+func driftSelection(net types.BriefNet) types.Selection {
+	if net.Selection == nil {
+		return types.Selection{}
+	}
+	return types.Selection{Labels: net.Selection.Labels}
+}
+
+// checkNetDrift calculates the drift scores of a single net and requests its retraining if needed
+func checkNetDrift(seriesID string, net types.BriefNet, points []pointstores.Point, nps paramstores.NetParamStore, tServ queues.Queue, conf config.Config) error {
+	var prev types.Drift
+	_, err := nps.Load(paramstores.AuxID(net.ID, nets.DriftRecord), paramstores.JSON{Value: &prev})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	drift := types.Drift{Checked: now.Unix(), Points: len(points), Requested: prev.Requested, Scores: map[string]float32{}}
+	drifted := ""
+	for _, input := range nets.PointInputs(net) {
+		dist, ok := net.Distributions[input]
+		if !ok || len(dist.Edges) == 0 || net.Normalization[input] == types.Excluded {
+			// Constant values (and nets trained before distributions were recorded) have nothing to compare against
+			continue
+		}
+		values := make([]float32, len(points))
+		for i := range points {
+			values[i] = points[i].Values[input]
+		}
+		drift.Scores[input] = psi(values, dist)
+		if drift.Scores[input] > conf.Series.DriftThreshold {
+			drifted = input
+		}
+	}
+	cooldown := time.Duration(conf.Series.DriftCooldown) * time.Second
+	if drifted != "" && now.Sub(time.Unix(prev.Requested, 0)) >= cooldown {
+		logger.Info(fmt.Sprintf("Input %s of net %s drifted (PSI = %f), requesting retraining", drifted, net.ID, drift.Scores[drifted]))
+		drift.Requested = now.Unix()
+		err = tServ.Push(retrainRequest(seriesID, net, conf))
+		if err != nil {
+			return err
+		}
+	}
+	return nps.Save(paramstores.AuxID(net.ID, nets.DriftRecord), paramstores.JSON{Value: drift})
+}
+
+// retrainRequest returns a training request that will produce a replacement for the given net
+func retrainRequest(seriesID string, net types.BriefNet, conf config.Config) types.TrainRequest {
+	norms := map[string]string{}
+	for label, scheme := range net.Normalization {
+		if scheme != types.Excluded {
+			norms[label] = scheme
+		}
+	}
+	tr := types.TrainRequest{
+		ErrMargin:     net.ErrMargin,
+		ID:            uuid.New().String(),
+		Inputs:        nets.PointInputs(net),
+		Normalization: norms,
+		Outputs:       net.Outputs,
+		SeriesID:      seriesID,
+	}
+	// The range is left out so that the replacement is trained with the points that made the old net drift (it isn't
+	// part of the net ID so the replacement keeps it)
+	tr.Selection = driftSelection(net)
+	if net.Features != nil {
+		tr.Lags = net.Features.Lags
+		tr.TimeFeatures = net.Features.Time
+		tr.Windows = net.Features.Windows
+	}
+	tr.Required = nets.RequiredFor(tr, conf)
+	return tr
+}
+
+// psi returns the population stability index of the given values with respect to the distribution of the training
+// set, using the same bins
+func psi(values []float32, dist types.Distribution) float32 {
+	if len(values) == 0 || len(dist.Fractions) != len(dist.Edges)+1 {
+		return 0
+	}
+	counts := make([]int, len(dist.Fractions))
+	for _, value := range values {
+		counts[dist.Bin(value)]++
+	}
+	total := 0.0
+	for i, count := range counts {
+		// Empty bins would give infinite scores
+		expected := math.Max(float64(dist.Fractions[i]), 1e-4)
+		actual := math.Max(float64(count)/float64(len(values)), 1e-4)
+		total += (actual - expected) * math.Log(actual/expected)
+	}
+	return float32(total)
+}
@@ -0,0 +1,218 @@
+package series
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/nets"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/nets/queues"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+// normalDistribution returns the deciles of a normal distribution with the given mean and standard deviation
+func normalDistribution(avg, dev float64) types.Distribution {
+	dist := types.Distribution{Edges: []float32{}, Fractions: []float32{}}
+	for i := 1; i < 10; i++ {
+		dist.Edges = append(dist.Edges, float32(avg+dev*math.Sqrt2*math.Erfinv(2*float64(i)/10-1)))
+	}
+	for i := 0; i < 10; i++ {
+		dist.Fractions = append(dist.Fractions, 0.1)
+	}
+	return dist
+}
+
+func TestPSI(t *testing.T) {
+	rand.Seed(42)
+	same := make([]float32, 1000)
+	shifted := make([]float32, 1000)
+	for i := range same {
+		same[i] = float32(rand.NormFloat64()*2 + 10)
+		shifted[i] = float32(rand.NormFloat64()*2 + 12)
+	}
+	if score := psi(same, normalDistribution(10, 2)); score > 0.1 {
+		t.Errorf("Expected a PSI close to 0 for values from the same distribution, got %f", score)
+	}
+	if score := psi(shifted, normalDistribution(10, 2)); score < 0.25 {
+		t.Errorf("Expected a PSI above 0.25 for values shifted by one standard deviation, got %f", score)
+	}
+
+	// Skewed training sets are compared bin by bin instead of against a normal curve with the same mean and deviation
+	skewed := types.Distribution{Edges: []float32{1, 2}, Fractions: []float32{0.8, 0.1, 0.1}}
+	values := []float32{}
+	for i := 0; i < 100; i++ {
+		values = append(values, float32(i%10)/4) // 40% below 1, 40% between 1 and 2, 20% above 2
+	}
+	if score := psi(values, skewed); score < 0.25 {
+		t.Errorf("Expected a PSI above 0.25 for values that don't follow the skewed training set, got %f", score)
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	conf := config.Config{
+		ML:     config.MLParams{MaxHLayers: 1},
+		Series: config.SeriesParams{DriftCooldown: 3600, DriftThreshold: 0.2, DriftWindow: 100},
+	}
+	nps := paramstores.FileAdapter{Path: "."}
+	id := "test-drift-51e1890284194a8e4bb9923994e46cf59cfdd90d-89368e1d68015693ab48ee189d0632cb5d6edfb3-" + types.MultilayerPerceptron
+	params := paramstores.MLPParams{
+		ActivationFunc: types.BipolarSigmoid,
+		Averages:       map[string]float32{"stable": 0, "moving": 0, "size": 0},
+		Deviations:     map[string]float32{"stable": 1, "moving": 1, "size": 1},
+		Distributions:  map[string]types.Distribution{"stable": normalDistribution(0, 1), "moving": normalDistribution(0, 1)},
+		ErrMargin:      0.1,
+		Inputs:         []string{"moving", "stable"},
+		Topology:       []int{2, 2, 1},
+		Outputs:        []string{"size"},
+	}
+	err := nps.Save(id, &params)
+	if err != nil {
+		t.Fatalf("Failed to save net params (%s)", err.Error())
+	}
+	defer nps.Delete(id)
+	defer nps.Delete(paramstores.AuxID(id, nets.DriftRecord))
+
+	// A net trained with the points of env b only, where the moving input stays put
+	selID := "test-drift-0123456789abcdef0123456789abcdef01234567-89368e1d68015693ab48ee189d0632cb5d6edfb3-" + types.MultilayerPerceptron
+	selParams := params
+	selParams.Selection = &types.Selection{Labels: map[string]string{"env": "b"}, To: 1}
+	err = nps.Save(selID, &selParams)
+	if err != nil {
+		t.Fatalf("Failed to save net params (%s)", err.Error())
+	}
+	defer nps.Delete(selID)
+	defer nps.Delete(paramstores.AuxID(selID, nets.DriftRecord))
+
+	rand.Seed(42)
+	points := make([]pointstores.Point, 200)
+	for i := range points {
+		// The newest points (env a) are the ones that drifted
+		env, shift := "b", 0.0
+		if i >= 100 {
+			env, shift = "a", 3
+		}
+		points[i].Labels = map[string]string{"env": env}
+		points[i].TimeStamp = int64(1612706310 + i)
+		points[i].Values = map[string]float32{
+			"stable": float32(rand.NormFloat64()),
+			"moving": float32(rand.NormFloat64() + shift),
+			"size":   float32(rand.NormFloat64()),
+		}
+	}
+	ps, err := pointstores.NewFileAdapter(map[string]interface{}{"Path": "."})
+	if err != nil {
+		t.Fatalf("Failed to initialize point store (%s)", err.Error())
+	}
+	defer ps.DeleteSeries("test-drift")
+	err = ps.AddPoints("test-drift", points)
+	if err != nil {
+		t.Fatalf("Failed to add points (%s)", err.Error())
+	}
+	dir, err := ioutil.TempDir("", "nerd-drift")
+	if err != nil {
+		t.Fatalf("Failed to create queue dir (%s)", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	tServ, err := queues.NewFileAdapter(map[string]interface{}{"Path": dir}, 3)
+	if err != nil {
+		t.Fatalf("Failed to create queue (%s)", err.Error())
+	}
+	err = CheckDrift("test-drift", ps, &nps, tServ, conf)
+	if err != nil {
+		t.Fatalf("Failed to check drift (%s)", err.Error())
+	}
+
+	var drift types.Drift
+	found, err := nps.Load(paramstores.AuxID(id, nets.DriftRecord), paramstores.JSON{Value: &drift})
+	if err != nil || !found {
+		t.Fatalf("Failed to load drift scores (%v)", err)
+	}
+	if drift.Points != conf.Series.DriftWindow || drift.Scores["stable"] > 0.2 || drift.Scores["moving"] < 0.2 {
+		t.Errorf("Expected only the moving input to drift, got %+v", drift)
+	}
+	found, err = nps.Load(paramstores.AuxID(selID, nets.DriftRecord), paramstores.JSON{Value: &drift})
+	if err != nil || !found {
+		t.Fatalf("Failed to load drift scores (%v)", err)
+	}
+	if drift.Points != conf.Series.DriftWindow || drift.Scores["moving"] > 0.2 || drift.Requested != 0 {
+		t.Errorf("Expected the net trained with env b to be compared with its own points only, got %+v", drift)
+	}
+	requests := drain(tServ)
+	if len(requests) != 1 {
+		t.Fatalf("Expected one retraining request, got %d", len(requests))
+	}
+	tr := requests[0]
+	if tr.SeriesID != "test-drift" || len(tr.Inputs) != 2 || tr.Outputs[0] != "size" || tr.ErrMargin != 0.1 || !tr.Selection.Empty() {
+		t.Errorf("The retraining request doesn't match the drifted net, got %+v", tr)
+	}
+
+	// A second check shouldn't request retraining again until the cooldown is over
+	err = CheckDrift("test-drift", ps, &nps, tServ, conf)
+	if err != nil {
+		t.Fatalf("Failed to check drift (%s)", err.Error())
+	}
+	if requests = drain(tServ); len(requests) != 0 {
+		t.Errorf("Expected no retraining requests during the cooldown, got %d", len(requests))
+	}
+	conf.Series.DriftCooldown = 0
+	err = CheckDrift("test-drift", ps, &nps, tServ, conf)
+	if err != nil {
+		t.Fatalf("Failed to check drift (%s)", err.Error())
+	}
+	if requests = drain(tServ); len(requests) != 1 {
+		t.Errorf("Expected a retraining request without a cooldown, got %d", len(requests))
+	}
+}
+
+func TestRetrainRequest(t *testing.T) {
+	conf := config.Config{ML: config.MLParams{MaxHLayers: 2}}
+	original := types.TrainRequest{
+		Inputs:       []string{"moving", "stable"},
+		Lags:         []types.Lag{{Label: "size", Steps: 2}},
+		Outputs:      []string{"size"},
+		Selection:    types.Selection{From: 1612706310, Labels: map[string]string{"env": "a"}, To: 1612706410},
+		SeriesID:     "test-drift",
+		TimeFeatures: []string{types.Hour},
+	}
+	net := types.BriefNet{
+		ErrMargin: 0.1,
+		Features:  &types.Features{Lags: original.Lags, Time: original.TimeFeatures},
+		ID:        nets.NetID(original, "size", types.MultilayerPerceptron),
+		Inputs:    []string{types.Hour, "moving", "size@t-1", "size@t-2", "stable"},
+		Outputs:   original.Outputs,
+		Selection: &original.Selection,
+	}
+
+	tr := retrainRequest("test-drift", net, conf)
+	if id := nets.NetID(tr, "size", types.MultilayerPerceptron); id != net.ID {
+		t.Errorf("Expected the retraining request to replace %s, it would produce %s", net.ID, id)
+	}
+	if tr.Selection.From != 0 || tr.Selection.To != 0 || tr.Selection.Labels["env"] != "a" {
+		t.Errorf("Expected the selection to keep only its labels, got %+v", tr.Selection)
+	}
+	if required := nets.RequiredFor(original, conf); tr.Required != required {
+		t.Errorf("Expected the retraining request to require %d points like a regular one, got %d", required, tr.Required)
+	}
+}
+
+// drain pops every request that is ready in the queue
+func drain(q queues.Queue) []types.TrainRequest {
+	requests := []types.TrainRequest{}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		item, err := q.Pop(ctx)
+		cancel()
+		if err != nil {
+			return requests
+		}
+		q.Ack(item)
+		requests = append(requests, item.Request)
+	}
+}
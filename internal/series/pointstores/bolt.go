@@ -0,0 +1,432 @@
+package pointstores
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/logger"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltFile is the name of the database file that the bolt point store keeps in the configured directory
+const boltFile = "nerd-series.db"
+
+// Names of the buckets and keys used by the bolt point store. Each series gets a bucket inside the series one, which
+// holds its points (keyed by timestamp so that they are sorted), an index of its points by label and its metadata
+var (
+	seriesBucket = []byte("series")
+	pointsBucket = []byte("points")
+	labelsBucket = []byte("labels")
+	countKey     = []byte("count")
+	retentionKey = []byte("retention")
+)
+
+// boltDBs holds the databases opened by this process by path, as bolt locks the file and every part of the service
+// creates its own point store
+var (
+	boltDBs   = map[string]*bolt.DB{}
+	boltDBsMu sync.Mutex
+)
+
+// BoltAdapter is a point store implementation that uses an embedded bolt database. It's indexed by series, timestamp
+// and label, making it suitable for production use when there is a single instance (the file can't be shared)
+type BoltAdapter struct {
+	db        *bolt.DB
+	retention int // Days that the points of the series created by AddPoint are kept for (0 keeps them forever)
+}
+
+// NewBoltAdapter returns an initialized bolt point store, opening the database in the configured directory (or reusing
+// it if this process already has it open)
+func NewBoltAdapter(conf map[string]interface{}, retention int) (*BoltAdapter, error) {
+	path := conf["Path"].(string) + "/" + boltFile
+	boltDBsMu.Lock()
+	defer boltDBsMu.Unlock()
+	db, ok := boltDBs[path]
+	if !ok {
+		var err error
+		db, err = bolt.Open(path, 0644, &bolt.Options{Timeout: 10 * time.Second})
+		if err != nil {
+			return nil, err
+		}
+		boltDBs[path] = db
+	}
+	return &BoltAdapter{db: db, retention: retention}, nil
+}
+
+// AddPoint stores a point in the bucket of the given series (replacing it if it was already there) and indexes it by
+// label, the series is created if it doesn't exist
+func (ba BoltAdapter) AddPoint(name string, p Point) error {
+	return ba.AddPoints(name, []Point{p})
+}
+
+// AddPoints stores a batch of points in the bucket of the given series within a single transaction, so either all of
+// them are stored or none are. The series is created if it doesn't exist
+func (ba BoltAdapter) AddPoints(name string, points []Point) error {
+	return ba.db.Update(func(tx *bolt.Tx) error {
+		sb := seriesOf(tx, name)
+		if sb == nil {
+			var err error
+			sb, err = createSeries(tx, name, ba.retention)
+			if err != nil {
+				return err
+			}
+		}
+		for _, p := range points {
+			err := putPoint(sb, p)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AddSeries creates the bucket that holds a time series and records its retention, which is enforced by Prune
+func (ba BoltAdapter) AddSeries(name string, sample Point, retentionDays int) error {
+	return ba.db.Update(func(tx *bolt.Tx) error {
+		_, err := createSeries(tx, name, retentionDays)
+		return err
+	})
+}
+
+// DeleteSeries removes the bucket of a series along with all of its points
+func (ba BoltAdapter) DeleteSeries(name string) error {
+	return ba.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(seriesBucket)
+		if root == nil {
+			return nil
+		}
+		err := root.DeleteBucket([]byte(cleanDir(name)))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// Exists returns true if there is a bucket for the specified series
+func (ba BoltAdapter) Exists(name string) (bool, error) {
+	exists := false
+	err := ba.db.View(func(tx *bolt.Tx) error {
+		exists = seriesOf(tx, name) != nil
+		return nil
+	})
+	return exists, err
+}
+
+// GetCount retrieves the number of points recorded for the given series with the specified labels (returns 0 if the
+// series doesn't exist). Without labels it's kept up to date as points are added, otherwise the label index is used
+func (ba BoltAdapter) GetCount(name string, labels map[string]string) (int, error) {
+	count := 0
+	err := ba.db.View(func(tx *bolt.Tx) error {
+		sb := seriesOf(tx, name)
+		if sb == nil {
+			return nil
+		}
+		if len(labels) == 0 {
+			count = int(getUint64(sb, countKey))
+			return nil
+		}
+		return scan(sb, types.Selection{Labels: labels}, func(Point) bool {
+			count++
+			return true
+		})
+	})
+	return count, err
+}
+
+// GetLatest returns the most recent point of the series with the specified labels
+func (ba BoltAdapter) GetLatest(name string, labels map[string]string) (Point, error) {
+	points, err := ba.GetLastN(name, labels, 1)
+	if err != nil {
+		return Point{}, err
+	}
+	if len(points) < 1 {
+		return Point{}, RequestError("no points found")
+	}
+	return points[0], nil
+}
+
+// GetLastN returns the last n points for the given series with the specified labels
+func (ba BoltAdapter) GetLastN(name string, labels map[string]string, n int) ([]Point, error) {
+	return ba.GetLastNSelected(name, types.Selection{Labels: labels}, n)
+}
+
+// GetLastNSelected returns the last n points of the given series that match the selection, walking backwards from the
+// end of the time range through either the points or (if there are labels) the label index
+func (ba BoltAdapter) GetLastNSelected(name string, sel types.Selection, n int) ([]Point, error) {
+	points := []Point{}
+	err := ba.db.View(func(tx *bolt.Tx) error {
+		sb := seriesOf(tx, name)
+		if sb == nil {
+			return RequestError("series " + name + " doesn't exist")
+		}
+		if n < 1 {
+			return nil
+		}
+		return scan(sb, sel, func(p Point) bool {
+			points = append(points, p)
+			return len(points) < n
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// GetRetention returns the number of days that the points of the given series are kept for
+func (ba BoltAdapter) GetRetention(name string) (int, error) {
+	days := 0
+	err := ba.db.View(func(tx *bolt.Tx) error {
+		sb := seriesOf(tx, name)
+		if sb == nil {
+			return RequestError("series " + name + " doesn't exist")
+		}
+		days = int(getUint64(sb, retentionKey))
+		return nil
+	})
+	return days, err
+}
+
+// ListSeries returns a list of all the series in the database
+func (ba BoltAdapter) ListSeries() ([]types.BriefSeries, error) {
+	series := []types.BriefSeries{}
+	err := ba.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(seriesBucket)
+		if root == nil {
+			return nil
+		}
+		return root.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return nil // Not a bucket
+			}
+			series = append(series, types.BriefSeries{
+				Name:  string(k),
+				Count: int(getUint64(root.Bucket(k), countKey)),
+			})
+			return nil
+		})
+	})
+	return series, err
+}
+
+// Prune deletes the points that are older than the retention of their series at the given time, returning how many
+// were deleted. As points are sorted by timestamp, only the expired ones are visited
+func (ba BoltAdapter) Prune(now time.Time) (int, error) {
+	series, err := ba.ListSeries()
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	err = ba.db.Update(func(tx *bolt.Tx) error {
+		for _, s := range series {
+			sb := seriesOf(tx, s.Name)
+			if sb == nil {
+				continue // Deleted in the meantime
+			}
+			days := getUint64(sb, retentionKey)
+			if days == 0 {
+				continue
+			}
+			cutoff := tsBytes(now.AddDate(0, 0, -int(days)).Unix())
+			expired := map[string][]byte{}
+			c := sb.Bucket(pointsBucket).Cursor()
+			for k, data := c.First(); k != nil && bytes.Compare(k[:8], cutoff) < 0; k, data = c.Next() {
+				expired[string(k)] = append([]byte{}, data...)
+			}
+			if len(expired) == 0 {
+				continue
+			}
+			for k, data := range expired {
+				err := deletePoint(sb, []byte(k), data)
+				if err != nil {
+					return err
+				}
+			}
+			err := sb.Put(countKey, uint64Bytes(getUint64(sb, countKey)-uint64(len(expired))))
+			if err != nil {
+				return err
+			}
+			pruned += len(expired)
+		}
+		return nil
+	})
+	return pruned, err
+}
+
+// SetRetention changes the number of days that the points of an existing series are kept for
+func (ba BoltAdapter) SetRetention(name string, retentionDays int) error {
+	return ba.db.Update(func(tx *bolt.Tx) error {
+		sb := seriesOf(tx, name)
+		if sb == nil {
+			return RequestError("series " + name + " doesn't exist")
+		}
+		return sb.Put(retentionKey, uint64Bytes(uint64(retentionDays)))
+	})
+}
+
+// createSeries creates the buckets of a new series
+func createSeries(tx *bolt.Tx, name string, retentionDays int) (*bolt.Bucket, error) {
+	logger.Info("Creating new bucket for series " + name)
+	root, err := tx.CreateBucketIfNotExists(seriesBucket)
+	if err != nil {
+		return nil, err
+	}
+	sb, err := root.CreateBucket([]byte(cleanDir(name)))
+	if err != nil {
+		return nil, err
+	}
+	_, err = sb.CreateBucket(pointsBucket)
+	if err != nil {
+		return nil, err
+	}
+	_, err = sb.CreateBucket(labelsBucket)
+	if err != nil {
+		return nil, err
+	}
+	err = sb.Put(countKey, uint64Bytes(0))
+	if err != nil {
+		return nil, err
+	}
+	return sb, sb.Put(retentionKey, uint64Bytes(uint64(retentionDays)))
+}
+
+// putPoint stores a point in the bucket of a series (replacing it if it was already there), indexes it by label and
+// updates the count of the series
+func putPoint(sb *bolt.Bucket, p Point) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	key := pointKey(p)
+	count := getUint64(sb, countKey)
+	if old := sb.Bucket(pointsBucket).Get(key); old != nil {
+		err := deletePoint(sb, key, old)
+		if err != nil {
+			return err
+		}
+		count--
+	}
+	err = sb.Bucket(pointsBucket).Put(key, data)
+	if err != nil {
+		return err
+	}
+	labels := sb.Bucket(labelsBucket)
+	for label, value := range p.Labels {
+		err = labels.Put(append(labelPrefix(label, value), key...), []byte{})
+		if err != nil {
+			return err
+		}
+	}
+	return sb.Put(countKey, uint64Bytes(count+1))
+}
+
+// deletePoint removes a point and its label index entries from the bucket of a series (without updating its count)
+func deletePoint(sb *bolt.Bucket, key, data []byte) error {
+	var p Point
+	err := json.Unmarshal(data, &p)
+	if err != nil {
+		return err
+	}
+	labels := sb.Bucket(labelsBucket)
+	for label, value := range p.Labels {
+		err = labels.Delete(append(labelPrefix(label, value), key...))
+		if err != nil {
+			return err
+		}
+	}
+	return sb.Bucket(pointsBucket).Delete(key)
+}
+
+// scan walks the points of a series that match the selection from newest to oldest until fn returns false. When
+// there are labels, the index of one of them is walked instead and the rest are checked on each point
+func scan(sb *bolt.Bucket, sel types.Selection, fn func(Point) bool) error {
+	points := sb.Bucket(pointsBucket)
+	c := points.Cursor()
+	prefix := []byte{}
+	if len(sel.Labels) > 0 {
+		names := make([]string, 0, len(sel.Labels))
+		for label := range sel.Labels {
+			names = append(names, label)
+		}
+		sort.Strings(names)
+		c = sb.Bucket(labelsBucket).Cursor()
+		prefix = labelPrefix(names[0], sel.Labels[names[0]])
+	}
+	to := int64(math.MaxInt64)
+	if sel.To != 0 {
+		to = sel.To
+	}
+	// Keys after the timestamp are hex IDs, so this is just past the last key of the range
+	k, _ := c.Seek(append(append(append([]byte{}, prefix...), tsBytes(to)...), 0xff))
+	if k == nil {
+		k, _ = c.Last()
+	} else {
+		k, _ = c.Prev()
+	}
+	from := tsBytes(sel.From)
+	for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Prev() {
+		key := k[len(prefix):]
+		if sel.From != 0 && bytes.Compare(key[:8], from) < 0 {
+			break
+		}
+		var p Point
+		err := json.Unmarshal(points.Get(key), &p)
+		if err != nil {
+			return err
+		}
+		if !p.HasLabels(sel.Labels) {
+			continue
+		}
+		if !fn(p) {
+			break
+		}
+	}
+	return nil
+}
+
+// seriesOf returns the bucket of a series, nil if it doesn't exist
+func seriesOf(tx *bolt.Tx, name string) *bolt.Bucket {
+	root := tx.Bucket(seriesBucket)
+	if root == nil {
+		return nil
+	}
+	return root.Bucket([]byte(cleanDir(name)))
+}
+
+// pointKey returns the key of a point, its timestamp followed by its ID so that points are sorted by time
+func pointKey(p Point) []byte {
+	return append(tsBytes(p.TimeStamp), p.ID()...)
+}
+
+// labelPrefix returns the prefix of the label index keys of the points that have a label with the given value
+func labelPrefix(label, value string) []byte {
+	return []byte(strconv.Quote(label) + "=" + strconv.Quote(value) + "/")
+}
+
+// tsBytes encodes a timestamp so that the byte order matches the numeric order (for positive timestamps)
+func tsBytes(ts int64) []byte {
+	return uint64Bytes(uint64(ts))
+}
+
+func uint64Bytes(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func getUint64(b *bolt.Bucket, key []byte) uint64 {
+	v := b.Get(key)
+	if len(v) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
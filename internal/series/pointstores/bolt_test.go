@@ -0,0 +1,219 @@
+package pointstores
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+)
+
+func initBoltStoreTest(name string) (PointStore, string, error) {
+	dir, err := ioutil.TempDir("", "nerd-bolt")
+	if err != nil {
+		return nil, "", err
+	}
+	conf := config.Config{
+		Series: config.SeriesParams{
+			StoreType:   config.BoltSeriesStore,
+			StoreParams: map[string]interface{}{"Path": dir},
+		},
+	}
+	ps, err := New(conf)
+	if err != nil {
+		return nil, dir, err
+	}
+
+	sec := int64(777808800)
+	points := []Point{
+		{Labels: map[string]string{"env": "test"}, Values: map[string]float32{"subs": 12000, "size": 50}, TimeStamp: sec},
+		{Labels: map[string]string{"env": "test"}, Values: map[string]float32{"subs": 12010, "size": 51}, TimeStamp: sec + 60},
+	}
+	for _, p := range points {
+		err = ps.AddPoint(name, p)
+		if err != nil {
+			return nil, dir, err
+		}
+	}
+	return ps, dir, nil
+}
+
+func TestGetLastNSelectedBolt(t *testing.T) {
+	ps, dir, err := initBoltStoreTest(t.Name())
+	defer os.RemoveAll(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize point store (%s)", err.Error())
+	}
+
+	sec := int64(777808800)
+	tests := []struct {
+		sel      types.Selection
+		expected []int64
+	}{
+		{types.Selection{}, []int64{sec + 60, sec}},
+		{types.Selection{From: sec + 30}, []int64{sec + 60}},
+		{types.Selection{To: sec + 60}, []int64{sec + 60, sec}},
+		{types.Selection{Labels: map[string]string{"env": "test"}, To: sec}, []int64{sec}},
+		{types.Selection{Labels: map[string]string{"env": "prod"}}, []int64{}},
+	}
+	for _, test := range tests {
+		points, err := ps.GetLastNSelected(t.Name(), test.sel, 4)
+		if err != nil {
+			t.Fatalf("Failed to get selected points from store (%s)", err.Error())
+		}
+		if len(points) != len(test.expected) {
+			t.Errorf("Expected %d points for selection %+v, got %d", len(test.expected), test.sel, len(points))
+			continue
+		}
+		for i, p := range points {
+			if p.TimeStamp != test.expected[i] {
+				t.Errorf("Expected point %d of selection %+v to have timestamp %d, got %d", i, test.sel, test.expected[i], p.TimeStamp)
+			}
+		}
+	}
+	latest, err := ps.GetLatest(t.Name(), nil)
+	if err != nil {
+		t.Fatalf("Failed to get latest point from store (%s)", err.Error())
+	}
+	if latest.TimeStamp != sec+60 || latest.Values["size"] != 51 {
+		t.Errorf("Point does not match the latest in the series, got %+v", latest)
+	}
+	_, err = ps.GetLastN(t.Name()+"-should-not-exist", nil, 4)
+	if err == nil {
+		t.Errorf("Getting the points of a series that doesn't exist should return an error")
+	}
+}
+
+func TestLabelsBolt(t *testing.T) {
+	ps, dir, err := initBoltStoreTest(t.Name())
+	defer os.RemoveAll(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize point store (%s)", err.Error())
+	}
+	points := []Point{
+		{Labels: map[string]string{"stage": "production", "subject": "env-a"}, Values: map[string]float32{"size": 1}, TimeStamp: 777808800},
+		{Labels: map[string]string{"stage": "production", "subject": "env-b"}, Values: map[string]float32{"size": 2}, TimeStamp: 777808860},
+		{Labels: map[string]string{"stage": "test", "subject": "env-a"}, Values: map[string]float32{"size": 3}, TimeStamp: 777808920},
+	}
+	name := t.Name() + "-labels"
+	for _, p := range points {
+		err := ps.AddPoint(name, p)
+		if err != nil {
+			t.Fatalf("Failed to add point to store (%s)", err.Error())
+		}
+	}
+	// Adding the same point again replaces it
+	err = ps.AddPoint(name, points[0])
+	if err != nil {
+		t.Fatalf("Failed to add point to store (%s)", err.Error())
+	}
+
+	tests := []struct {
+		labels   map[string]string
+		expected []float32 // Sizes of the matching points, newest first
+	}{
+		{nil, []float32{3, 2, 1}},
+		{map[string]string{"stage": "production"}, []float32{2, 1}},
+		{map[string]string{"stage": "production", "subject": "env-a"}, []float32{1}},
+		{map[string]string{"stage": "test", "subject": "env-b"}, []float32{}},
+	}
+	for _, test := range tests {
+		count, err := ps.GetCount(name, test.labels)
+		if err != nil {
+			t.Fatalf("Failed to count points (%s)", err.Error())
+		}
+		if count != len(test.expected) {
+			t.Errorf("Expected %d points with labels %v, counted %d", len(test.expected), test.labels, count)
+		}
+		res, err := ps.GetLastN(name, test.labels, 10)
+		if err != nil {
+			t.Fatalf("Failed to get points (%s)", err.Error())
+		}
+		if len(res) != len(test.expected) {
+			t.Errorf("Expected %d points with labels %v, got %d", len(test.expected), test.labels, len(res))
+			continue
+		}
+		for i, p := range res {
+			if p.Values["size"] != test.expected[i] {
+				t.Errorf("Expected point %d with labels %v to have size %f, got %f", i, test.labels, test.expected[i], p.Values["size"])
+			}
+		}
+	}
+}
+
+func TestRetentionBolt(t *testing.T) {
+	ps, dir, err := initBoltStoreTest(t.Name())
+	defer os.RemoveAll(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize point store (%s)", err.Error())
+	}
+
+	err = ps.SetRetention(t.Name(), 7)
+	if err != nil {
+		t.Fatalf("Failed to set retention (%s)", err.Error())
+	}
+	days, err := ps.GetRetention(t.Name())
+	if err != nil {
+		t.Fatalf("Failed to get retention (%s)", err.Error())
+	}
+	if days != 7 {
+		t.Errorf("Expected a retention of 7 days, got %d", days)
+	}
+	// The test points are 60 seconds apart so only the oldest one is past the retention
+	pruned, err := ps.(Pruner).Prune(time.Unix(777808800+30, 0).AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatalf("Failed to prune series (%s)", err.Error())
+	}
+	if pruned != 1 {
+		t.Errorf("Expected 1 point to be pruned, %d were", pruned)
+	}
+	count, err := ps.GetCount(t.Name(), map[string]string{"env": "test"})
+	if err != nil {
+		t.Fatalf("Failed to count points (%s)", err.Error())
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 point with the env label to be left after pruning, got %d", count)
+	}
+}
+
+func TestListSeriesBolt(t *testing.T) {
+	ps, dir, err := initBoltStoreTest(t.Name())
+	defer os.RemoveAll(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize point store (%s)", err.Error())
+	}
+
+	res, err := ps.ListSeries()
+	if err != nil {
+		t.Fatalf("Failed to get series list (%s)", err.Error())
+	}
+	if len(res) != 1 || res[0].Name != cleanDir(t.Name()) || res[0].Count != 2 {
+		t.Errorf("Expected the test series with 2 points, got %+v", res)
+	}
+	err = ps.DeleteSeries(t.Name())
+	if err != nil {
+		t.Fatalf("Failed to delete series (%s)", err.Error())
+	}
+	found, err := ps.Exists(t.Name())
+	if err != nil {
+		t.Fatalf("Failed to check if series exists (%s)", err.Error())
+	}
+	if found {
+		t.Errorf("Series was deleted so the Exists method should return false, it instead returned true")
+	}
+}
+
+func TestConformanceBolt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nerd-bolt")
+	if err != nil {
+		t.Fatalf("Failed to create database directory (%s)", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	ps, err := NewBoltAdapter(map[string]interface{}{"Path": dir}, 0)
+	if err != nil {
+		t.Fatalf("Failed to get point store (%s)", err.Error())
+	}
+	testConformance(t, ps, "conformance", func() {})
+}
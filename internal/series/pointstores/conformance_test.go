@@ -0,0 +1,248 @@
+package pointstores
+
+import (
+	"testing"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+)
+
+// testConformance checks that a point store behaves as the rest of the service expects, every adapter should run it.
+// The settle function is called after writing points, for stores that don't make them visible straight away
+func testConformance(t *testing.T, ps PointStore, prefix string, settle func()) {
+	t.Run("Exists", func(t *testing.T) { testExists(t, ps, prefix+"-exists") })
+	t.Run("Points", func(t *testing.T) { testPoints(t, ps, prefix+"-points", settle) })
+	t.Run("Batch", func(t *testing.T) { testBatch(t, ps, prefix+"-batch", settle) })
+	t.Run("Retention", func(t *testing.T) { testRetention(t, ps, prefix+"-retention", settle) })
+}
+
+// conformancePoints returns the points used by the conformance suite, oldest first
+func conformancePoints() []Point {
+	sec := int64(777808800)
+	return []Point{
+		{Labels: map[string]string{"stage": "production", "subject": "env-a"}, Values: map[string]float32{"size": 1}, TimeStamp: sec},
+		{Labels: map[string]string{"stage": "production", "subject": "env-b"}, Values: map[string]float32{"size": 2}, TimeStamp: sec + 60},
+		{Labels: map[string]string{"stage": "test", "subject": "env-a"}, Values: map[string]float32{"size": 3}, TimeStamp: sec + 120},
+	}
+}
+
+func addConformancePoints(ps PointStore, name string, settle func()) error {
+	points := conformancePoints()
+	// Adding the same point twice must replace it
+	for _, p := range append(points, points[0]) {
+		err := ps.AddPoint(name, p)
+		if err != nil {
+			return err
+		}
+	}
+	settle()
+	return nil
+}
+
+func testExists(t *testing.T, ps PointStore, name string) {
+	found, err := ps.Exists(name)
+	if err != nil {
+		t.Fatalf("Failed to check if series exists (%s)", err.Error())
+	}
+	if found {
+		t.Errorf("Series doesn't exist so the Exists method should return false, it instead returned true")
+	}
+	err = ps.AddSeries(name, conformancePoints()[0], 0)
+	if err != nil {
+		t.Fatalf("Failed to add series to store (%s)", err.Error())
+	}
+	found, err = ps.Exists(name)
+	if err != nil {
+		t.Fatalf("Failed to check if series exists (%s)", err.Error())
+	}
+	if !found {
+		t.Errorf("Series exists so the Exists method should return true, it instead returned false")
+	}
+	count, err := ps.GetCount(name, nil)
+	if err != nil {
+		t.Fatalf("Failed to count points (%s)", err.Error())
+	}
+	if count != 0 {
+		t.Errorf("Expected a new series to be empty, counted %d points", count)
+	}
+	err = ps.DeleteSeries(name)
+	if err != nil {
+		t.Fatalf("Failed to delete series (%s)", err.Error())
+	}
+	found, err = ps.Exists(name)
+	if err != nil {
+		t.Fatalf("Failed to check if series exists (%s)", err.Error())
+	}
+	if found {
+		t.Errorf("Series was deleted so the Exists method should return false, it instead returned true")
+	}
+}
+
+func testPoints(t *testing.T, ps PointStore, name string, settle func()) {
+	err := addConformancePoints(ps, name, settle)
+	if err != nil {
+		t.Fatalf("Failed to add points to store (%s)", err.Error())
+	}
+	defer ps.DeleteSeries(name)
+
+	sec := int64(777808800)
+	tests := []struct {
+		sel      types.Selection
+		n        int
+		expected []float32 // Sizes of the matching points, newest first
+	}{
+		{types.Selection{}, 10, []float32{3, 2, 1}},
+		{types.Selection{}, 2, []float32{3, 2}},
+		{types.Selection{From: sec + 30}, 10, []float32{3, 2}},
+		{types.Selection{To: sec + 60}, 10, []float32{2, 1}},
+		{types.Selection{From: sec + 30, To: sec + 90}, 10, []float32{2}},
+		{types.Selection{Labels: map[string]string{"stage": "production"}}, 10, []float32{2, 1}},
+		{types.Selection{Labels: map[string]string{"stage": "production", "subject": "env-a"}}, 10, []float32{1}},
+		{types.Selection{Labels: map[string]string{"subject": "env-a"}, To: sec + 60}, 10, []float32{1}},
+		{types.Selection{Labels: map[string]string{"stage": "test", "subject": "env-b"}}, 10, []float32{}},
+	}
+	for _, test := range tests {
+		res, err := ps.GetLastNSelected(name, test.sel, test.n)
+		if err != nil {
+			t.Fatalf("Failed to get selected points from store (%s)", err.Error())
+		}
+		if len(res) != len(test.expected) {
+			t.Errorf("Expected %d points for selection %+v, got %d", len(test.expected), test.sel, len(res))
+			continue
+		}
+		for i, p := range res {
+			if p.Values["size"] != test.expected[i] {
+				t.Errorf("Expected point %d of selection %+v to have size %f, got %f", i, test.sel, test.expected[i], p.Values["size"])
+			}
+		}
+		if test.sel.From != 0 || test.sel.To != 0 || test.n < 10 {
+			continue
+		}
+		count, err := ps.GetCount(name, test.sel.Labels)
+		if err != nil {
+			t.Fatalf("Failed to count points (%s)", err.Error())
+		}
+		if count != len(test.expected) {
+			t.Errorf("Expected %d points with labels %v, counted %d", len(test.expected), test.sel.Labels, count)
+		}
+	}
+
+	latest, err := ps.GetLatest(name, map[string]string{"stage": "production"})
+	if err != nil {
+		t.Fatalf("Failed to get latest point from store (%s)", err.Error())
+	}
+	if latest.TimeStamp != sec+60 || latest.Values["size"] != 2 {
+		t.Errorf("Point does not match the latest in production, got %+v", latest)
+	}
+	_, err = ps.GetLatest(name, map[string]string{"stage": "staging"})
+	if err == nil {
+		t.Errorf("Getting the latest point when none match the labels should return an error")
+	}
+	_, err = ps.GetLastN(name+"-should-not-exist", nil, 10)
+	if err == nil {
+		t.Errorf("Getting the points of a series that doesn't exist should return an error")
+	}
+	count, err := ps.GetCount(name+"-should-not-exist", nil)
+	if err != nil {
+		t.Fatalf("Failed to count points (%s)", err.Error())
+	}
+	if count != 0 {
+		t.Errorf("Expected a series that doesn't exist to have no points, counted %d", count)
+	}
+
+	series, err := ps.ListSeries()
+	if err != nil {
+		t.Fatalf("Failed to get series list (%s)", err.Error())
+	}
+	found := false
+	for _, s := range series {
+		if s.Name == name {
+			found = true
+			if s.Count != 3 {
+				t.Errorf("Test series has incorrect count, expected 3 got %d", s.Count)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Test series is missing from the results array")
+	}
+}
+
+func testBatch(t *testing.T, ps PointStore, name string, settle func()) {
+	points := conformancePoints()
+	// The first batch creates the series and the second one replaces one of its points
+	err := ps.AddPoints(name, points[:2])
+	if err != nil {
+		t.Fatalf("Failed to add points to store (%s)", err.Error())
+	}
+	defer ps.DeleteSeries(name)
+	replaced := points[0]
+	replaced.Values = map[string]float32{"size": 4}
+	err = ps.AddPoints(name, []Point{replaced, points[2]})
+	if err != nil {
+		t.Fatalf("Failed to add points to store (%s)", err.Error())
+	}
+	err = ps.AddPoints(name, nil)
+	if err != nil {
+		t.Errorf("Adding an empty batch shouldn't fail, got %s", err.Error())
+	}
+	settle()
+
+	res, err := ps.GetLastN(name, nil, 10)
+	if err != nil {
+		t.Fatalf("Failed to get points (%s)", err.Error())
+	}
+	expected := []float32{3, 2, 4}
+	if len(res) != len(expected) {
+		t.Fatalf("Expected %d points in the series, got %d", len(expected), len(res))
+	}
+	for i, p := range res {
+		if p.Values["size"] != expected[i] {
+			t.Errorf("Expected point %d to have size %f, got %f", i, expected[i], p.Values["size"])
+		}
+	}
+}
+
+func testRetention(t *testing.T, ps PointStore, name string, settle func()) {
+	err := addConformancePoints(ps, name, settle)
+	if err != nil {
+		t.Fatalf("Failed to add points to store (%s)", err.Error())
+	}
+	defer ps.DeleteSeries(name)
+
+	err = ps.SetRetention(name, 7)
+	if err != nil {
+		t.Fatalf("Failed to set retention (%s)", err.Error())
+	}
+	days, err := ps.GetRetention(name)
+	if err != nil {
+		t.Fatalf("Failed to get retention (%s)", err.Error())
+	}
+	if days != 7 {
+		t.Errorf("Expected a retention of 7 days, got %d", days)
+	}
+	err = ps.SetRetention(name+"-should-not-exist", 7)
+	if err == nil {
+		t.Errorf("Setting the retention of a series that doesn't exist should return an error")
+	}
+
+	pruner, ok := ps.(Pruner)
+	if !ok {
+		return
+	}
+	// The points are 60 seconds apart so only the oldest one is past the retention
+	pruned, err := pruner.Prune(time.Unix(777808800+30, 0).AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatalf("Failed to prune series (%s)", err.Error())
+	}
+	if pruned != 1 {
+		t.Errorf("Expected 1 point to be pruned, %d were", pruned)
+	}
+	count, err := ps.GetCount(name, map[string]string{"subject": "env-a"})
+	if err != nil {
+		t.Fatalf("Failed to count points (%s)", err.Error())
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 point of env-a to be left after pruning, got %d", count)
+	}
+}
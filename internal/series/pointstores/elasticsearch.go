@@ -18,7 +18,8 @@ import (
 
 // ElasticAdapter is a point store implementation for Elasticsearch
 type ElasticAdapter struct {
-	client *elastic.Client
+	client    *elastic.Client
+	retention int // Days that the points of the series created by AddPoint are kept for (0 keeps them forever)
 }
 
 // QResponse is used to facilitate parsing Elasticsearch point query responses
@@ -38,6 +39,22 @@ type QResponse struct {
 	} `json:"hits"`
 }
 
+// BulkResponse is used to facilitate parsing Elasticsearch bulk responses
+type BulkResponse struct {
+	Took   int  `json:"took"`
+	Errors bool `json:"errors"`
+	Items  []struct {
+		Index struct {
+			ID     string `json:"_id"`
+			Status int    `json:"status"`
+			Error  struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"index"`
+	} `json:"items"`
+}
+
 // NewElasticAdapter returns an initialized Elasticsearch point store
 func NewElasticAdapter(sp config.SeriesParams) (*ElasticAdapter, error) {
 	cfg := elastic.Config{
@@ -49,7 +66,7 @@ func NewElasticAdapter(sp config.SeriesParams) (*ElasticAdapter, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &ElasticAdapter{client}, nil
+	return &ElasticAdapter{client: client, retention: sp.Retention}, nil
 }
 
 type mappingProps struct {
@@ -78,7 +95,7 @@ func (ea ElasticAdapter) AddPoint(name string, p Point) error {
 	defer res.Body.Close()
 	if res.IsError() {
 		if res.StatusCode == 404 {
-			err = ea.AddSeries(name, p, 90)
+			err = ea.AddSeries(name, p, ea.retention)
 			if err != nil {
 				return err
 			}
@@ -97,9 +114,55 @@ func (ea ElasticAdapter) AddPoint(name string, p Point) error {
 	return nil
 }
 
-// AddSeries creates and configures a new index in Elasticsearch to hold a time series
+// AddPoints upserts a batch of measurements into the index of a given series with a single bulk request. If the
+// series doesn't exist yet, it's created and the points that were rejected because of it are sent again
+func (ea ElasticAdapter) AddPoints(name string, points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	res, err := ea.bulkIndex(name, points)
+	if err != nil {
+		return err
+	}
+	failed := map[int]error{}
+	missing := []int{} // Positions of the points that were rejected because the series doesn't exist
+	for i, item := range res.Items {
+		switch {
+		case item.Index.Status == 404:
+			missing = append(missing, i)
+		case item.Index.Status >= 300:
+			failed[i] = esToErr("indexing document", item.Index.Error.Type+": "+item.Index.Error.Reason)
+		}
+	}
+	if len(missing) > 0 {
+		err = ea.AddSeries(name, points[missing[0]], ea.retention)
+		if err != nil {
+			return err
+		}
+		retry := make([]Point, len(missing))
+		for i, pos := range missing {
+			retry[i] = points[pos]
+		}
+		res, err = ea.bulkIndex(name, retry)
+		if err != nil {
+			return err
+		}
+		for i, item := range res.Items {
+			if item.Index.Status >= 300 {
+				failed[missing[i]] = esToErr("indexing document", item.Index.Error.Type+": "+item.Index.Error.Reason)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return BatchError{Failed: failed}
+	}
+	return nil
+}
+
+// AddSeries creates and configures a new index in Elasticsearch to hold a time series. The series is really an alias
+// whose indices are rolled over by an ILM policy, which deletes each of them once its points are past the retention
 func (ea ElasticAdapter) AddSeries(name string, sample Point, retentionDays int) error {
-	index := prefix + cleanIndex(name)
+	alias := prefix + cleanIndex(name)
 	props := make(map[string]mappingProps, 1+len(sample.Labels)+len(sample.Values))
 
 	props["@timestamp"] = mappingProps{"date", true}
@@ -114,26 +177,83 @@ func (ea ElasticAdapter) AddSeries(name string, sample Point, retentionDays int)
 	if err != nil {
 		return err
 	}
-	mapping := `{"mappings":{"date_detection": false, "properties":` + string(jProps) + "}}"
+	// Labels that weren't in the sample are mapped as keywords too so that they can be filtered with term queries
+	templates := `[{"labels": {"match_mapping_type": "string", "mapping": {"type": "keyword"}}}]`
+	mapping := `{"date_detection": false, "dynamic_templates": ` + templates + `, "properties":` + string(jProps) + "}"
+	err = ea.putPolicy(alias, retentionDays)
+	if err != nil {
+		return err
+	}
+	// The indices created on rollover get their settings and mappings from the template. Longer names get a higher
+	// priority so that the template of a series wins over those of the series whose names are a prefix of its own
+	template, err := json.Marshal(map[string]interface{}{
+		"index_patterns": []string{alias + "-*"},
+		"priority":       len(alias),
+		"template": map[string]interface{}{
+			"settings": map[string]string{"index.lifecycle.name": alias, "index.lifecycle.rollover_alias": alias},
+			"mappings": json.RawMessage(mapping),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	req := esapi.IndicesPutIndexTemplateRequest{Name: alias, Body: strings.NewReader(string(template))}
+	res, err := req.Do(context.Background(), ea.client)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.IsError() {
+		return esToErr("creating index template", res.Status())
+	}
 	logger.Info("Creating new index for series " + name)
-	res, err := ea.client.Indices.Create(index, ea.client.Indices.Create.WithBody(strings.NewReader(mapping)))
+	aliases := `{"aliases": {"` + alias + `": {"is_write_index": true}}}`
+	res, err = ea.client.Indices.Create(alias+"-000001", ea.client.Indices.Create.WithBody(strings.NewReader(aliases)))
 	if err != nil {
 		return err
 	}
+	res.Body.Close()
 	if res.IsError() {
 		return esToErr("creating index", res.Status())
 	}
 	return nil
 }
 
-// DeleteSeries removes the index used to store a series
+// DeleteSeries removes the indices used to store a series along with its index template and ILM policy
 func (ea ElasticAdapter) DeleteSeries(name string) error {
-	index := prefix + cleanIndex(name)
-	res, err := ea.client.Indices.Delete([]string{index})
+	alias := prefix + cleanIndex(name)
+	indices, err := ea.seriesIndices(alias)
+	if err != nil {
+		return err
+	}
+	if len(indices) > 0 {
+		res, err := ea.client.Indices.Delete(indices)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.IsError() && res.StatusCode != 404 {
+			return esToErr("deleting indices", res.Status())
+		}
+	}
+	tReq := esapi.IndicesDeleteIndexTemplateRequest{Name: alias}
+	res, err := tReq.Do(context.Background(), ea.client)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.IsError() && res.StatusCode != 404 {
+		return esToErr("deleting index template", res.Status())
+	}
+	pReq := esapi.ILMDeleteLifecycleRequest{Policy: alias}
+	res, err = pReq.Do(context.Background(), ea.client)
 	if err != nil {
 		return err
 	}
 	res.Body.Close()
+	if res.IsError() && res.StatusCode != 404 {
+		return esToErr("deleting ILM policy", res.Status())
+	}
 	return nil
 }
 
@@ -162,9 +282,14 @@ func (ea ElasticAdapter) Exists(name string) (bool, error) {
 // series doesn't exist)
 func (ea ElasticAdapter) GetCount(name string, labels map[string]string) (int, error) {
 	index := prefix + cleanIndex(name)
+	stmt, err := json.Marshal(map[string]interface{}{"query": selectionQuery(types.Selection{Labels: labels})})
+	if err != nil {
+		return 0, err
+	}
 	res, err := ea.client.Count(
 		ea.client.Count.WithContext(context.Background()),
 		ea.client.Count.WithIndex(index),
+		ea.client.Count.WithBody(strings.NewReader(string(stmt))),
 	)
 	if err != nil {
 		return 0, err
@@ -191,23 +316,34 @@ func (ea ElasticAdapter) GetCount(name string, labels map[string]string) (int, e
 
 // GetLatest retrieves the most recent value of the series with the specified labels
 func (ea ElasticAdapter) GetLatest(name string, labels map[string]string) (Point, error) {
-	index := prefix + cleanIndex(name)
-	stmt := `{"sort": [{"@timestamp": {"order": "desc"}}],"size": 1}`
-	res, err := ea.query(index, stmt)
+	points, err := ea.GetLastN(name, labels, 1)
 	if err != nil {
 		return Point{}, err
 	}
-	if len(res.Hits.Hits) < 1 {
-		return Point{}, errors.New("no points found")
+	if len(points) < 1 {
+		return Point{}, RequestError("no points found")
 	}
-	return res.Hits.Hits[0].P, nil
+	return points[0], nil
 }
 
 // GetLastN retrieves the last N points for the given series with the specified labels
 func (ea ElasticAdapter) GetLastN(name string, labels map[string]string, n int) ([]Point, error) {
+	return ea.GetLastNSelected(name, types.Selection{Labels: labels}, n)
+}
+
+// GetLastNSelected retrieves the last N points of the given series that match the selection, using term filters for
+// the labels and a range filter for the timestamps
+func (ea ElasticAdapter) GetLastNSelected(name string, sel types.Selection, n int) ([]Point, error) {
 	index := prefix + cleanIndex(name)
-	stmt := `{"sort": [{"@timestamp": {"order": "desc"}}],"size": ` + strconv.Itoa(n) + `}`
-	res, err := ea.query(index, stmt)
+	stmt, err := json.Marshal(map[string]interface{}{
+		"query": selectionQuery(sel),
+		"size":  n,
+		"sort":  []interface{}{map[string]interface{}{"@timestamp": map[string]string{"order": "desc"}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	res, err := ea.query(index, string(stmt))
 	if err != nil {
 		return nil, err
 	}
@@ -219,8 +355,46 @@ func (ea ElasticAdapter) GetLastN(name string, labels map[string]string, n int)
 	return points, nil
 }
 
-// ListSeries as its name implies, returns a list of all the series that are available in Elasticsearch
+// GetRetention returns the number of days that the points of the given series are kept for, according to the delete
+// phase of its ILM policy. Series created before retention was supported keep their points forever
+func (ea ElasticAdapter) GetRetention(name string) (int, error) {
+	alias := prefix + cleanIndex(name)
+	req := esapi.ILMGetLifecycleRequest{Policy: alias}
+	res, err := req.Do(context.Background(), ea.client)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		if res.StatusCode == 404 {
+			return 0, nil
+		}
+		return 0, esToErr("getting ILM policy", res.Status())
+	}
+	var r map[string]struct {
+		Policy struct {
+			Phases map[string]struct {
+				MinAge string `json:"min_age"`
+			} `json:"phases"`
+		} `json:"policy"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return 0, err
+	}
+	phase, ok := r[alias].Policy.Phases["delete"]
+	if !ok {
+		return 0, nil
+	}
+	return strconv.Atoi(strings.TrimSuffix(phase.MinAge, "d"))
+}
+
+// ListSeries as its name implies, returns a list of all the series that are available in Elasticsearch, adding up the
+// points of all the indices behind each of them
 func (ea ElasticAdapter) ListSeries() ([]types.BriefSeries, error) {
+	aliasOf, err := ea.aliases()
+	if err != nil {
+		return nil, err
+	}
 	req := esapi.CatIndicesRequest{
 		Index:  []string{prefix + "*"},
 		Format: "json",
@@ -240,6 +414,7 @@ func (ea ElasticAdapter) ListSeries() ([]types.BriefSeries, error) {
 		return nil, err
 	}
 	series := []types.BriefSeries{}
+	positions := map[string]int{}
 	for _, s := range r {
 		count := 0
 		if s["docs.count"] != nil {
@@ -248,12 +423,40 @@ func (ea ElasticAdapter) ListSeries() ([]types.BriefSeries, error) {
 				return nil, err
 			}
 		}
-		series = append(series, types.BriefSeries{Name: s["index"].(string)[len(prefix):], Count: count})
+		index := s["index"].(string)
+		if alias, ok := aliasOf[index]; ok {
+			index = alias
+		}
+		if i, ok := positions[index]; ok {
+			series[i].Count += count
+			continue
+		}
+		positions[index] = len(series)
+		series = append(series, types.BriefSeries{Name: index[len(prefix):], Count: count})
 	}
 
 	return series, nil
 }
 
+// SetRetention changes the number of days that the points of an existing series are kept for by updating its ILM
+// policy. Series created before retention was supported don't have one, so they keep their points forever
+func (ea ElasticAdapter) SetRetention(name string, retentionDays int) error {
+	alias := prefix + cleanIndex(name)
+	req := esapi.IndicesExistsAliasRequest{Name: []string{alias}}
+	res, err := req.Do(context.Background(), ea.client)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode == 404 {
+		return RequestError("series " + name + " has no retention policy, it either doesn't exist or was created before retention was supported")
+	}
+	if res.IsError() {
+		return esToErr("checking if alias exists", res.Status())
+	}
+	return ea.putPolicy(alias, retentionDays)
+}
+
 // LoadTestSet loads a set of points from a single file for testing (not part of the standard PointStore interface)
 func (ea ElasticAdapter) LoadTestSet(name, path string) error {
 	ps := FileAdapter{Path: "."}
@@ -261,13 +464,70 @@ func (ea ElasticAdapter) LoadTestSet(name, path string) error {
 	if err != nil {
 		return err
 	}
-	for _, point := range points {
-		err = ea.AddPoint(name, point)
+	return ea.AddPoints(name, points)
+}
+
+// bulkIndex sends a bulk request that upserts the given points into the index of a series, returning the result of
+// each of them in the same order
+func (ea ElasticAdapter) bulkIndex(name string, points []Point) (*BulkResponse, error) {
+	var body strings.Builder
+	for _, p := range points {
+		data, err := json.Marshal(p)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		body.WriteString(`{"index": {"_id": "` + p.ID() + `"}}` + "\n")
+		body.Write(data)
+		body.WriteString("\n")
 	}
-	return nil
+	logger.Trace(fmt.Sprintf("Indexing %d documents in bulk", len(points)))
+	req := esapi.BulkRequest{
+		Index: prefix + cleanIndex(name),
+		Body:  strings.NewReader(body.String()),
+	}
+	res, err := req.Do(context.Background(), ea.client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, esToErr("indexing documents in bulk", res.Status())
+	}
+	var r BulkResponse
+	err = json.NewDecoder(res.Body).Decode(&r)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Items) != len(points) {
+		return nil, errors.New("bulk response has " + strconv.Itoa(len(r.Items)) + " items but " + strconv.Itoa(len(points)) + " points were sent")
+	}
+	return &r, nil
+}
+
+// aliases returns the series alias of each of the indices that have one
+func (ea ElasticAdapter) aliases() (map[string]string, error) {
+	req := esapi.CatAliasesRequest{
+		Name:   []string{prefix + "*"},
+		Format: "json",
+		H:      []string{"alias", "index"},
+	}
+	res, err := req.Do(context.Background(), ea.client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, esToErr("listing aliases", res.Status())
+	}
+	var r []map[string]string
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	aliasOf := make(map[string]string, len(r))
+	for _, a := range r {
+		aliasOf[a["index"]] = a["alias"]
+	}
+	return aliasOf, nil
 }
 
 func esToErr(context, err string) error {
@@ -315,6 +575,82 @@ func (ea ElasticAdapter) query(index, stmt string) (QResponse, error) {
 	return r, nil
 }
 
+// putPolicy creates or updates the ILM policy of a series. Its indices are rolled over daily when points have to be
+// deleted (and monthly otherwise, which keeps the number of indices low while allowing a retention to be set later)
+func (ea ElasticAdapter) putPolicy(alias string, retentionDays int) error {
+	phases := map[string]interface{}{}
+	rollover := "30d"
+	if retentionDays > 0 {
+		rollover = "1d"
+		phases["delete"] = map[string]interface{}{
+			"min_age": strconv.Itoa(retentionDays) + "d",
+			"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+		}
+	}
+	phases["hot"] = map[string]interface{}{
+		"actions": map[string]interface{}{"rollover": map[string]string{"max_age": rollover}},
+	}
+	policy, err := json.Marshal(map[string]interface{}{"policy": map[string]interface{}{"phases": phases}})
+	if err != nil {
+		return err
+	}
+	req := esapi.ILMPutLifecycleRequest{Policy: alias, Body: strings.NewReader(string(policy))}
+	res, err := req.Do(context.Background(), ea.client)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.IsError() {
+		return esToErr("saving ILM policy", res.Status())
+	}
+	return nil
+}
+
+// selectionQuery returns a query that only matches the points of a selection
+func selectionQuery(sel types.Selection) map[string]interface{} {
+	filters := []interface{}{}
+	for label, value := range sel.Labels {
+		filters = append(filters, map[string]interface{}{"term": map[string]string{label: value}})
+	}
+	if sel.From != 0 || sel.To != 0 {
+		bounds := map[string]int64{}
+		if sel.From != 0 {
+			bounds["gte"] = sel.From
+		}
+		if sel.To != 0 {
+			bounds["lte"] = sel.To
+		}
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"@timestamp": bounds}})
+	}
+	return map[string]interface{}{"bool": map[string]interface{}{"filter": filters}}
+}
+
+// seriesIndices returns the names of the indices that hold the points of a series, which is a single one named like
+// the series for those created before retention was supported
+func (ea ElasticAdapter) seriesIndices(alias string) ([]string, error) {
+	req := esapi.IndicesGetAliasRequest{Index: []string{alias}}
+	res, err := req.Do(context.Background(), ea.client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		if res.StatusCode == 404 {
+			return nil, nil
+		}
+		return nil, esToErr("getting indices of alias", res.Status())
+	}
+	var r map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	indices := make([]string, 0, len(r))
+	for index := range r {
+		indices = append(indices, index)
+	}
+	return indices, nil
+}
+
 func cleanIndex(name string) string {
 	res := strings.ToLower(name)
 	return strings.ReplaceAll(res, ":", "_")
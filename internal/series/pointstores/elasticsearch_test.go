@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/qvantel/nerd/api/types"
 	"github.com/qvantel/nerd/internal/config"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -151,6 +152,40 @@ func TestGetLastN(t *testing.T) {
 	}
 }
 
+func TestGetLastNSelected(t *testing.T) {
+	err := initTest(t.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize point store (%s)", err.Error())
+	}
+	defer testElasticStore.DeleteSeries(t.Name())
+
+	sec := int64(777808800)
+	tests := []struct {
+		sel      types.Selection
+		expected []int64
+	}{
+		{types.Selection{}, []int64{sec + 60, sec}},
+		{types.Selection{From: sec + 30}, []int64{sec + 60}},
+		{types.Selection{Labels: map[string]string{"env": "test"}, To: sec}, []int64{sec}},
+		{types.Selection{Labels: map[string]string{"env": "prod"}}, []int64{}},
+	}
+	for _, test := range tests {
+		points, err := testElasticStore.GetLastNSelected(t.Name(), test.sel, 4)
+		if err != nil {
+			t.Fatalf("Failed to get selected points from store (%s)", err.Error())
+		}
+		if len(points) != len(test.expected) {
+			t.Errorf("Expected %d points for selection %+v, got %d", len(test.expected), test.sel, len(points))
+			continue
+		}
+		for i, p := range points {
+			if p.TimeStamp != test.expected[i] {
+				t.Errorf("Expected point %d of selection %+v to have timestamp %d, got %d", i, test.sel, test.expected[i], p.TimeStamp)
+			}
+		}
+	}
+}
+
 func TestGetCount(t *testing.T) {
 	err := initTest(t.Name())
 	if err != nil {
@@ -167,6 +202,65 @@ func TestGetCount(t *testing.T) {
 	}
 }
 
+func TestLabels(t *testing.T) {
+	points := []Point{
+		{Labels: map[string]string{"stage": "production", "subject": "env-a"}, Values: map[string]float32{"size": 1}, TimeStamp: 777808800},
+		{Labels: map[string]string{"stage": "production", "subject": "env-b"}, Values: map[string]float32{"size": 2}, TimeStamp: 777808860},
+		{Labels: map[string]string{"stage": "test", "subject": "env-a"}, Values: map[string]float32{"size": 3}, TimeStamp: 777808920},
+	}
+	for _, p := range points {
+		err := testElasticStore.AddPoint(t.Name(), p)
+		if err != nil {
+			t.Fatalf("Failed to add point to store (%s)", err.Error())
+		}
+	}
+	defer testElasticStore.DeleteSeries(t.Name())
+	// Pause for refresh
+	time.Sleep(1 * time.Second)
+
+	tests := []struct {
+		labels   map[string]string
+		expected []float32 // Sizes of the matching points, newest first
+	}{
+		{nil, []float32{3, 2, 1}},
+		{map[string]string{"stage": "production"}, []float32{2, 1}},
+		{map[string]string{"stage": "production", "subject": "env-a"}, []float32{1}},
+		{map[string]string{"stage": "test", "subject": "env-b"}, []float32{}},
+	}
+	for _, test := range tests {
+		count, err := testElasticStore.GetCount(t.Name(), test.labels)
+		if err != nil {
+			t.Fatalf("Failed to count points (%s)", err.Error())
+		}
+		if count != len(test.expected) {
+			t.Errorf("Expected %d points with labels %v, counted %d", len(test.expected), test.labels, count)
+		}
+		res, err := testElasticStore.GetLastN(t.Name(), test.labels, 10)
+		if err != nil {
+			t.Fatalf("Failed to get points (%s)", err.Error())
+		}
+		if len(res) != len(test.expected) {
+			t.Errorf("Expected %d points with labels %v, got %d", len(test.expected), test.labels, len(res))
+			continue
+		}
+		for i, p := range res {
+			if p.Values["size"] != test.expected[i] {
+				t.Errorf("Expected point %d with labels %v to have size %f, got %f", i, test.labels, test.expected[i], p.Values["size"])
+			}
+		}
+		latest, err := testElasticStore.GetLatest(t.Name(), test.labels)
+		if len(test.expected) == 0 {
+			if err == nil {
+				t.Errorf("Expected an error getting the latest point with labels %v, got %+v", test.labels, latest)
+			}
+			continue
+		}
+		if err != nil || latest.Values["size"] != test.expected[0] {
+			t.Errorf("Expected the latest point with labels %v to have size %f, got %+v (%v)", test.labels, test.expected[0], latest, err)
+		}
+	}
+}
+
 func TestListSeries(t *testing.T) {
 	err := initTest(t.Name())
 	if err != nil {
@@ -196,6 +290,42 @@ func TestListSeries(t *testing.T) {
 	}
 }
 
+func TestRetention(t *testing.T) {
+	err := initTest(t.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize point store (%s)", err.Error())
+	}
+	defer testElasticStore.DeleteSeries(t.Name())
+
+	days, err := testElasticStore.GetRetention(t.Name())
+	if err != nil {
+		t.Fatalf("Failed to get retention (%s)", err.Error())
+	}
+	if days != 0 {
+		t.Errorf("Expected the series to keep its points forever, got a retention of %d days", days)
+	}
+	err = testElasticStore.SetRetention(t.Name(), 30)
+	if err != nil {
+		t.Fatalf("Failed to set retention (%s)", err.Error())
+	}
+	days, err = testElasticStore.GetRetention(t.Name())
+	if err != nil {
+		t.Fatalf("Failed to get retention (%s)", err.Error())
+	}
+	if days != 30 {
+		t.Errorf("Expected a retention of 30 days, got %d", days)
+	}
+	err = testElasticStore.SetRetention(t.Name()+"-should-not-exist", 30)
+	if err == nil {
+		t.Errorf("Setting the retention of a series that doesn't exist should return an error")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	// Pause for refresh after writing
+	testConformance(t, testElasticStore, "conformance", func() { time.Sleep(1 * time.Second) })
+}
+
 func TestLoadTestSet(t *testing.T) {
 	found, err := testElasticStore.Exists(t.Name())
 	if err != nil {
@@ -8,6 +8,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/qvantel/nerd/api/types"
@@ -17,9 +18,17 @@ import (
 // FileAdapter is a point store implementation that uses the filesystem. Its main purpose is to facilitate
 // testing, given its low performance it is strongly discouraged for production use
 type FileAdapter struct {
-	Path string
+	Path      string
+	Retention int // Days that the points of the series created by AddPoint are kept for (0 keeps them forever)
 }
 
+// retentionExt is the extension of the file, next to the directory of each series, that holds its retention
+const retentionExt = ".retention"
+
+// labelCache keeps the labels of the point stored in each file (by path) so that filtering a series by labels only
+// needs to read each file once, which is safe because the labels of a file never change once written
+var labelCache sync.Map
+
 // NewFileAdapter returns an initialized file point store object
 func NewFileAdapter(conf map[string]interface{}) (*FileAdapter, error) {
 	return &FileAdapter{Path: conf["Path"].(string)}, nil
@@ -31,7 +40,7 @@ func (fa FileAdapter) AddPoint(name string, p Point) error {
 	dir := prefix + cleanDir(name)
 	series := fa.Path + "/" + dir
 	if _, err := os.Stat(series); os.IsNotExist(err) {
-		err = fa.AddSeries(name, p, 90)
+		err = fa.AddSeries(name, p, fa.Retention)
 		if err != nil {
 			return err
 		}
@@ -52,19 +61,50 @@ func (fa FileAdapter) AddPoint(name string, p Point) error {
 	f.Sync()
 	ts := time.Unix(p.TimeStamp, 0)
 	os.Chtimes(fa.Path+"/"+dir+"/"+p.ID(), ts, ts)
+	labelCache.Store(fa.Path+"/"+dir+"/"+p.ID(), p.Labels)
+	return nil
+}
+
+// AddPoints creates a file for each of the points in the given series, carrying on past the ones that fail
+func (fa FileAdapter) AddPoints(name string, points []Point) error {
+	failed := map[int]error{}
+	for i, p := range points {
+		err := fa.AddPoint(name, p)
+		if err != nil {
+			failed[i] = err
+		}
+	}
+	if len(failed) > 0 {
+		return BatchError{Failed: failed}
+	}
 	return nil
 }
 
-// AddSeries creates a directory to hold a time series
+// AddSeries creates a directory to hold a time series and records its retention, which is enforced by Prune
 func (fa FileAdapter) AddSeries(name string, sample Point, retentionDays int) error {
 	dir := prefix + cleanDir(name)
 	logger.Info("Creating new directory for series " + name)
-	return os.Mkdir(fa.Path+"/"+dir, 0755)
+	err := os.Mkdir(fa.Path+"/"+dir, 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fa.Path+"/"+dir+retentionExt, []byte(strconv.Itoa(retentionDays)), 0644)
 }
 
 // DeleteSeries removes the subdirectory used to store a series
 func (fa FileAdapter) DeleteSeries(name string) error {
 	dir := prefix + cleanDir(name)
+	series := fa.Path + "/" + dir + "/"
+	labelCache.Range(func(key, _ interface{}) bool {
+		if strings.HasPrefix(key.(string), series) {
+			labelCache.Delete(key)
+		}
+		return true
+	})
+	err := os.Remove(fa.Path + "/" + dir + retentionExt)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return os.RemoveAll(fa.Path + "/" + dir)
 }
 
@@ -82,18 +122,31 @@ func (fa FileAdapter) Exists(name string) (bool, error) {
 	return true, nil
 }
 
-// GetCount retrieves the number of points recorded for the given series (labels are ignored in this adapter for speed)
-// (returns 0 if the series doesn't exist)
+// GetCount retrieves the number of points recorded for the given series with the specified labels (returns 0 if the
+// series doesn't exist)
 func (fa FileAdapter) GetCount(name string, labels map[string]string) (int, error) {
-	dir := prefix + cleanDir(name)
-	files, err := ioutil.ReadDir(fa.Path + "/" + dir)
+	dir := fa.Path + "/" + prefix + cleanDir(name)
+	files, err := ioutil.ReadDir(dir)
 	if os.IsNotExist(err) {
 		return 0, nil
 	}
 	if err != nil {
 		return 0, err
 	}
-	return len(files), nil
+	if len(labels) == 0 {
+		return len(files), nil
+	}
+	count := 0
+	for _, file := range files {
+		ok, err := hasLabels(dir+"/"+file.Name(), labels)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			count++
+		}
+	}
+	return count, nil
 }
 
 // GetLatest returns the most recent value of the series by looking for the most recent file in its subdir
@@ -102,41 +155,71 @@ func (fa FileAdapter) GetLatest(name string, labels map[string]string) (Point, e
 	if err != nil {
 		return Point{}, err
 	}
+	if len(points) < 1 {
+		return Point{}, RequestError("no points found")
+	}
 	return points[0], nil
 }
 
-// GetLastN returns the last n points for the given series
+// GetLastN returns the last n points for the given series with the specified labels
 func (fa FileAdapter) GetLastN(name string, labels map[string]string, n int) ([]Point, error) {
+	return fa.GetLastNSelected(name, types.Selection{Labels: labels}, n)
+}
+
+// GetLastNSelected returns the last n points of the given series that match the selection. As the modification time of
+// each file is set to the timestamp of its point, only the files within the time range are read
+func (fa FileAdapter) GetLastNSelected(name string, sel types.Selection, n int) ([]Point, error) {
 	dir := prefix + cleanDir(name)
 
 	files, err := ioutil.ReadDir(fa.Path + "/" + dir)
 	if err != nil {
 		return nil, err
 	}
-	if len(files) < n {
-		n = len(files)
-	}
 	sort.SliceStable(files, func(i, j int) bool {
 		return files[j].ModTime().Before(files[i].ModTime())
 	})
 
-	var points []Point
-	for _, file := range files[:n] {
-		dat, err := ioutil.ReadFile(fa.Path + "/" + dir + "/" + file.Name())
-		if err != nil {
-			return nil, err
+	points := []Point{}
+	for _, file := range files {
+		if len(points) >= n {
+			break
+		}
+		ts := file.ModTime().Unix()
+		if sel.To != 0 && ts > sel.To {
+			continue
+		}
+		if sel.From != 0 && ts < sel.From {
+			break
 		}
-		var p Point
-		err = json.Unmarshal(dat, &p)
+		path := fa.Path + "/" + dir + "/" + file.Name()
+		if labels, ok := labelCache.Load(path); ok && !(Point{Labels: labels.(map[string]string)}).HasLabels(sel.Labels) {
+			continue // Skip reading the files that are known not to match
+		}
+		p, err := readPoint(path)
 		if err != nil {
 			return nil, err
 		}
-		points = append(points, p)
+		if p.HasLabels(sel.Labels) {
+			points = append(points, p)
+		}
 	}
 
 	return points, nil
 }
 
+// GetRetention returns the number of days that the points of the given series are kept for, series created before
+// retention was supported keep their points forever
+func (fa FileAdapter) GetRetention(name string) (int, error) {
+	dat, err := ioutil.ReadFile(fa.Path + "/" + prefix + cleanDir(name) + retentionExt)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(dat)))
+}
+
 // ListSeries returns a list of all the available series in the configured directory
 func (fa FileAdapter) ListSeries() ([]types.BriefSeries, error) {
 	files, err := ioutil.ReadDir(fa.Path)
@@ -162,6 +245,56 @@ func (fa FileAdapter) ListSeries() ([]types.BriefSeries, error) {
 	return series, nil
 }
 
+// Prune deletes the points that are older than the retention of their series at the given time, returning how many
+// were deleted. As the modification time of each file is set to the timestamp of its point, no files have to be read
+func (fa FileAdapter) Prune(now time.Time) (int, error) {
+	series, err := fa.ListSeries()
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for _, s := range series {
+		days, err := fa.GetRetention(s.Name)
+		if err != nil {
+			return pruned, err
+		}
+		if days == 0 {
+			continue
+		}
+		cutoff := now.AddDate(0, 0, -days)
+		dir := fa.Path + "/" + prefix + s.Name
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return pruned, err
+		}
+		for _, file := range files {
+			if !file.ModTime().Before(cutoff) {
+				continue
+			}
+			err = os.Remove(dir + "/" + file.Name())
+			if err != nil && !os.IsNotExist(err) {
+				return pruned, err
+			}
+			labelCache.Delete(dir + "/" + file.Name())
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+// SetRetention changes the number of days that the points of an existing series are kept for
+func (fa FileAdapter) SetRetention(name string, retentionDays int) error {
+	exists, err := fa.Exists(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return RequestError("series " + name + " doesn't exist")
+	}
+	dir := prefix + cleanDir(name)
+	return ioutil.WriteFile(fa.Path+"/"+dir+retentionExt, []byte(strconv.Itoa(retentionDays)), 0644)
+}
+
 // LoadTestSet loads a set of points from a single file for testing (not part of the standard PointStore interface)
 func (fa FileAdapter) LoadTestSet(name string) ([]Point, error) {
 	file, err := os.Open(fa.Path + "/" + name)
@@ -191,6 +324,35 @@ func (fa FileAdapter) LoadTestSet(name string) ([]Point, error) {
 	return points, nil
 }
 
+// hasLabels returns true if the point in the given file has all of the labels, reading the file only if its labels
+// aren't cached yet
+func hasLabels(path string, labels map[string]string) (bool, error) {
+	cached, ok := labelCache.Load(path)
+	if !ok {
+		p, err := readPoint(path)
+		if err != nil {
+			return false, err
+		}
+		return p.HasLabels(labels), nil
+	}
+	return Point{Labels: cached.(map[string]string)}.HasLabels(labels), nil
+}
+
+// readPoint reads the point stored in the given file and caches its labels
+func readPoint(path string) (Point, error) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Point{}, err
+	}
+	var p Point
+	err = json.Unmarshal(dat, &p)
+	if err != nil {
+		return Point{}, err
+	}
+	labelCache.Store(path, p.Labels)
+	return p, nil
+}
+
 func cleanDir(name string) string {
 	res := strings.ToLower(name)
 	return strings.ReplaceAll(res, ":", "_")
@@ -2,8 +2,12 @@ package pointstores
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/qvantel/nerd/api/types"
 	"github.com/qvantel/nerd/internal/config"
 )
 
@@ -94,6 +98,152 @@ func TestGetLastNFile(t *testing.T) {
 	}
 }
 
+func TestGetLastNSelectedFile(t *testing.T) {
+	ps, err := initFileStoreTest(t.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize point store (%s)", err.Error())
+	}
+	defer ps.DeleteSeries(t.Name())
+
+	sec := int64(777808800)
+	tests := []struct {
+		sel      types.Selection
+		expected []int64
+	}{
+		{types.Selection{}, []int64{sec + 60, sec}},
+		{types.Selection{From: sec + 30}, []int64{sec + 60}},
+		{types.Selection{Labels: map[string]string{"env": "test"}, To: sec}, []int64{sec}},
+		{types.Selection{Labels: map[string]string{"env": "prod"}}, []int64{}},
+	}
+	for _, test := range tests {
+		points, err := ps.GetLastNSelected(t.Name(), test.sel, 4)
+		if err != nil {
+			t.Fatalf("Failed to get selected points from store (%s)", err.Error())
+		}
+		if len(points) != len(test.expected) {
+			t.Errorf("Expected %d points for selection %+v, got %d", len(test.expected), test.sel, len(points))
+			continue
+		}
+		for i, p := range points {
+			if p.TimeStamp != test.expected[i] {
+				t.Errorf("Expected point %d of selection %+v to have timestamp %d, got %d", i, test.sel, test.expected[i], p.TimeStamp)
+			}
+		}
+	}
+}
+
+func TestLabelsFile(t *testing.T) {
+	points := []Point{
+		{Labels: map[string]string{"stage": "production", "subject": "env-a"}, Values: map[string]float32{"size": 1}, TimeStamp: 777808800},
+		{Labels: map[string]string{"stage": "production", "subject": "env-b"}, Values: map[string]float32{"size": 2}, TimeStamp: 777808860},
+		{Labels: map[string]string{"stage": "test", "subject": "env-a"}, Values: map[string]float32{"size": 3}, TimeStamp: 777808920},
+	}
+	ps, err := getTestFileStore()
+	if err != nil {
+		t.Fatalf("Failed to initialize point store (%s)", err.Error())
+	}
+	for _, p := range points {
+		err := ps.AddPoint(t.Name(), p)
+		if err != nil {
+			t.Fatalf("Failed to add point to store (%s)", err.Error())
+		}
+	}
+	defer ps.DeleteSeries(t.Name())
+
+	tests := []struct {
+		labels   map[string]string
+		expected []float32 // Sizes of the matching points, newest first
+	}{
+		{nil, []float32{3, 2, 1}},
+		{map[string]string{"stage": "production"}, []float32{2, 1}},
+		{map[string]string{"stage": "production", "subject": "env-a"}, []float32{1}},
+		{map[string]string{"stage": "test", "subject": "env-b"}, []float32{}},
+	}
+	for _, test := range tests {
+		count, err := ps.GetCount(t.Name(), test.labels)
+		if err != nil {
+			t.Fatalf("Failed to count points (%s)", err.Error())
+		}
+		if count != len(test.expected) {
+			t.Errorf("Expected %d points with labels %v, counted %d", len(test.expected), test.labels, count)
+		}
+		res, err := ps.GetLastN(t.Name(), test.labels, 10)
+		if err != nil {
+			t.Fatalf("Failed to get points (%s)", err.Error())
+		}
+		if len(res) != len(test.expected) {
+			t.Errorf("Expected %d points with labels %v, got %d", len(test.expected), test.labels, len(res))
+			continue
+		}
+		for i, p := range res {
+			if p.Values["size"] != test.expected[i] {
+				t.Errorf("Expected point %d with labels %v to have size %f, got %f", i, test.labels, test.expected[i], p.Values["size"])
+			}
+		}
+		latest, err := ps.GetLatest(t.Name(), test.labels)
+		if len(test.expected) == 0 {
+			if err == nil {
+				t.Errorf("Expected an error getting the latest point with labels %v, got %+v", test.labels, latest)
+			}
+			continue
+		}
+		if err != nil || latest.Values["size"] != test.expected[0] {
+			t.Errorf("Expected the latest point with labels %v to have size %f, got %+v (%v)", test.labels, test.expected[0], latest, err)
+		}
+	}
+}
+
+func TestRetentionFile(t *testing.T) {
+	ps, err := initFileStoreTest(t.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize point store (%s)", err.Error())
+	}
+	defer ps.DeleteSeries(t.Name())
+
+	days, err := ps.GetRetention(t.Name())
+	if err != nil {
+		t.Fatalf("Failed to get retention (%s)", err.Error())
+	}
+	if days != 0 {
+		t.Errorf("Expected the series to keep its points forever, got a retention of %d days", days)
+	}
+	// The test points are 60 seconds apart so only the oldest one is past the retention
+	now := time.Unix(777808800+30, 0).AddDate(0, 0, 7)
+	pruned, err := ps.(Pruner).Prune(now)
+	if err != nil {
+		t.Fatalf("Failed to prune series (%s)", err.Error())
+	}
+	if pruned != 0 {
+		t.Errorf("Expected no points to be pruned from a series without retention, %d were", pruned)
+	}
+	err = ps.SetRetention(t.Name(), 7)
+	if err != nil {
+		t.Fatalf("Failed to set retention (%s)", err.Error())
+	}
+	days, err = ps.GetRetention(t.Name())
+	if err != nil {
+		t.Fatalf("Failed to get retention (%s)", err.Error())
+	}
+	if days != 7 {
+		t.Errorf("Expected a retention of 7 days, got %d", days)
+	}
+	_, err = ps.(Pruner).Prune(now)
+	if err != nil {
+		t.Fatalf("Failed to prune series (%s)", err.Error())
+	}
+	count, err := ps.GetCount(t.Name(), nil)
+	if err != nil {
+		t.Fatalf("Failed to count points (%s)", err.Error())
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 point to be left after pruning, got %d", count)
+	}
+	err = ps.SetRetention(t.Name()+"-should-not-exist", 7)
+	if err == nil {
+		t.Errorf("Setting the retention of a series that doesn't exist should return an error")
+	}
+}
+
 func TestListSeriesDirs(t *testing.T) {
 	ps, err := initFileStoreTest(t.Name())
 	if err != nil {
@@ -122,3 +272,16 @@ func TestListSeriesDirs(t *testing.T) {
 		t.Errorf("Test series is missing from the results array")
 	}
 }
+
+func TestConformanceFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nerd-file")
+	if err != nil {
+		t.Fatalf("Failed to create series directory (%s)", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	ps, err := NewFileAdapter(map[string]interface{}{"Path": dir})
+	if err != nil {
+		t.Fatalf("Failed to get point store (%s)", err.Error())
+	}
+	testConformance(t, ps, "conformance", func() {})
+}
@@ -0,0 +1,286 @@
+package pointstores
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/logger"
+)
+
+// memoryStores holds the memory stores of the process by snapshot path, so that every part of the service that creates
+// a store gets the same one
+var (
+	memoryStores   = map[string]*MemoryAdapter{}
+	memoryStoresMu sync.Mutex
+)
+
+// MemoryAdapter is a point store implementation that keeps every series in memory, its main purpose is to facilitate
+// local development and testing. If a snapshot path is configured, the series are loaded from it when the store is
+// created and written back to it by SaveSnapshots (which is called when the service shuts down)
+type MemoryAdapter struct {
+	mu        sync.RWMutex
+	retention int // Days that the points of the series created by AddPoint are kept for (0 keeps them forever)
+	series    map[string]*memorySeries
+	snapshot  string
+}
+
+// memorySeries holds the points of a series sorted by timestamp (oldest first) and its retention
+type memorySeries struct {
+	Points    []Point `json:"points"`
+	Retention int     `json:"retention"`
+}
+
+// insert puts a point in its place in the series, replacing it if it was already there
+func (s *memorySeries) insert(p Point) {
+	id := p.ID()
+	i := sort.Search(len(s.Points), func(i int) bool {
+		return s.Points[i].TimeStamp > p.TimeStamp || (s.Points[i].TimeStamp == p.TimeStamp && s.Points[i].ID() >= id)
+	})
+	if i < len(s.Points) && s.Points[i].TimeStamp == p.TimeStamp && s.Points[i].ID() == id {
+		s.Points[i] = p
+		return
+	}
+	s.Points = append(s.Points, Point{})
+	copy(s.Points[i+1:], s.Points[i:])
+	s.Points[i] = p
+}
+
+// NewMemoryAdapter returns the memory point store of the process for the configured snapshot path (if any), creating
+// it the first time
+func NewMemoryAdapter(conf map[string]interface{}, retention int) (*MemoryAdapter, error) {
+	snapshot, _ := conf["Snapshot"].(string)
+	memoryStoresMu.Lock()
+	defer memoryStoresMu.Unlock()
+	ma, ok := memoryStores[snapshot]
+	if !ok {
+		var err error
+		ma, err = newMemoryAdapter(snapshot, retention)
+		if err != nil {
+			return nil, err
+		}
+		memoryStores[snapshot] = ma
+	}
+	return ma, nil
+}
+
+// newMemoryAdapter returns a new memory store with the series of the given snapshot, if it exists
+func newMemoryAdapter(snapshot string, retention int) (*MemoryAdapter, error) {
+	ma := &MemoryAdapter{retention: retention, series: map[string]*memorySeries{}, snapshot: snapshot}
+	if snapshot == "" {
+		return ma, nil
+	}
+	data, err := ioutil.ReadFile(snapshot)
+	if os.IsNotExist(err) {
+		return ma, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &ma.series)
+	if err != nil {
+		return nil, err
+	}
+	return ma, nil
+}
+
+// SaveSnapshots writes the series of every memory store of the process that has a snapshot path to it
+func SaveSnapshots() error {
+	memoryStoresMu.Lock()
+	defer memoryStoresMu.Unlock()
+	for _, ma := range memoryStores {
+		err := ma.SaveSnapshot()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddPoint inserts a point in its place in the given series (replacing it if it was already there), the series is
+// created if it doesn't exist
+func (ma *MemoryAdapter) AddPoint(name string, p Point) error {
+	return ma.AddPoints(name, []Point{p})
+}
+
+// AddPoints inserts a batch of points in the given series at once, the series is created if it doesn't exist
+func (ma *MemoryAdapter) AddPoints(name string, points []Point) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	s, ok := ma.series[cleanDir(name)]
+	if !ok {
+		logger.Info("Creating new series " + name + " in memory")
+		s = &memorySeries{Points: []Point{}, Retention: ma.retention}
+		ma.series[cleanDir(name)] = s
+	}
+	for _, p := range points {
+		s.insert(p)
+	}
+	return nil
+}
+
+// AddSeries creates an empty series that keeps its points for the given number of days
+func (ma *MemoryAdapter) AddSeries(name string, sample Point, retentionDays int) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	if _, ok := ma.series[cleanDir(name)]; ok {
+		return RequestError("series " + name + " already exists")
+	}
+	ma.series[cleanDir(name)] = &memorySeries{Points: []Point{}, Retention: retentionDays}
+	return nil
+}
+
+// DeleteSeries removes a series along with all of its points
+func (ma *MemoryAdapter) DeleteSeries(name string) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	delete(ma.series, cleanDir(name))
+	return nil
+}
+
+// Exists returns true if the specified series is in memory
+func (ma *MemoryAdapter) Exists(name string) (bool, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+	_, ok := ma.series[cleanDir(name)]
+	return ok, nil
+}
+
+// GetCount retrieves the number of points recorded for the given series with the specified labels (returns 0 if the
+// series doesn't exist)
+func (ma *MemoryAdapter) GetCount(name string, labels map[string]string) (int, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+	s, ok := ma.series[cleanDir(name)]
+	if !ok {
+		return 0, nil
+	}
+	if len(labels) == 0 {
+		return len(s.Points), nil
+	}
+	count := 0
+	for _, p := range s.Points {
+		if p.HasLabels(labels) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetLatest returns the most recent point of the series with the specified labels
+func (ma *MemoryAdapter) GetLatest(name string, labels map[string]string) (Point, error) {
+	points, err := ma.GetLastN(name, labels, 1)
+	if err != nil {
+		return Point{}, err
+	}
+	if len(points) < 1 {
+		return Point{}, RequestError("no points found")
+	}
+	return points[0], nil
+}
+
+// GetLastN returns the last n points for the given series with the specified labels
+func (ma *MemoryAdapter) GetLastN(name string, labels map[string]string, n int) ([]Point, error) {
+	return ma.GetLastNSelected(name, types.Selection{Labels: labels}, n)
+}
+
+// GetLastNSelected returns the last n points of the given series that match the selection, newest first
+func (ma *MemoryAdapter) GetLastNSelected(name string, sel types.Selection, n int) ([]Point, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+	s, ok := ma.series[cleanDir(name)]
+	if !ok {
+		return nil, RequestError("series " + name + " doesn't exist")
+	}
+	end := len(s.Points)
+	if sel.To != 0 {
+		end = sort.Search(len(s.Points), func(i int) bool { return s.Points[i].TimeStamp > sel.To })
+	}
+	points := []Point{}
+	for i := end - 1; i >= 0 && len(points) < n; i-- {
+		p := s.Points[i]
+		if sel.From != 0 && p.TimeStamp < sel.From {
+			break
+		}
+		if p.HasLabels(sel.Labels) {
+			points = append(points, p)
+		}
+	}
+	return points, nil
+}
+
+// GetRetention returns the number of days that the points of the given series are kept for
+func (ma *MemoryAdapter) GetRetention(name string) (int, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+	s, ok := ma.series[cleanDir(name)]
+	if !ok {
+		return 0, RequestError("series " + name + " doesn't exist")
+	}
+	return s.Retention, nil
+}
+
+// ListSeries returns a list of all the series in memory sorted by name
+func (ma *MemoryAdapter) ListSeries() ([]types.BriefSeries, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+	series := make([]types.BriefSeries, 0, len(ma.series))
+	for name, s := range ma.series {
+		series = append(series, types.BriefSeries{Name: name, Count: len(s.Points)})
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Name < series[j].Name })
+	return series, nil
+}
+
+// Prune deletes the points that are older than the retention of their series at the given time, returning how many
+// were deleted
+func (ma *MemoryAdapter) Prune(now time.Time) (int, error) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	pruned := 0
+	for _, s := range ma.series {
+		if s.Retention == 0 {
+			continue
+		}
+		cutoff := now.AddDate(0, 0, -s.Retention).Unix()
+		i := sort.Search(len(s.Points), func(i int) bool { return s.Points[i].TimeStamp >= cutoff })
+		s.Points = append([]Point{}, s.Points[i:]...)
+		pruned += i
+	}
+	return pruned, nil
+}
+
+// SaveSnapshot writes every series to the snapshot path of the store (if it has one), through a temporary file so
+// that a previous snapshot is never left half written
+func (ma *MemoryAdapter) SaveSnapshot() error {
+	if ma.snapshot == "" {
+		return nil
+	}
+	ma.mu.RLock()
+	data, err := json.Marshal(ma.series)
+	ma.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(ma.snapshot+".tmp", data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(ma.snapshot+".tmp", ma.snapshot)
+}
+
+// SetRetention changes the number of days that the points of an existing series are kept for
+func (ma *MemoryAdapter) SetRetention(name string, retentionDays int) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	s, ok := ma.series[cleanDir(name)]
+	if !ok {
+		return RequestError("series " + name + " doesn't exist")
+	}
+	s.Retention = retentionDays
+	return nil
+}
@@ -0,0 +1,72 @@
+package pointstores
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/qvantel/nerd/internal/config"
+)
+
+func TestConformanceMemory(t *testing.T) {
+	ma, err := newMemoryAdapter("", 0)
+	if err != nil {
+		t.Fatalf("Failed to get point store (%s)", err.Error())
+	}
+	testConformance(t, ma, "conformance", func() {})
+}
+
+func TestSnapshotMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nerd-memory")
+	if err != nil {
+		t.Fatalf("Failed to create snapshot directory (%s)", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	conf := config.Config{
+		Series: config.SeriesParams{
+			Retention:   30,
+			StoreType:   config.MemorySeriesStore,
+			StoreParams: map[string]interface{}{"Snapshot": dir + "/series.json"},
+		},
+	}
+	ps, err := New(conf)
+	if err != nil {
+		t.Fatalf("Failed to get point store (%s)", err.Error())
+	}
+	for _, p := range conformancePoints() {
+		err = ps.AddPoint(t.Name(), p)
+		if err != nil {
+			t.Fatalf("Failed to add point to store (%s)", err.Error())
+		}
+	}
+	same, err := New(conf)
+	if err != nil {
+		t.Fatalf("Failed to get point store (%s)", err.Error())
+	}
+	if same != ps {
+		t.Error("Expected the stores created with the same configuration to be shared")
+	}
+	err = SaveSnapshots()
+	if err != nil {
+		t.Fatalf("Failed to save snapshots (%s)", err.Error())
+	}
+
+	reloaded, err := newMemoryAdapter(dir+"/series.json", 0)
+	if err != nil {
+		t.Fatalf("Failed to reload point store (%s)", err.Error())
+	}
+	points, err := reloaded.GetLastN(t.Name(), map[string]string{"subject": "env-a"}, 10)
+	if err != nil {
+		t.Fatalf("Failed to get points from store (%s)", err.Error())
+	}
+	if len(points) != 2 || points[0].Values["size"] != 3 || points[1].Values["size"] != 1 {
+		t.Errorf("Expected the reloaded series to have the points of env-a, got %+v", points)
+	}
+	days, err := reloaded.GetRetention(t.Name())
+	if err != nil {
+		t.Fatalf("Failed to get retention (%s)", err.Error())
+	}
+	if days != 30 {
+		t.Errorf("Expected the reloaded series to keep a retention of 30 days, got %d", days)
+	}
+}
@@ -15,6 +15,16 @@ type Point struct {
 	TimeStamp int64
 }
 
+// HasLabels returns true if the point has all of the given labels with the same values
+func (p Point) HasLabels(labels map[string]string) bool {
+	for label, value := range labels {
+		if p.Labels[label] != value {
+			return false
+		}
+	}
+	return true
+}
+
 // ID generates a string that uniquely identifies a point. Useful for deduplication
 func (p Point) ID() string {
 	// We have to sort the labels in the map to ensure the hash is deterministic
@@ -3,6 +3,10 @@ package pointstores
 
 import (
 	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/qvantel/nerd/api/types"
 	"github.com/qvantel/nerd/internal/config"
@@ -14,7 +18,10 @@ const prefix string = "nerd-"
 type PointStore interface {
 	// Adds a point to a series, should create it if it doesn't exist (calling AddSeries)
 	AddPoint(name string, p Point) error
-	// Create a new series
+	// Adds a batch of points to a series in one go, should create it if it doesn't exist. If only some of the points
+	// can't be stored, a BatchError should be returned
+	AddPoints(name string, points []Point) error
+	// Create a new series whose points are kept for the given number of days (0 keeps them forever)
 	AddSeries(name string, sample Point, retentionDays int) error
 	// Delete a series
 	DeleteSeries(name string) error
@@ -23,17 +30,64 @@ type PointStore interface {
 	// Gets the current value of the series
 	GetLatest(name string, labels map[string]string) (Point, error)
 	GetLastN(name string, labels map[string]string, n int) ([]Point, error)
+	// Gets the last n points of the series that match the selection (labels and time range)
+	GetLastNSelected(name string, sel types.Selection, n int) ([]Point, error)
+	// Gets the number of days that the points of the series are kept for
+	GetRetention(name string) (int, error)
 	// Get list of available series
 	ListSeries() ([]types.BriefSeries, error)
+	// Changes the number of days that the points of an existing series are kept for
+	SetRetention(name string, retentionDays int) error
+}
+
+// Pruner is implemented by the point stores that can't enforce the retention of the series on their own
+type Pruner interface {
+	// Deletes the points that are past the retention of their series, returning how many were deleted
+	Prune(now time.Time) (int, error)
+}
+
+// BatchError is returned by AddPoints when some of the points of a batch couldn't be stored (the rest were)
+type BatchError struct {
+	Failed map[int]error // Reason each failed point couldn't be stored, by its position in the batch
+}
+
+func (be BatchError) Error() string {
+	positions := make([]int, 0, len(be.Failed))
+	for i := range be.Failed {
+		positions = append(positions, i)
+	}
+	sort.Ints(positions)
+	reasons := make([]string, 0, len(positions))
+	for _, i := range positions {
+		reasons = append(reasons, "point "+strconv.Itoa(i)+" ("+be.Failed[i].Error()+")")
+	}
+	return "failed to store " + strconv.Itoa(len(be.Failed)) + " points of the batch: " + strings.Join(reasons, ", ")
+}
+
+// RequestError is returned when a call can't succeed because of what was asked for (like a series that doesn't exist)
+// rather than because of a problem with the store, so trying again won't help
+type RequestError string
+
+func (re RequestError) Error() string {
+	return string(re)
 }
 
 // New returns an initialized point store of the type specified in the configuration
 func New(conf config.Config) (PointStore, error) {
 	switch conf.Series.StoreType {
 	case config.FileSeriesStore:
-		return NewFileAdapter(conf.Series.StoreParams)
+		fa, err := NewFileAdapter(conf.Series.StoreParams)
+		if err != nil {
+			return nil, err
+		}
+		fa.Retention = conf.Series.Retention
+		return fa, nil
+	case config.BoltSeriesStore:
+		return NewBoltAdapter(conf.Series.StoreParams, conf.Series.Retention)
 	case config.ElasticsearchSeriesStore:
 		return NewElasticAdapter(conf.Series)
+	case config.MemorySeriesStore:
+		return NewMemoryAdapter(conf.Series.StoreParams, conf.Series.Retention)
 	default:
 		return nil, errors.New(conf.Series.StoreType + " is not a valid point store type")
 	}
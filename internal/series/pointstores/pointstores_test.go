@@ -0,0 +1,14 @@
+package pointstores
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBatchError(t *testing.T) {
+	err := BatchError{Failed: map[int]error{7: errors.New("mapping conflict"), 2: errors.New("rejected")}}
+	expected := "failed to store 2 points of the batch: point 2 (rejected), point 7 (mapping conflict)"
+	if err.Error() != expected {
+		t.Errorf("Expected the error to read %q, got %q", expected, err.Error())
+	}
+}
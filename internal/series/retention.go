@@ -0,0 +1,47 @@
+package series
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/logger"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+// pruneInterval is how often the points that are past the retention of their series are deleted
+const pruneInterval = time.Hour
+
+// Pruner deletes the points that are past the retention of their series every pruneInterval until the context is done.
+// Stores that enforce retention on their own (like Elasticsearch, through ILM) don't need it, so it just waits for them
+func Pruner(ctx context.Context, conf config.Config) error {
+	ps, err := pointstores.New(conf)
+	if err != nil {
+		logger.Error("Failed to initialize point store", err)
+		return err
+	}
+	pr, ok := ps.(pointstores.Pruner)
+	if !ok {
+		<-ctx.Done()
+		return nil
+	}
+	logger.Info("Pruner initialized")
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		pruned, err := pr.Prune(time.Now())
+		if err != nil {
+			// It will be tried again on the next tick
+			logger.Error("Failed to prune series", err)
+		} else if pruned > 0 {
+			logger.Info("Pruned " + strconv.Itoa(pruned) + " points that were past the retention of their series")
+		}
+		select {
+		case <-ctx.Done():
+			logger.Info("Pruner stopped")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
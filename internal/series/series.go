@@ -9,16 +9,19 @@ import (
 	"time"
 
 	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/google/uuid"
 	"github.com/qvantel/nerd/api/types"
 	"github.com/qvantel/nerd/internal/config"
 	"github.com/qvantel/nerd/internal/logger"
 	"github.com/qvantel/nerd/internal/nets"
+	"github.com/qvantel/nerd/internal/nets/paramstores"
+	"github.com/qvantel/nerd/internal/nets/queues"
 	"github.com/qvantel/nerd/internal/series/pointstores"
 )
 
 // ProcessUpdate serves to separate the cloud event processing logic from that which is Kafka specific, that way
 // allowing for training data to be ingested into the system through other channels
-func ProcessUpdate(event event.Event, ps pointstores.PointStore, tServ chan types.TrainRequest, conf config.Config) error {
+func ProcessUpdate(event event.Event, ps pointstores.PointStore, nps paramstores.NetParamStore, tServ queues.Queue, conf config.Config) error {
 	switch event.Type() {
 	case "com.qvantel.nerd.metricsupdate":
 		// Unmarshal
@@ -52,6 +55,7 @@ func ProcessUpdate(event event.Event, ps pointstores.PointStore, tServ chan type
 		}
 		mu.Labels["subject"] = event.Subject()
 		mu.Labels["stage"] = mu.Stage
+		points := make([]pointstores.Point, 0, len(mu.Points))
 		for _, point := range mu.Points {
 			values := map[string]float32{}
 			for key, value := range point.Inputs {
@@ -60,35 +64,42 @@ func ProcessUpdate(event event.Event, ps pointstores.PointStore, tServ chan type
 			for key, value := range point.Outputs {
 				values[key] = value
 			}
-			p := pointstores.Point{
+			points = append(points, pointstores.Point{
 				Labels:    mu.Labels,
 				Values:    values,
 				TimeStamp: point.TimeStamp,
-			}
-			err = ps.AddPoint(mu.SeriesID, p)
-			if err != nil {
-				logger.Error("Error encountered while persisting point to store", err)
-				return err
-			}
+			})
+		}
+		err = ps.AddPoints(mu.SeriesID, points)
+		if err != nil {
+			logger.Error("Error encountered while persisting points to store", err)
+			return err
 		}
 		// Queue up training if enough points are available
 		req := nets.Required(len(inputs), 1, conf.ML.MaxHLayers, conf) // 1 because we'll be creating individual nets for each output
 		logger.Trace(fmt.Sprintf("Got %d points for %s, %d required for training", count+len(mu.Points), mu.SeriesID, req))
-		if count < req && count+len(mu.Points) >= req {
-			if conf.Series.StoreType == config.ElasticsearchSeriesStore {
-				// Pause for refresh, otherwise the points might not be readable yet and/or the next call might see the
-				// old count again
-				time.Sleep(1 * time.Second)
+		// Check the existing nets for drift every time a full window of new points is available
+		window := conf.Series.DriftWindow
+		if conf.Series.DriftThreshold > 0 && count >= req && (count+len(mu.Points))/window > count/window {
+			refresh(conf)
+			err = CheckDrift(mu.SeriesID, ps, nps, tServ, conf)
+			if err != nil {
+				// The points have already been persisted so this shouldn't count as a processing failure
+				logger.Error("Failed to check the nets of "+mu.SeriesID+" for drift", err)
 			}
+		}
+		if count < req && count+len(mu.Points) >= req {
+			refresh(conf)
 			sort.Strings(inputs)
 			sort.Strings(outputs)
-			tServ <- types.TrainRequest{
+			return tServ.Push(types.TrainRequest{
 				ErrMargin: mu.ErrMargin,
+				ID:        uuid.New().String(),
 				Inputs:    inputs,
 				Outputs:   outputs,
 				Required:  req,
 				SeriesID:  mu.SeriesID,
-			}
+			})
 		}
 
 		return nil
@@ -97,3 +108,12 @@ func ProcessUpdate(event event.Event, ps pointstores.PointStore, tServ chan type
 		return errors.New("unsupported event type")
 	}
 }
+
+// refresh waits for the point store to make the latest points readable if needed
+func refresh(conf config.Config) {
+	if conf.Series.StoreType == config.ElasticsearchSeriesStore {
+		// Pause for refresh, otherwise the points might not be readable yet and/or the next call might see the old
+		// count again
+		time.Sleep(1 * time.Second)
+	}
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/qvantel/nerd/api/types"
+	"github.com/qvantel/nerd/internal/config"
+	"github.com/qvantel/nerd/internal/nets"
+	"github.com/qvantel/nerd/internal/series/pointstores"
+)
+
+// loadBanknotes reads the banknote authentication data set (comma separated values, the last one being the class)
+func loadBanknotes() ([]pointstores.Point, error) {
+	file, err := os.Open("shuffled_banknote_authentication.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	t := int64(777808800)
+	var points []pointstores.Point
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		values := strings.Split(scanner.Text(), ",")
+		vmap := map[string]float32{}
+		for i, rValue := range values {
+			value, err := strconv.ParseFloat(rValue, 32)
+			if err != nil {
+				return nil, err
+			}
+			vmap["value-"+strconv.Itoa(i)] = float32(value)
+		}
+		points = append(points, pointstores.Point{Values: vmap, TimeStamp: t})
+		t++
+	}
+	return points, scanner.Err()
+}
+
+// converge runs the genetic algorithm with the given params from a fixed seed and returns the best fitness of each
+// generation
+func converge(t *testing.T, mlConf config.MLParams, tr types.TrainRequest, points []pointstores.Point) []float32 {
+	rand.Seed(42)
+	net, err := nets.NewPopulation(mlConf).Optimal(context.Background(), tr, tr.Outputs, points)
+	if err != nil {
+		t.Fatalf("Optimal search with %s selection and %s crossover failed (%s)", mlConf.Selection, mlConf.Crossover, err.Error())
+	}
+	history := net.Params().TrainingHistory()
+	if len(history.Generations) != mlConf.Generations+1 {
+		t.Fatalf("Expected the best fitness of %d generations, got %v", mlConf.Generations+1, history.Generations)
+	}
+	return history.Generations
+}
+
+func TestOptimalConvergence(t *testing.T) {
+	// The default operators of the genetic algorithm
+	defaults := config.MLParams{
+		Crossover:   config.UniformCrossover,
+		Elitism:     1,
+		Generations: 4,
+		MaxEpoch:    1000,
+		MaxHLayers:  2,
+		MinHLayers:  1,
+		Mutation:    0.2,
+		Selection:   config.TournamentSelection,
+		TestSet:     0.4,
+		Tolerance:   0.1,
+		Tournament:  3,
+		Variations:  6,
+	}
+	// The simplest of the configurable operators (point crossover, roulette selection and no elitism) as a reference
+	reference := defaults
+	reference.Crossover = config.PointCrossover
+	reference.Elitism = 0
+	reference.Selection = config.RouletteSelection
+	points, err := loadBanknotes()
+	if err != nil {
+		t.Fatalf("Failed to load test data (%s)", err.Error())
+	}
+	tr := types.TrainRequest{
+		ErrMargin: 0.4999999,
+		Inputs:    []string{"value-0", "value-1", "value-2", "value-3"},
+		Outputs:   []string{"value-4"},
+		SeriesID:  "banknote-forgery-detection",
+	}
+
+	got := converge(t, defaults, tr, points)
+	// Thanks to elitism, the best individual can only be replaced by a better one
+	for gen := 1; gen < len(got); gen++ {
+		if got[gen] < got[gen-1] {
+			t.Errorf("Best fitness decreased from %f to %f in generation %d", got[gen-1], got[gen], gen)
+		}
+	}
+	ref := converge(t, reference, tr, points)
+	t.Logf("Best fitness per generation: %v (%v with the reference operators)", got, ref)
+	if last := len(got) - 1; got[last] < ref[last] {
+		t.Errorf("Expected the default operators to converge at least as well as the reference ones (%f), got %f", ref[last], got[last])
+	}
+	if acc := got[len(got)-1]; acc < 0.9 {
+		t.Errorf("Expected at least 90 percent accuracy for the banknote data set, got %f", acc)
+	}
+}